@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/skeema/tengo"
+)
+
+// AnonymizeMap tracks the anonymized name assigned to each original schema,
+// table, column, and index name, as well as each enum or set value. It is
+// persisted as JSON in the file configured by the anonymize-map option, so that
+// repeated exports use the same names. This file reveals all original names,
+// and should never be shared along with the exported files.
+type AnonymizeMap struct {
+	Schemas map[string]string `json:"schemas"`
+	Tables  map[string]string `json:"tables"`
+	Columns map[string]string `json:"columns"`
+	Indexes map[string]string `json:"indexes"`
+	Values  map[string]string `json:"values"`
+}
+
+// ReadAnonymizeMap reads the mapping file at mapPath. If the file does not
+// exist, an empty AnonymizeMap is returned.
+func ReadAnonymizeMap(mapPath string) (*AnonymizeMap, error) {
+	am := &AnonymizeMap{}
+	contents, err := ioutil.ReadFile(mapPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	} else if err == nil {
+		if err := json.Unmarshal(contents, am); err != nil {
+			return nil, fmt.Errorf("Unable to parse anonymize map file %s: %s", mapPath, err)
+		}
+	}
+	for _, m := range []*map[string]string{&am.Schemas, &am.Tables, &am.Columns, &am.Indexes, &am.Values} {
+		if *m == nil {
+			*m = make(map[string]string)
+		}
+	}
+	return am, nil
+}
+
+// Write persists the mapping to mapPath. The file is replaced atomically, so
+// that an interrupted write cannot leave it truncated.
+func (am *AnonymizeMap) Write(mapPath string) error {
+	contents, err := json.MarshalIndent(am, "", "  ")
+	if err != nil {
+		return err
+	}
+	tempPath := fmt.Sprintf("%s.tmp%d", mapPath, os.Getpid())
+	if err := ioutil.WriteFile(tempPath, append(contents, '\n'), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tempPath, mapPath)
+}
+
+// name returns the anonymized name for orig in m, assigning the next unused
+// name with the supplied prefix if orig has not been seen before.
+func (am *AnonymizeMap) name(m map[string]string, prefix, orig string) string {
+	if anon, ok := m[orig]; ok {
+		return anon
+	}
+	used := make(map[string]bool, len(m))
+	for _, anon := range m {
+		used[anon] = true
+	}
+	var anon string
+	for n := len(m) + 1; anon == "" || used[anon]; n++ {
+		anon = prefix + strconv.Itoa(n)
+	}
+	m[orig] = anon
+	return anon
+}
+
+// SchemaName returns the anonymized name for the supplied schema name.
+func (am *AnonymizeMap) SchemaName(name string) string {
+	return am.name(am.Schemas, "schema", name)
+}
+
+// Table returns a copy of table with all identifiers, comments, enum and set
+// values, and textual default values anonymized. The copy's next auto-increment
+// value is omitted. Structure is otherwise preserved, including column types,
+// index definitions, and table options. Tables with UnsupportedDDL cannot be
+// anonymized, since their CREATE TABLE cannot be regenerated.
+func (am *AnonymizeMap) Table(table *tengo.Table) (*tengo.Table, error) {
+	if table.UnsupportedDDL {
+		return nil, fmt.Errorf("table %s uses features that Skeema cannot regenerate", table.Name)
+	}
+	result := &tengo.Table{
+		Name:          am.name(am.Tables, "table", table.Name),
+		Engine:        table.Engine,
+		CharSet:       table.CharSet,
+		Collation:     table.Collation,
+		CreateOptions: table.CreateOptions,
+		Comment:       anonymizeComment(table.Comment),
+		Columns:       make([]*tengo.Column, len(table.Columns)),
+	}
+	columns := make(map[*tengo.Column]*tengo.Column, len(table.Columns))
+	for n, col := range table.Columns {
+		anonCol := *col
+		anonCol.Name = am.name(am.Columns, "col", col.Name)
+		anonCol.Comment = anonymizeComment(col.Comment)
+		anonCol.TypeInDB, anonCol.Default = am.typeAndDefault(col)
+		result.Columns[n] = &anonCol
+		columns[col] = &anonCol
+	}
+	result.PrimaryKey = am.index(table.PrimaryKey, columns)
+	result.SecondaryIndexes = make([]*tengo.Index, len(table.SecondaryIndexes))
+	for n, idx := range table.SecondaryIndexes {
+		result.SecondaryIndexes[n] = am.index(idx, columns)
+	}
+	return result, nil
+}
+
+func (am *AnonymizeMap) index(idx *tengo.Index, columns map[*tengo.Column]*tengo.Column) *tengo.Index {
+	if idx == nil {
+		return nil
+	}
+	anonIdx := &tengo.Index{
+		Name:       idx.Name,
+		Columns:    make([]*tengo.Column, len(idx.Columns)),
+		SubParts:   idx.SubParts,
+		PrimaryKey: idx.PrimaryKey,
+		Unique:     idx.Unique,
+		Comment:    anonymizeComment(idx.Comment),
+	}
+	if !idx.PrimaryKey {
+		anonIdx.Name = am.name(am.Indexes, "idx", idx.Name)
+	}
+	for n, col := range idx.Columns {
+		anonIdx.Columns[n] = columns[col]
+	}
+	return anonIdx
+}
+
+// typeAndDefault returns the anonymized type and default value for col. Enum
+// and set values are mapped consistently, so that a default value still
+// matches one of the column's values. Other textual and binary default values
+// are replaced with a placeholder of the same length.
+func (am *AnonymizeMap) typeAndDefault(col *tengo.Column) (string, tengo.ColumnDefault) {
+	typeInDB, def := col.TypeInDB, col.Default
+	if strings.HasPrefix(typeInDB, "enum(") || strings.HasPrefix(typeInDB, "set(") {
+		open := strings.IndexByte(typeInDB, '(')
+		closeParen := strings.LastIndexByte(typeInDB, ')')
+		values := parseEnumValues(typeInDB[open+1 : closeParen])
+		for n, value := range values {
+			values[n] = "'" + am.name(am.Values, "value", value) + "'"
+		}
+		typeInDB = typeInDB[:open+1] + strings.Join(values, ",") + typeInDB[closeParen:]
+		if def.Quoted && def.Value != "" {
+			defValues := strings.Split(def.Value, ",")
+			for n, value := range defValues {
+				defValues[n] = am.name(am.Values, "value", value)
+			}
+			def.Value = strings.Join(defValues, ",")
+		}
+	} else if def.Quoted && (col.CharSet != "" || strings.Contains(typeInDB, "binary")) {
+		def.Value = strings.Repeat("x", utf8.RuneCountInString(def.Value))
+	}
+	return typeInDB, def
+}
+
+// parseEnumValues splits the quoted value list of an enum or set column type,
+// as displayed by SHOW CREATE TABLE, into unquoted values.
+func parseEnumValues(list string) []string {
+	var values []string
+	var current []byte
+	var inQuote bool
+	for n := 0; n < len(list); n++ {
+		c := list[n]
+		if !inQuote {
+			if c == '\'' {
+				inQuote = true
+				current = current[:0]
+			}
+			continue
+		}
+		if c == '\'' {
+			if n+1 < len(list) && list[n+1] == '\'' {
+				current = append(current, c)
+				n++
+				continue
+			}
+			inQuote = false
+			values = append(values, string(current))
+			continue
+		}
+		current = append(current, c)
+	}
+	return values
+}
+
+// anonymizeComment returns a placeholder for a non-blank comment, so that the
+// presence of a comment is preserved without revealing its contents.
+func anonymizeComment(comment string) string {
+	if comment == "" {
+		return ""
+	}
+	return "comment"
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/skeema/tengo"
+)
+
+func TestParseEnumValues(t *testing.T) {
+	cases := map[string][]string{
+		"'a','b','c'":   {"a", "b", "c"},
+		"'it''s','x,y'": {"it's", "x,y"},
+		"'','(paren)'":  {"", "(paren)"},
+		"'single'":      {"single"},
+	}
+	for input, expected := range cases {
+		if actual := parseEnumValues(input); !reflect.DeepEqual(actual, expected) {
+			t.Errorf("Expected parseEnumValues(%q) to return %v, instead found %v", input, expected, actual)
+		}
+	}
+}
+
+func TestAnonymizeMapTable(t *testing.T) {
+	id := &tengo.Column{Name: "customer_id", TypeInDB: "int(10) unsigned", AutoIncrement: true}
+	email := &tengo.Column{Name: "email", TypeInDB: "varchar(100)", Nullable: true, Default: tengo.ColumnDefaultValue("n/a"), CharSet: "utf8mb4", Comment: "customer email"}
+	status := &tengo.Column{Name: "status", TypeInDB: "enum('active','it''s closed')", Default: tengo.ColumnDefaultValue("active"), CharSet: "utf8mb4"}
+	balance := &tengo.Column{Name: "balance", TypeInDB: "int(11)", Default: tengo.ColumnDefaultValue("0")}
+	table := &tengo.Table{
+		Name:              "customers",
+		Engine:            "InnoDB",
+		CharSet:           "utf8mb4",
+		Comment:           "all paying customers",
+		Columns:           []*tengo.Column{id, email, status, balance},
+		PrimaryKey:        &tengo.Index{Name: "PRIMARY", Columns: []*tengo.Column{id}, SubParts: []uint16{0}, PrimaryKey: true, Unique: true},
+		SecondaryIndexes:  []*tengo.Index{{Name: "email_lookup", Columns: []*tengo.Column{email, status}, SubParts: []uint16{10, 0}}},
+		NextAutoIncrement: 1234,
+	}
+
+	am, err := ReadAnonymizeMap("/nonexistent/anonymize-map.json")
+	if err != nil {
+		t.Fatalf("Unexpected error from ReadAnonymizeMap: %s", err)
+	}
+	anon, err := am.Table(table)
+	if err != nil {
+		t.Fatalf("Unexpected error from Table: %s", err)
+	}
+	expected := "CREATE TABLE `table1` (\n" +
+		"  `col1` int(10) unsigned NOT NULL AUTO_INCREMENT,\n" +
+		"  `col2` varchar(100) DEFAULT 'xxx' COMMENT 'comment',\n" +
+		"  `col3` enum('value1','value2') NOT NULL DEFAULT 'value1',\n" +
+		"  `col4` int(11) NOT NULL DEFAULT '0',\n" +
+		"  PRIMARY KEY (`col1`),\n" +
+		"  KEY `idx1` (`col2`(10),`col3`)\n" +
+		") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='comment'"
+	if actual := anon.CreateStatement(); actual != expected {
+		t.Errorf("Unexpected anonymized CREATE TABLE:\n%s\nExpected:\n%s", actual, expected)
+	}
+	for _, orig := range []string{"customer", "email", "active", "closed", "paying"} {
+		if strings.Contains(anon.CreateStatement(), orig) {
+			t.Errorf("Anonymized CREATE TABLE unexpectedly contains %q", orig)
+		}
+	}
+	if table.Name != "customers" || email.Name != "email" || table.PrimaryKey.Columns[0] != id {
+		t.Error("Table unexpectedly modified original table")
+	}
+
+	table.UnsupportedDDL = true
+	if _, err := am.Table(table); err == nil {
+		t.Error("Expected error anonymizing table with UnsupportedDDL, but err was nil")
+	}
+
+	// Confirm mapping persists across a write and read
+	tempDir, err := ioutil.TempDir("", "skeema-test-anonymize")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(tempDir)
+	mapPath := path.Join(tempDir, "anonymize-map.json")
+	if err := am.Write(mapPath); err != nil {
+		t.Fatalf("Unexpected error from Write: %s", err)
+	}
+	reread, err := ReadAnonymizeMap(mapPath)
+	if err != nil {
+		t.Fatalf("Unexpected error from ReadAnonymizeMap: %s", err)
+	}
+	if !reflect.DeepEqual(am, reread) {
+		t.Errorf("Mapping did not persist as expected: %+v vs %+v", am, reread)
+	}
+	if reread.SchemaName("product") != "schema1" || reread.SchemaName("analytics") != "schema2" || reread.SchemaName("product") != "schema1" {
+		t.Errorf("Unexpected schema name mapping: %+v", reread.Schemas)
+	}
+	reread.Tables["orders"] = "table3"
+	if anonName := reread.name(reread.Tables, "table", "invoices"); anonName != "table4" {
+		t.Errorf("Expected new name to avoid collision with existing names, instead found %s", anonName)
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// This file contains a registry of resources created during a run (temporary
+// schemas, temporary dirs, etc) which must be removed before the program exits,
+// even if the run is terminated early by an error or a signal.
+
+// runTempDirPrefix is the name prefix used for per-run temporary directories.
+// The process ID follows the prefix, so that `skeema cleanup` can determine
+// whether the run that created the dir is still alive.
+const runTempDirPrefix = "skeema-run-"
+
+// cleanupEntry represents a single resource requiring removal prior to exit.
+type cleanupEntry struct {
+	id          int
+	description string
+	fn          func() error
+}
+
+// ResourceRegistry tracks resources that have been created during the current
+// run, along with how to remove them.
+type ResourceRegistry struct {
+	entries []*cleanupEntry
+	nextID  int
+	tempDir string
+	*sync.Mutex
+}
+
+// Resources is the registry for the current run. It is global so that any
+// code path which creates a resource may register its removal, and so that
+// Exit can perform all pending removals regardless of where it was called.
+var Resources = &ResourceRegistry{Mutex: new(sync.Mutex)}
+
+// Register records a resource requiring cleanup, returning an ID which may be
+// passed to Unregister once the resource has been removed through normal
+// means. The description is used in log output if removal fails.
+func (rr *ResourceRegistry) Register(description string, fn func() error) int {
+	rr.Lock()
+	defer rr.Unlock()
+	rr.nextID++
+	rr.entries = append(rr.entries, &cleanupEntry{
+		id:          rr.nextID,
+		description: description,
+		fn:          fn,
+	})
+	return rr.nextID
+}
+
+// Unregister removes a previously-registered resource from the registry,
+// without running its cleanup function.
+func (rr *ResourceRegistry) Unregister(id int) {
+	rr.Lock()
+	defer rr.Unlock()
+	for n, entry := range rr.entries {
+		if entry.id == id {
+			rr.entries = append(rr.entries[:n], rr.entries[n+1:]...)
+			return
+		}
+	}
+}
+
+// Cleanup runs the cleanup functions of all registered resources, in the
+// reverse order of their registration, and then empties the registry. Failures
+// are logged but otherwise do not halt the process.
+func (rr *ResourceRegistry) Cleanup() {
+	rr.Lock()
+	entries := rr.entries
+	rr.entries = nil
+	rr.tempDir = ""
+	rr.Unlock()
+
+	for n := len(entries) - 1; n >= 0; n-- {
+		log.Debugf("Cleaning up %s", entries[n].description)
+		if err := entries[n].fn(); err != nil {
+			log.Warnf("Unable to clean up %s: %s", entries[n].description, err)
+		}
+	}
+}
+
+// TempDir returns the path to a temporary directory for use by the current
+// run, creating it upon first call. The dir and all of its contents will be
+// removed automatically upon exit.
+func (rr *ResourceRegistry) TempDir() (string, error) {
+	rr.Lock()
+	if rr.tempDir != "" {
+		defer rr.Unlock()
+		return rr.tempDir, nil
+	}
+	dirPath, err := ioutil.TempDir("", fmt.Sprintf("%s%d-", runTempDirPrefix, os.Getpid()))
+	if err != nil {
+		rr.Unlock()
+		return "", fmt.Errorf("Unable to create temporary dir: %s", err)
+	}
+	rr.tempDir = dirPath
+	rr.Unlock()
+
+	rr.Register("temporary dir "+dirPath, func() error {
+		return os.RemoveAll(dirPath)
+	})
+	return dirPath, nil
+}
+
+// HandleSignals arranges for registered resources to be cleaned up if the
+// process receives SIGINT or SIGTERM, prior to exiting.
+func HandleSignals() {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-sigs
+		signal.Stop(sigs)
+		Exit(NewExitValue(CodeFatalError, "Received signal %s; exiting after cleanup", sig))
+	}()
+}
+
+// staleRunTempDirs returns the paths of per-run temporary dirs that were left
+// behind by runs whose process is no longer alive.
+func staleRunTempDirs() ([]string, error) {
+	fileInfos, err := ioutil.ReadDir(os.TempDir())
+	if err != nil {
+		return nil, err
+	}
+	var result []string
+	for _, fi := range fileInfos {
+		if !fi.IsDir() || !strings.HasPrefix(fi.Name(), runTempDirPrefix) {
+			continue
+		}
+		pidStr := strings.SplitN(strings.TrimPrefix(fi.Name(), runTempDirPrefix), "-", 2)[0]
+		pid, err := strconv.Atoi(pidStr)
+		if err != nil || processAlive(pid) {
+			continue
+		}
+		result = append(result, filepath.Join(os.TempDir(), fi.Name()))
+	}
+	return result, nil
+}
+
+// processAlive returns true if a process with the supplied pid exists.
+func processAlive(pid int) bool {
+	if pid == os.Getpid() {
+		return true
+	}
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
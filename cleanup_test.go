@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestResourceRegistry(t *testing.T) {
+	rr := &ResourceRegistry{Mutex: new(sync.Mutex)}
+	var order []string
+	register := func(name string, fail bool) int {
+		return rr.Register(name, func() error {
+			order = append(order, name)
+			if fail {
+				return errors.New("failed")
+			}
+			return nil
+		})
+	}
+	register("first", false)
+	middle := register("middle", false)
+	register("last", true)
+	rr.Unregister(middle)
+	rr.Cleanup()
+	if expected := []string{"last", "first"}; !reflect.DeepEqual(order, expected) {
+		t.Errorf("Expected cleanup order %v, instead found %v", expected, order)
+	}
+
+	// Registry should now be empty
+	order = nil
+	rr.Cleanup()
+	if len(order) > 0 {
+		t.Errorf("Expected no cleanup functions to run on an empty registry, instead found %v", order)
+	}
+
+	dirPath, err := rr.TempDir()
+	if err != nil {
+		t.Fatalf("Unexpected error from TempDir: %s", err)
+	}
+	if again, _ := rr.TempDir(); again != dirPath {
+		t.Errorf("Expected repeated calls to TempDir to return %s, instead found %s", dirPath, again)
+	}
+	rr.Cleanup()
+	if _, err := os.Stat(dirPath); !os.IsNotExist(err) {
+		t.Errorf("Expected %s to be removed by Cleanup, but it still exists", dirPath)
+	}
+}
@@ -38,12 +38,12 @@ func AddEnvHandler(cfg *mybase.Config) error {
 		return NewExitValue(CodeBadConfig, "In add-environment, --dir must refer to a directory that already exists")
 	}
 	if !dir.HasOptionFile() {
-		return NewExitValue(CodeBadConfig, "Dir %s does not have an existing .skeema file! Can only use `skeema add-environment` on a dir previously created by `skeema init`", dir)
+		return NewExitValue(CodeBadConfig, "Dir %s does not have an existing %s file! Can only use `skeema add-environment` on a dir previously created by `skeema init`", dir, dir.OptionFileName())
 	}
 
 	hostOptionFile, err := dir.OptionFile()
 	if err != nil || hostOptionFile == nil {
-		return NewExitValue(CodeBadInput, "Unable to read .skeema file for %s: %s", dir, err)
+		return NewExitValue(CodeBadInput, "Unable to read %s file for %s: %s", dir.OptionFileName(), dir, err)
 	}
 
 	environment := cfg.Get("environment")
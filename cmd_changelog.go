@@ -0,0 +1,318 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/skeema/mybase"
+)
+
+func init() {
+	summary := "Output a changelog of schema evolution from git history"
+	desc := `Outputs a chronological, human-readable changelog of how the tables of each
+schema have evolved, in Markdown format to STDOUT. This is suitable for release
+notes or compliance reports.
+
+The changelog is generated from the git history of the *.sql files in each dir
+of the current directory tree, so the directory tree must be part of a git
+working tree. Each commit which modified a dir's *.sql files is listed along
+with its date, abbreviated hash, author, and subject, followed by the tables it
+created, dropped, or altered. For altered tables, added, dropped, and modified
+columns, indexes, and foreign keys are listed, as well as changes to table
+options.
+
+Since this command only examines *.sql files in git history, it does not
+require connecting to any database instance. Tables are compared textually,
+which works best when table files are kept in canonical format via
+` + "`" + `skeema lint` + "`" + ` or ` + "`" + `skeema pull` + "`" + `.
+
+Use --since to only include commits after the supplied git revision, for
+example the tag of the previous release.`
+
+	cmd := mybase.NewCommand("changelog", summary, desc, ChangelogHandler)
+	cmd.AddOption(mybase.StringOption("since", 0, "", "Only include commits after this git revision (commit, branch, or tag)"))
+	cmd.AddOption(mybase.StringOption("ignore-table", 0, "", "Ignore tables that match regex"))
+	cmd.AddArg("environment", "production", false)
+	CommandSuite.AddSubCommand(cmd)
+}
+
+// ChangelogHandler is the handler method for `skeema changelog`
+func ChangelogHandler(cfg *mybase.Config) error {
+	AddGlobalConfigFiles(cfg)
+	dir, err := NewDir(".", cfg)
+	if err != nil {
+		return err
+	}
+	if CurrentGitRef(dir) == "" {
+		return NewExitValue(CodeBadConfig, "%s is not in a git working tree, or its git history cannot be read", dir)
+	}
+
+	errCount := writeChangelog(os.Stdout, dir)
+	if errCount > 0 {
+		var plural string
+		if errCount > 1 {
+			plural = "s"
+		}
+		return NewExitValue(CodePartialError, "Skipped %d operation%s due to error%s", errCount, plural, plural)
+	}
+	return nil
+}
+
+// writeChangelog outputs the changelog of dir and its subdirs to w, returning
+// the number of dirs skipped due to errors.
+func writeChangelog(w io.Writer, dir *Dir) (errCount int) {
+	if dir.HasSchema() {
+		entries, err := dirChangelog(dir)
+		if err != nil {
+			log.Errorf("Skipping %s: %s", dir, err)
+			errCount++
+		} else {
+			writeDirChangelog(w, dir, entries)
+		}
+	}
+	subdirs, err := dir.Subdirs()
+	if err != nil {
+		log.Errorf("Unable to list subdirs of %s: %s", dir, err)
+		return errCount + 1
+	}
+	for _, sub := range subdirs {
+		// Don't iterate into hidden dirs, for same reasons as generateTargetsForDir
+		if sub.BaseName()[0] != '.' {
+			errCount += writeChangelog(w, sub)
+		}
+	}
+	return errCount
+}
+
+// changelogEntry describes the table changes made to one dir by one commit.
+type changelogEntry struct {
+	Hash    string
+	Date    string
+	Author  string
+	Subject string
+	Changes []string
+}
+
+// dirChangelog returns changelog entries for each commit which modified the
+// *.sql files directly in dir, oldest first.
+func dirChangelog(dir *Dir) ([]*changelogEntry, error) {
+	ignoreTable := dir.Config.Get("ignore-table")
+	re, err := regexp.Compile(ignoreTable)
+	if err != nil {
+		return nil, OptionError(dir.Config, "ignore-table", fmt.Errorf("Invalid regular expression on ignore-table: %s; %s", ignoreTable, err))
+	}
+	revRange := "HEAD"
+	if since := dir.Config.Get("since"); since != "" {
+		revRange = since + "..HEAD"
+	}
+	logFormat := "%x01%H%x09%ad%x09%an%x09%s"
+	extra := map[string]string{"range": revRange, "logformat": logFormat}
+	s, err := NewInterpolatedShellOut("git -C {DIRPATH} log --reverse --relative --no-renames --name-status --date=short --format={LOGFORMAT} {RANGE} -- .", dir, extra)
+	if err != nil {
+		return nil, err
+	}
+	output, err := s.RunCapture()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to obtain git history: %s", err)
+	}
+
+	var entries []*changelogEntry
+	for _, commit := range strings.Split(output, "\x01") {
+		lines := strings.Split(strings.TrimSpace(commit), "\n")
+		header := strings.SplitN(lines[0], "\t", 4)
+		if len(header) < 4 {
+			continue
+		}
+		entry := &changelogEntry{
+			Hash:    header[0],
+			Date:    header[1],
+			Author:  header[2],
+			Subject: header[3],
+		}
+		for _, line := range lines[1:] {
+			fields := strings.SplitN(line, "\t", 2)
+			if len(fields) < 2 || strings.Contains(fields[1], "/") || !strings.HasSuffix(fields[1], ".sql") {
+				continue
+			}
+			change, err := entry.describeFileChange(dir, fields[0], fields[1])
+			if err != nil {
+				return nil, err
+			}
+			tableName := strings.TrimSuffix(fields[1], ".sql")
+			if change != "" && (ignoreTable == "" || !re.MatchString(tableName)) {
+				entry.Changes = append(entry.Changes, change)
+			}
+		}
+		if len(entry.Changes) > 0 {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// describeFileChange returns a description of the change to fileName made by
+// entry's commit, based on its git status letter. A blank string is returned
+// if the change did not affect the table definition.
+func (entry *changelogEntry) describeFileChange(dir *Dir, status, fileName string) (string, error) {
+	var oldContents, newContents string
+	var err error
+	if status != "A" {
+		if oldContents, err = gitFileContents(dir, entry.Hash+"^", fileName); err != nil {
+			return "", err
+		}
+	}
+	if status != "D" {
+		if newContents, err = gitFileContents(dir, entry.Hash, fileName); err != nil {
+			return "", err
+		}
+	}
+	oldDef := parseTableDefinition(oldContents)
+	newDef := parseTableDefinition(newContents)
+	switch status {
+	case "A":
+		return fmt.Sprintf("Created table %s", markdownIdentifier(newDef.Name, fileName)), nil
+	case "D":
+		return fmt.Sprintf("Dropped table %s", markdownIdentifier(oldDef.Name, fileName)), nil
+	}
+	if oldDef.Name != "" && newDef.Name != "" && oldDef.Name != newDef.Name {
+		return fmt.Sprintf("Renamed table %s to %s", markdownIdentifier(oldDef.Name, fileName), markdownIdentifier(newDef.Name, fileName)), nil
+	}
+	changes := oldDef.Diff(newDef)
+	if len(changes) == 0 {
+		return "", nil
+	}
+	return fmt.Sprintf("Altered table %s: %s", markdownIdentifier(newDef.Name, fileName), strings.Join(changes, ", ")), nil
+}
+
+// gitFileContents returns the contents of fileName in dir as of the supplied
+// git revision.
+func gitFileContents(dir *Dir, rev, fileName string) (string, error) {
+	extra := map[string]string{"spec": rev + ":./" + fileName}
+	s, err := NewInterpolatedShellOut("git -C {DIRPATH} show {SPEC}", dir, extra)
+	if err != nil {
+		return "", err
+	}
+	contents, err := s.RunCapture()
+	if err != nil {
+		return "", fmt.Errorf("Unable to read %s as of %s: %s", path.Join(dir.Path, fileName), rev, err)
+	}
+	return contents, nil
+}
+
+// markdownIdentifier returns name formatted as inline code, falling back to the
+// table name implied by fileName if name is blank.
+func markdownIdentifier(name, fileName string) string {
+	if name == "" {
+		name = strings.TrimSuffix(fileName, ".sql")
+	}
+	return "`" + strings.Replace(name, "`", "", -1) + "`"
+}
+
+// tableDefinition is a textual representation of a CREATE TABLE statement,
+// split into its component definitions.
+type tableDefinition struct {
+	Name    string
+	Options string
+	defs    map[string]string // key is kind and name, e.g. "column `id`"
+	order   []string          // keys of defs in order of appearance
+}
+
+var reColumnName = regexp.MustCompile("^`(?:[^`]|``)+`")
+var reDefinitionName = regexp.MustCompile("^(?:(?:UNIQUE |FULLTEXT |SPATIAL )?KEY|CONSTRAINT) (`(?:[^`]|``)+`)")
+
+// parseTableDefinition splits the CREATE TABLE statement in contents into its
+// column, index, and foreign key definitions, as well as its table options. If
+// contents does not contain a CREATE TABLE statement, the result is empty.
+func parseTableDefinition(contents string) *tableDefinition {
+	def := &tableDefinition{defs: make(map[string]string)}
+	matches := reParseCreate.FindStringSubmatch(contents)
+	if matches == nil {
+		return def
+	}
+	def.Name = unquoteIdentifier(matches[2])
+	body := matches[3]
+	if closeParen := strings.LastIndex(body, ")"); closeParen >= 0 {
+		def.Options = strings.TrimSpace(reTableAutoInc.ReplaceAllString(body[closeParen+1:], ""))
+		body = body[:closeParen]
+	}
+	body = strings.TrimPrefix(strings.TrimSpace(body), "(")
+	for _, line := range strings.Split(body, "\n") {
+		line = strings.TrimSuffix(strings.TrimSpace(line), ",")
+		var key string
+		if line == "" {
+			continue
+		} else if m := reColumnName.FindString(line); m != "" {
+			key = "column " + m
+		} else if strings.HasPrefix(line, "PRIMARY KEY") {
+			key = "primary key"
+		} else if m := reDefinitionName.FindStringSubmatch(line); m != nil {
+			if strings.HasPrefix(line, "CONSTRAINT") {
+				key = "foreign key " + m[1]
+			} else {
+				key = "index " + m[1]
+			}
+		} else {
+			continue
+		}
+		if _, already := def.defs[key]; !already {
+			def.order = append(def.order, key)
+		}
+		def.defs[key] = line
+	}
+	return def
+}
+
+var reTableAutoInc = regexp.MustCompile(` AUTO_INCREMENT=\d+`)
+
+// Diff returns descriptions of the changes required to transform def into
+// other, for example "added column `email`" or "modified index `idx_name`".
+// Changes to the next auto-increment value are ignored.
+func (def *tableDefinition) Diff(other *tableDefinition) []string {
+	var changes []string
+	for _, key := range other.order {
+		if oldLine, existed := def.defs[key]; !existed {
+			changes = append(changes, "added "+key)
+		} else if oldLine != other.defs[key] {
+			changes = append(changes, "modified "+key)
+		}
+	}
+	for _, key := range def.order {
+		if _, exists := other.defs[key]; !exists {
+			changes = append(changes, "dropped "+key)
+		}
+	}
+	if def.Options != other.Options {
+		changes = append(changes, "changed table options")
+	}
+	return changes
+}
+
+// writeDirChangelog outputs the changelog entries of dir to w as Markdown.
+func writeDirChangelog(w io.Writer, dir *Dir, entries []*changelogEntry) {
+	name := dir.Config.GetRaw("schema")
+	if name == "" || name[0] == '`' || strings.Contains(name, ",") || name == "*" {
+		name = dir.BaseName()
+	}
+	fmt.Fprintf(w, "## %s\n\n", escapeMarkdown(name))
+	fmt.Fprintf(w, "Directory: `%s`\n\n", dir.Path)
+	if len(entries) == 0 {
+		fmt.Fprintf(w, "*No changes*\n\n")
+		return
+	}
+	for _, entry := range entries {
+		shortHash := entry.Hash
+		if len(shortHash) > 7 {
+			shortHash = shortHash[:7]
+		}
+		fmt.Fprintf(w, "### %s %s: %s (%s)\n\n", entry.Date, shortHash, escapeMarkdown(entry.Subject), escapeMarkdown(entry.Author))
+		for _, change := range entry.Changes {
+			fmt.Fprintf(w, "* %s\n", change)
+		}
+		fmt.Fprintln(w)
+	}
+}
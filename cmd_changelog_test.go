@@ -0,0 +1,50 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTableDefinitionDiff(t *testing.T) {
+	before := parseTableDefinition("CREATE TABLE `users` (\n" +
+		"  `id` int(10) unsigned NOT NULL AUTO_INCREMENT,\n" +
+		"  `name` varchar(30) NOT NULL,\n" +
+		"  `legacy` tinyint(1) DEFAULT NULL,\n" +
+		"  PRIMARY KEY (`id`),\n" +
+		"  KEY `name` (`name`)\n" +
+		") ENGINE=InnoDB AUTO_INCREMENT=12 DEFAULT CHARSET=latin1;\n")
+	if before.Name != "users" || len(before.order) != 5 {
+		t.Fatalf("Unexpected result from parseTableDefinition: %+v", *before)
+	}
+	after := parseTableDefinition("CREATE TABLE `users` (\n" +
+		"  `id` int(10) unsigned NOT NULL AUTO_INCREMENT,\n" +
+		"  `name` varchar(60) NOT NULL,\n" +
+		"  `email` varchar(100) DEFAULT NULL,\n" +
+		"  PRIMARY KEY (`id`),\n" +
+		"  KEY `name` (`name`),\n" +
+		"  UNIQUE KEY `email` (`email`)\n" +
+		") ENGINE=InnoDB AUTO_INCREMENT=45 DEFAULT CHARSET=latin1;\n")
+	expected := "modified column `name`, added column `email`, added index `email`, dropped column `legacy`"
+	if actual := strings.Join(before.Diff(after), ", "); actual != expected {
+		t.Errorf("Unexpected result from Diff: expected %q, found %q", expected, actual)
+	}
+	if changes := after.Diff(after); len(changes) != 0 {
+		t.Errorf("Expected no changes from comparing a table to itself, instead found %v", changes)
+	}
+
+	// Changes to next auto-increment value alone are ignored, but other table
+	// options are not
+	same := parseTableDefinition("CREATE TABLE `users` (\n  `id` int(10) unsigned NOT NULL\n) ENGINE=InnoDB AUTO_INCREMENT=9 DEFAULT CHARSET=latin1")
+	orig := parseTableDefinition("CREATE TABLE `users` (\n  `id` int(10) unsigned NOT NULL\n) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=latin1")
+	if changes := orig.Diff(same); len(changes) != 0 {
+		t.Errorf("Expected auto-increment change to be ignored, instead found %v", changes)
+	}
+	changed := parseTableDefinition("CREATE TABLE `users` (\n  `id` int(10) unsigned NOT NULL\n) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4")
+	if changes := orig.Diff(changed); len(changes) != 1 || changes[0] != "changed table options" {
+		t.Errorf("Expected table options change, instead found %v", changes)
+	}
+
+	if def := parseTableDefinition("-- not a table\n"); def.Name != "" || len(def.order) != 0 {
+		t.Errorf("Expected empty result for non-table file contents, instead found %+v", *def)
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"text/tabwriter"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/skeema/mybase"
+)
+
+func init() {
+	summary := "Validate table files for CI, without connecting to configured hosts"
+	desc := `Performs all validation of the filesystem representation of tables, without
+connecting to any database instance configured by the host option. This is
+intended for use in CI pipelines, which typically cannot (or should not) reach
+production databases.
+
+All *.sql files are run in a temporary schema on the scratch database instance
+specified by --check-host, which should ideally match the flavor declared by
+each dir's flavor option. The following are then reported:
+
+  syntax        table files with invalid SQL
+  flavor        features unsupported by the declared flavor, or a check-host
+                that does not match the declared flavor
+  format        table files which are not in canonical SHOW CREATE TABLE
+                format (run ` + "`" + `skeema lint` + "`" + ` to fix)
+  engine-policy tables using a storage engine with an engine-policy of warn
+  unsupported   tables using features that Skeema cannot diff or alter
+  metadata      invalid metadata sidecar files
+  config        invalid option values, or other problems preventing checks
+
+Unlike ` + "`" + `skeema lint` + "`" + `, no files are modified. With --format=json, each
+result is output as a JSON object on its own line, for consumption by other
+tools.
+
+An exit code of 0 will be returned if no problems were found, 1 if only
+warnings were found, or 2+ if any errors were found.`
+
+	cmd := mybase.NewCommand("check", summary, desc, CheckHandler)
+	cmd.AddOption(mybase.StringOption("check-host", 0, "", "Host (and optional :port) of scratch database instance to use for checks, instead of configured hosts"))
+	cmd.AddOption(mybase.StringOption("format", 0, "text", `Output format of results: "text" or "json"`))
+	cmd.AddOption(mybase.StringOption("ignore-table", 0, "", "Ignore tables that match regex"))
+	cmd.AddOption(mybase.StringOption("metadata-required", 0, "", "Comma-separated list of fields that every table's metadata file must specify"))
+	cmd.AddOption(mybase.StringOption("metadata-tiers", 0, "", "Comma-separated list of permitted values for tier in metadata files"))
+	cmd.AddOption(mybase.BoolOption("metadata-comments", 0, false, "Expect table comments to reflect metadata files"))
+	cmd.AddArg("environment", "production", false)
+	CommandSuite.AddSubCommand(cmd)
+}
+
+// CheckHandler is the handler method for `skeema check`
+func CheckHandler(cfg *mybase.Config) error {
+	AddGlobalConfigFiles(cfg)
+	dir, err := NewDir(".", cfg)
+	if err != nil {
+		return err
+	}
+	format, err := dir.Config.GetEnum("format", "text", "json")
+	if err != nil {
+		return OptionError(dir.Config, "format", NewExitValue(CodeBadConfig, "%s", err.Error()))
+	}
+	if dir.Config.Get("check-host") == "" {
+		return NewExitValue(CodeBadConfig, "Option check-host must be supplied, to specify a scratch database instance to use for checks")
+	}
+
+	state := &checkState{}
+	state.processDir(dir)
+	if err := state.results.Write(os.Stdout, format); err != nil {
+		return err
+	}
+
+	var plural string
+	if state.errCount > 1 || (state.errCount == 0 && state.warnCount > 1) {
+		plural = "s"
+	}
+	if state.errCount > 0 {
+		return NewExitValue(CodeFatalError, "Found %d error%s", state.errCount, plural).WithOutcome(OutcomeLintFailure)
+	} else if state.warnCount > 0 {
+		return NewExitValue(CodeDifferencesFound, "Found %d warning%s", state.warnCount, plural)
+	}
+	return nil
+}
+
+// Severity levels of checkResults
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// checkResult represents a single problem found by `skeema check`.
+type checkResult struct {
+	Dir      string `json:"dir"`
+	File     string `json:"file,omitempty"`
+	Check    string `json:"check"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+// checkResults is a list of checkResult, in the order they were found.
+type checkResults []checkResult
+
+// Write outputs results to w in the specified format, either "text" or "json".
+func (results checkResults) Write(w io.Writer, format string) error {
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		for _, result := range results {
+			result.Message = Redact(result.Message)
+			if err := enc.Encode(result); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if len(results) == 0 {
+		return nil
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SEVERITY\tCHECK\tLOCATION\tMESSAGE")
+	for _, result := range results {
+		location := result.File
+		if location == "" {
+			location = result.Dir
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", result.Severity, result.Check, location, Redact(result.Message))
+	}
+	return tw.Flush()
+}
+
+// checkState tracks results and counters for `skeema check`.
+type checkState struct {
+	results   checkResults
+	errCount  int
+	warnCount int
+}
+
+func (state *checkState) add(dir *Dir, file, check, severity, message string) {
+	state.results = append(state.results, checkResult{
+		Dir:      dir.Path,
+		File:     file,
+		Check:    check,
+		Severity: severity,
+		Message:  message,
+	})
+	if severity == SeverityError {
+		state.errCount++
+	} else {
+		state.warnCount++
+	}
+}
+
+func (state *checkState) processDir(dir *Dir) {
+	if !dir.AllowsEnvironment() {
+		dir.logSkippedEnvironment()
+	} else if dir.HasSchema() {
+		log.Infof("Checking %s", dir)
+		state.checkDir(dir)
+	}
+
+	subdirs, err := dir.Subdirs()
+	if err != nil {
+		state.add(dir, "", "config", SeverityError, fmt.Sprintf("Unable to list subdirs: %s", err))
+		return
+	}
+	for _, sub := range subdirs {
+		// Don't iterate into hidden dirs, for same reasons as generateTargetsForDir
+		if sub.BaseName()[0] != '.' {
+			state.processDir(sub)
+		}
+	}
+}
+
+func (state *checkState) checkDir(dir *Dir) {
+	declared, err := dir.Flavor()
+	if err != nil {
+		state.add(dir, "", "config", SeverityError, err.Error())
+	} else if declared != nil {
+		unsupported, err := declared.UnsupportedFeatures(dir)
+		if err != nil {
+			state.add(dir, "", "config", SeverityError, err.Error())
+		}
+		for _, name := range unsupported {
+			state.add(dir, "", "flavor", SeverityError, fmt.Sprintf("table files use %s, which is not supported by declared flavor %s", name, declared.Raw))
+		}
+	}
+	enginePolicies, err := ParseEnginePolicies(dir.Config.Get("engine-policy"))
+	if err != nil {
+		state.add(dir, "", "config", SeverityError, OptionError(dir.Config, "engine-policy", err).Error())
+		return
+	}
+	ignoreTable := dir.Config.Get("ignore-table")
+	re, err := regexp.Compile(ignoreTable)
+	if err != nil {
+		state.add(dir, "", "config", SeverityError, OptionError(dir.Config, "ignore-table", fmt.Errorf("Invalid regular expression on ignore-table: %s; %s", ignoreTable, err)).Error())
+		return
+	}
+
+	inst, err := dir.instanceOverridingHost("check-host")
+	if err != nil {
+		state.add(dir, "", "config", SeverityError, err.Error())
+		return
+	}
+	if declared != nil {
+		if actual, err := GetServerVersion(inst); err != nil {
+			state.add(dir, "", "config", SeverityError, fmt.Sprintf("Unable to determine server version of check-host %s: %s", inst, err))
+		} else if !actual.Matches(declared) {
+			state.add(dir, "", "flavor", SeverityError, fmt.Sprintf("check-host %s is %s %s, but dir declares flavor %s", inst, actual.Flavor, actual.Raw, declared.Raw))
+		}
+	}
+
+	t := dir.TargetTemplate(inst)
+	if t.Err != nil {
+		state.add(dir, "", "config", SeverityError, t.Err.Error())
+		return
+	}
+	for filePath, sf := range t.SQLFileErrors {
+		state.add(dir, filePath, "syntax", SeverityError, sf.Error.Error())
+	}
+	mc := newMetadataChecker(dir)
+	for _, problem := range mc.CheckDir() {
+		state.add(dir, path.Join(dir.Path, DirMetadataFileName), "metadata", SeverityError, problem.Error())
+	}
+	tables, _ := t.SchemaFromDir.Tables() // can ignore error since table list already guaranteed to be cached
+	for _, table := range tables {
+		if ignoreTable != "" && re.MatchString(table.Name) {
+			continue
+		}
+		var filePath string
+		if modulePath, fromModule := t.ModuleTables[table.Name]; fromModule {
+			filePath = modulePath
+		} else if sf, err := dir.TableSQLFile(table.Name); err != nil {
+			state.add(dir, "", "config", SeverityError, err.Error())
+			continue
+		} else {
+			filePath = sf.Path()
+			createStmt, problems := mc.CheckTable(table)
+			for _, problem := range problems {
+				state.add(dir, dir.TableMetadataPath(table.Name), "metadata", SeverityError, problem.Error())
+			}
+			if len(sf.Includes) == 0 && createStmt != sf.Contents {
+				state.add(dir, filePath, "format", SeverityWarning, "file is not in canonical format; run skeema lint to reformat")
+			}
+		}
+		if policy, engine := enginePolicies.Policy(table.Engine); policy == "ignore" {
+			continue
+		} else if policy == "warn" {
+			state.add(dir, filePath, "engine-policy", SeverityWarning, fmt.Sprintf("table %s uses storage engine %s, which has engine-policy warn", table.Name, engine))
+		}
+		if table.UnsupportedDDL {
+			state.add(dir, filePath, "unsupported", SeverityWarning, fmt.Sprintf("table %s uses features that Skeema cannot diff or alter", table.Name))
+		}
+	}
+}
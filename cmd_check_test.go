@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestCheckResultsWrite(t *testing.T) {
+	results := checkResults{
+		{Dir: "/tmp/mydb", File: "/tmp/mydb/foo.sql", Check: "syntax", Severity: SeverityError, Message: "Error 1064: bad syntax"},
+		{Dir: "/tmp/mydb", Check: "flavor", Severity: SeverityError, Message: "check-host db1 is mysql 5.6.40, but dir declares flavor mysql:5.7"},
+	}
+
+	var buf bytes.Buffer
+	if err := results.Write(&buf, "json"); err != nil {
+		t.Fatalf("Unexpected error from Write: %s", err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != len(results) {
+		t.Fatalf("Expected %d lines of JSON output, instead found %d", len(results), len(lines))
+	}
+	for n, line := range lines {
+		var actual checkResult
+		if err := json.Unmarshal([]byte(line), &actual); err != nil {
+			t.Errorf("Unable to unmarshal line %d: %s", n, err)
+		} else if actual != results[n] {
+			t.Errorf("Expected line %d to unmarshal to %+v, instead found %+v", n, results[n], actual)
+		}
+	}
+	if strings.Contains(lines[1], `"file"`) {
+		t.Errorf("Expected blank file to be omitted from JSON output, instead found %s", lines[1])
+	}
+
+	buf.Reset()
+	if err := results.Write(&buf, "text"); err != nil {
+		t.Fatalf("Unexpected error from Write: %s", err)
+	}
+	lines = strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != len(results)+1 || !strings.HasPrefix(lines[0], "SEVERITY") {
+		t.Fatalf("Unexpected text output: %s", buf.String())
+	}
+	if !strings.Contains(lines[1], "/tmp/mydb/foo.sql") || !strings.Contains(lines[2], "/tmp/mydb ") {
+		t.Errorf("Expected location to be file if present, or dir otherwise; instead found output %s", buf.String())
+	}
+
+	buf.Reset()
+	if err := (checkResults{}).Write(&buf, "text"); err != nil || buf.Len() > 0 {
+		t.Errorf("Expected no output and no error for empty results; instead found output %q, err %v", buf.String(), err)
+	}
+}
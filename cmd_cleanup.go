@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/skeema/mybase"
+	"github.com/skeema/tengo"
+)
+
+func init() {
+	summary := "Remove temporary schemas and dirs left behind by interrupted runs"
+	desc := `Removes resources that were left behind by a previous Skeema run which
+crashed or was killed before it could clean up after itself. This includes the
+temporary schema (see --temp-schema) on every database instance defined by the
+current directory tree, as well as any per-run temporary directories belonging
+to processes that are no longer running.
+
+A temporary schema is only dropped if it contains no rows, and if no other
+Skeema process is currently using it.
+
+You may optionally pass an environment name as a CLI option. This will affect
+which section of .skeema config files is used for determining which instances
+to examine. If no environment name is supplied, the default is "production".`
+
+	cmd := mybase.NewCommand("cleanup", summary, desc, CleanupHandler)
+	cmd.AddArg("environment", "production", false)
+	CommandSuite.AddSubCommand(cmd)
+}
+
+// CleanupHandler is the handler method for `skeema cleanup`
+func CleanupHandler(cfg *mybase.Config) error {
+	AddGlobalConfigFiles(cfg)
+	dir, err := NewDir(".", cfg)
+	if err != nil {
+		return err
+	}
+
+	var errCount int
+	seen := make(map[string]bool)
+	if err := cleanupDir(dir, seen, &errCount); err != nil {
+		return err
+	}
+
+	dirPaths, err := staleRunTempDirs()
+	if err != nil {
+		log.Warnf("Unable to examine temporary dirs: %s", err)
+		errCount++
+	}
+	for _, dirPath := range dirPaths {
+		if err := os.RemoveAll(dirPath); err != nil {
+			log.Errorf("Unable to remove %s: %s", dirPath, err)
+			errCount++
+		} else {
+			log.Infof("Removed temporary dir %s", dirPath)
+		}
+	}
+
+	if errCount == 0 {
+		return nil
+	}
+	var plural string
+	if errCount > 1 {
+		plural = "s"
+	}
+	return NewExitValue(CodePartialError, "Skipped %d operation%s due to error%s", errCount, plural, plural)
+}
+
+// cleanupDir drops leftover temporary schemas for each instance that dir maps
+// to, and then recursively does the same for dir's subdirectories. Each
+// combination of instance and temp-schema is only handled once, as tracked in
+// the seen map.
+func cleanupDir(dir *Dir, seen map[string]bool, errCount *int) error {
+	var instances []*tengo.Instance
+	var err error
+	if dir.AllowsEnvironment() {
+		instances, err = dir.Instances()
+	}
+	if err != nil {
+		log.Errorf("Skipping %s: %s", dir, err)
+		*errCount++
+	}
+
+	// If workspace pooling is in use, additional temp schemas may be present
+	poolSize, _ := dir.Config.GetInt("workspace-pool-size")
+	if poolSize < 1 {
+		poolSize = 1
+	}
+	// If workspace-host is in use, temp schemas are located there instead
+	if len(instances) > 0 && dir.Config.Get("workspace-host") != "" {
+		wsInstance, err := dir.WorkspaceInstance(instances[0])
+		if err != nil {
+			log.Errorf("Skipping workspace-host for %s: %s", dir, err)
+			*errCount++
+			instances = nil
+		} else {
+			instances = []*tengo.Instance{wsInstance}
+		}
+	}
+	for _, inst := range instances {
+		for n := 0; n < poolSize; n++ {
+			cleanupTempSchema(inst, workspaceName(dir.Config.Get("temp-schema"), n), seen, errCount)
+		}
+	}
+
+	subdirs, err := dir.Subdirs()
+	if err != nil {
+		return err
+	}
+	for _, subdir := range subdirs {
+		if subdir.BaseName()[0] != '.' {
+			if err := cleanupDir(subdir, seen, errCount); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// cleanupTempSchema drops the temporary schema tempSchemaName on inst, if it
+// exists and is not in use by another run. Each combination of instance and
+// schema name is only handled once, as tracked in the seen map.
+func cleanupTempSchema(inst *tengo.Instance, tempSchemaName string, seen map[string]bool, errCount *int) {
+	key := fmt.Sprintf("%s %s", inst, tempSchemaName)
+	if seen[key] {
+		return
+	}
+	seen[key] = true
+	if err := CheckConnect(inst); err != nil {
+		log.Errorf("Skipping %s: %s", inst, err)
+		*errCount++
+		return
+	}
+	tempSchema, err := inst.Schema(tempSchemaName)
+	if err != nil {
+		log.Errorf("Unable to check for existence of temp schema on %s: %s", inst, err)
+		*errCount++
+		return
+	} else if tempSchema == nil {
+		log.Debugf("No temporary schema %s present on %s", tempSchemaName, inst)
+		return
+	}
+
+	// Obtain the same lock used by other runs, to avoid dropping a temp schema
+	// that is actively in use
+	tx, err := lockWorkspace(inst, tempSchemaName, 5*time.Second)
+	if err != nil {
+		log.Warnf("Skipping %s: temporary schema %s is in use by another run", inst, tempSchemaName)
+		return
+	}
+	if err := inst.DropSchema(tempSchema, true); err != nil {
+		log.Errorf("Unable to drop temporary schema %s on %s: %s", tempSchemaName, inst, err)
+		*errCount++
+	} else {
+		log.Infof("Dropped temporary schema %s on %s", tempSchemaName, inst)
+	}
+	if err := unlockWorkspace(tx, tempSchemaName); err != nil {
+		log.Warnf("Unable to unlock temporary schema on %s: %s", inst, err)
+	}
+}
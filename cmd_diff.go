@@ -35,7 +35,7 @@ differences were found, or 2+ if an error occurred.`
 func DiffHandler(cfg *mybase.Config) error {
 	// We just delegate to PushHandler, forcing dry-run to be enabled and always
 	// using concurrency of 1
-	cfg.CLI.OptionValues["dry-run"] = "1"
+	setCLIOptionValue(cfg, "dry-run", "1")
 	return PushHandler(cfg)
 }
 
@@ -56,8 +56,18 @@ func clonePushOptionsToDiff() {
 		"safe-below-size": "Always permit generating destructive operations for tables below this size in bytes",
 	}
 	hiddenRewrites := map[string]bool{
-		"brief":   false,
-		"dry-run": true,
+		"at":                   true,
+		"brief":                false,
+		"dry-run":              true,
+		"event-sink":           true,
+		"event-sink-auth":      true,
+		"index-concurrency":    true,
+		"index-pause":          true,
+		"proxysql-admin":       true,
+		"proxysql-user":        true,
+		"proxysql-password":    true,
+		"schema-registry-url":  true,
+		"schema-registry-auth": true,
 	}
 
 	diffOptions := diff.Options()
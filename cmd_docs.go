@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/skeema/mybase"
+	"github.com/skeema/tengo"
+)
+
+func init() {
+	summary := "Output Markdown documentation of schemas and tables"
+	desc := `Outputs Markdown documentation of every schema and table defined by the
+current directory tree to STDOUT. Each table is listed along with its owner,
+tier, description, and any columns storing personally identifiable information,
+as specified by metadata sidecar files. See the manual for the format of these
+files. Tables without a metadata file are still listed, using their table
+comment as a description.
+
+This command relies on accessing database instances to parse the *.sql files.
+All DDL will be run against a temporary schema, with no impact on the real
+schema.
+
+You may optionally pass an environment name as a CLI option. This will affect
+which section of .skeema config files is used for obtaining a database instance
+to parse the *.sql files. If no environment name is supplied, the default is
+"production".`
+
+	cmd := mybase.NewCommand("docs", summary, desc, DocsHandler)
+	cmd.AddOption(mybase.StringOption("ignore-table", 0, "", "Ignore tables that match regex"))
+	cmd.AddArg("environment", "production", false)
+	CommandSuite.AddSubCommand(cmd)
+}
+
+// DocsHandler is the handler method for `skeema docs`
+func DocsHandler(cfg *mybase.Config) error {
+	AddGlobalConfigFiles(cfg)
+	dir, err := NewDir(".", cfg)
+	if err != nil {
+		return err
+	}
+
+	var errCount int
+	var docs schemaDocs
+	seen := make(map[string]bool)
+	for _, t := range dir.Targets() {
+		if t.Err != nil {
+			log.Errorf("Skipping %s:", t.Dir)
+			log.Errorf("    %s\n", t.Err)
+			errCount++
+			continue
+		}
+		if seen[t.Dir.Path] {
+			continue
+		}
+		seen[t.Dir.Path] = true
+		doc, err := newSchemaDoc(t)
+		if err != nil {
+			log.Errorf("Skipping %s: %s", t.Dir, err)
+			errCount++
+			continue
+		}
+		docs = append(docs, doc)
+	}
+	sort.Sort(docs)
+	for _, doc := range docs {
+		doc.Write(os.Stdout)
+	}
+
+	if errCount > 0 {
+		var plural string
+		if errCount > 1 {
+			plural = "s"
+		}
+		return NewExitValue(CodePartialError, "Skipped %d operation%s due to error%s", errCount, plural, plural)
+	}
+	return nil
+}
+
+// schemaDoc describes a single dir and its tables.
+type schemaDoc struct {
+	dir    *Dir
+	name   string
+	meta   *Metadata
+	tables []tableDoc
+}
+
+// tableDoc describes a single table.
+type tableDoc struct {
+	*tengo.Table
+	meta *Metadata
+}
+
+// schemaDocs is a sortable list of schemaDoc, ordered by dir path.
+type schemaDocs []*schemaDoc
+
+func (docs schemaDocs) Len() int           { return len(docs) }
+func (docs schemaDocs) Swap(i, j int)      { docs[i], docs[j] = docs[j], docs[i] }
+func (docs schemaDocs) Less(i, j int) bool { return docs[i].dir.Path < docs[j].dir.Path }
+
+// newSchemaDoc returns a schemaDoc for the dir of t, including all of its tables
+// aside from those matching ignore-table.
+func newSchemaDoc(t *Target) (*schemaDoc, error) {
+	doc := &schemaDoc{dir: t.Dir, name: t.SchemaFromDir.Name}
+	var err error
+	if doc.meta, err = t.Dir.DirMetadata(); err != nil {
+		return nil, err
+	}
+	ignoreTable := t.Dir.Config.Get("ignore-table")
+	re, err := regexp.Compile(ignoreTable)
+	if err != nil {
+		return nil, OptionError(t.Dir.Config, "ignore-table", fmt.Errorf("Invalid regular expression on ignore-table: %s; %s", ignoreTable, err))
+	}
+	tables, _ := t.SchemaFromDir.Tables() // can ignore error since table list already guaranteed to be cached
+	for _, table := range tables {
+		if ignoreTable != "" && re.MatchString(table.Name) {
+			continue
+		}
+		meta, err := t.Dir.TableMetadata(table.Name)
+		if err != nil {
+			return nil, err
+		}
+		doc.tables = append(doc.tables, tableDoc{Table: table, meta: meta})
+	}
+	return doc, nil
+}
+
+// Write outputs doc to w as Markdown.
+func (doc *schemaDoc) Write(w io.Writer) {
+	fmt.Fprintf(w, "## %s\n\n", escapeMarkdown(doc.name))
+	fmt.Fprintf(w, "Directory: `%s`\n\n", doc.dir.Path)
+	if doc.meta != nil {
+		if doc.meta.Description != "" {
+			fmt.Fprintf(w, "%s\n\n", escapeMarkdown(doc.meta.Description))
+		}
+		if doc.meta.Owner != "" {
+			fmt.Fprintf(w, "* Owner: %s\n", escapeMarkdown(doc.meta.Owner))
+		}
+		if doc.meta.Tier != "" {
+			fmt.Fprintf(w, "* Tier: %s\n", escapeMarkdown(doc.meta.Tier))
+		}
+		if doc.meta.Owner != "" || doc.meta.Tier != "" {
+			fmt.Fprintln(w)
+		}
+	}
+	if len(doc.tables) == 0 {
+		fmt.Fprintf(w, "*No tables*\n\n")
+		return
+	}
+	fmt.Fprintln(w, "Table | Owner | Tier | PII | Description")
+	fmt.Fprintln(w, "--- | --- | --- | --- | ---")
+	for _, td := range doc.tables {
+		owner, tier, pii, description := "", "", "", td.Comment
+		if td.meta != nil {
+			owner, tier = td.meta.Owner, td.meta.Tier
+			if td.meta.Description != "" {
+				description = td.meta.Description
+			}
+			if len(td.meta.PIIColumns) > 0 {
+				pii = strings.Join(td.meta.PIIColumns, ", ")
+			} else if td.meta.PII {
+				pii = "yes"
+			}
+		}
+		fmt.Fprintf(w, "%s | %s | %s | %s | %s\n", escapeMarkdown(td.Name), escapeMarkdown(owner), escapeMarkdown(tier), escapeMarkdown(pii), escapeMarkdown(description))
+	}
+	fmt.Fprintln(w)
+}
+
+// escapeMarkdown escapes characters which would otherwise be interpreted as
+// Markdown formatting or table cell delimiters, and collapses newlines.
+func escapeMarkdown(s string) string {
+	replacer := strings.NewReplacer(
+		`\`, `\\`, `|`, `\|`, `*`, `\*`, `_`, `\_`, "`", "\\`", `#`, `\#`,
+		"\r\n", " ", "\n", " ",
+	)
+	return replacer.Replace(s)
+}
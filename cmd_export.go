@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/skeema/mybase"
+	"github.com/skeema/tengo"
+)
+
+func init() {
+	summary := "Export an anonymized copy of schemas for sharing"
+	desc := `Writes a copy of the schemas and tables of the current directory tree to a
+separate directory, with all identifiers replaced by stable anonymized names
+such as schema1, table1, col1, and idx1. Comments, enum and set values, and
+textual default values are anonymized as well. The structure of each table --
+column types, indexes, character sets, and table options -- is preserved, so
+that the exported files can be shared with vendors or community members to
+reproduce diff or verify problems without revealing business-specific names.
+
+By default, table definitions are obtained from the database instances of the
+selected environment. Use --from=dir to instead export the definitions in the
+*.sql files, as normalized by a temporary schema. Exporting both sides, each to
+a different export-dir, allows a diff to be reproduced.
+
+The mapping between original and anonymized names is kept in the file
+specified by --anonymize-map, which is reused by subsequent exports so that
+names remain stable. This file reveals all original names, so do not share it.
+
+You may optionally pass an environment name as a CLI option. This will affect
+which section of .skeema config files is used for processing. If no
+environment name is supplied, the default is "production".`
+
+	cmd := mybase.NewCommand("export", summary, desc, ExportHandler)
+	cmd.AddOption(mybase.StringOption("export-dir", 0, "", "Path of new directory to write anonymized files to"))
+	cmd.AddOption(mybase.StringOption("anonymize-map", 0, "anonymize-map.json", "Path of file mapping original names to anonymized names"))
+	cmd.AddOption(mybase.StringOption("from", 0, "instance", `Source of table definitions: "instance" or "dir"`))
+	cmd.AddOption(mybase.StringOption("ignore-table", 0, "", "Ignore tables that match regex"))
+	cmd.AddArg("environment", "production", false)
+	CommandSuite.AddSubCommand(cmd)
+}
+
+// ExportHandler is the handler method for `skeema export`
+func ExportHandler(cfg *mybase.Config) error {
+	AddGlobalConfigFiles(cfg)
+	dir, err := NewDir(".", cfg)
+	if err != nil {
+		return err
+	}
+	from, err := dir.Config.GetEnum("from", "instance", "dir")
+	if err != nil {
+		return OptionError(dir.Config, "from", NewExitValue(CodeBadConfig, "%s", err.Error()))
+	}
+	exportPath := dir.Config.Get("export-dir")
+	if exportPath == "" {
+		return NewExitValue(CodeBadConfig, "Option export-dir must be supplied, to specify where to write anonymized files")
+	}
+	exportPath = expandUserPath(exportPath, dir.Path)
+	mapPath := dir.Config.Get("anonymize-map")
+	if mapPath == "" {
+		return NewExitValue(CodeBadConfig, "Option anonymize-map must be supplied, to specify where to store the mapping of anonymized names")
+	}
+	mapPath = expandUserPath(mapPath, dir.Path)
+	if rel, err := filepath.Rel(exportPath, mapPath); err == nil && !strings.HasPrefix(rel, "..") {
+		return OptionError(dir.Config, "anonymize-map", NewExitValue(CodeBadConfig, "anonymize-map file %s must not be inside export-dir %s", mapPath, exportPath))
+	}
+	if entries, err := ioutil.ReadDir(exportPath); err == nil && len(entries) > 0 {
+		return NewExitValue(CodeCantCreate, "export-dir %s already exists and is not empty", exportPath)
+	}
+
+	am, err := ReadAnonymizeMap(mapPath)
+	if err != nil {
+		return OptionError(dir.Config, "anonymize-map", err)
+	}
+	exportDir := &Dir{
+		Path:           exportPath,
+		Config:         dir.Config.Clone(),
+		section:        dir.section,
+		optionFileName: dir.optionFileName,
+	}
+	if _, err := exportDir.CreateIfMissing(); err != nil {
+		return NewExitValue(CodeCantCreate, "Unable to create export-dir %s: %s", exportPath, err)
+	}
+
+	var errCount int
+	exported := make(map[string]bool)
+	for _, t := range dir.Targets() {
+		if t.Err != nil {
+			log.Errorf("Skipping %s:", t.Dir)
+			log.Errorf("    %s\n", t.Err)
+			errCount++
+			continue
+		}
+		schema := t.SchemaFromInstance
+		if from == "dir" {
+			schema = t.SchemaFromDir
+		}
+		if schema == nil {
+			log.Warnf("Skipping %s: schema %s does not exist on %s", t.Dir, t.SchemaFromDir.Name, t.Instance)
+			continue
+		} else if exported[schema.Name] {
+			log.Debugf("Skipping %s %s: schema already exported", t.Instance, schema.Name)
+			continue
+		}
+		exported[schema.Name] = true
+		count, err := exportSchema(schema, t.Dir, exportDir, am)
+		errCount += count
+		if err != nil {
+			// Persist any names assigned so far, so that files already written remain
+			// consistent with the map
+			if writeErr := am.Write(mapPath); writeErr != nil {
+				log.Errorf("Unable to write anonymize-map file %s: %s", mapPath, writeErr)
+			}
+			return err
+		}
+	}
+
+	if err := am.Write(mapPath); err != nil {
+		return NewExitValue(CodeCantCreate, "Unable to write anonymize-map file %s: %s", mapPath, err)
+	}
+	log.Infof("Wrote name mapping to %s -- do not share this file", mapPath)
+
+	if errCount > 0 {
+		var plural string
+		if errCount > 1 {
+			plural = "s"
+		}
+		return NewExitValue(CodePartialError, "Skipped %d operation%s due to error%s", errCount, plural, plural)
+	}
+	return nil
+}
+
+// exportSchema writes anonymized *.sql files for all tables in schema to a new
+// subdir of exportDir, along with an option file for the subdir. Tables are
+// filtered using the ignore-table and engine-policy options of sourceDir. The
+// number of tables skipped due to errors is returned, along with any fatal
+// error.
+func exportSchema(schema *tengo.Schema, sourceDir, exportDir *Dir, am *AnonymizeMap) (int, error) {
+	ignoreTable := sourceDir.Config.Get("ignore-table")
+	re, err := regexp.Compile(ignoreTable)
+	if err != nil {
+		return 0, OptionError(sourceDir.Config, "ignore-table", fmt.Errorf("Invalid regular expression on ignore-table: %s; %s", ignoreTable, err))
+	}
+	enginePolicies, err := ParseEnginePolicies(sourceDir.Config.Get("engine-policy"))
+	if err != nil {
+		return 0, OptionError(sourceDir.Config, "engine-policy", err)
+	}
+	tables, err := schema.Tables()
+	if err != nil {
+		return 0, fmt.Errorf("Cannot obtain table information for %s: %s", schema.Name, err)
+	}
+
+	anonName := am.SchemaName(schema.Name)
+	optionFile := mybase.NewFile(exportDir.OptionFileName())
+	optionFile.SetOptionValue("", "schema", anonName)
+	if schema.CharSet != "" {
+		optionFile.SetOptionValue("", "default-character-set", schema.CharSet)
+	}
+	if schema.Collation != "" {
+		optionFile.SetOptionValue("", "default-collation", schema.Collation)
+	}
+	schemaDir, err := exportDir.CreateSubdir(anonName, optionFile)
+	if err != nil {
+		return 0, NewExitValue(CodeCantCreate, "Unable to use directory %s for schema %s: %s", path.Join(exportDir.Path, anonName), anonName, err)
+	}
+
+	var errCount int
+	for _, table := range tables {
+		if ignoreTable != "" && re.MatchString(table.Name) {
+			continue
+		}
+		if policy, _ := enginePolicies.Policy(table.Engine); policy == "ignore" {
+			continue
+		}
+		anonTable, err := am.Table(table)
+		if err != nil {
+			log.Errorf("Skipping %s: %s", sourceDir, err)
+			errCount++
+			continue
+		}
+		filePath, length, err := schemaDir.WriteTable(anonTable.Name, anonTable.CreateStatement())
+		if err != nil {
+			return errCount, NewExitValue(CodeCantCreate, "Unable to write to %s: %s", filePath, err)
+		}
+		log.Infof("Wrote %s (%d bytes)", filePath, length)
+	}
+	return errCount, nil
+}
+
+// expandUserPath expands a leading ~/ in filePath to the user's home
+// directory, and interprets relative paths as relative to baseDir.
+func expandUserPath(filePath, baseDir string) string {
+	if strings.HasPrefix(filePath, "~/") {
+		return path.Join(os.Getenv("HOME"), filePath[2:])
+	} else if !filepath.IsAbs(filePath) {
+		return path.Join(baseDir, filePath)
+	}
+	return filePath
+}
@@ -73,7 +73,7 @@ func InitHandler(cfg *mybase.Config) error {
 		return NewExitValue(CodeCantCreate, "Unable to use specified dir: %s", err)
 	}
 	if hostDir.HasOptionFile() {
-		return NewExitValue(CodeBadConfig, "Cannot use dir %s: already has .skeema file", hostDir.Path)
+		return NewExitValue(CodeBadConfig, "Cannot use dir %s: already has %s file", hostDir.Path, hostDir.OptionFileName())
 	}
 	if hostDir.Config.Changed("schema") && !hostDir.Config.OnCLI("schema") {
 		return NewExitValue(CodeBadConfig, "Cannot use dir %s: a parent dir already defines a schema", hostDir.Path)
@@ -114,7 +114,7 @@ func InitHandler(cfg *mybase.Config) error {
 	}
 
 	// Figure out what needs to go in the hostDir's .skeema file.
-	hostOptionFile := mybase.NewFile(hostDir.Path, ".skeema")
+	hostOptionFile := mybase.NewFile(hostDir.Path, hostDir.OptionFileName())
 	hostOptionFile.SetOptionValue(environment, "host", inst.Host)
 	if inst.Host == "localhost" && inst.SocketPath != "" {
 		hostOptionFile.SetOptionValue(environment, "socket", inst.SocketPath)
@@ -130,10 +130,24 @@ func InitHandler(cfg *mybase.Config) error {
 	if cfg.OnCLI("ignore-table") {
 		hostOptionFile.SetOptionValue(environment, "ignore-table", cfg.Get("ignore-table"))
 	}
+	for _, name := range []string{"schema-prefix", "schema-suffix"} {
+		if cfg.OnCLI(name) {
+			hostOptionFile.SetOptionValue(environment, name, cfg.Get(name))
+		}
+	}
+	if cfg.OnCLI("layout") {
+		// layout is placed outside of any named section/environment since the
+		// same files are used for all environments
+		hostOptionFile.SetOptionValue("", "layout", cfg.Get("layout"))
+	}
 	if !separateSchemaSubdir {
 		// schema name is placed outside of any named section/environment since the
 		// default assumption is that schema names match between environments
-		hostOptionFile.SetOptionValue("", "schema", onlySchema)
+		baseName, ok := hostDir.UntransformSchemaName(onlySchema)
+		if !ok {
+			return NewExitValue(CodeBadConfig, "Schema %s does not match schema-prefix and schema-suffix", onlySchema)
+		}
+		hostOptionFile.SetOptionValue("", "schema", OptionFileValue(baseName))
 		if overridesCharSet, overridesCollation, err := schemas[0].OverridesServerCharSet(); err == nil {
 			if overridesCharSet {
 				hostOptionFile.SetOptionValue("", "default-character-set", schemas[0].CharSet)
@@ -146,7 +160,7 @@ func InitHandler(cfg *mybase.Config) error {
 
 	// Write the option file
 	if err := hostDir.CreateOptionFile(hostOptionFile); err != nil {
-		return NewExitValue(CodeCantCreate, err.Error())
+		return NewExitValue(CodeCantCreate, "%s", err.Error())
 	}
 
 	verb := "Using"
@@ -183,7 +197,7 @@ func PopulateSchemaDir(s *tengo.Schema, parentDir *Dir, makeSubdir bool) error {
 	ignoreSchema := parentDir.Config.Get("ignore-schema")
 	schemaRE, sErr := regexp.Compile(ignoreSchema)
 	if sErr != nil {
-		return fmt.Errorf("Invalid regular expression on ignore-schema: %s; %s", ignoreSchema, sErr)
+		return OptionError(parentDir.Config, "ignore-schema", fmt.Errorf("Invalid regular expression on ignore-schema: %s; %s", ignoreSchema, sErr))
 	}
 	if ignoreSchema != "" && schemaRE.MatchString(s.Name) {
 		log.Warnf("Skipping schema %s because of ignore-schema='%s'", s.Name, ignoreSchema)
@@ -193,11 +207,19 @@ func PopulateSchemaDir(s *tengo.Schema, parentDir *Dir, makeSubdir bool) error {
 	var schemaDir *Dir
 	var err error
 	if makeSubdir {
+		// Subdir and option file use the schema name without any environment-
+		// specific schema-prefix or schema-suffix
+		baseName, ok := parentDir.UntransformSchemaName(s.Name)
+		if !ok {
+			log.Warnf("Skipping schema %s because it does not match schema-prefix and schema-suffix", s.Name)
+			return nil
+		}
+
 		// Put a .skeema file with the schema name in it. This is placed outside of
 		// any named section/environment since the default assumption is that schema
 		// names match between environments.
-		optionFile := mybase.NewFile(".skeema")
-		optionFile.SetOptionValue("", "schema", s.Name)
+		optionFile := mybase.NewFile(parentDir.OptionFileName())
+		optionFile.SetOptionValue("", "schema", OptionFileValue(baseName))
 		if overridesCharSet, overridesCollation, err := s.OverridesServerCharSet(); err == nil {
 			if overridesCharSet {
 				optionFile.SetOptionValue("", "default-character-set", s.CharSet)
@@ -206,8 +228,9 @@ func PopulateSchemaDir(s *tengo.Schema, parentDir *Dir, makeSubdir bool) error {
 				optionFile.SetOptionValue("", "default-collation", s.Collation)
 			}
 		}
-		if schemaDir, err = parentDir.CreateSubdir(s.Name, optionFile); err != nil {
-			return NewExitValue(CodeCantCreate, "Unable to use directory %s for schema %s: %s", path.Join(parentDir.Path, s.Name), s.Name, err)
+		subdirName := parentDir.SubdirName(baseName)
+		if schemaDir, err = parentDir.CreateSubdir(subdirName, optionFile); err != nil {
+			return NewExitValue(CodeCantCreate, "Unable to use directory %s for schema %s: %s", path.Join(parentDir.Path, subdirName), s.Name, err)
 		}
 	} else {
 		schemaDir = parentDir
@@ -226,13 +249,23 @@ func PopulateSchemaDir(s *tengo.Schema, parentDir *Dir, makeSubdir bool) error {
 	ignoreTable := parentDir.Config.Get("ignore-table")
 	re, err := regexp.Compile(ignoreTable)
 	if err != nil {
-		return fmt.Errorf("Invalid regular expression on ignore-table: %s; %s", ignoreTable, err)
+		return OptionError(parentDir.Config, "ignore-table", fmt.Errorf("Invalid regular expression on ignore-table: %s; %s", ignoreTable, err))
+	}
+	enginePolicies, err := ParseEnginePolicies(parentDir.Config.Get("engine-policy"))
+	if err != nil {
+		return OptionError(parentDir.Config, "engine-policy", err)
 	}
 	for _, t := range tables {
 		if ignoreTable != "" && re.MatchString(t.Name) {
 			log.Warnf("Skipping table %s because ignore-table matched %s", t.Name, ignoreTable)
 			continue
 		}
+		if policy, _ := enginePolicies.Policy(t.Engine); policy == "ignore" {
+			log.Warnf("Skipping table %s because engine-policy ignores engine %s", t.Name, t.Engine)
+			continue
+		} else if policy == "warn" {
+			log.Warnf("Table %s uses storage engine %s", t.Name, t.Engine)
+		}
 		createStmt := t.CreateStatement()
 
 		// Special handling for auto-increment tables: strip next-auto-inc value,
@@ -241,16 +274,11 @@ func PopulateSchemaDir(s *tengo.Schema, parentDir *Dir, makeSubdir bool) error {
 			createStmt, _ = tengo.ParseCreateAutoInc(createStmt)
 		}
 
-		sf := SQLFile{
-			Dir:      schemaDir,
-			FileName: fmt.Sprintf("%s.sql", t.Name),
-			Contents: createStmt,
-		}
-		var length int
-		if length, err = sf.Write(); err != nil {
-			return NewExitValue(CodeCantCreate, "Unable to write to %s: %s", sf.Path(), err)
+		filePath, length, err := schemaDir.WriteTable(t.Name, createStmt)
+		if err != nil {
+			return NewExitValue(CodeCantCreate, "Unable to write to %s: %s", filePath, err)
 		}
-		log.Infof("Wrote %s (%d bytes)", sf.Path(), length)
+		log.Infof("Wrote %s (%d bytes)", filePath, length)
 	}
 	os.Stderr.WriteString("\n")
 	return nil
@@ -27,9 +27,17 @@ supplied, the default is "production".
 
 An exit code of 0 will be returned if all files were already formatted properly,
 1 if some files were reformatted but all SQL was valid, or 2+ if at least one
-file had SQL syntax errors or some other error occurred.`
+file had SQL syntax errors, invalid metadata files, or some other error occurred.
+
+Each dir and table may optionally have a metadata sidecar file, describing its
+owner, description, tier, and PII columns; see the manual for details. These
+files are validated, and with --metadata-comments, table comments are rewritten
+to reflect them.`
 
 	cmd := mybase.NewCommand("lint", summary, desc, LintHandler)
+	cmd.AddOption(mybase.StringOption("metadata-required", 0, "", "Comma-separated list of fields that every table's metadata file must specify"))
+	cmd.AddOption(mybase.StringOption("metadata-tiers", 0, "", "Comma-separated list of permitted values for tier in metadata files"))
+	cmd.AddOption(mybase.BoolOption("metadata-comments", 0, false, "Reformat table comments to reflect metadata files"))
 	cmd.AddArg("environment", "production", false)
 	CommandSuite.AddSubCommand(cmd)
 }
@@ -42,7 +50,7 @@ func LintHandler(cfg *mybase.Config) error {
 		return err
 	}
 
-	var errCount, sqlErrCount, reformatCount int
+	var errCount, sqlErrCount, flavorErrCount, metadataErrCount, reformatCount int
 	for _, t := range dir.Targets() {
 		if t.Err != nil {
 			log.Errorf("Skipping %s:", t.Dir)
@@ -54,7 +62,7 @@ func LintHandler(cfg *mybase.Config) error {
 		ignoreSchema := t.Dir.Config.Get("ignore-schema")
 		re, sErr := regexp.Compile(ignoreSchema)
 		if sErr != nil {
-			return fmt.Errorf("Invalid regular expression on ignore-schema: %s; %s", ignoreSchema, sErr)
+			return OptionError(t.Dir.Config, "ignore-schema", fmt.Errorf("Invalid regular expression on ignore-schema: %s; %s", ignoreSchema, sErr))
 		}
 		dir := fmt.Sprintf("%s", t.Dir)
 		if ignoreSchema != "" && re.MatchString(dir) {
@@ -69,10 +77,29 @@ func LintHandler(cfg *mybase.Config) error {
 			sqlErrCount++
 		}
 
+		if declared, err := t.Dir.Flavor(); err != nil {
+			log.Error(err)
+			errCount++
+		} else if declared != nil {
+			unsupported, err := declared.UnsupportedFeatures(t.Dir)
+			if err != nil {
+				return err
+			}
+			for _, name := range unsupported {
+				log.Errorf("%s: table files use %s, which is not supported by declared flavor %s", t.Dir, name, declared.Raw)
+				flavorErrCount++
+			}
+		}
+
 		ignoreTable := t.Dir.Config.Get("ignore-table")
 		re, err := regexp.Compile(ignoreTable)
 		if err != nil {
-			return fmt.Errorf("Invalid regular expression on ignore-table: %s; %s", ignoreTable, err)
+			return OptionError(t.Dir.Config, "ignore-table", fmt.Errorf("Invalid regular expression on ignore-table: %s; %s", ignoreTable, err))
+		}
+		mc := newMetadataChecker(t.Dir)
+		for _, problem := range mc.CheckDir() {
+			log.Error(problem)
+			metadataErrCount++
 		}
 		tables, _ := t.SchemaFromDir.Tables() // can ignore error since table list already guaranteed to be cached
 		for _, table := range tables {
@@ -80,23 +107,31 @@ func LintHandler(cfg *mybase.Config) error {
 				log.Warnf("Skipping table %s because ignore-table matched %s", table.Name, ignoreTable)
 				continue
 			}
-			sf := SQLFile{
-				Dir:      t.Dir,
-				FileName: fmt.Sprintf("%s.sql", table.Name),
+			if _, fromModule := t.ModuleTables[table.Name]; fromModule {
+				continue
+			}
+			createStmt, problems := mc.CheckTable(table)
+			for _, problem := range problems {
+				log.Error(problem)
+				metadataErrCount++
 			}
-			if _, err := sf.Read(); err != nil {
+			sf, err := t.Dir.TableSQLFile(table.Name)
+			if err != nil {
 				return err
 			}
 			for _, warning := range sf.Warnings {
 				log.Debug(warning)
 			}
-			if table.CreateStatement() != sf.Contents {
-				sf.Contents = table.CreateStatement()
-				var length int
-				if length, err = sf.Write(); err != nil {
-					return fmt.Errorf("Unable to write to %s: %s", sf.Path(), err)
+			if len(sf.Includes) > 0 {
+				log.Debugf("%s: not normalizing format since file uses include directives", sf.Path())
+				continue
+			}
+			if createStmt != sf.Contents {
+				filePath, length, err := t.Dir.WriteTable(table.Name, createStmt)
+				if err != nil {
+					return fmt.Errorf("Unable to write to %s: %s", filePath, err)
 				}
-				log.Infof("Wrote %s (%d bytes) -- updated file to normalize format", sf.Path(), length)
+				log.Infof("Wrote %s (%d bytes) -- updated file to normalize format", filePath, length)
 				reformatCount++
 			}
 		}
@@ -104,14 +139,18 @@ func LintHandler(cfg *mybase.Config) error {
 	}
 
 	var plural string
-	if errCount > 1 || (errCount == 0 && sqlErrCount > 1) {
+	if errCount > 1 || (errCount == 0 && sqlErrCount > 1) || (errCount == 0 && sqlErrCount == 0 && flavorErrCount > 1) || (errCount == 0 && sqlErrCount == 0 && flavorErrCount == 0 && metadataErrCount > 1) {
 		plural = "s"
 	}
 	switch {
 	case errCount > 0:
 		return NewExitValue(CodeFatalError, "Skipped %d operation%s due to error%s", errCount, plural, plural)
 	case sqlErrCount > 0:
-		return NewExitValue(CodeFatalError, "Found syntax error%s in %d SQL file%s", plural, sqlErrCount, plural)
+		return NewExitValue(CodeFatalError, "Found syntax error%s in %d SQL file%s", plural, sqlErrCount, plural).WithOutcome(OutcomeLintFailure)
+	case flavorErrCount > 0:
+		return NewExitValue(CodeFatalError, "Found %d use%s of features unsupported by declared flavor", flavorErrCount, plural).WithOutcome(OutcomeLintFailure)
+	case metadataErrCount > 0:
+		return NewExitValue(CodeFatalError, "Found %d problem%s with metadata files", metadataErrCount, plural).WithOutcome(OutcomeLintFailure)
 	case reformatCount > 0:
 		return NewExitValue(CodeDifferencesFound, "")
 	default:
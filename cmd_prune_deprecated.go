@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/skeema/mybase"
+	"github.com/skeema/tengo"
+)
+
+func init() {
+	summary := "Drop columns previously deprecated by push, after a retention period"
+	desc := `Drops columns which were renamed instead of dropped by a previous
+` + "`" + `skeema push --deprecate-dropped-columns` + "`" + `, once they have been deprecated for longer
+than the retention period (see --retention). This permits the second phase of a
+two-phase column removal to be run long after the first, once it is certain
+that no application code still depends on the column's data.
+
+Only columns whose names begin with --deprecated-column-prefix, and whose
+comments contain a deprecation date recorded by push, are dropped. Columns
+which are defined in the filesystem are never dropped, regardless of name.
+
+You may optionally pass an environment name as a CLI option. This will affect
+which section of .skeema config files is used for processing. If no
+environment name is supplied, the default is "production".`
+
+	cmd := mybase.NewCommand("prune-deprecated", summary, desc, PruneDeprecatedHandler)
+	cmd.AddOption(mybase.StringOption("retention", 0, "30d", "Only drop columns deprecated at least this long ago (e.g. 30d or 36h)"))
+	cmd.AddOption(mybase.BoolOption("dry-run", 0, false, "Output DDL but don't run it"))
+	cmd.AddArg("environment", "production", false)
+	CommandSuite.AddSubCommand(cmd)
+	clonePushOptionsToPruneDeprecated()
+}
+
+// PruneDeprecatedHandler is the handler method for `skeema prune-deprecated`
+func PruneDeprecatedHandler(cfg *mybase.Config) error {
+	AddGlobalConfigFiles(cfg)
+	dir, err := NewDir(".", cfg)
+	if err != nil {
+		return err
+	}
+	retention, err := ParseRetention(dir.Config.Get("retention"))
+	if err != nil {
+		return OptionError(dir.Config, "retention", NewExitValue(CodeBadConfig, "%s", err.Error()))
+	}
+	prefix := dir.Config.Get("deprecated-column-prefix")
+	if prefix == "" {
+		return NewExitValue(CodeBadConfig, "Option deprecated-column-prefix cannot be blank")
+	}
+	cutoff := time.Now().UTC().Add(-retention)
+	dryRun := dir.Config.GetBool("dry-run")
+
+	var errCount, dropCount int
+	for tg := range dir.TargetGroups(dir.Config.GetBool("first-only"), true) {
+		for _, t := range tg {
+			if t.Err != nil {
+				log.Errorf("Skipping %s: %s", t.Dir, t.Err)
+				errCount++
+				continue
+			}
+			if t.SchemaFromInstance == nil {
+				continue
+			}
+			n, errs := pruneDeprecatedColumns(t, prefix, cutoff, dryRun)
+			dropCount += n
+			errCount += errs
+		}
+	}
+
+	if dropCount == 0 && errCount == 0 {
+		log.Info("No deprecated columns are past their retention period")
+	}
+	if errCount > 0 {
+		var plural string
+		if errCount > 1 {
+			plural = "s"
+		}
+		return NewExitValue(CodeFatalError, "Skipped %d operation%s due to error%s", errCount, plural, plural)
+	}
+	return nil
+}
+
+// pruneDeprecatedColumns generates and, unless dryRun is true, runs an ALTER
+// TABLE for each table of t's schema with columns deprecated before cutoff. It
+// returns the number of columns dropped, and the number of tables which could
+// not be altered.
+func pruneDeprecatedColumns(t *Target, prefix string, cutoff time.Time, dryRun bool) (dropCount, errCount int) {
+	schemaName := t.SchemaFromDir.Name
+	tables, err := t.SchemaFromInstance.Tables()
+	if err != nil {
+		log.Errorf("Unable to obtain tables of %s %s: %s", t.Instance, schemaName, err)
+		return 0, 1
+	}
+	dirTables, err := t.SchemaFromDir.TablesByName()
+	if err != nil {
+		log.Errorf("Unable to obtain tables of %s: %s", t.Dir, err)
+		return 0, 1
+	}
+	mods := tengo.StatementModifiers{
+		NextAutoInc: tengo.NextAutoIncIgnore,
+		AllowUnsafe: true,
+	}
+	if mods.AlgorithmClause, err = t.Dir.Config.GetEnum("alter-algorithm", "INPLACE", "COPY", "DEFAULT"); err != nil {
+		log.Error(OptionError(t.Dir.Config, "alter-algorithm", err))
+		return 0, 1
+	}
+	if mods.LockClause, err = t.Dir.Config.GetEnum("alter-lock", "NONE", "SHARED", "EXCLUSIVE", "DEFAULT"); err != nil {
+		log.Error(OptionError(t.Dir.Config, "alter-lock", err))
+		return 0, 1
+	}
+	var printedHeader bool
+
+	for _, table := range tables {
+		var dirColumns map[string]*tengo.Column
+		if dirTable, ok := dirTables[table.Name]; ok {
+			dirColumns = dirTable.ColumnsByName()
+		}
+		var clauses []tengo.TableAlterClause
+		for _, col := range table.Columns {
+			date, ok := DeprecatedColumnDate(col, prefix)
+			if !ok || dirColumns[col.Name] != nil {
+				continue
+			}
+			if date.After(cutoff) {
+				log.Debugf("Retaining %s %s column %s.%s, deprecated on %s", t.Instance, schemaName, table.Name, col.Name, date.Format("2006-01-02"))
+				continue
+			}
+			clauses = append(clauses, tengo.DropColumn{Table: table, Column: col})
+		}
+		if len(clauses) == 0 {
+			continue
+		}
+
+		ddl := NewDDLStatement(tengo.AlterTable{Table: table, Clauses: clauses}, mods, t)
+		if ddl == nil {
+			continue
+		}
+		if !printedHeader {
+			fmt.Printf("-- instance: %s\nUSE %s;\n", t.Instance, tengo.EscapeIdentifier(schemaName))
+			printedHeader = true
+		}
+		fmt.Println(ddl.String())
+		if ddl.Err != nil {
+			log.Errorf("%s. The affected DDL statement will be skipped.", ddl.Err)
+			errCount++
+			continue
+		}
+		if !dryRun {
+			if err := ddl.Execute(); err != nil {
+				log.Errorf("Error running DDL on %s %s: %s", t.Instance, schemaName, err)
+				errCount++
+				continue
+			}
+		} else if note := ddl.dryRunNote(); note != "" {
+			fmt.Println(note)
+		}
+		dropCount += len(clauses)
+	}
+	return dropCount, errCount
+}
+
+// clonePushOptionsToPruneDeprecated copies options from `skeema push` into
+// `skeema prune-deprecated`, so that DDL is run in the same manner as push,
+// including use of alter-wrapper. Options irrelevant to dropping columns are
+// hidden.
+func clonePushOptionsToPruneDeprecated() {
+	// Logic relies on init() having been called in both files, so we call it
+	// from both places, but only one will succeed
+	prune, ok1 := CommandSuite.SubCommands["prune-deprecated"]
+	push, ok2 := CommandSuite.SubCommands["push"]
+	if !ok1 || !ok2 {
+		return
+	}
+
+	visible := map[string]bool{
+		"alter-algorithm":          true,
+		"alter-lock":               true,
+		"alter-wrapper":            true,
+		"alter-wrapper-min-size":   true,
+		"ddl-wrapper":              true,
+		"deprecated-column-prefix": true,
+		"first-only":               true,
+		"post-statement-sql":       true,
+		"pre-statement-sql":        true,
+		"retry-backoff":            true,
+		"retry-count":              true,
+		"wrapper-max-length":       true,
+	}
+
+	pruneOptions := prune.Options()
+	for name, pushOpt := range push.Options() {
+		if _, already := pruneOptions[name]; already {
+			continue
+		}
+		pruneOpt := *pushOpt
+		pruneOpt.HiddenOnCLI = !visible[name]
+		prune.AddOption(&pruneOpt)
+	}
+}
@@ -73,9 +73,9 @@ func PullHandler(cfg *mybase.Config) error {
 		if diff.SchemaDDL != "" {
 			optionFile, err := t.Dir.OptionFile()
 			if err != nil {
-				log.Warnf("Unable to update character set and/or collation for %s/.skeema: %s", t.Dir, err)
+				log.Warnf("Unable to update character set and/or collation for %s/%s: %s", t.Dir, t.Dir.OptionFileName(), err)
 			} else if optionFile == nil {
-				log.Warnf("Unable to update character set and/or collation for %s/.skeema: cannot read file", t.Dir)
+				log.Warnf("Unable to update character set and/or collation for %s/%s: cannot read file", t.Dir, t.Dir.OptionFileName())
 			} else {
 				if overridesCharSet, overridesCollation, err := t.SchemaFromInstance.OverridesServerCharSet(); err == nil {
 					if overridesCharSet {
@@ -114,55 +114,66 @@ func PullHandler(cfg *mybase.Config) error {
 		ignoreTable := t.Dir.Config.Get("ignore-table")
 		re, err := regexp.Compile(ignoreTable)
 		if err != nil {
-			return fmt.Errorf("Invalid regular expression on ignore-table: %s; %s", ignoreTable, err)
+			return OptionError(t.Dir.Config, "ignore-table", fmt.Errorf("Invalid regular expression on ignore-table: %s; %s", ignoreTable, err))
+		}
+		enginePolicies, err := ParseEnginePolicies(t.Dir.Config.Get("engine-policy"))
+		if err != nil {
+			return OptionError(t.Dir.Config, "engine-policy", err)
 		}
 		for _, td := range diff.TableDiffs {
-			tableName := ""
+			var table *tengo.Table
 			switch td := td.(type) {
 			case tengo.CreateTable:
-				tableName = td.Table.Name
+				table = td.Table
 			case tengo.DropTable:
-				tableName = td.Table.Name
+				table = td.Table
 			case tengo.AlterTable:
-				tableName = td.Table.Name
+				table = td.Table
 			default:
 				return fmt.Errorf("Unsupported diff type %T", td)
 			}
+			tableName := table.Name
 			if ignoreTable != "" && re.MatchString(tableName) {
 				log.Warnf("Skipping table %s because ignore-table matched %s", tableName, ignoreTable)
 				continue
 			}
+			if policy, engine := enginePolicies.Policy(tableEngines(t.SchemaFromInstance, table)...); policy == "ignore" {
+				log.Warnf("Skipping table %s because engine-policy ignores engine %s", tableName, engine)
+				continue
+			} else if policy == "warn" {
+				log.Warnf("Table %s uses storage engine %s", tableName, engine)
+			}
+			if modulePath, fromModule := t.ModuleTables[tableName]; fromModule {
+				log.Warnf("Skipping table %s because it is defined by module file %s", tableName, modulePath)
+				continue
+			}
 			stmt, err := td.Statement(mods)
 			if err != nil {
 				return err
 			}
 			switch td := td.(type) {
 			case tengo.CreateTable:
-				sf := SQLFile{
-					Dir:      t.Dir,
-					FileName: fmt.Sprintf("%s.sql", td.Table.Name),
-					Contents: stmt,
-				}
-				if length, err := sf.Write(); err != nil {
-					return fmt.Errorf("Unable to write to %s: %s", sf.Path(), err)
-				} else if _, hadErr := t.SQLFileErrors[sf.Path()]; hadErr {
+				if filePath, length, err := t.Dir.WriteTable(td.Table.Name, stmt); err != nil {
+					return fmt.Errorf("Unable to write to %s: %s", filePath, err)
+				} else if _, hadErr := t.SQLFileErrors[filePath]; hadErr {
 					// SQL files with syntax errors will result in tengo.CreateTable since
 					// the temp schema will be missing the table, however we can detect this
 					// scenario by looking in the Target's SQLFileErrors
-					log.Infof("Wrote %s (%d bytes) -- updated file to replace invalid SQL", sf.Path(), length)
+					log.Infof("Wrote %s (%d bytes) -- updated file to replace invalid SQL", filePath, length)
 				} else {
-					log.Infof("Wrote %s (%d bytes) -- new table", sf.Path(), length)
+					log.Infof("Wrote %s (%d bytes) -- new table", filePath, length)
 				}
 			case tengo.DropTable:
 				table := td.Table
-				sf := SQLFile{
-					Dir:      t.Dir,
-					FileName: fmt.Sprintf("%s.sql", table.Name),
+				filePath, err := t.Dir.DeleteTable(table.Name)
+				if err != nil {
+					return fmt.Errorf("Unable to delete table %s from %s: %s", table.Name, filePath, err)
 				}
-				if err := sf.Delete(); err != nil {
-					return fmt.Errorf("Unable to delete %s: %s", sf.Path(), err)
+				if t.Dir.SingleFile() {
+					log.Infof("Removed table %s from %s -- table no longer exists", table.Name, filePath)
+				} else {
+					log.Infof("Deleted %s -- table no longer exists", filePath)
 				}
-				log.Infof("Deleted %s -- table no longer exists", sf.Path())
 			case tengo.AlterTable:
 				// skip if mods caused the diff to be a no-op
 				if stmt == "" {
@@ -173,16 +184,15 @@ func PullHandler(cfg *mybase.Config) error {
 				if err != nil {
 					return err
 				}
-				sf := SQLFile{
-					Dir:      t.Dir,
-					FileName: fmt.Sprintf("%s.sql", table.Name),
-					Contents: createStmt,
+				if sf, _ := t.Dir.TableSQLFile(table.Name); sf != nil && len(sf.Includes) > 0 {
+					log.Warnf("%s: table %s was altered, but file uses include directives and must be updated manually", sf.Path(), table.Name)
+					continue
 				}
-				var length int
-				if length, err = sf.Write(); err != nil {
-					return fmt.Errorf("Unable to write to %s: %s", sf.Path(), err)
+				filePath, length, err := t.Dir.WriteTable(table.Name, createStmt)
+				if err != nil {
+					return fmt.Errorf("Unable to write to %s: %s", filePath, err)
 				}
-				log.Infof("Wrote %s (%d bytes) -- updated file to reflect table alterations", sf.Path(), length)
+				log.Infof("Wrote %s (%d bytes) -- updated file to reflect table alterations", filePath, length)
 			case tengo.RenameTable:
 				return fmt.Errorf("Table renames not yet supported")
 			default:
@@ -194,20 +204,19 @@ func PullHandler(cfg *mybase.Config) error {
 		// updated. Handle same as AlterTable case, since created/dropped tables don't
 		// ever end up in UnsupportedTables since they don't do a diff operation.
 		for _, table := range diff.UnsupportedTables {
+			if modulePath, fromModule := t.ModuleTables[table.Name]; fromModule {
+				log.Warnf("Skipping table %s because it is defined by module file %s", table.Name, modulePath)
+				continue
+			}
 			createStmt := table.CreateStatement()
 			if table.HasAutoIncrement() && !t.Dir.Config.GetBool("include-auto-inc") {
 				createStmt, _ = tengo.ParseCreateAutoInc(createStmt)
 			}
-			sf := SQLFile{
-				Dir:      t.Dir,
-				FileName: fmt.Sprintf("%s.sql", table.Name),
-				Contents: createStmt,
-			}
-			var length int
-			if length, err = sf.Write(); err != nil {
-				return fmt.Errorf("Unable to write to %s: %s", sf.Path(), err)
+			filePath, length, err := t.Dir.WriteTable(table.Name, createStmt)
+			if err != nil {
+				return fmt.Errorf("Unable to write to %s: %s", filePath, err)
 			}
-			log.Infof("Wrote %s (%d bytes) -- updated file to reflect (unsupported) table alterations", sf.Path(), length)
+			log.Infof("Wrote %s (%d bytes) -- updated file to reflect (unsupported) table alterations", filePath, length)
 			if t.Dir.Config.GetBool("debug") {
 				log.Warnf("Table %s: table uses unsupported features", table.Name)
 				t.logUnsupportedTableDiff(table.Name)
@@ -216,23 +225,26 @@ func PullHandler(cfg *mybase.Config) error {
 
 		if dir.Config.GetBool("normalize") {
 			for _, table := range diff.SameTables {
-				sf := SQLFile{
-					Dir:      t.Dir,
-					FileName: fmt.Sprintf("%s.sql", table.Name),
+				if _, fromModule := t.ModuleTables[table.Name]; fromModule {
+					continue
 				}
-				if _, err := sf.Read(); err != nil {
+				sf, err := t.Dir.TableSQLFile(table.Name)
+				if err != nil {
 					return err
 				}
 				for _, warning := range sf.Warnings {
 					log.Debug(warning)
 				}
+				if len(sf.Includes) > 0 {
+					log.Debugf("%s: not normalizing format since file uses include directives", sf.Path())
+					continue
+				}
 				if table.CreateStatement() != sf.Contents {
-					sf.Contents = table.CreateStatement()
-					var length int
-					if length, err = sf.Write(); err != nil {
-						return fmt.Errorf("Unable to write to %s: %s", sf.Path(), err)
+					filePath, length, err := t.Dir.WriteTable(table.Name, table.CreateStatement())
+					if err != nil {
+						return fmt.Errorf("Unable to write to %s: %s", filePath, err)
 					}
-					log.Infof("Wrote %s (%d bytes) -- updated file to normalize format", sf.Path(), length)
+					log.Infof("Wrote %s (%d bytes) -- updated file to normalize format", filePath, length)
 				}
 			}
 		}
@@ -2,9 +2,13 @@ package main
 
 import (
 	"fmt"
+	"os"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/skeema/mybase"
@@ -26,23 +30,57 @@ top of the file. If no environment name is supplied, the default is
 "production".`
 
 	cmd := mybase.NewCommand("push", summary, desc, PushHandler)
-	cmd.AddOption(mybase.BoolOption("verify", 0, true, "Test all generated ALTER statements on temp schema to verify correctness"))
+	cmd.AddOption(mybase.BoolOption("verify", 0, true, "Test generated ALTER statements on temp schema to verify correctness; \"auto\" skips trivially safe ALTERs"))
+	cmd.AddOption(mybase.StringOption("verify-cache-dir", 0, "", "Dir for recording successful verifications, to avoid repeating them in later runs"))
+	cmd.AddOption(mybase.StringOption("normalize-cache-dir", 0, "", "Dir for recording how *.sql files were normalized, to skip temp schema usage for unchanged files in later runs"))
 	cmd.AddOption(mybase.BoolOption("allow-unsafe", 0, false, "Permit running ALTER or DROP operations that are potentially destructive"))
+	cmd.AddOption(mybase.BoolOption("allow-engine-change", 0, false, "Permit running ALTERs that change a table's storage engine"))
+	cmd.AddOption(mybase.StringOption("pii-policy", 0, "allow", `Handling of DDL adding unprotected PII columns: "allow", "warn", "approve", or "block"`))
+	cmd.AddOption(mybase.BoolOption("approve-pii", 0, false, "Permit adding unprotected PII columns when pii-policy is \"approve\""))
 	cmd.AddOption(mybase.BoolOption("dry-run", 0, false, "Output DDL but don't run it; equivalent to `skeema diff`"))
+	cmd.AddOption(mybase.BoolOption("only-additive", 0, false, "Only run CREATE TABLE and ALTERs that purely add columns or indexes; defer all other changes"))
 	cmd.AddOption(mybase.BoolOption("first-only", '1', false, "For dirs mapping to multiple instances or schemas, just run against the first per dir"))
+	cmd.AddOption(mybase.BoolOption("offline", 0, false, "Output unnormalized CREATE TABLE statements for the declared flavor, without connecting to any instance"))
+	cmd.AddOption(mybase.BoolOption("check-connect", 0, false, "Only test connectivity to all instances in parallel, and output a table of results"))
 	cmd.AddOption(mybase.BoolOption("brief", 'q', false, "<overridden by diff command>").Hidden())
+	cmd.AddOption(mybase.StringOption("format", 0, "text", `Output format: "text" for DDL, or "json" for machine-readable plan; see manual for JSON format`))
 	cmd.AddOption(mybase.StringOption("alter-wrapper", 'x', "", "External bin to shell out to for ALTER TABLE; see manual for template vars"))
 	cmd.AddOption(mybase.StringOption("alter-wrapper-min-size", 0, "0", "Ignore --alter-wrapper for tables smaller than this size in bytes"))
 	cmd.AddOption(mybase.StringOption("alter-lock", 0, "", `Apply a LOCK clause to all ALTER TABLEs (valid values: "NONE", "SHARED", "EXCLUSIVE")`))
 	cmd.AddOption(mybase.StringOption("alter-algorithm", 0, "", `Apply an ALGORITHM clause to all ALTER TABLEs (valid values: "INPLACE", "COPY")`))
 	cmd.AddOption(mybase.StringOption("ddl-wrapper", 'X', "", "Like --alter-wrapper, but applies to all DDL types (CREATE, DROP, ALTER)"))
+	cmd.AddOption(mybase.StringOption("wrapper-max-length", 0, "0", "Pass DDL to wrappers via {DDLFILE} if the expanded command would exceed this length in bytes; 0 for no limit"))
+	cmd.AddOption(mybase.StringOption("pre-statement-sql", 0, "", "SQL to run before each DDL statement on the same connection, or a /* comment */ hint to prefix each DDL statement with"))
+	cmd.AddOption(mybase.StringOption("post-statement-sql", 0, "", "SQL to run after each DDL statement on the same connection, or a /* comment */ hint to suffix each DDL statement with"))
 	cmd.AddOption(mybase.StringOption("safe-below-size", 0, "0", "Always permit destructive operations for tables below this size in bytes"))
 	cmd.AddOption(mybase.StringOption("concurrent-instances", 'c', "1", "Perform operations on this number of instances concurrently"))
+	cmd.AddOption(mybase.BoolOption("strict-replication", 0, false, "Skip DDL that interacts unsafely with the instance's binlog_format or binlog_row_image"))
+	cmd.AddOption(mybase.StringOption("index-concurrency", 0, "1", "Max number of index-only ALTER TABLEs to run at once per schema, across different tables"))
+	cmd.AddOption(mybase.StringOption("index-pause", 0, "", "Delay after each index-only ALTER TABLE before its slot is reused (e.g. 30s)"))
+	cmd.AddOption(mybase.StringOption("retry-count", 0, "0", "Retry statements failing due to lock wait timeout or deadlock up to this many times"))
+	cmd.AddOption(mybase.StringOption("retry-backoff", 0, "1s", "Delay before first retry of a statement; doubles with each subsequent retry"))
+	cmd.AddOption(mybase.StringOption("row-count-tolerance", 0, "", "Flag risky ALTERs whose estimated row count changes by more than this percentage"))
+	cmd.AddOption(mybase.StringOption("compare-collations", 0, "enforce", `How to handle collation-only differences (valid values: "enforce", "warn", "ignore")`))
+	cmd.AddOption(mybase.BoolOption("deprecate-dropped-columns", 0, false, "Rename dropped columns with deprecated-column-prefix instead of dropping them; see prune-deprecated command"))
+	cmd.AddOption(mybase.StringOption("deprecated-column-prefix", 0, "zzz_deprecated_", "Prefix for columns renamed by deprecate-dropped-columns"))
+	cmd.AddOption(mybase.BoolOption("invisible-before-drop", 0, false, "Make dropped indexes invisible instead of dropping them; a subsequent push drops them"))
+	cmd.AddOption(mybase.StringOption("only", 0, "", "Only run statements with these comma-separated IDs, as shown by --format=json"))
+	cmd.AddOption(mybase.StringOption("skip", 0, "", "Do not run statements with these comma-separated IDs, as shown by --format=json"))
+	cmd.AddOption(mybase.StringOption("at", 0, "", `Compute plan immediately, but wait until this time ("HH:MM" or RFC3339) to re-verify and apply it`))
+	cmd.AddOption(mybase.StringOption("max-runtime", 0, "", "Do not begin any new statements after this duration (e.g. 45m) has elapsed"))
+	cmd.AddOption(mybase.StringOption("proxysql-admin", 0, "", "Host (and optional :port) of ProxySQL admin interface to flush after pushing changes"))
+	cmd.AddOption(mybase.StringOption("proxysql-user", 0, "admin", "User for connecting to ProxySQL admin interface"))
+	cmd.AddOption(mybase.StringOption("proxysql-password", 0, "", "Password for connecting to ProxySQL admin interface"))
+	cmd.AddOption(mybase.StringOption("schema-registry-url", 0, "", "After successful push, publish each schema's canonical definition to this http(s):// or s3:// URL; see manual for template vars"))
+	cmd.AddOption(mybase.StringOption("schema-registry-auth", 0, "", "Value of Authorization header for requests to an http(s):// schema-registry-url"))
+	cmd.AddOption(mybase.StringOption("event-sink", 0, "", "Emit an event for each applied statement to this http(s):// URL or kafka://brokers/topic"))
+	cmd.AddOption(mybase.StringOption("event-sink-auth", 0, "", "Value of Authorization header for requests to an http(s):// event-sink"))
 	cmd.AddOption(mybase.StringOption("ignore-schema", 0, "", "Ignore schemas that match regex"))
 	cmd.AddOption(mybase.StringOption("ignore-table", 0, "", "Ignore tables that match regex"))
 	cmd.AddArg("environment", "production", false)
 	CommandSuite.AddSubCommand(cmd)
 	clonePushOptionsToDiff()
+	clonePushOptionsToPruneDeprecated()
 }
 
 // sharedPushState stores and manages state shared between multiple push workers
@@ -50,13 +88,44 @@ type sharedPushState struct {
 	targetGroups       <-chan TargetGroup
 	dryRun             bool
 	briefOutput        bool
+	plan               *PlanDocument    // only non-nil if format=json
+	tokens             map[string]bool  // idempotency tokens; only non-nil if at is in use
+	filter             *StatementFilter // only non-nil if only or skip is in use
+	skipCounts         map[string]int   // number of statements skipped, keyed by SkipReason
+	deprecationDate    time.Time        // recorded in comments of deprecated columns
+	silent             bool             // if true, suppress STDOUT output
 	errCount           int
+	connectErrCount    int // subset of errCount due to connection failures
+	unsafeSkipCount    int // subset of errCount due to unsafe statements being forbidden
 	diffCount          int
+	appliedCount       int // number of statements successfully executed
 	unsupportedCount   int
 	lastStdoutInstance string
 	lastStdoutSchema   string
 	seenInstance       map[string]bool
+	deadline           time.Time // zero value if max-runtime not in use
+	deadlineSkipCount  int       // number of statements or targets skipped due to max-runtime
+	conversionWarnings int       // number of server warnings about implicit conversion or truncation
+	rowCountMismatches int       // number of ALTERs flagged by row-count-tolerance
 	fatalError         error
+
+	// Binary logging configuration of each instance, keyed by instance string,
+	// and a dedicated mutex protecting it, which is held while querying
+	binlogSettings map[string]*BinlogSettings
+	binlogMutex    sync.Mutex
+
+	// ProxySQL admin interfaces to flush after all targets, keyed by address, and
+	// number which could not be flushed
+	proxySQLAdmins   map[string]*ProxySQLAdmin
+	proxySQLErrCount int
+
+	// Schema publications to send after all targets, keyed by registry URL, and
+	// number which could not be sent
+	publications     map[string]*pendingPublication
+	registryErrCount int
+
+	eventErrCount int // number of events which could not be emitted to event-sink
+
 	*sync.WaitGroup
 	*sync.Mutex // protects counters as well as STDOUT output and tracking vars
 }
@@ -69,39 +138,149 @@ func PushHandler(cfg *mybase.Config) error {
 		return err
 	}
 
+	// Options controlling the overall run are obtained from dir.Config, rather
+	// than cfg, so that option files may supply per-environment defaults for them
+	if dir.Config.GetBool("check-connect") {
+		return CheckConnectivity(dir)
+	}
+
+	format, err := dir.Config.GetEnum("format", "text", "json")
+	if err != nil {
+		return OptionError(dir.Config, "format", NewExitValue(CodeBadConfig, "%s", err.Error()))
+	} else if format == "json" && (dir.Config.GetBool("offline") || dir.Config.GetBool("brief")) {
+		return NewExitValue(CodeBadConfig, "Option format=json cannot be combined with offline or brief")
+	}
+
+	if dir.Config.GetBool("offline") {
+		if !dir.Config.GetBool("dry-run") {
+			return NewExitValue(CodeBadConfig, "Option offline may only be used with skeema diff or skeema push --dry-run")
+		}
+		return OfflineCreateStatements(dir, dir.Config.GetBool("brief"))
+	}
+
 	workerCount, err := dir.Config.GetInt("concurrent-instances")
 	if err == nil && workerCount < 1 {
 		err = fmt.Errorf("concurrent-instances cannot be less than 1")
 	}
+	if err != nil {
+		return OptionError(dir.Config, "concurrent-instances", err)
+	}
+
+	maxRuntime, err := maxRuntimeDuration(dir.Config)
 	if err != nil {
 		return err
 	}
 
+	filter, err := NewStatementFilter(dir.Config)
+	if err != nil {
+		return err
+	}
+
+	// Capture the deprecation date before any waiting due to the at option, so
+	// that statements are identical between planning and applying
+	deprecationDate := time.Now().UTC()
+	dryRun := dir.Config.GetBool("dry-run")
+	if atValue := dir.Config.Get("at"); atValue != "" {
+		if dryRun || format == "json" {
+			return NewExitValue(CodeBadConfig, "Option at may only be used with skeema push, and cannot be combined with dry-run or format=json")
+		}
+		at, err := ParseScheduledTime(atValue, time.Now())
+		if err != nil {
+			return OptionError(dir.Config, "at", NewExitValue(CodeBadConfig, "%s", err.Error()))
+		}
+		if proceed, err := waitForScheduledPush(dir, workerCount, at, filter, deprecationDate); !proceed {
+			return err
+		}
+	}
+
 	// The 2nd param of dir.TargetGroups indicates that SQLFile errors are to be
 	// treated as fatal. This is required for push and diff. Otherwise, a file with
 	// invalid CREATE TABLE SQL would lead to a table being missing in the temp
 	// schema, which would confuse the logic that diffs schemas.
 	sps := &sharedPushState{
-		targetGroups: dir.TargetGroups(cfg.GetBool("first-only"), true),
-		dryRun:       cfg.GetBool("dry-run"),
-		briefOutput:  cfg.GetBool("brief") && cfg.GetBool("dry-run"),
-		Mutex:        new(sync.Mutex),
-		WaitGroup:    new(sync.WaitGroup),
+		targetGroups:    dir.TargetGroups(dir.Config.GetBool("first-only"), true),
+		dryRun:          dryRun,
+		briefOutput:     dir.Config.GetBool("brief") && dryRun,
+		filter:          filter,
+		deprecationDate: deprecationDate,
+		Mutex:           new(sync.Mutex),
+		WaitGroup:       new(sync.WaitGroup),
 	}
-
-	for n := 0; n < workerCount; n++ {
-		sps.Add(1) // increment the waitgroup
-		go pushWorker(sps)
+	if maxRuntime > 0 {
+		sps.deadline = time.Now().Add(maxRuntime)
+	}
+	if format == "json" {
+		sps.plan = &PlanDocument{
+			FormatVersion: PlanFormatVersion,
+			Command:       cfg.CLI.Command.Name,
+			Environment:   dir.Config.Get("environment"),
+			DryRun:        dryRun,
+		}
 	}
 
-	sps.Wait()
+	sps.run(workerCount)
+
+	// Flush ProxySQL even if a fatal error occurred, since some statements may
+	// have already been applied
+	sps.flushProxySQL()
+	if sps.plan != nil {
+		// Output even if a fatal error occurred, since some statements may have
+		// already been applied
+		if err := sps.plan.Write(os.Stdout); err != nil {
+			log.Errorf("Unable to write JSON output: %s", err)
+		}
+	}
 	if sps.fatalError != nil {
 		return sps.fatalError
 	}
+	sps.publishSchemas()
+	summary, skipTotal := sps.skipSummary()
+	if skipTotal > 0 {
+		var plural string
+		if skipTotal > 1 {
+			plural = "s"
+		}
+		log.Infof("%d statement%s skipped: %s", skipTotal, plural, summary)
+	}
+	unmatched := filter.Unmatched()
+	if len(unmatched) > 0 {
+		log.Warnf("Statement IDs supplied to only or skip did not match any generated statement: %s. These statements may have already been run, or the IDs may be from an outdated plan.", strings.Join(unmatched, ", "))
+	}
+	if !sps.dryRun && skipTotal+sps.deadlineSkipCount+sps.errCount+sps.unsupportedCount+sps.rowCountMismatches == 0 {
+		if err := RecordPush(dir, sps.appliedCount); err != nil {
+			log.Warnf("Unable to update state-file: %s", err)
+		}
+	}
+	if sps.deadlineSkipCount > 0 {
+		return NewExitValue(CodeMaxRuntime, "Reached max-runtime of %s before completion; remaining statements and targets were not started", maxRuntime)
+	}
 
 	if sps.errCount+sps.unsupportedCount == 0 {
+		// Each message is a format string taking the count and its plural suffix
+		problems := []struct {
+			count   int
+			code    int
+			message string
+		}{
+			{len(unmatched), CodePartialError, "%d statement ID%s supplied to only or skip did not match; see above output"},
+			{sps.eventErrCount, CodePartialError, "Unable to emit %d event%s to event-sink; see above output"},
+			{sps.registryErrCount, CodePartialError, "Unable to publish %d schema%s to schema-registry-url after pushing changes; see above output"},
+			{sps.proxySQLErrCount, CodePartialError, "Unable to flush %d ProxySQL admin interface%s after pushing changes; see above output"},
+			{sps.rowCountMismatches, CodeFatalError, "Estimated row count changed beyond row-count-tolerance for %d table%s; see above output"},
+			{sps.conversionWarnings, CodePartialError, "Server reported %d warning%s about implicit conversion or data truncation; see above output"},
+		}
+		for _, problem := range problems {
+			if problem.count == 0 {
+				continue
+			}
+			var plural string
+			if problem.count > 1 {
+				plural = "s"
+			}
+			return NewExitValue(problem.code, problem.message, problem.count, plural)
+		}
 		if sps.dryRun && sps.diffCount > 0 {
-			return NewExitValue(CodeDifferencesFound, "")
+			return NewExitValue(CodeDifferencesFound, "").WithOutcome(OutcomeDriftFound)
 		}
 		return nil
 	}
@@ -110,15 +289,32 @@ func PushHandler(cfg *mybase.Config) error {
 	if sps.errCount+sps.unsupportedCount > 1 {
 		plural = "s"
 	}
+	var outcome Outcome
 	if sps.errCount == 0 {
 		code = CodePartialError
 		reason = "unsupported feature"
+		outcome = OutcomePartialPush
 	} else if sps.unsupportedCount == 0 {
 		reason = "error"
+		if sps.connectErrCount == sps.errCount {
+			outcome = OutcomeConnectionFailure
+		} else if sps.unsafeSkipCount == sps.errCount {
+			outcome = OutcomeUnsafeSkipped
+		}
 	} else {
 		reason = "unsupported features or error"
 	}
-	return NewExitValue(code, "Skipped %d operation%s due to %s%s", sps.errCount+sps.unsupportedCount, plural, reason, plural)
+	return NewExitValue(code, "Skipped %d operation%s due to %s%s", sps.errCount+sps.unsupportedCount, plural, reason, plural).WithOutcome(outcome)
+}
+
+// run starts workerCount pushWorkers to consume sps.targetGroups, and blocks
+// until they have all completed.
+func (sps *sharedPushState) run(workerCount int) {
+	for n := 0; n < workerCount; n++ {
+		sps.Add(1) // increment the waitgroup
+		go pushWorker(sps)
+	}
+	sps.Wait()
 }
 
 func pushWorker(sps *sharedPushState) {
@@ -129,10 +325,16 @@ func pushWorker(sps *sharedPushState) {
 	}
 
 	for tg := range sps.targetGroups { // consume a TargetGroup from the channel
-		for _, t := range tg { // iterate over each Target in the TargetGroup
+		for i, t := range tg { // iterate over each Target in the TargetGroup
 			if sps.fatalError != nil {
 				return
 			}
+			if sps.pastDeadline() {
+				// Keep consuming the channel, so that every remaining Target is counted
+				log.Warnf("Reached max-runtime; skipping remaining targets on %s (count: %d)", t.Instance, len(tg)-i)
+				sps.incrementDeadlineSkipCount(len(tg) - i)
+				break
+			}
 			if t.Err != nil {
 				if t.Instance == nil {
 					log.Errorf("Skipping %s: %s\n", t.Dir, t.Err)
@@ -142,6 +344,11 @@ func pushWorker(sps *sharedPushState) {
 					log.Errorf("Skipping %s %s for %s: %s\n", t.Instance, t.SchemaFromDir.Name, t.Dir, t.Err)
 				}
 				sps.incrementErrCount(1)
+				if _, isConnectErr := t.Err.(*ConnectError); isConnectErr {
+					sps.Lock()
+					sps.connectErrCount++
+					sps.Unlock()
+				}
 				continue
 			}
 
@@ -163,12 +370,78 @@ func pushWorker(sps *sharedPushState) {
 				sps.setFatalError(err)
 				return
 			}
+			if count := ResolveIndexVisibility(diff); count > 0 {
+				var plural string
+				if count > 1 {
+					plural = "s"
+				}
+				log.Debugf("%s %s: diffing %d table%s with invisible indexes", t.Instance, schemaName, count, plural)
+			}
 			var targetStmtCount int
+			var targetFailed bool // true if any statement for this target was skipped or failed
+
+			// Note the ProxySQL admin interface before running any DDL, so that it is
+			// flushed even if a later statement hits a fatal error
+			proxySQLAdmin, err := NewProxySQLAdmin(t.Dir)
+			if err != nil {
+				sps.setFatalError(err)
+				return
+			}
+			if proxySQLAdmin != nil && !sps.dryRun && (diff.SchemaDDL != "" || len(diff.TableDiffs) > 0) {
+				sps.addProxySQLAdmin(proxySQLAdmin)
+			}
+
+			var deferred []tengo.TableDiff
+			if t.Dir.Config.GetBool("only-additive") {
+				if strings.HasPrefix(diff.SchemaDDL, "ALTER DATABASE") {
+					log.Warnf("Deferring schema-level DDL for %s %s due to only-additive", t.Instance, schemaName)
+					schemaStmtID := StatementID(t.Instance.String(), schemaName, diff.SchemaDDL)
+					token := IdempotencyToken(schemaStmtID, t.SchemaFromInstance.CharSet+" "+t.SchemaFromInstance.Collation)
+					sps.printSkipped(t.Instance, "", schemaStmtID, SkipReasonOnlyAdditive, diff.SchemaDDL+";")
+					sps.addPlanStatement(t, schemaStmtID, token, "", "ALTER DATABASE", SafetySafe, diff.SchemaDDL+";").skip(SkipReasonOnlyAdditive, nil)
+					sps.incrementSkipCount(SkipReasonOnlyAdditive, 1)
+					diff.SchemaDDL = ""
+				}
+				if deferred = FilterAdditive(diff); len(deferred) == 1 {
+					log.Warnf("Deferring 1 non-additive table statement for %s %s due to only-additive", t.Instance, schemaName)
+				} else if len(deferred) > 1 {
+					log.Warnf("Deferring %d non-additive table statements for %s %s due to only-additive", len(deferred), t.Instance, schemaName)
+				}
+			}
 
+			var schemaStmtID, schemaType string
+			if diff.SchemaDDL != "" {
+				schemaStmtID = StatementID(t.Instance.String(), schemaName, diff.SchemaDDL)
+				schemaType = "ALTER DATABASE"
+				if strings.HasPrefix(diff.SchemaDDL, "CREATE DATABASE") {
+					schemaType = "CREATE DATABASE"
+				}
+				if !sps.filter.Allows(schemaStmtID) {
+					if schemaType == "CREATE DATABASE" {
+						// Nothing else can be run without the schema existing
+						log.Warnf("Skipping %s %s entirely, since its CREATE DATABASE statement %s was excluded by only or skip", t.Instance, schemaName, schemaStmtID)
+						sps.printSkipped(t.Instance, "", schemaStmtID, SkipReasonFiltered, diff.SchemaDDL+";")
+						sps.incrementSkipCount(SkipReasonFiltered, 1+len(diff.TableDiffs))
+						continue
+					}
+					log.Infof("Skipping %s %s schema-level statement %s due to only or skip", t.Instance, schemaName, schemaStmtID)
+					sps.printSkipped(t.Instance, "", schemaStmtID, SkipReasonFiltered, diff.SchemaDDL+";")
+					sps.incrementSkipCount(SkipReasonFiltered, 1)
+					diff.SchemaDDL = ""
+				}
+			}
 			if diff.SchemaDDL != "" {
 				sps.syncPrintf(t.Instance, "", "%s;\n", diff.SchemaDDL)
 				targetStmtCount++
+				var before string
+				if t.SchemaFromInstance != nil {
+					before = t.SchemaFromInstance.CharSet + " " + t.SchemaFromInstance.Collation
+				}
+				token := sps.recordToken(schemaStmtID, before)
+				ps := sps.addPlanStatement(t, schemaStmtID, token, "", schemaType, SafetySafe, diff.SchemaDDL+";")
 				if !sps.dryRun {
+					// Any failure here is fatal, so assume failure until proven otherwise
+					ps.setStatus(StatusFailed, nil)
 					if strings.HasPrefix(diff.SchemaDDL, "CREATE DATABASE") && t.SchemaFromInstance == nil {
 						t.SchemaFromInstance, err = t.Instance.CreateSchema(schemaName, t.SchemaFromDir.CharSet, t.SchemaFromDir.Collation)
 						if err != nil {
@@ -185,13 +458,8 @@ func pushWorker(sps *sharedPushState) {
 						sps.setFatalError(fmt.Errorf("Refusing to run unexpectedly-generated schema-level DDL: %s", diff.SchemaDDL))
 						return
 					}
-				}
-			}
-
-			if t.Dir.Config.GetBool("verify") && len(diff.TableDiffs) > 0 && !sps.briefOutput {
-				if err := t.verifyDiff(diff); err != nil {
-					sps.setFatalError(err)
-					return
+					ps.setStatus(StatusApplied, nil)
+					sps.incrementAppliedCount()
 				}
 			}
 
@@ -200,62 +468,253 @@ func pushWorker(sps *sharedPushState) {
 			mods.AllowUnsafe = t.Dir.Config.GetBool("allow-unsafe") || sps.briefOutput
 			mods.AlgorithmClause, err = t.Dir.Config.GetEnum("alter-algorithm", "INPLACE", "COPY", "DEFAULT")
 			if err != nil {
-				sps.setFatalError(err)
+				sps.setFatalError(OptionError(t.Dir.Config, "alter-algorithm", err))
 				return
 			}
 			mods.LockClause, err = t.Dir.Config.GetEnum("alter-lock", "NONE", "SHARED", "EXCLUSIVE", "DEFAULT")
+			if err != nil {
+				sps.setFatalError(OptionError(t.Dir.Config, "alter-lock", err))
+				return
+			}
+			tolerance, err := rowCountTolerance(t.Dir.Config)
 			if err != nil {
 				sps.setFatalError(err)
 				return
 			}
+			compareCollations, err := t.Dir.Config.GetEnum("compare-collations", "enforce", "warn", "ignore")
+			if err != nil {
+				sps.setFatalError(OptionError(t.Dir.Config, "compare-collations", err))
+				return
+			}
+			if compareCollations != "enforce" {
+				for _, change := range FilterCollationChanges(diff) {
+					if compareCollations == "warn" {
+						log.Warnf("%s %s: skipping collation difference for %s due to compare-collations=warn", t.Instance, schemaName, change)
+						sps.syncPrintf(t.Instance, schemaName, "-- WARNING: collation differs for %s\n", change)
+					} else {
+						log.Debugf("%s %s: ignoring collation difference for %s due to compare-collations=ignore", t.Instance, schemaName, change)
+					}
+				}
+			}
+			if t.Dir.Config.GetBool("deprecate-dropped-columns") {
+				prefix := t.Dir.Config.Get("deprecated-column-prefix")
+				if prefix == "" {
+					sps.setFatalError(OptionError(t.Dir.Config, "deprecated-column-prefix", fmt.Errorf("Option deprecated-column-prefix cannot be blank when deprecate-dropped-columns is enabled")))
+					return
+				}
+				if count := DeprecateDroppedColumns(diff, prefix, sps.deprecationDate); count > 0 {
+					var plural string
+					if count > 1 {
+						plural = "s"
+					}
+					log.Infof("%s %s: renaming %d dropped column%s with prefix %s instead of dropping, due to deprecate-dropped-columns", t.Instance, schemaName, count, plural, prefix)
+				}
+			}
+			if t.Dir.Config.GetBool("invisible-before-drop") {
+				if supported, err := SupportsInvisibleIndexes(t.Instance); err != nil {
+					sps.setFatalError(err)
+					return
+				} else if !supported {
+					log.Warnf("%s does not support invisible indexes; ignoring invisible-before-drop for %s", t.Instance, schemaName)
+				} else if count := HideDroppedIndexes(diff); count > 0 {
+					var plural string
+					if count > 1 {
+						plural = "es"
+					}
+					log.Infof("%s %s: making %d dropped index%s invisible instead of dropping, due to invisible-before-drop", t.Instance, schemaName, count, plural)
+				}
+			}
+			if t.Dir.Config.GetBool("verify") && len(diff.TableDiffs) > 0 && !sps.briefOutput {
+				if err := t.verifyDiff(diff); err != nil {
+					sps.setFatalError(err)
+					return
+				}
+			}
 			ignoreTable := t.Dir.Config.Get("ignore-table")
 			re, err := regexp.Compile(ignoreTable)
 			if err != nil {
-				sps.setFatalError(fmt.Errorf("Invalid regular expression on ignore-table: %s; %s", ignoreTable, err))
+				sps.setFatalError(OptionError(t.Dir.Config, "ignore-table", fmt.Errorf("Invalid regular expression on ignore-table: %s; %s", ignoreTable, err)))
+				return
+			}
+			enginePolicies, err := ParseEnginePolicies(t.Dir.Config.Get("engine-policy"))
+			if err != nil {
+				sps.setFatalError(OptionError(t.Dir.Config, "engine-policy", err))
 				return
 			}
+			registry, err := NewSchemaRegistry(t)
+			if err != nil {
+				sps.setFatalError(err)
+				return
+			}
+			var eventSink *EventSink
+			var pool *indexBuildPool
+			if !sps.dryRun {
+				if eventSink, err = NewEventSink(t.Dir); err != nil {
+					sps.setFatalError(err)
+					return
+				}
+				if pool, err = newIndexBuildPool(t.Dir.Config); err != nil {
+					sps.setFatalError(err)
+					return
+				}
+			}
+			for _, tableDiff := range deferred {
+				// Output placeholders for statements deferred by only-additive above,
+				// now that mods have been fully populated
+				var table *tengo.Table
+				var diffType string
+				switch td := tableDiff.(type) {
+				case tengo.DropTable:
+					table, diffType = td.Table, "DROP"
+				case tengo.AlterTable:
+					table, diffType = td.Table, "ALTER"
+				default:
+					continue
+				}
+				if ignoreTable != "" && re.MatchString(table.Name) {
+					continue
+				}
+				if policy, _ := enginePolicies.Policy(tableEngines(t.SchemaFromInstance, table)...); policy == "ignore" {
+					continue
+				}
+				ddl := NewDDLStatement(tableDiff, mods, t)
+				if ddl == nil {
+					continue
+				}
+				sps.printSkipped(t.Instance, schemaName, ddl.ID(), SkipReasonOnlyAdditive, ddl.Text())
+				sps.incrementSkipCount(SkipReasonOnlyAdditive, 1)
+				ps := sps.addPlanStatement(t, ddl.ID(), IdempotencyToken(ddl.ID(), table.CreateStatement()), table.Name, diffType+" TABLE", StatementSafety(tableDiff), ddl.Text())
+				ps.skip(SkipReasonOnlyAdditive, nil)
+			}
 			for n, tableDiff := range diff.TableDiffs {
+				if sps.pastDeadline() {
+					skipCount := len(diff.TableDiffs) - n
+					log.Warnf("Reached max-runtime; skipping remaining statements on %s %s (count: %d)", t.Instance, schemaName, skipCount)
+					sps.incrementDeadlineSkipCount(skipCount)
+					targetFailed = true
+					break
+				}
+				if pool.Failed() {
+					skipCount := len(diff.TableDiffs) - n
+					log.Warnf("Due to previous error, skipping %d additional statements on %s %s", skipCount, t.Instance, schemaName)
+					sps.incrementErrCount(skipCount)
+					break
+				}
 				ddl := NewDDLStatement(tableDiff, mods, t)
 				if ddl == nil {
 					// skip blank DDL (which may happen due to NextAutoInc modifier)
 					continue
 				}
-				tableName := ""
+				var table *tengo.Table
+				var diffType string
 				switch td := tableDiff.(type) {
 				case tengo.CreateTable:
-					tableName = td.Table.Name
+					table, diffType = td.Table, "CREATE"
 				case tengo.DropTable:
-					tableName = td.Table.Name
+					table, diffType = td.Table, "DROP"
 				case tengo.AlterTable:
-					tableName = td.Table.Name
+					table, diffType = td.Table, "ALTER"
 				default:
+					pool.Wait()
 					sps.setFatalError(fmt.Errorf("Unsupported diff type %T", td))
 					return
 				}
+				tableName := table.Name
 				if ignoreTable != "" && re.MatchString(tableName) {
 					log.Warnf("Skipping table %s because ignore-table matched %s", tableName, ignoreTable)
 					continue
 				}
+				policy, engine := enginePolicies.Policy(tableEngines(t.SchemaFromInstance, table)...)
+				if policy == "ignore" {
+					log.Warnf("Skipping table %s because engine-policy ignores engine %s", tableName, engine)
+					continue
+				}
 				targetStmtCount++
 				sps.incrementDiffCount()
+				var before string
+				if diffType != "CREATE" {
+					before = table.CreateStatement()
+				}
+				if !sps.filter.Allows(ddl.ID()) {
+					log.Infof("Skipping %s %s table %s statement %s due to only or skip", t.Instance, schemaName, tableName, ddl.ID())
+					sps.printSkipped(t.Instance, schemaName, ddl.ID(), SkipReasonFiltered, ddl.Text())
+					sps.incrementSkipCount(SkipReasonFiltered, 1)
+					ps := sps.addPlanStatement(t, ddl.ID(), IdempotencyToken(ddl.ID(), before), tableName, diffType+" TABLE", StatementSafety(tableDiff), ddl.Text())
+					ps.skip(SkipReasonFiltered, errStatementFiltered)
+					targetFailed = true
+					continue
+				}
+				token := sps.recordToken(ddl.ID(), before)
+				ps := sps.addPlanStatement(t, ddl.ID(), token, tableName, diffType+" TABLE", StatementSafety(tableDiff), ddl.Text())
+				if policy == "warn" {
+					log.Warnf("%s %s table %s uses storage engine %s", t.Instance, schemaName, tableName, engine)
+					sps.syncPrintf(t.Instance, schemaName, "-- WARNING: table %s uses storage engine %s\n", tableName, engine)
+					ps.AddWarning(fmt.Sprintf("table %s uses storage engine %s", tableName, engine))
+				}
+				for _, finding := range sps.getBinlogSettings(t.Instance).Findings(ddl) {
+					log.Warnf("Replication safety: %s %s table %s: %s", t.Instance, schemaName, tableName, finding)
+					sps.syncPrintf(t.Instance, schemaName, "-- WARNING: %s\n", finding)
+					ps.AddWarning(finding)
+					if t.Dir.Config.GetBool("strict-replication") {
+						ddl.setErr(fmt.Errorf("Refusing to run DDL for table %s due to strict-replication: %s", tableName, finding))
+					}
+				}
 				if ddl.Err != nil {
 					log.Errorf("%s. The affected DDL statement will be skipped. See --help for more information.", ddl.Err)
+					reason := SkipReasonForError(ddl.Err)
+					ps.skip(reason, ddl.Err)
+					sps.printSkipped(t.Instance, schemaName, ddl.ID(), reason, ddl.Text())
+					targetFailed = true
 					sps.incrementErrCount(1)
+					sps.incrementSkipCount(reason, 1)
+					if reason == SkipReasonUnsafe {
+						sps.Lock()
+						sps.unsafeSkipCount++
+						sps.Unlock()
+					}
+					continue
 				}
 				sps.syncPrintf(t.Instance, schemaName, "%s\n", ddl.String())
-				if !sps.dryRun && ddl.Err == nil && ddl.Execute() != nil {
-					log.Errorf("Error running DDL on %s %s: %s", t.Instance, schemaName, ddl.Err)
+				if sps.dryRun {
+					if note := ddl.dryRunNote(); note != "" {
+						sps.syncPrintf(t.Instance, schemaName, "%s\n", note)
+					}
+					continue
+				}
+				if alter, ok := tableDiff.(tengo.AlterTable); ok && pool != nil && AlterIsIndexOnly(alter) {
+					pool.Go(func() error {
+						err := sps.executeStatement(t, ddl, ps, tableName, diffType, tolerance, eventSink)
+						if err != nil {
+							sps.incrementErrCount(1)
+						}
+						return err
+					})
+					continue
+				}
+				pool.Wait()
+				if err := sps.executeStatement(t, ddl, ps, tableName, diffType, tolerance, eventSink); err != nil {
 					skipCount := len(diff.TableDiffs) - n
 					if skipCount > 1 {
 						log.Warnf("Due to previous error, skipping %d additional statements on %s %s", skipCount-1, t.Instance, schemaName)
 					}
 					sps.incrementErrCount(skipCount)
+					targetFailed = true
 					break
 				}
 			}
+			pool.Wait()
+			if pool.Failed() {
+				targetFailed = true
+			}
 			for _, table := range diff.UnsupportedTables {
+				if policy, engine := enginePolicies.Policy(tableEngines(t.SchemaFromInstance, table)...); policy == "ignore" {
+					log.Warnf("Skipping table %s because engine-policy ignores engine %s", table.Name, engine)
+					continue
+				}
 				sps.incrementUnsupportedCount()
+				sps.addPlanUnsupportedTable(t, table.Name)
 				targetStmtCount++
+				targetFailed = true
 				if t.Dir.Config.GetBool("debug") {
 					log.Warnf("Skipping table %s: unable to generate ALTER TABLE due to use of unsupported features", table.Name)
 					t.logUnsupportedTableDiff(table.Name)
@@ -264,6 +723,18 @@ func pushWorker(sps *sharedPushState) {
 				}
 			}
 
+			if registry != nil && !targetFailed && !sps.dryRun {
+				var ignoreRegexp *regexp.Regexp
+				if ignoreTable != "" {
+					ignoreRegexp = re
+				}
+				if pub, err := NewSchemaPublication(t, ignoreRegexp); err != nil {
+					log.Errorf("Unable to generate schema publication for %s %s: %s", t.Instance, schemaName, err)
+					sps.incrementRegistryErrCount()
+				} else {
+					sps.addPublication(registry, pub)
+				}
+			}
 			if targetStmtCount == 0 {
 				log.Infof("%s %s: No differences found\n", t.Instance, schemaName)
 			} else {
@@ -279,24 +750,408 @@ func pushWorker(sps *sharedPushState) {
 	}
 }
 
+// executeStatement runs ddl, along with the row count check, event emission,
+// and warning handling configured for it. If the statement fails, its error is
+// returned.
+func (sps *sharedPushState) executeStatement(t *Target, ddl *DDLStatement, ps *PlanStatement, tableName, diffType string, tolerance float64, eventSink *EventSink) error {
+	schemaName := t.SchemaFromDir.Name
+	rowsBefore := int64(-1)
+	if tolerance >= 0 && ddl.NeedsRowCountCheck() {
+		var err error
+		if rowsBefore, err = ddl.RowCount(); err != nil {
+			log.Warnf("Unable to estimate row count of %s %s table %s; skipping row-count-tolerance check: %s", t.Instance, schemaName, tableName, err)
+			rowsBefore = -1
+		}
+	}
+	start := time.Now()
+	if ddl.Execute() != nil {
+		log.Errorf("Error running DDL on %s %s: %s", t.Instance, schemaName, ddl.Err)
+		ps.setStatus(StatusFailed, ddl.Err)
+		return ddl.Err
+	}
+	ps.setStatus(StatusApplied, nil)
+	sps.incrementAppliedCount()
+	if eventSink != nil {
+		event := &SchemaChangeEvent{
+			Schema:      schemaName,
+			Environment: t.Dir.Config.Get("environment"),
+			Instance:    t.Instance.String(),
+			Table:       tableName,
+			Type:        diffType,
+			Statement:   ddl.String(),
+			Timestamp:   start.UTC(),
+			DurationMS:  int64(time.Since(start) / time.Millisecond),
+		}
+		if err := eventSink.Emit(event); err != nil {
+			log.Errorf("Unable to emit event for %s %s table %s: %s", t.Instance, schemaName, tableName, err)
+			sps.incrementEventErrCount()
+		}
+	}
+	if rowsBefore >= 0 {
+		sps.checkRowCount(t, ddl, tableName, rowsBefore, tolerance)
+	}
+	for _, warning := range ddl.Warnings {
+		if warning.IsConversion() {
+			log.Warnf("Implicit conversion: %s %s table %s: server reported %s", t.Instance, schemaName, tableName, warning)
+			sps.syncPrintf(t.Instance, schemaName, "-- WARNING: %s\n", warning)
+			ps.AddWarning(warning.String())
+			sps.incrementConversionWarningCount()
+		} else {
+			log.Debugf("%s %s table %s: server reported %s", t.Instance, schemaName, tableName, warning)
+		}
+	}
+	return nil
+}
+
+// indexBuildPool runs index-only ALTER TABLEs for a single target in the
+// background, with at most a fixed number running at once. After each
+// statement completes successfully, its slot remains occupied for a pause
+// interval, to limit I/O pressure on the instance. A nil pool is permitted,
+// in which case Wait and Failed are no-ops.
+type indexBuildPool struct {
+	slots  chan bool
+	pause  time.Duration
+	failed bool
+	wg     sync.WaitGroup
+	mu     sync.Mutex
+}
+
+// newIndexBuildPool returns an indexBuildPool based on the index-concurrency
+// and index-pause options in cfg, or nil if neither option has been changed
+// from its default.
+func newIndexBuildPool(cfg *mybase.Config) (*indexBuildPool, error) {
+	limit, err := cfg.GetInt("index-concurrency")
+	if err != nil || limit < 1 {
+		return nil, OptionError(cfg, "index-concurrency", fmt.Errorf("Option index-concurrency must be a positive integer; found \"%s\"", cfg.Get("index-concurrency")))
+	}
+	var pause time.Duration
+	if value := cfg.Get("index-pause"); value != "" {
+		if pause, err = time.ParseDuration(value); err != nil || pause < 0 {
+			return nil, OptionError(cfg, "index-pause", fmt.Errorf("Option index-pause must be a duration such as 30s or 5m; found \"%s\"", value))
+		}
+	}
+	if limit == 1 && pause == 0 {
+		return nil, nil
+	}
+	return &indexBuildPool{
+		slots: make(chan bool, limit),
+		pause: pause,
+	}, nil
+}
+
+// Go runs f in the background once a slot is available, blocking until then.
+// If f returns an error, Failed will subsequently return true.
+func (pool *indexBuildPool) Go(f func() error) {
+	pool.slots <- true
+	pool.wg.Add(1)
+	go func() {
+		defer pool.wg.Done()
+		if err := f(); err != nil {
+			pool.mu.Lock()
+			pool.failed = true
+			pool.mu.Unlock()
+		} else if pool.pause > 0 {
+			time.Sleep(pool.pause)
+		}
+		<-pool.slots
+	}()
+}
+
+// Wait blocks until all statements started by Go have completed, including
+// their pause interval.
+func (pool *indexBuildPool) Wait() {
+	if pool != nil {
+		pool.wg.Wait()
+	}
+}
+
+// Failed returns true if any statement started by Go has returned an error.
+func (pool *indexBuildPool) Failed() bool {
+	if pool == nil {
+		return false
+	}
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	return pool.failed
+}
+
+func (sps *sharedPushState) incrementSkipCount(reason string, n int) {
+	sps.Lock()
+	if sps.skipCounts == nil {
+		sps.skipCounts = make(map[string]int)
+	}
+	sps.skipCounts[reason] += n
+	sps.Unlock()
+}
+
+// skipSummary returns a description of the number of skipped statements for
+// each SkipReason, along with the total number of skipped statements.
+func (sps *sharedPushState) skipSummary() (string, int) {
+	sps.Lock()
+	defer sps.Unlock()
+	reasons := make([]string, 0, len(sps.skipCounts))
+	for reason := range sps.skipCounts {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	var total int
+	parts := make([]string, len(reasons))
+	for n, reason := range reasons {
+		parts[n] = fmt.Sprintf("%d %s", sps.skipCounts[reason], reason)
+		total += sps.skipCounts[reason]
+	}
+	return strings.Join(parts, ", "), total
+}
+
 func (sps *sharedPushState) incrementErrCount(n int) {
 	sps.Lock()
 	sps.errCount += n
 	sps.Unlock()
 }
 
+// checkRowCount compares the estimated row count of ddl's table to the count
+// prior to executing ddl, logging an error if the change exceeds tolerance
+// (expressed as a percentage).
+func (sps *sharedPushState) checkRowCount(t *Target, ddl *DDLStatement, tableName string, rowsBefore int64, tolerance float64) {
+	schemaName := t.SchemaFromDir.Name
+	rowsAfter, err := ddl.RowCount()
+	if err != nil {
+		log.Warnf("Unable to estimate row count of %s %s table %s after ALTER; skipping row-count-tolerance check: %s", t.Instance, schemaName, tableName, err)
+		return
+	}
+	delta := rowsAfter - rowsBefore
+	if delta < 0 {
+		delta = -delta
+	}
+	var changePct float64
+	if rowsBefore > 0 {
+		changePct = 100 * float64(delta) / float64(rowsBefore)
+	} else if delta > 0 {
+		changePct = 100
+	}
+	log.Debugf("Estimated row count of %s %s table %s: %d before ALTER, %d after", t.Instance, schemaName, tableName, rowsBefore, rowsAfter)
+	if changePct > tolerance {
+		finding := fmt.Sprintf("estimated row count of table %s changed from %d to %d (%.1f%%), exceeding row-count-tolerance of %g%%", tableName, rowsBefore, rowsAfter, changePct, tolerance)
+		log.Errorf("Row count sanity check failed on %s %s: %s", t.Instance, schemaName, finding)
+		sps.syncPrintf(t.Instance, schemaName, "-- WARNING: %s\n", finding)
+		sps.Lock()
+		sps.rowCountMismatches++
+		sps.Unlock()
+	}
+}
+
+// rowCountTolerance returns the percentage value of the row-count-tolerance
+// option, or -1 if the option is not in use.
+func rowCountTolerance(cfg *mybase.Config) (float64, error) {
+	value := strings.TrimSpace(cfg.Get("row-count-tolerance"))
+	if value == "" {
+		return -1, nil
+	}
+	tolerance, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+	if err != nil || tolerance < 0 {
+		return -1, OptionError(cfg, "row-count-tolerance", NewExitValue(CodeBadConfig, "Option row-count-tolerance must be a non-negative percentage such as 10%%; found \"%s\"", value))
+	}
+	return tolerance, nil
+}
+
+// maxRuntimeDuration returns the value of the max-runtime option, or 0 if
+// the option is not in use.
+func maxRuntimeDuration(cfg *mybase.Config) (time.Duration, error) {
+	value := strings.TrimSpace(cfg.Get("max-runtime"))
+	if value == "" {
+		return 0, nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		return 0, OptionError(cfg, "max-runtime", NewExitValue(CodeBadConfig, "Option max-runtime must be a positive duration such as 30s, 45m, or 2h; found \"%s\"", value))
+	}
+	return duration, nil
+}
+
+func (sps *sharedPushState) incrementConversionWarningCount() {
+	sps.Lock()
+	sps.conversionWarnings++
+	sps.Unlock()
+}
+
 func (sps *sharedPushState) incrementDiffCount() {
 	sps.Lock()
 	sps.diffCount++
 	sps.Unlock()
 }
 
+func (sps *sharedPushState) incrementAppliedCount() {
+	sps.Lock()
+	sps.appliedCount++
+	sps.Unlock()
+}
+
+func (sps *sharedPushState) incrementDeadlineSkipCount(n int) {
+	sps.Lock()
+	sps.deadlineSkipCount += n
+	sps.Unlock()
+}
+
+// pastDeadline returns true if max-runtime is in use and has elapsed.
+func (sps *sharedPushState) pastDeadline() bool {
+	return !sps.deadline.IsZero() && time.Now().After(sps.deadline)
+}
+
 func (sps *sharedPushState) incrementUnsupportedCount() {
 	sps.Lock()
 	sps.unsupportedCount++
 	sps.Unlock()
 }
 
+// getBinlogSettings returns the binary logging configuration of instance,
+// querying it only upon first call for each instance. If the configuration
+// cannot be determined, nil is returned, which is safe to use with
+// BinlogSettings.Findings. A dedicated mutex is used, rather than the one
+// guarding STDOUT and counters, so that a slow instance does not stall output
+// from other workers.
+func (sps *sharedPushState) getBinlogSettings(instance *tengo.Instance) *BinlogSettings {
+	sps.binlogMutex.Lock()
+	defer sps.binlogMutex.Unlock()
+	if sps.binlogSettings == nil {
+		sps.binlogSettings = make(map[string]*BinlogSettings)
+	}
+	if bs, already := sps.binlogSettings[instance.String()]; already {
+		return bs
+	}
+	bs, err := GetBinlogSettings(instance)
+	if err != nil {
+		log.Debugf("Unable to determine binlog settings for %s: %s", instance, err)
+	}
+	sps.binlogSettings[instance.String()] = bs
+	return bs
+}
+
+// addProxySQLAdmin notes that the supplied ProxySQL admin interface should be
+// flushed once all targets have been pushed.
+func (sps *sharedPushState) addProxySQLAdmin(pa *ProxySQLAdmin) {
+	sps.Lock()
+	defer sps.Unlock()
+	if sps.proxySQLAdmins == nil {
+		sps.proxySQLAdmins = make(map[string]*ProxySQLAdmin)
+	}
+	if _, already := sps.proxySQLAdmins[pa.String()]; !already {
+		sps.proxySQLAdmins[pa.String()] = pa
+	}
+}
+
+// flushProxySQL flushes each ProxySQL admin interface noted by
+// addProxySQLAdmin, once per interface regardless of how many targets are
+// routed through it. This must only be called after all workers have
+// completed.
+func (sps *sharedPushState) flushProxySQL() {
+	for _, pa := range sps.proxySQLAdmins {
+		if err := pa.Flush(); err != nil {
+			log.Error(err)
+			sps.proxySQLErrCount++
+		} else {
+			log.Infof("Flushed ProxySQL query cache via admin interface %s", pa)
+		}
+	}
+}
+
+// pendingPublication is a SchemaPublication waiting to be sent to a registry.
+type pendingPublication struct {
+	registry *SchemaRegistry
+	pub      *SchemaPublication
+}
+
+// addPublication notes that pub should be published to registry once all
+// targets have been pushed. Since a registry URL typically identifies a single
+// schema and environment, only one publication is sent per URL per run, even
+// if many targets (e.g. shards) were pushed.
+func (sps *sharedPushState) addPublication(registry *SchemaRegistry, pub *SchemaPublication) {
+	sps.Lock()
+	defer sps.Unlock()
+	if sps.publications == nil {
+		sps.publications = make(map[string]*pendingPublication)
+	}
+	if _, already := sps.publications[registry.URL]; !already {
+		sps.publications[registry.URL] = &pendingPublication{registry: registry, pub: pub}
+	}
+}
+
+func (sps *sharedPushState) incrementEventErrCount() {
+	sps.Lock()
+	sps.eventErrCount++
+	sps.Unlock()
+}
+
+func (sps *sharedPushState) incrementRegistryErrCount() {
+	sps.Lock()
+	sps.registryErrCount++
+	sps.Unlock()
+}
+
+// publishSchemas sends each publication noted by addPublication. This must
+// only be called after all workers have completed.
+func (sps *sharedPushState) publishSchemas() {
+	for _, pending := range sps.publications {
+		if err := pending.registry.Publish(pending.pub); err != nil {
+			log.Errorf("Unable to publish schema %s to %s: %s", pending.pub.Schema, pending.registry, err)
+			sps.registryErrCount++
+		} else {
+			log.Infof("Published schema %s to %s", pending.pub.Schema, pending.registry)
+		}
+	}
+}
+
+// recordToken returns the idempotency token for the statement with the supplied
+// ID and prior object definition. If sps is tracking tokens for the at option,
+// the token is also recorded.
+func (sps *sharedPushState) recordToken(id, before string) string {
+	token := IdempotencyToken(id, before)
+	if sps.tokens != nil {
+		sps.Lock()
+		sps.tokens[token] = true
+		sps.Unlock()
+	}
+	return token
+}
+
+// addPlanStatement records a statement in the JSON output, with an initial
+// status of planned. It returns nil if JSON output is not in use; PlanStatement
+// methods used by the caller are nil-safe.
+func (sps *sharedPushState) addPlanStatement(t *Target, id, token, tableName, stmtType, safety, text string) *PlanStatement {
+	if sps.plan == nil {
+		return nil
+	}
+	ps := &PlanStatement{
+		ID:               id,
+		IdempotencyToken: token,
+		Instance:         t.Instance.String(),
+		Schema:           t.SchemaFromDir.Name,
+		Table:            tableName,
+		Type:             stmtType,
+		Safety:           safety,
+		Statement:        text,
+		Status:           StatusPlanned,
+	}
+	sps.Lock()
+	sps.plan.Statements = append(sps.plan.Statements, ps)
+	sps.Unlock()
+	return ps
+}
+
+// addPlanUnsupportedTable records an unsupported table in the JSON output, if
+// JSON output is in use.
+func (sps *sharedPushState) addPlanUnsupportedTable(t *Target, tableName string) {
+	if sps.plan == nil {
+		return
+	}
+	sps.Lock()
+	sps.plan.UnsupportedTables = append(sps.plan.UnsupportedTables, &PlanUnsupportedTable{
+		Instance: t.Instance.String(),
+		Schema:   t.SchemaFromDir.Name,
+		Table:    tableName,
+	})
+	sps.Unlock()
+}
+
 func (sps *sharedPushState) setFatalError(err error) {
 	sps.Lock()
 	if sps.fatalError == nil {
@@ -305,6 +1160,13 @@ func (sps *sharedPushState) setFatalError(err error) {
 	sps.Unlock()
 }
 
+// printSkipped outputs a commented-out placeholder for a generated statement
+// which will not be run, so that text output never appears to be complete
+// when it is not.
+func (sps *sharedPushState) printSkipped(instance *tengo.Instance, schemaName, id, reason, text string) {
+	sps.syncPrintf(instance, schemaName, "-- skipped %s (%s):\n%s\n", id, reason, commentOut(text))
+}
+
 // syncPrintf prevents interleaving of STDOUT output from multiple workers.
 // It also adds instance and schema lines before output if the previous STDOUT
 // was for a different instance or schema.
@@ -313,6 +1175,10 @@ func (sps *sharedPushState) syncPrintf(instance *tengo.Instance, schemaName stri
 	sps.Lock()
 	defer sps.Unlock()
 
+	if sps.plan != nil || sps.silent {
+		// JSON output is written all at once, after all workers complete
+		return
+	}
 	if sps.briefOutput {
 		if sps.seenInstance == nil {
 			sps.seenInstance = make(map[string]bool)
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/skeema/mybase"
+	"github.com/skeema/tengo"
+)
+
+func TestRowCountTolerance(t *testing.T) {
+	cases := map[string]float64{
+		"":      -1,
+		"10":    10,
+		"25%":   25,
+		" 2.5%": 2.5,
+		"0":     0,
+	}
+	for value, expected := range cases {
+		cfg := getConfig(map[string]string{"row-count-tolerance": value})
+		if actual, err := rowCountTolerance(cfg); err != nil {
+			t.Errorf("Unexpected error from rowCountTolerance with value \"%s\": %s", value, err)
+		} else if actual != expected {
+			t.Errorf("Expected rowCountTolerance with value \"%s\" to return %g, instead found %g", value, expected, actual)
+		}
+	}
+	for _, value := range []string{"-5%", "ten", "%"} {
+		cfg := getConfig(map[string]string{"row-count-tolerance": value})
+		if _, err := rowCountTolerance(cfg); err == nil {
+			t.Errorf("Expected error from rowCountTolerance with value \"%s\", but err was nil", value)
+		}
+	}
+}
+
+func TestIndexBuildPool(t *testing.T) {
+	pool, err := newIndexBuildPool(getConfig(map[string]string{"index-concurrency": "1", "index-pause": ""}))
+	if pool != nil || err != nil {
+		t.Errorf("Expected nil pool and nil error with default options, instead found %v, %v", pool, err)
+	}
+	pool.Wait() // confirm nil-safe
+	if pool.Failed() {
+		t.Error("Expected nil pool to never be failed")
+	}
+	for _, values := range []map[string]string{
+		{"index-concurrency": "0", "index-pause": ""},
+		{"index-concurrency": "two", "index-pause": ""},
+		{"index-concurrency": "1", "index-pause": "soon"},
+	} {
+		if _, err := newIndexBuildPool(getConfig(values)); err == nil {
+			t.Errorf("Expected error from newIndexBuildPool with %v, but received none", values)
+		}
+	}
+
+	pool, err = newIndexBuildPool(getConfig(map[string]string{"index-concurrency": "2", "index-pause": "1ms"}))
+	if err != nil || pool == nil {
+		t.Fatalf("Unexpected result from newIndexBuildPool: %v, %v", pool, err)
+	}
+	var running, maxRunning int
+	var mu sync.Mutex
+	for n := 0; n < 6; n++ {
+		pool.Go(func() error {
+			mu.Lock()
+			running++
+			if running > maxRunning {
+				maxRunning = running
+			}
+			mu.Unlock()
+			time.Sleep(5 * time.Millisecond)
+			mu.Lock()
+			running--
+			mu.Unlock()
+			return nil
+		})
+	}
+	pool.Wait()
+	if maxRunning != 2 || pool.Failed() {
+		t.Errorf("Expected max of 2 concurrent statements without failure; found %d, failed=%t", maxRunning, pool.Failed())
+	}
+	pool.Go(func() error { return errors.New("boom") })
+	pool.Wait()
+	if !pool.Failed() {
+		t.Error("Expected pool to be failed after statement returned error, but it was not")
+	}
+}
+
+func TestSkipSummary(t *testing.T) {
+	sps := &sharedPushState{Mutex: new(sync.Mutex)}
+	if summary, total := sps.skipSummary(); summary != "" || total != 0 {
+		t.Errorf("Expected empty summary, instead found %q, %d", summary, total)
+	}
+	sps.incrementSkipCount(SkipReasonUnsafe, 2)
+	sps.incrementSkipCount(SkipReasonFiltered, 1)
+	sps.incrementSkipCount(SkipReasonUnsafe, 1)
+	if summary, total := sps.skipSummary(); summary != "1 filtered, 3 unsafe" || total != 4 {
+		t.Errorf("Unexpected result from skipSummary: %q, %d", summary, total)
+	}
+}
+
+func TestMaxRuntimeDuration(t *testing.T) {
+	cases := map[string]time.Duration{
+		"":     0,
+		"45m":  45 * time.Minute,
+		" 2h ": 2 * time.Hour,
+		"90s":  90 * time.Second,
+	}
+	for value, expected := range cases {
+		cfg := getConfig(map[string]string{"max-runtime": value})
+		if actual, err := maxRuntimeDuration(cfg); err != nil {
+			t.Errorf("Unexpected error from maxRuntimeDuration with value \"%s\": %s", value, err)
+		} else if actual != expected {
+			t.Errorf("Expected maxRuntimeDuration with value \"%s\" to return %s, instead found %s", value, expected, actual)
+		}
+	}
+	for _, value := range []string{"45", "-5m", "0s", "soon"} {
+		cfg := getConfig(map[string]string{"max-runtime": value})
+		if _, err := maxRuntimeDuration(cfg); err == nil {
+			t.Errorf("Expected error from maxRuntimeDuration with value \"%s\", but err was nil", value)
+		}
+	}
+}
+
+func TestPastDeadline(t *testing.T) {
+	sps := &sharedPushState{}
+	if sps.pastDeadline() {
+		t.Error("Expected pastDeadline to return false when max-runtime is not in use")
+	}
+	sps.deadline = time.Now().Add(time.Hour)
+	if sps.pastDeadline() {
+		t.Error("Expected pastDeadline to return false before the deadline")
+	}
+	sps.deadline = time.Now().Add(-time.Second)
+	if !sps.pastDeadline() {
+		t.Error("Expected pastDeadline to return true after the deadline")
+	}
+}
+
+func TestPushWorkerDeadlineDrainsTargets(t *testing.T) {
+	inst1, err := tengo.NewInstance("mysql", "root@tcp(127.0.0.1:3306)/")
+	if err != nil {
+		t.Fatalf("Unable to create instance: %s", err)
+	}
+	inst2, err := tengo.NewInstance("mysql", "root@tcp(127.0.0.1:3307)/")
+	if err != nil {
+		t.Fatalf("Unable to create instance: %s", err)
+	}
+	groups := make(chan TargetGroup)
+	go func() {
+		groups <- TargetGroup{{Instance: inst1}, {Instance: inst1}, {Instance: inst1}}
+		groups <- TargetGroup{{Instance: inst2}, {Instance: inst2}}
+		groups <- TargetGroup{{Instance: inst2}}
+		close(groups)
+	}()
+	sps := &sharedPushState{
+		targetGroups: groups,
+		deadline:     time.Now().Add(-time.Second),
+		Mutex:        new(sync.Mutex),
+		WaitGroup:    new(sync.WaitGroup),
+	}
+
+	// A single worker must consume every group for all targets to be counted
+	sps.run(1)
+	if sps.deadlineSkipCount != 6 {
+		t.Errorf("Expected all 6 targets to be counted as skipped due to max-runtime, instead found %d", sps.deadlineSkipCount)
+	}
+}
+
+func TestRunLevelOptionsFromOptionFiles(t *testing.T) {
+	base, err := ioutil.TempDir("", "skeematest")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(base)
+	contents := "concurrent-instances=2\n\n[development]\ndry-run\nconcurrent-instances=10\nmax-runtime=45m\n"
+	if err := ioutil.WriteFile(filepath.Join(base, ".skeema"), []byte(contents), 0666); err != nil {
+		t.Fatalf("Unable to write option file: %s", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(base, RootMarkerFileName), []byte{}, 0666); err != nil {
+		t.Fatalf("Unable to write root marker file: %s", err)
+	}
+
+	getDir := func(environment string, cliValues map[string]string) *Dir {
+		cmd := mybase.NewCommand("push", "1.0", "this is for testing", nil)
+		AddGlobalOptions(cmd)
+		cmd.AddOption(mybase.BoolOption("dry-run", 0, false, "dry-run"))
+		cmd.AddOption(mybase.StringOption("concurrent-instances", 'c', "1", "concurrent-instances"))
+		cmd.AddOption(mybase.StringOption("max-runtime", 0, "", "max-runtime"))
+		cmd.AddArg("environment", "production", false)
+		cli := &mybase.CommandLine{Command: cmd, OptionValues: cliValues, ArgValues: []string{environment}}
+		dir, err := NewDir(base, mybase.NewConfig(cli))
+		if err != nil {
+			t.Fatalf("Unexpected error from NewDir: %s", err)
+		}
+		return dir
+	}
+	assertOptions := func(dir *Dir, dryRun bool, workers int, runtime time.Duration) {
+		if actual := dir.Config.GetBool("dry-run"); actual != dryRun {
+			t.Errorf("Expected dry-run=%t in environment %s, instead found %t", dryRun, dir.section, actual)
+		}
+		if actual, _ := dir.Config.GetInt("concurrent-instances"); actual != workers {
+			t.Errorf("Expected concurrent-instances=%d in environment %s, instead found %d", workers, dir.section, actual)
+		}
+		if actual, err := maxRuntimeDuration(dir.Config); err != nil || actual != runtime {
+			t.Errorf("Expected max-runtime=%s in environment %s, instead found %s (err=%v)", runtime, dir.section, actual, err)
+		}
+	}
+
+	// Option files supply per-environment defaults for run-level options
+	assertOptions(getDir("production", nil), false, 2, 0)
+	assertOptions(getDir("development", nil), true, 10, 45*time.Minute)
+
+	// The command-line takes precedence over option files
+	assertOptions(getDir("development", map[string]string{"concurrent-instances": "3", "max-runtime": "5m"}), true, 3, 5*time.Minute)
+}
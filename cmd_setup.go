@@ -0,0 +1,449 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/skeema/mybase"
+	"github.com/skeema/tengo"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+func init() {
+	summary := "Interactively configure a new host dir"
+	desc := `Guides you through creating a host directory and its .skeema option file, by
+prompting for environment names, database hosts, credentials, and flavor.
+Connectivity to each environment's host is tested before anything is written.
+Optionally, the schemas of the first environment's host may then be imported,
+in the same manner as ` + "`" + `skeema init` + "`" + `.
+
+This command is intended for first-time setup by a human; it requires answers
+on STDIN. For scripted setup, use ` + "`" + `skeema init` + "`" + ` and ` + "`" + `skeema add-environment` + "`" + `
+instead.`
+
+	cmd := mybase.NewCommand("setup", summary, desc, SetupHandler)
+	cmd.AddOption(mybase.StringOption("dir", 'd', "", "Base dir to create for the host's schemas; prompted for if omitted"))
+	CommandSuite.AddSubCommand(cmd)
+}
+
+// Credential sources offered by `skeema setup`
+const (
+	CredentialsEnvVar = "env"    // SKEEMA_PASSWORD environment variable
+	CredentialsMyCnf  = "mycnf"  // ~/.my.cnf
+	CredentialsPrompt = "prompt" // --password supplied without a value on each run
+	CredentialsFile   = "file"   // password stored in the host dir's .skeema file
+	CredentialsNone   = "none"   // no password
+)
+
+var credentialChoices = []struct {
+	source      string
+	description string
+}{
+	{CredentialsEnvVar, "SKEEMA_PASSWORD environment variable (recommended for automation)"},
+	{CredentialsMyCnf, "~/.my.cnf, shared with the MySQL client (recommended for humans)"},
+	{CredentialsPrompt, "Prompt each time, by running skeema with --password"},
+	{CredentialsFile, "Store in the host dir's .skeema file (not recommended; exposes the password to source control)"},
+	{CredentialsNone, "No password"},
+}
+
+// SetupEnvironment holds the connection information for one environment, as
+// collected by `skeema setup`.
+type SetupEnvironment struct {
+	Name   string
+	Host   string
+	Port   int
+	Socket string // only used if Host is localhost
+}
+
+// SetupAnswers holds all responses collected by `skeema setup`.
+type SetupAnswers struct {
+	Dir          string
+	Environments []SetupEnvironment
+	User         string
+	Credentials  string // one of the Credentials constants
+	Password     string // only persisted with CredentialsFile or a newly-created ~/.my.cnf
+	Flavor       string
+}
+
+// OptionFile returns the host dir option file for answers. Connection options
+// are placed in a section for each environment, while options shared by all
+// environments are placed at the top of the file.
+func (answers *SetupAnswers) OptionFile() *mybase.File {
+	f := mybase.NewFile(answers.Dir, ".skeema")
+	if answers.Flavor != "" {
+		f.SetOptionValue("", "flavor", answers.Flavor)
+	}
+	f.SetOptionValue("", "user", answers.User)
+	if answers.Credentials == CredentialsFile {
+		f.SetOptionValue("", "password", answers.Password)
+	}
+	for _, env := range answers.Environments {
+		f.SetOptionValue(env.Name, "host", env.Host)
+		if env.Host == "localhost" && env.Socket != "" {
+			f.SetOptionValue(env.Name, "socket", env.Socket)
+		} else {
+			f.SetOptionValue(env.Name, "port", strconv.Itoa(env.Port))
+		}
+	}
+	return f
+}
+
+// setupSource is a configuration source which supplies the connection options
+// of one environment collected by `skeema setup`, so that connectivity may be
+// tested before any option file is written.
+type setupSource map[string]string
+
+// OptionValue satisfies mybase.OptionValuer.
+func (s setupSource) OptionValue(optionName string) (string, bool) {
+	value, ok := s[optionName]
+	return value, ok
+}
+
+func (answers *SetupAnswers) source(env SetupEnvironment) setupSource {
+	s := setupSource{
+		"host": env.Host,
+		"port": strconv.Itoa(env.Port),
+		"user": answers.User,
+	}
+	if env.Host == "localhost" && env.Socket != "" {
+		s["socket"] = env.Socket
+		delete(s, "port")
+	}
+	if answers.Credentials != CredentialsNone {
+		s["password"] = answers.Password
+	}
+	return s
+}
+
+// prompter asks questions and reads answers, one per line.
+type prompter struct {
+	in           *bufio.Reader
+	out          io.Writer
+	readPassword func() (string, error) // reads without echoing; nil if input is not a terminal
+}
+
+func newPrompter(in io.Reader, out io.Writer) *prompter {
+	return &prompter{in: bufio.NewReader(in), out: out}
+}
+
+var errSetupInput = errors.New("Unexpected end of input")
+
+// ask returns the next line of input, or defaultValue if the line is blank.
+func (p *prompter) ask(question, defaultValue string) (string, error) {
+	if defaultValue != "" {
+		fmt.Fprintf(p.out, "%s [%s]: ", question, defaultValue)
+	} else {
+		fmt.Fprintf(p.out, "%s: ", question)
+	}
+	line, err := p.in.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", errSetupInput
+	}
+	if line = strings.TrimSpace(line); line == "" {
+		return defaultValue, nil
+	}
+	return line, nil
+}
+
+// askValid behaves like ask, but repeats the question until validate returns
+// nil for the answer.
+func (p *prompter) askValid(question, defaultValue string, validate func(string) error) (string, error) {
+	for {
+		answer, err := p.ask(question, defaultValue)
+		if err != nil {
+			return "", err
+		}
+		if err := validate(answer); err != nil {
+			fmt.Fprintf(p.out, "%s\n", err)
+			continue
+		}
+		return answer, nil
+	}
+}
+
+// confirm asks a yes/no question.
+func (p *prompter) confirm(question string, defaultYes bool) (bool, error) {
+	defaultValue := "y/N"
+	if defaultYes {
+		defaultValue = "Y/n"
+	}
+	answer, err := p.askValid(question, defaultValue, func(answer string) error {
+		switch strings.ToLower(answer) {
+		case "y", "yes", "n", "no", "y/n":
+			return nil
+		}
+		return errors.New("Please answer y or n")
+	})
+	if err != nil {
+		return false, err
+	}
+	switch strings.ToLower(answer) {
+	case "y", "yes":
+		return true, nil
+	case "n", "no":
+		return false, nil
+	}
+	return defaultYes, nil
+}
+
+// choose asks the user to pick one of choices by number, returning its index.
+func (p *prompter) choose(question string, choices []string, defaultIndex int) (int, error) {
+	fmt.Fprintf(p.out, "%s\n", question)
+	for n, choice := range choices {
+		fmt.Fprintf(p.out, "  %d) %s\n", n+1, choice)
+	}
+	answer, err := p.askValid("Choice", strconv.Itoa(defaultIndex+1), func(answer string) error {
+		if n, err := strconv.Atoi(answer); err != nil || n < 1 || n > len(choices) {
+			return fmt.Errorf("Please enter a number from 1 to %d", len(choices))
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	n, _ := strconv.Atoi(answer)
+	return n - 1, nil
+}
+
+// password reads a password, without echoing it if input is a terminal.
+func (p *prompter) password() (string, error) {
+	if p.readPassword == nil {
+		return p.ask("Enter password", "")
+	}
+	password, err := p.readPassword()
+	fmt.Fprintln(p.out)
+	return password, err
+}
+
+// validateEnvironmentNames returns an error if value is not a comma-separated
+// list of unique, valid environment names.
+func validateEnvironmentNames(value string) error {
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" || strings.ContainsAny(name, "[]\n\r") {
+			return fmt.Errorf("Environment name \"%s\" is invalid", name)
+		}
+		if seen[name] {
+			return fmt.Errorf("Environment name \"%s\" is listed more than once", name)
+		}
+		seen[name] = true
+	}
+	return nil
+}
+
+func validatePort(value string) error {
+	if port, err := strconv.Atoi(value); err != nil || port < 1 || port > 65535 {
+		return fmt.Errorf("Port must be a number from 1 to 65535")
+	}
+	return nil
+}
+
+// SetupHandler is the handler method for `skeema setup`
+func SetupHandler(cfg *mybase.Config) error {
+	AddGlobalConfigFiles(cfg)
+	p := newPrompter(os.Stdin, os.Stdout)
+	if terminal.IsTerminal(int(syscall.Stdin)) {
+		p.readPassword = PromptPassword
+	}
+	err := runSetup(cfg, p)
+	if err == errSetupInput {
+		return NewExitValue(CodeNoInput, "Setup aborted: %s", err)
+	}
+	return err
+}
+
+func runSetup(cfg *mybase.Config, p *prompter) error {
+	fmt.Fprintln(p.out, "This will create a host dir and .skeema option file for a database instance.")
+	fmt.Fprintln(p.out, "Press enter to accept the [default] for any question.")
+	fmt.Fprintln(p.out)
+
+	answers := &SetupAnswers{Dir: cfg.Get("dir")}
+	envList, err := p.askValid("Environment names, comma-separated", cfg.Get("environment"), validateEnvironmentNames)
+	if err != nil {
+		return err
+	}
+	defaultHost := "127.0.0.1"
+	defaultPort := strconv.Itoa(cfg.GetIntOrDefault("port"))
+	for _, name := range strings.Split(envList, ",") {
+		env := SetupEnvironment{Name: strings.TrimSpace(name)}
+		if env.Host, err = p.ask(fmt.Sprintf("Hostname for [%s]", env.Name), defaultHost); err != nil {
+			return err
+		}
+		if env.Host == "localhost" {
+			if env.Socket, err = p.ask(fmt.Sprintf("Socket path for [%s]", env.Name), cfg.Get("socket")); err != nil {
+				return err
+			}
+		} else {
+			port, err := p.askValid(fmt.Sprintf("Port for [%s]", env.Name), defaultPort, validatePort)
+			if err != nil {
+				return err
+			}
+			env.Port, _ = strconv.Atoi(port)
+			defaultPort = port
+		}
+		defaultHost = env.Host
+		answers.Environments = append(answers.Environments, env)
+	}
+	if answers.Dir == "" {
+		if answers.Dir, err = p.ask("Directory to create", answers.Environments[0].Host); err != nil {
+			return err
+		}
+	}
+
+	if answers.User, err = p.ask("Database user", cfg.Get("user")); err != nil {
+		return err
+	}
+	descriptions := make([]string, len(credentialChoices))
+	for n, choice := range credentialChoices {
+		descriptions[n] = choice.description
+	}
+	n, err := p.choose("How should skeema obtain this user's password?", descriptions, 0)
+	if err != nil {
+		return err
+	}
+	answers.Credentials = credentialChoices[n].source
+	if answers.Credentials == CredentialsMyCnf && cfg.Changed("password") {
+		// A password is already configured, e.g. by an existing ~/.my.cnf
+		answers.Password = cfg.Get("password")
+	} else if answers.Credentials != CredentialsNone {
+		if answers.Password, err = p.password(); err != nil {
+			return err
+		}
+		Secrets.Add(answers.Password)
+	}
+
+	// Test connectivity to every environment before writing anything
+	var firstInstance *tengo.Instance
+	for _, env := range answers.Environments {
+		// Option files of the current dir and its parents are intentionally not
+		// used here, since the answers should be tested as-is
+		testDir := &Dir{Path: answers.Dir, Config: cfg.Clone()}
+		testDir.Config.AddSource(answers.source(env))
+		inst, err := testDir.FirstInstance()
+		if err == nil {
+			fmt.Fprintf(p.out, "Connected to %s for [%s]\n", inst, env.Name)
+			if firstInstance == nil {
+				firstInstance = inst
+			}
+			continue
+		}
+		fmt.Fprintf(p.out, "Unable to connect for [%s]: %s\n", env.Name, Redact(err.Error()))
+		if ok, err := p.confirm("Write configuration anyway?", false); err != nil {
+			return err
+		} else if !ok {
+			return NewExitValue(CodeBadConfig, "Setup aborted due to connection failure for environment %s", env.Name)
+		}
+	}
+
+	var detected string
+	if firstInstance != nil {
+		if sv, err := GetServerVersion(firstInstance); err == nil && len(sv.Version) >= 2 {
+			detected = fmt.Sprintf("%s:%d.%d", sv.Flavor, sv.Version[0], sv.Version[1])
+		}
+	}
+	answers.Flavor, err = p.askValid("Database flavor, e.g. mysql:8.0 or mariadb:10.3 (blank to omit)", detected, func(answer string) error {
+		if answer == "" {
+			return nil
+		}
+		_, err := ParseFlavor(answer)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return writeSetup(cfg, p, answers, firstInstance)
+}
+
+// writeSetup creates the host dir and option file described by answers, and
+// optionally imports schemas from inst, which may be nil if connecting failed.
+func writeSetup(cfg *mybase.Config, p *prompter, answers *SetupAnswers, inst *tengo.Instance) error {
+	hostDir, err := NewDir(answers.Dir, cfg)
+	if err != nil {
+		return err
+	}
+	if _, err := hostDir.CreateIfMissing(); err != nil {
+		return NewExitValue(CodeCantCreate, "Unable to use specified dir: %s", err)
+	}
+	if hostDir.HasOptionFile() {
+		return NewExitValue(CodeBadConfig, "Cannot use dir %s: already has %s file", hostDir.Path, hostDir.OptionFileName())
+	}
+	optionFile := answers.OptionFile()
+	optionFile.Name = hostDir.OptionFileName()
+	if err := hostDir.CreateOptionFile(optionFile); err != nil {
+		return NewExitValue(CodeCantCreate, "%s", err.Error())
+	}
+	fmt.Fprintf(p.out, "Wrote %s\n", optionFile.Path())
+
+	switch answers.Credentials {
+	case CredentialsEnvVar:
+		fmt.Fprintln(p.out, "Before running skeema, set the SKEEMA_PASSWORD environment variable to this user's password.")
+	case CredentialsPrompt:
+		fmt.Fprintln(p.out, "Run skeema with --password (without a value) to be prompted for the password.")
+	case CredentialsMyCnf:
+		if err := writeMyCnf(p, answers); err != nil {
+			return err
+		}
+	}
+
+	if inst != nil {
+		if ok, err := p.confirm(fmt.Sprintf("Import schemas from %s now?", inst), true); err != nil {
+			return err
+		} else if ok {
+			schemas, err := inst.Schemas()
+			if err != nil {
+				return NewExitValue(CodeFatalError, "Cannot examine schemas on %s: %s", inst, err)
+			}
+			for _, s := range schemas {
+				if err := PopulateSchemaDir(s, hostDir, true); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	log.Infof("Setup complete. To verify, run: cd %s && skeema diff %s", hostDir.Path, answers.Environments[0].Name)
+	return nil
+}
+
+// myCnfValue returns value formatted for use in a MySQL option file. The value
+// is always double-quoted, so that characters such as # are not interpreted as
+// the start of a comment; backslashes and double quotes are escaped.
+func myCnfValue(value string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+	return `"` + replacer.Replace(value) + `"`
+}
+
+// writeMyCnf offers to create ~/.my.cnf containing the credentials from
+// answers, if the file does not already exist. Existing files are never
+// modified, to avoid disturbing their formatting and comments.
+func writeMyCnf(p *prompter, answers *SetupAnswers) error {
+	home := os.Getenv("HOME")
+	if home == "" {
+		fmt.Fprintln(p.out, "Add this user's password to the [client] section of ~/.my.cnf.")
+		return nil
+	}
+	myCnfPath := path.Join(filepath.Clean(home), ".my.cnf")
+	if _, err := os.Stat(myCnfPath); err == nil {
+		fmt.Fprintf(p.out, "If not already present, add this user's password to the [client] section of %s.\n", myCnfPath)
+		return nil
+	}
+	if ok, err := p.confirm(fmt.Sprintf("Create %s containing this user and password?", myCnfPath), true); err != nil || !ok {
+		return err
+	}
+	contents := fmt.Sprintf("[client]\nuser=%s\npassword=%s\n", myCnfValue(answers.User), myCnfValue(answers.Password))
+	if err := ioutil.WriteFile(myCnfPath, []byte(contents), 0600); err != nil {
+		return NewExitValue(CodeCantCreate, "Unable to write %s: %s", myCnfPath, err)
+	}
+	fmt.Fprintf(p.out, "Wrote %s\n", myCnfPath)
+	return nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPrompter(t *testing.T) {
+	input := strings.Join([]string{
+		"",           // ask: accept default
+		"  answer  ", // ask: trimmed
+		"maybe",      // confirm: invalid, re-asked
+		"YES",        // confirm
+		"",           // confirm: accept default
+		"9",          // choose: out of range, re-asked
+		"two",        // choose: not a number, re-asked
+		"2",          // choose
+		"secret",     // password, read as a line since input is not a terminal
+	}, "\n")
+	var out bytes.Buffer
+	p := newPrompter(strings.NewReader(input), &out)
+
+	if answer, err := p.ask("Question", "default"); answer != "default" || err != nil {
+		t.Errorf("Expected default answer, instead found %q, %v", answer, err)
+	}
+	if answer, err := p.ask("Question", "default"); answer != "answer" || err != nil {
+		t.Errorf("Expected trimmed answer, instead found %q, %v", answer, err)
+	}
+	if ok, err := p.confirm("Confirm?", false); !ok || err != nil {
+		t.Errorf("Expected confirmation, instead found %t, %v", ok, err)
+	}
+	if ok, err := p.confirm("Confirm?", false); ok || err != nil {
+		t.Errorf("Expected default of no, instead found %t, %v", ok, err)
+	}
+	if n, err := p.choose("Pick one", []string{"first", "second"}, 0); n != 1 || err != nil {
+		t.Errorf("Expected second choice, instead found %d, %v", n, err)
+	}
+	if password, err := p.password(); password != "secret" || err != nil {
+		t.Errorf("Unexpected result from password: %q, %v", password, err)
+	}
+	if _, err := p.ask("Question", "default"); err != errSetupInput {
+		t.Errorf("Expected errSetupInput at end of input, instead found %v", err)
+	}
+	if output := out.String(); !strings.Contains(output, "Please answer y or n") || !strings.Contains(output, "Please enter a number from 1 to 2") || !strings.Contains(output, "  2) second\n") {
+		t.Errorf("Unexpected prompter output:\n%s", output)
+	}
+}
+
+func TestSetupValidation(t *testing.T) {
+	for _, value := range []string{"production", "production, staging,development"} {
+		if err := validateEnvironmentNames(value); err != nil {
+			t.Errorf("Unexpected error from validateEnvironmentNames(%q): %s", value, err)
+		}
+	}
+	for _, value := range []string{"", "production,", "prod[1]", "staging,staging"} {
+		if err := validateEnvironmentNames(value); err == nil {
+			t.Errorf("Expected error from validateEnvironmentNames(%q), but received none", value)
+		}
+	}
+	if err := validatePort("3306"); err != nil {
+		t.Errorf("Unexpected error from validatePort: %s", err)
+	}
+	for _, value := range []string{"0", "65536", "mysql"} {
+		if err := validatePort(value); err == nil {
+			t.Errorf("Expected error from validatePort(%q), but received none", value)
+		}
+	}
+}
+
+func TestSetupAnswersOptionFile(t *testing.T) {
+	answers := &SetupAnswers{
+		Dir: "mydb",
+		Environments: []SetupEnvironment{
+			{Name: "production", Host: "db.example.com", Port: 3306},
+			{Name: "development", Host: "localhost", Socket: "/var/run/mysqld.sock"},
+		},
+		User:        "deployer",
+		Credentials: CredentialsEnvVar,
+		Password:    "hunter2",
+		Flavor:      "mysql:8.0",
+	}
+	f := answers.OptionFile()
+	expected := map[string][]string{
+		"flavor": {""},
+		"user":   {""},
+		"host":   {"production", "development"},
+		"port":   {"production"},
+		"socket": {"development"},
+	}
+	for name, sections := range expected {
+		if actual := f.SectionsWithOption(name); !reflect.DeepEqual(actual, sections) {
+			t.Errorf("Expected option %s in sections %v, instead found %v", name, sections, actual)
+		}
+	}
+	if f.SomeSectionHasOption("password") {
+		t.Error("Expected password to be omitted from option file unless CredentialsFile is used")
+	}
+	answers.Credentials = CredentialsFile
+	if f := answers.OptionFile(); !f.SomeSectionHasOption("password") {
+		t.Error("Expected password to be included in option file with CredentialsFile")
+	}
+
+	source := answers.source(answers.Environments[1])
+	if _, ok := source.OptionValue("port"); ok || source["socket"] != "/var/run/mysqld.sock" || source["password"] != "hunter2" {
+		t.Errorf("Unexpected setupSource for localhost environment: %v", source)
+	}
+	answers.Credentials = CredentialsNone
+	if _, ok := answers.source(answers.Environments[0]).OptionValue("password"); ok {
+		t.Error("Expected setupSource to omit password with CredentialsNone")
+	}
+}
+
+func TestMyCnfValue(t *testing.T) {
+	cases := map[string]string{
+		"root":       `"root"`,
+		"pa#ss":      `"pa#ss"`,
+		`say "hi"`:   `"say \"hi\""`,
+		`back\slash`: `"back\\slash"`,
+		"it's":       `"it's"`,
+		"":           `""`,
+	}
+	for value, expected := range cases {
+		if actual := myCnfValue(value); actual != expected {
+			t.Errorf("Expected myCnfValue(%q) to return %s, instead found %s", value, expected, actual)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/skeema/mybase"
+	"github.com/skeema/tengo"
+)
+
+func init() {
+	summary := "Summarize the state of the repo versus database instances"
+	desc := `Displays a summary of how the database instances of an environment compare to
+the filesystem. Each instance and schema defined by the current directory tree
+is reported as one of the following:
+
+  in-sync          no differences were found
+  drifted          some tables or schema defaults differ from the filesystem
+  never-deployed   the schema does not exist on the instance
+  unreachable      the instance could not be connected to
+  error            the instance or dir could not be examined for another reason
+
+Unless --skip-check-instances is supplied, this requires connecting to every
+instance, and uses the same diff logic as ` + "`" + `skeema diff` + "`" + `, but only outputs the
+number of differing objects rather than any DDL.
+
+Additionally, if the state-file option is set, the most recent successful
+` + "`" + `skeema push` + "`" + ` to each environment is displayed: its git commit, timestamp, and
+number of statements run. Environments whose most recent push used a commit
+other than the currently checked-out one are flagged.
+
+You may optionally pass an environment name as a CLI option. This will affect
+which section of .skeema config files is used for determining which instances
+to examine. If no environment name is supplied, the default is "production".
+
+An exit code of 0 will be returned if every schema is in sync, 1 if any schema
+is drifted or never deployed, or 2+ if any instance could not be examined.`
+
+	cmd := mybase.NewCommand("status", summary, desc, StatusHandler)
+	cmd.AddOption(mybase.BoolOption("check-instances", 0, true, "Connect to each instance to determine whether it is in sync with the filesystem"))
+	cmd.AddArg("environment", "production", false)
+	CommandSuite.AddSubCommand(cmd)
+}
+
+// StatusHandler is the handler method for `skeema status`
+func StatusHandler(cfg *mybase.Config) error {
+	AddGlobalConfigFiles(cfg)
+	dir, err := NewDir(".", cfg)
+	if err != nil {
+		return err
+	}
+	if statePath := StateFilePath(dir); statePath != "" {
+		if err := showPushState(dir, statePath); err != nil {
+			return err
+		}
+	} else if !dir.Config.GetBool("check-instances") {
+		return NewExitValue(CodeBadConfig, "Option state-file is not set for %s, and --skip-check-instances was supplied; nothing to do", dir)
+	}
+	if !dir.Config.GetBool("check-instances") {
+		return nil
+	} else if StateFilePath(dir) != "" {
+		fmt.Println()
+	}
+	return showFleetStatus(dir)
+}
+
+// showPushState outputs the contents of the state file at statePath.
+func showPushState(dir *Dir, statePath string) error {
+	state, err := ReadPushState(statePath)
+	if err != nil {
+		return NewExitValue(CodeBadInput, "%s", err.Error())
+	}
+
+	names := make([]string, 0, len(state.Environments))
+	for name := range state.Environments {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	headRef := CurrentGitRef(dir)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ENVIRONMENT\tLAST PUSH\tGIT REF\tSTATEMENTS\tDETAIL")
+	for _, name := range names {
+		record := state.Environments[name]
+		var detail string
+		if record.GitRef != "" && headRef != "" && record.GitRef != headRef {
+			detail = "pushed ref differs from current HEAD"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\n", name, record.Timestamp.Format("2006-01-02 15:04:05 MST"), shortRef(record.GitRef), record.Statements, detail)
+	}
+	w.Flush()
+	return nil
+}
+
+// Status values reported for each target by `skeema status`
+const (
+	StatusInSync        = "in-sync"
+	StatusDrifted       = "drifted"
+	StatusNeverDeployed = "never-deployed"
+	StatusUnreachable   = "unreachable"
+	StatusError         = "error"
+)
+
+// statusRow represents one line of `skeema status` output for a target.
+type statusRow struct {
+	instance, schema, status, detail string
+}
+
+// statusRows implements sort.Interface, ordering by instance and then schema.
+type statusRows []statusRow
+
+func (rows statusRows) Len() int      { return len(rows) }
+func (rows statusRows) Swap(i, j int) { rows[i], rows[j] = rows[j], rows[i] }
+func (rows statusRows) Less(i, j int) bool {
+	if rows[i].instance != rows[j].instance {
+		return rows[i].instance < rows[j].instance
+	}
+	return rows[i].schema < rows[j].schema
+}
+
+// targetStatus returns the status of t, along with a detail string.
+func targetStatus(t *Target) (status, detail string) {
+	if t.Err != nil {
+		if _, isConnectErr := t.Err.(*ConnectError); isConnectErr {
+			return StatusUnreachable, t.Err.Error()
+		}
+		return StatusError, t.Err.Error()
+	}
+	if t.SchemaFromInstance == nil {
+		return StatusNeverDeployed, ""
+	}
+	diff, err := tengo.NewSchemaDiff(t.SchemaFromInstance, t.SchemaFromDir)
+	if err != nil {
+		return StatusError, err.Error()
+	}
+	count := len(diff.TableDiffs) + len(diff.UnsupportedTables)
+	if diff.SchemaDDL != "" {
+		count++
+	}
+	if count == 0 {
+		return StatusInSync, ""
+	}
+	var plural string
+	if count > 1 {
+		plural = "s"
+	}
+	return StatusDrifted, fmt.Sprintf("%d differing object%s", count, plural)
+}
+
+// showFleetStatus outputs the status of each target of dir, along with a
+// summary of the counts of each status.
+func showFleetStatus(dir *Dir) error {
+	var rows statusRows
+	counts := make(map[string]int)
+	for tg := range dir.TargetGroups(false, true) {
+		for _, t := range tg {
+			row := statusRow{instance: "-", schema: "-"}
+			if t.Instance != nil {
+				row.instance = t.Instance.String()
+			}
+			if t.SchemaFromDir != nil {
+				row.schema = t.SchemaFromDir.Name
+			}
+			row.status, row.detail = targetStatus(t)
+			if t.Instance == nil {
+				row.detail = fmt.Sprintf("%s: %s", t.Dir, row.detail)
+			}
+			counts[row.status]++
+			rows = append(rows, row)
+		}
+	}
+	sort.Sort(rows)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "INSTANCE\tSCHEMA\tSTATUS\tDETAIL")
+	for _, row := range rows {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", row.instance, row.schema, row.status, row.detail)
+	}
+	w.Flush()
+	log.Infof("Environment %s: %d %s, %d %s, %d %s, %d %s, %d %s", dir.section,
+		counts[StatusInSync], StatusInSync, counts[StatusDrifted], StatusDrifted, counts[StatusNeverDeployed], StatusNeverDeployed,
+		counts[StatusUnreachable], StatusUnreachable, counts[StatusError], StatusError)
+
+	failCount := counts[StatusUnreachable] + counts[StatusError]
+	var plural string
+	if failCount > 1 {
+		plural = "s"
+	}
+	if failCount > 0 && counts[StatusError] == 0 {
+		return NewExitValue(CodeFatalError, "Unable to connect for %d target%s", failCount, plural).WithOutcome(OutcomeConnectionFailure)
+	} else if failCount > 0 {
+		return NewExitValue(CodeFatalError, "Unable to examine %d target%s", failCount, plural)
+	}
+	if counts[StatusDrifted]+counts[StatusNeverDeployed] > 0 {
+		return NewExitValue(CodeDifferencesFound, "").WithOutcome(OutcomeDriftFound)
+	}
+	return nil
+}
+
+// shortRef abbreviates a git commit hash for display.
+func shortRef(ref string) string {
+	if len(ref) > 12 {
+		return ref[0:12]
+	}
+	return ref
+}
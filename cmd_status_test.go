@@ -0,0 +1,40 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"testing"
+
+	"github.com/skeema/tengo"
+)
+
+func TestTargetStatus(t *testing.T) {
+	cases := []struct {
+		target   *Target
+		expected string
+	}{
+		{&Target{Err: &ConnectError{Kind: ConnectErrorUnknown, Err: errors.New("dial tcp: connection refused")}}, StatusUnreachable},
+		{&Target{Err: errors.New("invalid connection options")}, StatusError},
+		{&Target{SchemaFromDir: &tengo.Schema{Name: "product"}}, StatusNeverDeployed},
+	}
+	for _, c := range cases {
+		if actual, _ := targetStatus(c.target); actual != c.expected {
+			t.Errorf("Expected status %s, instead found %s", c.expected, actual)
+		}
+	}
+}
+
+func TestStatusRowsSort(t *testing.T) {
+	rows := statusRows{
+		{instance: "db2:3306", schema: "a"},
+		{instance: "db1:3306", schema: "c"},
+		{instance: "db1:3306", schema: "b"},
+	}
+	sort.Sort(rows)
+	expected := []string{"db1:3306 b", "db1:3306 c", "db2:3306 a"}
+	for n, row := range rows {
+		if actual := row.instance + " " + row.schema; actual != expected[n] {
+			t.Errorf("Expected row %d to be %s, instead found %s", n, expected[n], actual)
+		}
+	}
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/skeema/mybase"
+	"github.com/skeema/tengo"
+)
+
+func init() {
+	summary := "Display program version"
+	desc := `Displays the version of Skeema.
+
+If --check-targets is supplied, Skeema additionally connects to every database
+instance defined by the current directory tree, and reports each instance's
+flavor and version. Table files are examined for use of features requiring a
+minimum server version, such as CHECK constraints or JSON columns, and any
+instance that cannot support the features used by its directories is flagged.
+
+You may optionally pass an environment name as a CLI option. This will affect
+which section of .skeema config files is used for determining which instances
+to examine. If no environment name is supplied, the default is "production".
+
+With --check-targets, an exit code of 0 will be returned if every instance
+supports the features used by its directories, or 2+ if any instance lacks
+required features or could not be examined.`
+
+	// This replaces mybase's built-in version command
+	cmd := mybase.NewCommand("version", summary, desc, VersionHandler)
+	cmd.AddOption(mybase.BoolOption("check-targets", 0, false, "Report flavor and version of each instance, and whether it supports features used in table files"))
+	cmd.AddArg("environment", "production", false)
+	CommandSuite.AddSubCommand(cmd)
+}
+
+// VersionHandler is the handler method for `skeema version`
+func VersionHandler(cfg *mybase.Config) error {
+	fmt.Println("skeema version", version)
+	if !cfg.GetBool("check-targets") {
+		return nil
+	}
+
+	AddGlobalConfigFiles(cfg)
+	dir, err := NewDir(".", cfg)
+	if err != nil {
+		return err
+	}
+	return CheckTargetCompatibility(dir)
+}
+
+// ServerFeature describes a table feature which requires a minimum server
+// version. A nil minimum version indicates that the flavor does not support
+// the feature at all.
+type ServerFeature struct {
+	Name       string
+	Pattern    *regexp.Regexp
+	MinMySQL   []int
+	MinMariaDB []int
+}
+
+// ServerFeatures lists features detected in table files by `skeema version
+// --check-targets`.
+var ServerFeatures = []ServerFeature{
+	{"utf8mb4 character set", regexp.MustCompile(`(?i)utf8mb4`), []int{5, 5, 3}, []int{5, 5}},
+	{"FULLTEXT indexes on InnoDB", regexp.MustCompile(`(?is)FULLTEXT\s+(KEY|INDEX).*ENGINE\s*=\s*InnoDB`), []int{5, 6, 4}, []int{10, 0, 5}},
+	{"JSON columns", regexp.MustCompile("(?i)[\\s`]json[\\s,(]"), []int{5, 7, 8}, []int{10, 2, 7}},
+	{"generated columns", regexp.MustCompile(`(?i)\sAS\s*\(.*\)\s*(VIRTUAL|STORED|PERSISTENT)`), []int{5, 7, 6}, []int{10, 2}},
+	{"CHECK constraints", regexp.MustCompile(`(?i)\bCHECK\s*\(`), []int{8, 0, 16}, []int{10, 2, 1}},
+	{"invisible indexes", regexp.MustCompile(`(?i)\)\s*(COMMENT\s+'[^']*'\s*)?INVISIBLE\b`), []int{8, 0}, nil},
+}
+
+// ServerVersion represents the flavor and version of a database instance.
+type ServerVersion struct {
+	Flavor  string // "mysql" or "mariadb"
+	Version []int
+	Raw     string // full value of @@version
+}
+
+// GetServerVersion queries the supplied instance for its flavor and version.
+func GetServerVersion(instance *tengo.Instance) (*ServerVersion, error) {
+	db, err := instance.Connect("", "")
+	if err != nil {
+		return nil, err
+	}
+	sv := &ServerVersion{Flavor: "mysql"}
+	var comment string
+	if err := db.QueryRow("SELECT @@version, @@version_comment").Scan(&sv.Raw, &comment); err != nil {
+		return nil, err
+	}
+	if strings.Contains(strings.ToLower(sv.Raw+comment), "mariadb") {
+		sv.Flavor = "mariadb"
+	}
+	if sv.Version, err = parseVersion(sv.Raw); err != nil {
+		return nil, err
+	}
+	return sv, nil
+}
+
+// ParseFlavor parses a value of the flavor option, such as "mysql:8.0" or
+// "mariadb:10.3", into a ServerVersion. Percona Server is treated as mysql,
+// since its feature set matches the corresponding MySQL version. The version
+// may be omitted, or may specify any number of components.
+func ParseFlavor(value string) (*ServerVersion, error) {
+	tokens := strings.SplitN(strings.ToLower(strings.TrimSpace(value)), ":", 2)
+	sv := &ServerVersion{Flavor: tokens[0], Raw: value}
+	switch sv.Flavor {
+	case "mysql", "mariadb":
+	case "percona":
+		sv.Flavor = "mysql"
+	default:
+		return nil, fmt.Errorf("Invalid flavor \"%s\": must be mysql, percona, or mariadb, optionally followed by a colon and version, for example mysql:8.0", value)
+	}
+	if len(tokens) > 1 {
+		var err error
+		if sv.Version, err = parseVersion(tokens[1]); err != nil {
+			return nil, fmt.Errorf("Invalid flavor \"%s\": %s", value, err)
+		}
+	}
+	return sv, nil
+}
+
+// Matches returns true if sv satisfies declared, a ServerVersion obtained from
+// the flavor option. Only the version components specified by declared are
+// compared, so a declared flavor of mysql:5.7 matches any MySQL 5.7.x.
+func (sv *ServerVersion) Matches(declared *ServerVersion) bool {
+	if sv.Flavor != declared.Flavor || len(sv.Version) < len(declared.Version) {
+		return false
+	}
+	return compareVersions(sv.Version[0:len(declared.Version)], declared.Version) == 0
+}
+
+// Supports returns true if the server flavor and version supports feature.
+func (sv *ServerVersion) Supports(feature ServerFeature) bool {
+	minVersion := feature.MinMySQL
+	if sv.Flavor == "mariadb" {
+		minVersion = feature.MinMariaDB
+	}
+	if minVersion == nil {
+		return false
+	} else if len(sv.Version) == 0 {
+		// Declared flavor without any version: assume a new enough version
+		return true
+	}
+	return compareVersions(sv.Version, minVersion) >= 0
+}
+
+// UnsupportedFeatures returns the names of ServerFeatures used by dir's table
+// files that are not supported by sv.
+func (sv *ServerVersion) UnsupportedFeatures(dir *Dir) ([]string, error) {
+	used, err := featuresUsed(dir)
+	if err != nil {
+		return nil, err
+	}
+	var result []string
+	for _, name := range used {
+		for _, feature := range ServerFeatures {
+			if feature.Name == name && !sv.Supports(feature) {
+				result = append(result, name)
+			}
+		}
+	}
+	return result, nil
+}
+
+// featuresUsed returns the names of ServerFeatures used by any valid *.sql file
+// of dir or its modules.
+func featuresUsed(dir *Dir) ([]string, error) {
+	sqlFiles, err := dir.SQLFiles()
+	if err != nil {
+		return nil, err
+	}
+	moduleFiles, err := dir.ModuleSQLFiles()
+	if err != nil {
+		return nil, err
+	}
+	var result []string
+	for _, feature := range ServerFeatures {
+		for _, sf := range append(sqlFiles, moduleFiles...) {
+			if sf.Error == nil && feature.Pattern.MatchString(sf.Contents) {
+				result = append(result, feature.Name)
+				break
+			}
+		}
+	}
+	return result, nil
+}
+
+// compatibilityResult tracks the outcome of examining a single instance, along
+// with the set of features used by dirs mapping to that instance.
+type compatibilityResult struct {
+	instance *tengo.Instance
+	features map[string]bool
+	declared map[string]*ServerVersion // flavor option values of dirs mapping to the instance
+	version  *ServerVersion
+	err      error
+}
+
+// CheckTargetCompatibility examines every instance that dir and its subdirs map
+// to, in parallel, and outputs a table to STDOUT listing each instance's flavor
+// and version, and any features used by its dirs that it does not support. An
+// error is returned if any instance is incompatible or could not be examined.
+func CheckTargetCompatibility(dir *Dir) error {
+	results := make(map[string]*compatibilityResult)
+	var order []string
+	var configErrCount int
+	var walk func(*Dir) error
+	walk = func(d *Dir) error {
+		if d.AllowsEnvironment() && d.HostConfigured() && d.HasSchema() {
+			instances, err := d.Instances()
+			if err != nil {
+				log.Errorf("Skipping %s: %s", d, err)
+				configErrCount++
+			}
+			features, err := featuresUsed(d)
+			if err != nil {
+				log.Errorf("Skipping %s: %s", d, err)
+				configErrCount++
+			}
+			declared, err := d.Flavor()
+			if err != nil {
+				log.Errorf("Skipping %s: %s", d, err)
+				configErrCount++
+			}
+			for _, inst := range instances {
+				key := inst.String()
+				if results[key] == nil {
+					results[key] = &compatibilityResult{
+						instance: inst,
+						features: make(map[string]bool),
+						declared: make(map[string]*ServerVersion),
+					}
+					order = append(order, key)
+				}
+				for _, feature := range features {
+					results[key].features[feature] = true
+				}
+				if declared != nil {
+					results[key].declared[declared.Raw] = declared
+				}
+			}
+		}
+		subdirs, err := d.Subdirs()
+		if err != nil {
+			return err
+		}
+		for _, subdir := range subdirs {
+			if subdir.BaseName()[0] != '.' {
+				if err := walk(subdir); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	if err := walk(dir); err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	for _, result := range results {
+		wg.Add(1)
+		go func(result *compatibilityResult) {
+			defer wg.Done()
+			if result.err = CheckConnect(result.instance); result.err == nil {
+				result.version, result.err = GetServerVersion(result.instance)
+			}
+		}(result)
+	}
+	wg.Wait()
+
+	var failCount int
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "INSTANCE\tFLAVOR\tVERSION\tSTATUS\tDETAIL")
+	for _, key := range order {
+		result := results[key]
+		if result.err != nil {
+			failCount++
+			fmt.Fprintf(w, "%s\t-\t-\terror\t%s\n", key, result.err)
+			continue
+		}
+		var unsupported []string
+		for _, feature := range ServerFeatures {
+			if result.features[feature.Name] && !result.version.Supports(feature) {
+				unsupported = append(unsupported, feature.Name)
+			}
+		}
+		sort.Strings(unsupported)
+		var mismatched []string
+		for raw, declared := range result.declared {
+			if !result.version.Matches(declared) {
+				mismatched = append(mismatched, raw)
+			}
+		}
+		sort.Strings(mismatched)
+		status, detail := "ok", ""
+		if len(unsupported) > 0 {
+			failCount++
+			status, detail = "incompatible", "unsupported: "+strings.Join(unsupported, ", ")
+		} else if len(mismatched) > 0 {
+			failCount++
+			status, detail = "mismatch", "declared flavor: "+strings.Join(mismatched, ", ")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", key, result.version.Flavor, result.version.Raw, status, detail)
+	}
+	w.Flush()
+
+	if configErrCount > 0 {
+		return NewExitValue(CodeBadConfig, "Skipped %d dirs due to invalid configuration; %d of %d instances incompatible or unreachable", configErrCount, failCount, len(results))
+	} else if failCount > 0 {
+		return NewExitValue(CodeFatalError, "%d of %d instances incompatible or unreachable", failCount, len(results))
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestServerVersionSupports(t *testing.T) {
+	features := make(map[string]ServerFeature, len(ServerFeatures))
+	for _, feature := range ServerFeatures {
+		features[feature.Name] = feature
+	}
+	cases := []struct {
+		flavor   string
+		version  string
+		feature  string
+		expected bool
+	}{
+		{"mysql", "5.7.22-log", "CHECK constraints", false},
+		{"mysql", "8.0.16", "CHECK constraints", true},
+		{"mariadb", "10.2.14-MariaDB", "CHECK constraints", true},
+		{"mysql", "5.6.40", "JSON columns", false},
+		{"mysql", "5.7.8", "JSON columns", true},
+		{"mariadb", "10.3.7-MariaDB", "invisible indexes", false},
+		{"mysql", "8.0.11", "invisible indexes", true},
+	}
+	for _, c := range cases {
+		parsed, err := parseVersion(c.version)
+		if err != nil {
+			t.Fatalf("Unexpected error parsing version %s: %s", c.version, err)
+		}
+		sv := &ServerVersion{Flavor: c.flavor, Version: parsed, Raw: c.version}
+		if actual := sv.Supports(features[c.feature]); actual != c.expected {
+			t.Errorf("Expected %s %s support for %s to be %t, instead found %t", c.flavor, c.version, c.feature, c.expected, actual)
+		}
+	}
+}
+
+func TestParseFlavor(t *testing.T) {
+	cases := []struct {
+		value    string
+		flavor   string
+		version  string
+		expected bool
+	}{
+		{"mysql:8.0", "mysql", "8.0.18", true},
+		{"mysql:8.0", "mysql", "5.7.26", false},
+		{"mysql:8.0", "mariadb", "8.0.0", false},
+		{"percona:5.7", "mysql", "5.7.26-29-log", true},
+		{"MariaDB:10.3", "mariadb", "10.3.7-MariaDB", true},
+		{"mariadb", "mariadb", "10.2.14-MariaDB", true},
+		{"mysql:5.7.20", "mysql", "5.7.26", false},
+	}
+	for _, c := range cases {
+		declared, err := ParseFlavor(c.value)
+		if err != nil {
+			t.Fatalf("Unexpected error parsing flavor %s: %s", c.value, err)
+		}
+		parsed, err := parseVersion(c.version)
+		if err != nil {
+			t.Fatalf("Unexpected error parsing version %s: %s", c.version, err)
+		}
+		sv := &ServerVersion{Flavor: c.flavor, Version: parsed, Raw: c.version}
+		if actual := sv.Matches(declared); actual != c.expected {
+			t.Errorf("Expected %s %s matching declared flavor %s to be %t, instead found %t", c.flavor, c.version, c.value, c.expected, actual)
+		}
+	}
+	for _, value := range []string{"", "oracle:12", "mysql:abc"} {
+		if _, err := ParseFlavor(value); err == nil {
+			t.Errorf("Expected error parsing flavor \"%s\", but err is nil", value)
+		}
+	}
+}
+
+func TestServerFeaturePatterns(t *testing.T) {
+	stmt := "CREATE TABLE `foo` (\n  `id` int NOT NULL,\n  `doc` json DEFAULT NULL,\n  `total` int AS (id * 2) VIRTUAL,\n  PRIMARY KEY (`id`),\n  KEY `idx_total` (`total`) INVISIBLE,\n  CONSTRAINT `chk` CHECK (`id` > 0)\n) ENGINE=InnoDB DEFAULT CHARSET=latin1"
+	for _, feature := range ServerFeatures {
+		expected := (feature.Name != "utf8mb4 character set" && feature.Name != "FULLTEXT indexes on InnoDB")
+		if actual := feature.Pattern.MatchString(stmt); actual != expected {
+			t.Errorf("Expected pattern for %s to return %t, instead found %t", feature.Name, expected, actual)
+		}
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"path"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 	"syscall"
 
@@ -24,12 +25,24 @@ func AddGlobalOptions(cmd *mybase.Command) {
 	// Options typically only found in .skeema files -- all hidden by default
 	cmd.AddOption(mybase.StringOption("host", 0, "", "Database hostname or IP address").Hidden())
 	cmd.AddOption(mybase.StringOption("port", 0, "3306", "Port to use for database host").Hidden())
+	cmd.AddOption(mybase.StringOption("host-group", 0, "", "Name of a host group defined in a [hosts:NAME] option file section").Hidden())
+	cmd.AddOption(mybase.StringOption("profile", 0, "", "Name of a connection profile defined in a [profile:NAME] option file section").Hidden())
+	cmd.AddOption(mybase.StringOption("read-host", 0, "", "Replica hostname(s) to use for schema introspection instead of host").Hidden())
 	cmd.AddOption(mybase.StringOption("socket", 'S', "/tmp/mysql.sock", "Absolute path to Unix socket file used if host is localhost").Hidden())
 	cmd.AddOption(mybase.StringOption("schema", 0, "", "Database schema name").Hidden())
+	cmd.AddOption(mybase.BoolOption("default-schema-dir", 0, false, "Map each subdir of a host dir to a schema of the same name, unless it specifies a schema").Hidden())
+	cmd.AddOption(mybase.StringOption("schema-prefix", 0, "", "Prefix to prepend to schema names in this environment, e.g. stg_").Hidden())
+	cmd.AddOption(mybase.StringOption("schema-suffix", 0, "", "Suffix to append to schema names in this environment").Hidden())
 	cmd.AddOption(mybase.StringOption("ignore-schema", 0, "", "Ignore schemas that match regex").Hidden())
 	cmd.AddOption(mybase.StringOption("ignore-table", 0, "", "Ignore tables that match regex").Hidden())
+	cmd.AddOption(mybase.StringOption("engine-policy", 0, "", "Comma-separated engine=policy pairs, where policy is allow, warn, or ignore").Hidden())
 	cmd.AddOption(mybase.StringOption("default-character-set", 0, "", "Schema-level default character set").Hidden())
 	cmd.AddOption(mybase.StringOption("default-collation", 0, "", "Schema-level default collation").Hidden())
+	cmd.AddOption(mybase.StringOption("state-file", 0, "", "File recording the most recent successful push to each environment").Hidden())
+	cmd.AddOption(mybase.StringOption("modules", 0, "", "Comma-separated list of paths or git URLs of schema modules to merge into this dir").Hidden())
+	cmd.AddOption(mybase.StringOption("depends-on", 0, "", "Comma-separated list of dirs whose schemas must be pushed before this dir's").Hidden())
+	cmd.AddOption(mybase.StringOption("requires-skeema-version", 0, "", "Minimum version of Skeema required to use this option file").Hidden())
+	cmd.AddOption(mybase.StringOption("allowed-environments", 0, "", "Comma-separated list of environment names valid for this dir").Hidden())
 
 	// Visible global options
 	cmd.AddOption(mybase.StringOption("user", 'u', "root", "Username to connect to database host"))
@@ -38,7 +51,96 @@ func AddGlobalOptions(cmd *mybase.Command) {
 	cmd.AddOption(mybase.StringOption("temp-schema", 't', "_skeema_tmp", "Name of temporary schema for intermediate operations, created and dropped each run unless --reuse-temp-schema"))
 	cmd.AddOption(mybase.StringOption("connect-options", 'o', "", "Comma-separated session options to set upon connecting to each database instance"))
 	cmd.AddOption(mybase.BoolOption("reuse-temp-schema", 0, false, "Do not drop temp-schema when done"))
+	cmd.AddOption(mybase.StringOption("workspace-pool-size", 0, "0", "Keep up to this many temp schemas per instance warm across targets, instead of one per target"))
+	cmd.AddOption(mybase.StringOption("workspace-host", 0, "", "Host (optionally with :port) to use for temp schemas, instead of each target instance"))
+	cmd.AddOption(mybase.StringOption("flavor", 0, "", "Database flavor and version of this dir's instances, e.g. mysql:8.0 or mariadb:10.3"))
+	cmd.AddOption(mybase.StringOption("environment", 0, "production", "Environment name, as an alternative to supplying it as a positional arg"))
 	cmd.AddOption(mybase.BoolOption("debug", 0, false, "Enable debug logging"))
+	cmd.AddOption(mybase.StringOption("skeema-file", 0, ".skeema", "Name of per-directory option files to use instead of .skeema"))
+	cmd.AddOption(mybase.StringOption("parent-config-depth", 0, "20", "Max number of parent dirs to examine for option files; 0 to only use this dir's"))
+	cmd.AddOption(mybase.BoolOption("parent-configs", 0, true, "Apply global option files and those of parent dirs; skip to only use this dir's option file and the command-line"))
+	cmd.AddOption(mybase.StringOption("parent-config-timeout", 0, "5s", "Fail if the filesystem does not respond in this time when examining dirs for option files"))
+	cmd.AddOption(mybase.StringOption("config", 0, "", "Path to an additional option file, applied after global option files"))
+	cmd.AddOption(mybase.BoolOption("no-global-config", 0, false, "Do not apply global option files, such as /etc/skeema or ~/.skeema"))
+	cmd.AddOption(mybase.StringOption("layout", 0, "per-table", `Layout of table files: "per-table" or "single-file"`))
+	cmd.AddOption(mybase.StringOption("filename-policy", 0, "unicode", `How to represent schema and table names in filenames: "unicode", "ascii", or "transliterate"`))
+	cmd.AddOption(mybase.BoolOption("respect-gitignore", 0, false, "Skip subdirs and *.sql files matched by .gitignore files"))
+	cmd.AddOption(mybase.BoolOption("loose-config", 0, false, "Warn about, rather than fail on, unknown options and version requirements in option files"))
+	cmd.AddOption(mybase.BoolOption("detailed-exit-codes", 0, false, "Use a distinct exit code for each type of outcome; see manual for values"))
+	cmd.AddOption(mybase.BoolOption("success-on-drift", 0, false, "Exit with code 0, rather than 1, when differences are found"))
+}
+
+// envVarSource is a configuration source supplying option values obtained
+// from environment variables.
+type envVarSource map[string]string
+
+// OptionValue satisfies mybase.OptionValuer.
+func (s envVarSource) OptionValue(optionName string) (string, bool) {
+	value, ok := s[optionName]
+	return value, ok
+}
+
+// EnvVarName returns the name of the environment variable which may be used to
+// supply a value for the option optionName. For example, option
+// "connect-options" maps to SKEEMA_CONNECT_OPTIONS.
+func EnvVarName(optionName string) string {
+	return "SKEEMA_" + strings.ToUpper(strings.Replace(optionName, "-", "_", -1))
+}
+
+// NewEnvVarSource returns an envVarSource containing a value for each option
+// of cmd which has a non-blank SKEEMA_* environment variable set.
+func NewEnvVarSource(cmd *mybase.Command) envVarSource {
+	source := make(envVarSource)
+	for name := range cmd.Options() {
+		if value := os.Getenv(EnvVarName(name)); value != "" {
+			source[name] = value
+		}
+	}
+	return source
+}
+
+// GlobalConfigPaths returns the paths of global option files, in order from
+// lowest to highest priority. The returned files may not exist. If the
+// SKEEMA_CONFIG_PATH environment variable is set, its list of paths (separated
+// like PATH) is used instead of the default locations. Any path which is a
+// directory is replaced with a file named "config" inside of it.
+func GlobalConfigPaths() []string {
+	var paths []string
+	if value := os.Getenv("SKEEMA_CONFIG_PATH"); value != "" {
+		for _, p := range filepath.SplitList(value) {
+			if p != "" {
+				paths = append(paths, globalConfigFile(p))
+			}
+		}
+		return paths
+	}
+
+	paths = []string{globalConfigFile("/etc/skeema"), globalConfigFile("/usr/local/etc/skeema")}
+	var home string
+	if value := os.Getenv("HOME"); value != "" {
+		home = filepath.Clean(value)
+	}
+	// Per the XDG base directory spec, a relative XDG_CONFIG_HOME is invalid
+	configHome := os.Getenv("XDG_CONFIG_HOME")
+	if !filepath.IsAbs(configHome) && home != "" {
+		configHome = path.Join(home, ".config")
+	}
+	if filepath.IsAbs(configHome) {
+		paths = append(paths, path.Join(configHome, "skeema", "config"))
+	}
+	if home != "" {
+		paths = append(paths, path.Join(home, ".my.cnf"), path.Join(home, ".skeema"))
+	}
+	return paths
+}
+
+// globalConfigFile returns p if it is a file or does not exist, or the path of
+// a file named "config" inside of p if it is a directory.
+func globalConfigFile(p string) string {
+	if fi, err := os.Stat(p); err == nil && fi.IsDir() {
+		return path.Join(p, "config")
+	}
+	return p
 }
 
 // AddGlobalConfigFiles takes the mybase.Config generated from the CLI and adds
@@ -46,10 +148,23 @@ func AddGlobalOptions(cmd *mybase.Command) {
 // options. Generally, subcommand handlers should call AddGlobalConfigFiles at
 // the top of the method.
 func AddGlobalConfigFiles(cfg *mybase.Config) {
-	globalFilePaths := []string{"/etc/skeema", "/usr/local/etc/skeema"}
-	home := filepath.Clean(os.Getenv("HOME"))
-	if home != "" {
-		globalFilePaths = append(globalFilePaths, path.Join(home, ".my.cnf"), path.Join(home, ".skeema"))
+	// Options may be supplied via SKEEMA_* environment variables, at lower
+	// precedence than all option files. This must be handled first, since the
+	// environment name (SKEEMA_ENVIRONMENT or --environment, as alternatives to
+	// the positional arg) determines which section of each option file is used.
+	if source := NewEnvVarSource(cfg.CLI.Command); len(source) > 0 {
+		cfg.AddSource(source)
+	}
+	if flagValue, onCLI := cfg.CLI.OptionValues["environment"]; onCLI && len(cfg.CLI.ArgValues) > 0 && cfg.CLI.ArgValues[0] != flagValue {
+		Exit(NewExitValue(CodeBadUsage, "Environment name supplied as both positional arg \"%s\" and --environment=%s", cfg.CLI.ArgValues[0], flagValue))
+	}
+
+	// With skip-parent-configs or no-global-config, global option files are not
+	// used, in order to make the run independent of anything outside of the
+	// current dir.
+	var globalFilePaths []string
+	if cfg.GetBool("parent-configs") && !cfg.GetBool("no-global-config") {
+		globalFilePaths = GlobalConfigPaths()
 	}
 	for _, path := range globalFilePaths {
 		f := mybase.NewFile(path)
@@ -67,6 +182,9 @@ func AddGlobalConfigFiles(cfg *mybase.Config) {
 			log.Warnf("Ignoring global option file %s due to parse error: %s", f.Path(), err)
 			continue
 		}
+		if err := checkNoEnvironment(f); err != nil {
+			Exit(NewExitValue(CodeBadConfig, "%s", err))
+		}
 		if strings.HasSuffix(path, ".my.cnf") {
 			_ = f.UseSection("skeema", "client", "mysql") // safe to ignore error (doesn't matter if section doesn't exist)
 		} else {
@@ -76,34 +194,83 @@ func AddGlobalConfigFiles(cfg *mybase.Config) {
 		cfg.AddSource(f)
 	}
 
+	// An explicit option file may be supplied via --config. Unlike the global
+	// option files above, problems reading or parsing it are fatal, since the
+	// user specifically requested it.
+	if cfg.Changed("config") {
+		f := mybase.NewFile(cfg.Get("config"))
+		if err := f.Read(); err != nil {
+			Exit(NewExitValue(CodeNoInput, "Unable to read option file %s: %s", f.Path(), err))
+		}
+		if err := ParseOptionFile(f, cfg); err != nil {
+			Exit(NewExitValue(CodeBadConfig, "Unable to parse option file %s: %s", f.Path(), err))
+		}
+		_ = f.UseSection(cfg.Get("environment")) // safe to ignore error (doesn't matter if section doesn't exist)
+		if err := CheckRequiredVersion(f, cfg); err != nil {
+			Exit(NewExitValue(CodeBadConfig, "%s", err.Error()))
+		}
+		cfg.AddSource(f)
+	}
+
+	// The skeema-file option only supplies a base filename; a path is not
+	// permitted, since the file is looked up in each directory being evaluated.
+	if skeemaFile := cfg.Get("skeema-file"); skeemaFile == "" || skeemaFile == "." || skeemaFile == ".." || strings.ContainsRune(skeemaFile, os.PathSeparator) {
+		Exit(OptionError(cfg, "skeema-file", NewExitValue(CodeBadConfig, "Option skeema-file must be a filename without any directory path; found \"%s\"", skeemaFile)))
+	}
+
+	if _, err := cfg.GetEnum("layout", "per-table", "single-file"); err != nil {
+		Exit(OptionError(cfg, "layout", NewExitValue(CodeBadConfig, "%s", err.Error())))
+	}
+	if _, err := cfg.GetEnum("filename-policy", "unicode", "ascii", "transliterate"); err != nil {
+		Exit(OptionError(cfg, "filename-policy", NewExitValue(CodeBadConfig, "%s", err.Error())))
+	}
+
 	// The host and schema options are special -- most commands only expect
 	// to find them when recursively crawling directory configs. So if these
-	// options have been set globally (via CLI or a global config file), and
-	// the current subcommand hasn't explicitly overridden these options (as
-	// init and add-environment do), silently ignore the value.
+	// options have been set globally (via CLI or a global config file), and the
+	// current subcommand hasn't explicitly overridden these options (as init and
+	// add-environment do), silently ignore the value. Values from SKEEMA_HOST
+	// and SKEEMA_SCHEMA are exempt, so that containerized deployments may supply
+	// these for dirs which don't specify them; since environment variables have
+	// the lowest precedence, any dir's option files still override them.
 	for _, name := range []string{"host", "schema"} {
-		if cfg.Changed(name) && cfg.FindOption(name) == CommandSuite.Options()[name] {
-			cfg.CLI.OptionValues[name] = ""
-			cfg.MarkDirty()
+		if !cfg.Changed(name) || cfg.FindOption(name) != CommandSuite.Options()[name] {
+			continue
+		}
+		if _, fromEnv := cfg.Source(name).(envVarSource); !fromEnv {
+			setCLIOptionValue(cfg, name, "")
 		}
 	}
 
 	// Special handling for password option: supplying it with no value prompts on STDIN
 	if cfg.Get("password") == "" {
-		var err error
-		cfg.CLI.OptionValues["password"], err = PromptPassword()
+		password, err := PromptPassword()
 		if err != nil {
-			Exit(NewExitValue(CodeNoInput, err.Error()))
+			Exit(NewExitValue(CodeNoInput, "%s", err.Error()))
 		}
-		cfg.MarkDirty()
+		setCLIOptionValue(cfg, "password", password)
 		fmt.Println()
 	}
+	if cfg.Changed("password") {
+		Secrets.Add(cfg.Get("password"))
+	}
 
 	if cfg.GetBool("debug") {
 		log.SetLevel(log.DebugLevel)
 	}
 }
 
+// setCLIOptionValue overrides an option's value as if it had been supplied on
+// the command-line. This mutates the shared cfg.CLI.OptionValues map: the
+// CommandLine is shared by cfg and every Config cloned from it, and clones do
+// not notice the change. So this must only be called while a handler is
+// setting up cfg, before any Clone of cfg (including via NewDir), and before
+// any other goroutine may read it.
+func setCLIOptionValue(cfg *mybase.Config, name, value string) {
+	cfg.CLI.OptionValues[name] = value
+	cfg.MarkDirty()
+}
+
 // PromptPassword reads a password from STDIN without echoing the typed
 // characters. Requires that STDIN is a TTY.
 func PromptPassword() (string, error) {
@@ -119,6 +286,153 @@ func PromptPassword() (string, error) {
 	return string(bytePassword), nil
 }
 
+// OptionSource returns a human-readable description of where cfg obtained its
+// value for the named option, such as a specific option file and section, for
+// use in error messages.
+func OptionSource(cfg *mybase.Config, name string) string {
+	cfg.Get(name) // cfg.Source does not rebuild a dirty config, but cfg.Get does
+	switch source := cfg.Source(name).(type) {
+	case *mybase.CommandLine:
+		return "the command-line"
+	case *mybase.File:
+		sections := source.SectionsWithOption(name)
+		var environment string
+		if _, ok := cfg.CLI.Command.OptionValue("environment"); ok {
+			environment = cfg.Get("environment")
+		}
+		for _, section := range sections {
+			if section == environment && section != "" {
+				return fmt.Sprintf("option file %s section [%s]", source.Path(), section)
+			}
+		}
+		for _, section := range sections {
+			if section == "" {
+				return fmt.Sprintf("option file %s", source.Path())
+			}
+		}
+		if len(sections) > 0 {
+			return fmt.Sprintf("option file %s section [%s]", source.Path(), sections[0])
+		}
+		return fmt.Sprintf("option file %s", source.Path())
+	case envVarSource:
+		return fmt.Sprintf("environment variable %s", EnvVarName(name))
+	case profileSource:
+		return fmt.Sprintf("connection profile %s", cfg.Get("profile"))
+	case defaultSchemaSource:
+		return "default-schema-dir"
+	case hostOverrideSource:
+		return fmt.Sprintf("the %s option", source.optionName)
+	case *mybase.Command:
+		return "its default value"
+	default:
+		return "an unknown source"
+	}
+}
+
+// OptionError returns an error indicating that the value of the named option
+// caused err, and where that value was obtained. If err is an *ExitValue, its
+// Code and Outcome are preserved.
+func OptionError(cfg *mybase.Config, name string, err error) error {
+	message := fmt.Sprintf("%s (%s supplied by %s)", err, name, OptionSource(cfg, name))
+	if ev, ok := err.(*ExitValue); ok {
+		return &ExitValue{Code: ev.Code, Outcome: ev.Outcome, message: message}
+	}
+	return errors.New(message)
+}
+
+// ParseOptionFile parses f using the options known to cfg. Normally an unknown
+// option in the file results in an error. However, if loose-config is enabled,
+// a warning is logged instead and any unknown options are ignored. This permits
+// option files written for newer versions of Skeema to be used with older
+// versions, when desired.
+func ParseOptionFile(f *mybase.File, cfg *mybase.Config) error {
+	err := f.Parse(cfg)
+	if _, unknown := err.(mybase.OptionNotDefinedError); unknown && cfg.GetBool("loose-config") {
+		log.Warnf("%s; ignoring unknown options in this file since loose-config is enabled", err)
+		f.IgnoreUnknownOptions = true
+		err = f.Parse(cfg)
+	}
+	if err == nil {
+		err = checkNoEnvironment(f)
+	}
+	return err
+}
+
+// checkNoEnvironment returns an error if parsed option file f sets the
+// environment option. The environment name determines which section of each
+// option file is used, so it may only be supplied via the command-line or the
+// SKEEMA_ENVIRONMENT environment variable.
+func checkNoEnvironment(f *mybase.File) error {
+	if f.SomeSectionHasOption("environment") {
+		return fmt.Errorf("Option environment cannot be set in option file %s; supply it on the command-line or via SKEEMA_ENVIRONMENT instead", f.Path())
+	}
+	return nil
+}
+
+// CheckRequiredVersion returns an error if f sets requires-skeema-version to a
+// version newer than the running version of Skeema. If loose-config is
+// enabled, a warning is logged instead and nil is returned. f must already be
+// parsed, with the desired section selected.
+func CheckRequiredVersion(f *mybase.File, cfg *mybase.Config) error {
+	required, ok := f.OptionValue("requires-skeema-version")
+	if !ok || required == "" {
+		return nil
+	}
+	requiredParts, err := parseVersion(required)
+	if err != nil {
+		return fmt.Errorf("%s: invalid value for requires-skeema-version: %s", f.Path(), err)
+	}
+	runningParts, _ := parseVersion(version)
+	if compareVersions(runningParts, requiredParts) >= 0 {
+		return nil
+	}
+	err = fmt.Errorf("%s requires Skeema version %s or newer, but this is version %s", f.Path(), required, version)
+	if cfg.GetBool("loose-config") {
+		log.Warnf("%s; proceeding anyway since loose-config is enabled", err)
+		return nil
+	}
+	return err
+}
+
+// parseVersion converts a version string such as "1.2.3" into a slice of ints.
+// Any suffix beginning with a space or hyphen, such as " (beta)" or "-rc1", is
+// ignored.
+func parseVersion(value string) ([]int, error) {
+	value = strings.TrimPrefix(strings.TrimSpace(value), "v")
+	if end := strings.IndexAny(value, " -"); end > -1 {
+		value = value[:end]
+	}
+	tokens := strings.Split(value, ".")
+	result := make([]int, len(tokens))
+	for n, token := range tokens {
+		part, err := strconv.Atoi(token)
+		if err != nil || part < 0 {
+			return nil, fmt.Errorf("cannot parse version \"%s\"", value)
+		}
+		result[n] = part
+	}
+	return result, nil
+}
+
+// compareVersions returns a negative number if a is older than b, a positive
+// number if a is newer than b, or 0 if they are equal. Missing trailing
+// components are treated as 0.
+func compareVersions(a, b []int) int {
+	for n := 0; n < len(a) || n < len(b); n++ {
+		var partA, partB int
+		if n < len(a) {
+			partA = a[n]
+		}
+		if n < len(b) {
+			partB = b[n]
+		}
+		if partA != partB {
+			return partA - partB
+		}
+	}
+	return 0
+}
+
 // SplitConnectOptions takes a string containing a comma-separated list of
 // connection options (typically obtained from the "connect-options" option)
 // and splits them into a map of individual key: value strings. This function
@@ -185,6 +499,18 @@ func SplitConnectOptions(connectOpts string) (map[string]string, error) {
 	return result, nil
 }
 
+// OptionFileValue returns value in a form suitable for writing to an option
+// file, such that it will be read back verbatim. Values containing quotes,
+// backslashes, commas, hash characters, or leading or trailing whitespace are
+// wrapped in double quotes, with any double quotes and backslashes escaped.
+func OptionFileValue(value string) string {
+	if value == strings.TrimSpace(value) && !strings.ContainsAny(value, "#'\"`\\,") {
+		return value
+	}
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+	return fmt.Sprintf(`"%s"`, replacer.Replace(value))
+}
+
 // RealConnectOptions takes a comma-separated string of connection options,
 // strips any Go driver-specific ones, and then returns the new string which
 // is now suitable for passing to an external tool.
@@ -1,9 +1,14 @@
 package main
 
 import (
+	"io/ioutil"
+	"os"
+	"path"
 	"reflect"
 	"strings"
 	"testing"
+
+	"github.com/skeema/mybase"
 )
 
 func TestSplitConnectOptions(t *testing.T) {
@@ -64,3 +69,377 @@ func TestRealConnectOptions(t *testing.T) {
 	assertResult("strict=1,foo=2,charset='utf8mb4,utf8'", "foo=2")
 	assertResult("timeout=10ms,TIMEOUT=20ms,timeOut=30ms", "")
 }
+
+func TestCompareVersions(t *testing.T) {
+	cases := []struct {
+		a, b     string
+		expected int // only the sign is compared
+	}{
+		{"0.2 (beta)", "0.2", 0},
+		{"0.2", "0.2.0", 0},
+		{"0.2", "0.3", -1},
+		{"v1.10", "1.9.5", 1},
+		{"2.0-rc1", "1.99", 1},
+	}
+	for _, c := range cases {
+		a, errA := parseVersion(c.a)
+		b, errB := parseVersion(c.b)
+		if errA != nil || errB != nil {
+			t.Errorf("Unexpected error parsing versions %s, %s: %v, %v", c.a, c.b, errA, errB)
+			continue
+		}
+		actual := compareVersions(a, b)
+		if (actual < 0 && c.expected >= 0) || (actual > 0 && c.expected <= 0) || (actual == 0 && c.expected != 0) {
+			t.Errorf("Expected compareVersions(%s, %s) to have sign of %d, instead found %d", c.a, c.b, c.expected, actual)
+		}
+	}
+	if _, err := parseVersion("1.x"); err == nil {
+		t.Error("Expected error parsing invalid version, but it was nil")
+	}
+}
+
+func TestParseOptionFileLoose(t *testing.T) {
+	dirPath, err := ioutil.TempDir("", "skeematest")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dirPath)
+	contents := "requires-skeema-version=99.0\nsome-future-option=1\nschema=foo\n"
+	if err := ioutil.WriteFile(path.Join(dirPath, ".skeema"), []byte(contents), 0666); err != nil {
+		t.Fatalf("Unable to write option file: %s", err)
+	}
+	getConfig := func(loose string) *mybase.Config {
+		cmd := mybase.NewCommand("test", "1.0", "this is for testing", nil)
+		AddGlobalOptions(cmd)
+		cli := &mybase.CommandLine{Command: cmd}
+		return mybase.NewConfig(cli, dummySource(map[string]string{"loose-config": loose}))
+	}
+
+	f := mybase.NewFile(dirPath, ".skeema")
+	if err := ParseOptionFile(f, getConfig("0")); err == nil {
+		t.Error("Expected unknown option to cause an error without loose-config, but it did not")
+	}
+	f = mybase.NewFile(dirPath, ".skeema")
+	cfg := getConfig("1")
+	if err := ParseOptionFile(f, cfg); err != nil {
+		t.Fatalf("Unexpected error parsing with loose-config: %s", err)
+	}
+	if value, _ := f.OptionValue("schema"); value != "foo" {
+		t.Errorf("Expected schema to be parsed as foo, instead found %s", value)
+	}
+	if err := CheckRequiredVersion(f, cfg); err != nil {
+		t.Errorf("Expected no error from CheckRequiredVersion with loose-config, instead found %s", err)
+	}
+	if err := CheckRequiredVersion(f, getConfig("0")); err == nil {
+		t.Error("Expected error from CheckRequiredVersion without loose-config, but it was nil")
+	}
+}
+
+func TestParseOptionFileEnvironment(t *testing.T) {
+	dirPath, err := ioutil.TempDir("", "skeematest")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dirPath)
+	cmd := mybase.NewCommand("test", "1.0", "this is for testing", nil)
+	AddGlobalOptions(cmd)
+	cfg := mybase.NewConfig(&mybase.CommandLine{Command: cmd})
+
+	for contents, expectErr := range map[string]bool{
+		"schema=foo\n":                         false,
+		"environment=staging\n":                true,
+		"schema=foo\n[qa]\nenvironment=prod\n": true,
+	} {
+		if err := ioutil.WriteFile(path.Join(dirPath, ".skeema"), []byte(contents), 0666); err != nil {
+			t.Fatalf("Unable to write option file: %s", err)
+		}
+		f := mybase.NewFile(dirPath, ".skeema")
+		if err := ParseOptionFile(f, cfg); expectErr && err == nil {
+			t.Errorf("Expected option file %q to cause an error, but it did not", contents)
+		} else if !expectErr && err != nil {
+			t.Errorf("Unexpected error parsing option file %q: %s", contents, err)
+		}
+	}
+}
+
+func TestEnvironmentSources(t *testing.T) {
+	origHome, origEnv := os.Getenv("HOME"), os.Getenv("SKEEMA_ENVIRONMENT")
+	defer func() {
+		os.Setenv("HOME", origHome)
+		os.Setenv("SKEEMA_ENVIRONMENT", origEnv)
+	}()
+	os.Setenv("HOME", "/nonexistent") // avoid interference from the user's own option files
+
+	getConfig := func(flagValue string, argValues ...string) *mybase.Config {
+		cmd := mybase.NewCommand("test", "1.0", "this is for testing", nil)
+		AddGlobalOptions(cmd)
+		cmd.AddArg("environment", "production", false)
+		cli := &mybase.CommandLine{Command: cmd, OptionValues: map[string]string{}, ArgValues: argValues}
+		if flagValue != "" {
+			cli.OptionValues["environment"] = flagValue
+		}
+		cfg := mybase.NewConfig(cli)
+		AddGlobalConfigFiles(cfg)
+		return cfg
+	}
+
+	os.Setenv("SKEEMA_ENVIRONMENT", "")
+	if actual := getConfig("").Get("environment"); actual != "production" {
+		t.Errorf("Expected default environment production, instead found %s", actual)
+	}
+	if actual := getConfig("staging").Get("environment"); actual != "staging" {
+		t.Errorf("Expected environment from flag, instead found %s", actual)
+	}
+	os.Setenv("SKEEMA_ENVIRONMENT", "qa")
+	if actual := getConfig("").Get("environment"); actual != "qa" {
+		t.Errorf("Expected environment from SKEEMA_ENVIRONMENT, instead found %s", actual)
+	}
+	if actual := getConfig("staging").Get("environment"); actual != "staging" {
+		t.Errorf("Expected flag to take precedence over SKEEMA_ENVIRONMENT, instead found %s", actual)
+	}
+	if actual := getConfig("", "development").Get("environment"); actual != "development" {
+		t.Errorf("Expected positional arg to take precedence over SKEEMA_ENVIRONMENT, instead found %s", actual)
+	}
+}
+
+func TestEnvVarSource(t *testing.T) {
+	if actual := EnvVarName("connect-options"); actual != "SKEEMA_CONNECT_OPTIONS" {
+		t.Errorf("Unexpected result from EnvVarName: %s", actual)
+	}
+
+	origHome := os.Getenv("HOME")
+	names := []string{"SKEEMA_USER", "SKEEMA_PORT", "SKEEMA_DEBUG", "SKEEMA_TEMP_SCHEMA"}
+	origValues := make([]string, len(names))
+	for n, name := range names {
+		origValues[n] = os.Getenv(name)
+	}
+	defer func() {
+		os.Setenv("HOME", origHome)
+		for n, name := range names {
+			os.Setenv(name, origValues[n])
+		}
+	}()
+	os.Setenv("HOME", "/nonexistent") // avoid interference from the user's own option files
+	os.Setenv("SKEEMA_USER", "deployer")
+	os.Setenv("SKEEMA_PORT", "3307")
+	os.Setenv("SKEEMA_DEBUG", "")
+	os.Setenv("SKEEMA_TEMP_SCHEMA", "from_env")
+
+	cmd := mybase.NewCommand("test", "1.0", "this is for testing", nil)
+	AddGlobalOptions(cmd)
+	cmd.AddArg("environment", "production", false)
+	source := NewEnvVarSource(cmd)
+	if len(source) != 3 {
+		t.Errorf("Expected 3 values in source, instead found %d: %v", len(source), source)
+	}
+	if _, ok := source.OptionValue("debug"); ok {
+		t.Error("Expected blank environment variable to be ignored")
+	}
+
+	cli := &mybase.CommandLine{Command: cmd, OptionValues: map[string]string{"temp-schema": "from_cli"}}
+	cfg := mybase.NewConfig(cli)
+	AddGlobalConfigFiles(cfg)
+	if cfg.Get("user") != "deployer" || cfg.Get("port") != "3307" {
+		t.Errorf("Expected options from environment variables, instead found user=%s port=%s", cfg.Get("user"), cfg.Get("port"))
+	}
+	if cfg.Get("temp-schema") != "from_cli" {
+		t.Errorf("Expected command-line to take precedence over environment variable, instead found %s", cfg.Get("temp-schema"))
+	}
+
+	// Option files take precedence over environment variables
+	dir, err := ioutil.TempDir("", "skeema-test-envvar")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(path.Join(dir, ".skeema"), []byte("port=3308\n"), 0666); err != nil {
+		t.Fatalf("Unable to write option file: %s", err)
+	}
+	cfg = mybase.NewConfig(cli)
+	AddGlobalConfigFiles(cfg)
+	d, err := NewDir(dir, cfg)
+	if err != nil {
+		t.Fatalf("Unexpected error from NewDir: %s", err)
+	}
+	if d.Config.Get("port") != "3308" || d.Config.Get("user") != "deployer" {
+		t.Errorf("Expected option file to take precedence over environment variable, instead found user=%s port=%s", d.Config.Get("user"), d.Config.Get("port"))
+	}
+}
+
+func TestSkipParentConfigs(t *testing.T) {
+	home, err := ioutil.TempDir("", "skeema-test-home")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(home)
+	if err := ioutil.WriteFile(path.Join(home, ".skeema"), []byte("user=fromhome\n"), 0666); err != nil {
+		t.Fatalf("Unable to write option file: %s", err)
+	}
+	origHome := os.Getenv("HOME")
+	defer os.Setenv("HOME", origHome)
+	os.Setenv("HOME", home)
+
+	getConfig := func(cliValues map[string]string) *mybase.Config {
+		cmd := mybase.NewCommand("test", "1.0", "this is for testing", nil)
+		AddGlobalOptions(cmd)
+		cmd.AddArg("environment", "production", false)
+		cfg := mybase.NewConfig(&mybase.CommandLine{Command: cmd, OptionValues: cliValues})
+		AddGlobalConfigFiles(cfg)
+		return cfg
+	}
+	if actual := getConfig(map[string]string{}).Get("user"); actual != "fromhome" {
+		t.Errorf("Expected global option file to be used, instead found user=%s", actual)
+	}
+	if actual := getConfig(map[string]string{"parent-configs": "0"}).Get("user"); actual != "root" {
+		t.Errorf("Expected global option file to be skipped, instead found user=%s", actual)
+	}
+	if actual := getConfig(map[string]string{"no-global-config": "1"}).Get("user"); actual != "root" {
+		t.Errorf("Expected global option file to be skipped with no-global-config, instead found user=%s", actual)
+	}
+}
+
+func TestGlobalConfigPaths(t *testing.T) {
+	names := []string{"HOME", "XDG_CONFIG_HOME", "SKEEMA_CONFIG_PATH"}
+	origValues := make([]string, len(names))
+	for n, name := range names {
+		origValues[n] = os.Getenv(name)
+	}
+	defer func() {
+		for n, name := range names {
+			os.Setenv(name, origValues[n])
+		}
+	}()
+	home, err := ioutil.TempDir("", "skeema-test-home")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(home)
+	os.Setenv("HOME", home)
+	os.Setenv("SKEEMA_CONFIG_PATH", "")
+
+	assertPaths := func(expectedTail ...string) {
+		paths := GlobalConfigPaths()
+		if len(paths) < len(expectedTail) {
+			t.Fatalf("Expected at least %d paths, instead found %v", len(expectedTail), paths)
+		}
+		actualTail := paths[len(paths)-len(expectedTail):]
+		for n := range expectedTail {
+			if actualTail[n] != expectedTail[n] {
+				t.Errorf("Expected paths to end with %v, instead found %v", expectedTail, paths)
+				return
+			}
+		}
+	}
+	os.Setenv("XDG_CONFIG_HOME", "")
+	assertPaths(path.Join(home, ".config/skeema/config"), path.Join(home, ".my.cnf"), path.Join(home, ".skeema"))
+	os.Setenv("XDG_CONFIG_HOME", "/xdg")
+	assertPaths("/xdg/skeema/config", path.Join(home, ".my.cnf"), path.Join(home, ".skeema"))
+	os.Setenv("XDG_CONFIG_HOME", "relative/xdg")
+	assertPaths(path.Join(home, ".config/skeema/config"), path.Join(home, ".my.cnf"), path.Join(home, ".skeema"))
+
+	// SKEEMA_CONFIG_PATH replaces the defaults; directories map to a config file
+	// inside of them
+	if err := os.Mkdir(path.Join(home, "confdir"), 0777); err != nil {
+		t.Fatalf("Unable to create dir: %s", err)
+	}
+	os.Setenv("SKEEMA_CONFIG_PATH", path.Join(home, "confdir")+string(os.PathListSeparator)+path.Join(home, "other.cnf"))
+	if paths := GlobalConfigPaths(); len(paths) != 2 || paths[0] != path.Join(home, "confdir/config") || paths[1] != path.Join(home, "other.cnf") {
+		t.Errorf("Unexpected paths from SKEEMA_CONFIG_PATH: %v", paths)
+	}
+
+	// Later paths take precedence
+	if err := ioutil.WriteFile(path.Join(home, "confdir/config"), []byte("user=fromdir\nport=3307\n"), 0666); err != nil {
+		t.Fatalf("Unable to write option file: %s", err)
+	}
+	if err := ioutil.WriteFile(path.Join(home, "other.cnf"), []byte("user=fromother\n"), 0666); err != nil {
+		t.Fatalf("Unable to write option file: %s", err)
+	}
+	cmd := mybase.NewCommand("test", "1.0", "this is for testing", nil)
+	AddGlobalOptions(cmd)
+	cmd.AddArg("environment", "production", false)
+	cfg := mybase.NewConfig(&mybase.CommandLine{Command: cmd, OptionValues: map[string]string{}})
+	AddGlobalConfigFiles(cfg)
+	if cfg.Get("user") != "fromother" || cfg.Get("port") != "3307" {
+		t.Errorf("Unexpected option values from SKEEMA_CONFIG_PATH files: user=%s port=%s", cfg.Get("user"), cfg.Get("port"))
+	}
+}
+
+func TestOptionSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "skeema-test-source")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	contents := "port=3307\n\n[production]\nuser=produser\n\n[staging]\nuser=stguser\n"
+	if err := ioutil.WriteFile(path.Join(dir, ".skeema"), []byte(contents), 0666); err != nil {
+		t.Fatalf("Unable to write option file: %s", err)
+	}
+
+	cmd := mybase.NewCommand("test", "1.0", "this is for testing", nil)
+	AddGlobalOptions(cmd)
+	cmd.AddArg("environment", "production", false)
+	cli := &mybase.CommandLine{Command: cmd, OptionValues: map[string]string{"temp-schema": "foo"}}
+	cfg := mybase.NewConfig(cli, envVarSource{"socket": "/var/run/mysqld.sock"})
+	f := mybase.NewFile(dir, ".skeema")
+	if err := f.Read(); err != nil {
+		t.Fatalf("Unexpected error reading option file: %s", err)
+	}
+	if err := f.Parse(cfg); err != nil {
+		t.Fatalf("Unexpected error parsing option file: %s", err)
+	}
+	_ = f.UseSection("production")
+	cfg.AddSource(f)
+	cfg.AddSource(hostOverrideSource{optionName: "workspace-host", values: map[string]string{"host": "ws.example.com"}})
+
+	expected := map[string]string{
+		"host":        "the workspace-host option",
+		"temp-schema": "the command-line",
+		"user":        "option file " + f.Path() + " section [production]",
+		"port":        "option file " + f.Path(),
+		"socket":      "environment variable SKEEMA_SOCKET",
+		"debug":       "its default value",
+	}
+	for name, expect := range expected {
+		if actual := OptionSource(cfg, name); actual != expect {
+			t.Errorf("Expected OptionSource(cfg, \"%s\") to return \"%s\", instead found \"%s\"", name, expect, actual)
+		}
+	}
+
+	err = OptionError(cfg, "port", NewExitValue(CodeBadConfig, "bad port"))
+	if ev, ok := err.(*ExitValue); !ok || ev.Code != CodeBadConfig {
+		t.Errorf("Expected OptionError to preserve ExitValue code, instead found %+v", err)
+	} else if expect := "bad port (port supplied by option file " + f.Path() + ")"; ev.Error() != expect {
+		t.Errorf("Expected error message \"%s\", instead found \"%s\"", expect, ev.Error())
+	}
+}
+
+func TestOptionFileValue(t *testing.T) {
+	dirPath, err := ioutil.TempDir("", "skeematest")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dirPath)
+
+	names := []string{"plain", "café", "has#hash", "has,comma", `we're "quoted"`, `back\slash`, " padded ", "`backticks`"}
+	for _, name := range names {
+		f := mybase.NewFile(dirPath, ".skeema")
+		f.SetOptionValue("", "schema", OptionFileValue(name))
+		if err := f.Write(true); err != nil {
+			t.Fatalf("Unable to write option file: %s", err)
+		}
+		cmd := mybase.NewCommand("test", "1.0", "this is for testing", nil)
+		AddGlobalOptions(cmd)
+		cfg := mybase.NewConfig(&mybase.CommandLine{Command: cmd})
+		f = mybase.NewFile(dirPath, ".skeema")
+		if err := ParseOptionFile(f, cfg); err != nil {
+			t.Fatalf("Unexpected error parsing option file for %q: %s", name, err)
+		}
+		cfg.AddSource(f)
+		dir := &Dir{Path: dirPath, Config: cfg}
+		if schemaNames, err := dir.SchemaNames(nil); err != nil {
+			t.Errorf("Unexpected error from SchemaNames for %q: %s", name, err)
+		} else if len(schemaNames) != 1 || schemaNames[0] != name {
+			t.Errorf("Expected schema name %q to round-trip, instead found %q", name, schemaNames)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/VividCortex/mysqlerr"
+	"github.com/go-sql-driver/mysql"
+	"github.com/skeema/mybase"
+	"github.com/skeema/tengo"
+)
+
+// ConnectErrorKind classifies the underlying cause of a failed connection
+// attempt.
+type ConnectErrorKind string
+
+// Constants representing the classifications of connection errors.
+const (
+	ConnectErrorUnknown   ConnectErrorKind = "unknown"
+	ConnectErrorDNS       ConnectErrorKind = "dns"
+	ConnectErrorRefused   ConnectErrorKind = "refused"
+	ConnectErrorTimeout   ConnectErrorKind = "timeout"
+	ConnectErrorAuth      ConnectErrorKind = "auth"
+	ConnectErrorPrivilege ConnectErrorKind = "privilege"
+	ConnectErrorTLS       ConnectErrorKind = "tls"
+)
+
+// probePorts lists ports that are tried when a TCP connection is refused, in
+// order to suggest a likely port to the user.
+var probePorts = []int{3306, 3307, 3308, 3310, 33060}
+
+// ConnectError wraps an error from connecting to an instance, adding a
+// classification of the problem and an actionable hint for the user.
+type ConnectError struct {
+	Instance *tengo.Instance
+	Kind     ConnectErrorKind
+	Hint     string
+	Option   string // name of option most likely responsible for the problem, if known
+	Err      error
+}
+
+// Error returns an error string, satisfying the Go builtin error interface.
+func (ce *ConnectError) Error() string {
+	if ce.Hint == "" {
+		return ce.Err.Error()
+	}
+	return fmt.Sprintf("%s (%s)", ce.Err, ce.Hint)
+}
+
+// NewConnectError examines an error returned from attempting to connect to
+// instance, and returns a *ConnectError classifying it. If err is nil, nil is
+// returned.
+func NewConnectError(instance *tengo.Instance, err error) *ConnectError {
+	if err == nil {
+		return nil
+	}
+	ce := &ConnectError{
+		Instance: instance,
+		Kind:     ConnectErrorUnknown,
+		Err:      err,
+	}
+
+	if merr, ok := err.(*mysql.MySQLError); ok {
+		switch merr.Number {
+		case mysqlerr.ER_ACCESS_DENIED_ERROR, mysqlerr.ER_ACCESS_DENIED_NO_PASSWORD_ERROR:
+			ce.Kind = ConnectErrorAuth
+			ce.Hint = "check the user and password options, and confirm the user is permitted to connect from this client host"
+		case mysqlerr.ER_HOST_NOT_PRIVILEGED, mysqlerr.ER_HOST_IS_BLOCKED:
+			ce.Kind = ConnectErrorAuth
+			ce.Hint = "the server is refusing connections from this client host"
+		case mysqlerr.ER_DBACCESS_DENIED_ERROR, mysqlerr.ER_SPECIFIC_ACCESS_DENIED_ERROR, mysqlerr.ER_TABLEACCESS_DENIED_ERROR:
+			ce.Kind = ConnectErrorPrivilege
+			ce.Hint = "the user is missing a required privilege; see the requirements documentation"
+		}
+		return ce
+	}
+
+	if opErr, ok := err.(*net.OpError); ok {
+		if _, isDNS := opErr.Err.(*net.DNSError); isDNS {
+			ce.Kind = ConnectErrorDNS
+			ce.Hint = "hostname could not be resolved; check the host option for typos"
+			ce.Option = "host"
+			return ce
+		}
+		if opErr.Timeout() {
+			ce.Kind = ConnectErrorTimeout
+			ce.Hint = "host did not respond; check firewall rules, or increase timeout via connect-options"
+			ce.Option = "host"
+			return ce
+		}
+		if isConnRefused(opErr) {
+			ce.Kind = ConnectErrorRefused
+			ce.Hint = probeHint(instance)
+			ce.Option = "port"
+			if instance != nil && instance.SocketPath != "" {
+				ce.Option = "socket"
+			}
+			return ce
+		}
+	}
+
+	switch err.(type) {
+	case x509.CertificateInvalidError, x509.HostnameError, x509.UnknownAuthorityError, tls.RecordHeaderError:
+		ce.Kind = ConnectErrorTLS
+		ce.Hint = "TLS negotiation failed; check the tls setting in connect-options"
+		ce.Option = "connect-options"
+		return ce
+	}
+	if strings.HasPrefix(err.Error(), "tls:") || strings.HasPrefix(err.Error(), "x509:") {
+		ce.Kind = ConnectErrorTLS
+		ce.Hint = "TLS negotiation failed; check the tls setting in connect-options"
+		ce.Option = "connect-options"
+	}
+	return ce
+}
+
+// TraceOption adds to ce's hint a description of where cfg obtained the value
+// of the option most likely responsible for the problem, if known.
+func (ce *ConnectError) TraceOption(cfg *mybase.Config) {
+	if ce.Option == "" || ce.Hint == "" {
+		return
+	}
+	ce.Hint = fmt.Sprintf("%s; %s supplied by %s", ce.Hint, ce.Option, OptionSource(cfg, ce.Option))
+}
+
+// isConnRefused returns true if opErr indicates the remote host actively
+// refused a TCP connection.
+func isConnRefused(opErr *net.OpError) bool {
+	if sysErr, ok := opErr.Err.(*os.SyscallError); ok {
+		return sysErr.Err == syscall.ECONNREFUSED
+	}
+	return strings.Contains(opErr.Err.Error(), "connection refused")
+}
+
+// probeHint attempts TCP connections to common alternative MySQL ports on the
+// instance's host, returning a hint suggesting any port that accepted a
+// connection. Instances using a UNIX domain socket are not probed.
+func probeHint(instance *tengo.Instance) string {
+	if instance == nil {
+		return ""
+	} else if instance.SocketPath != "" {
+		return "check the socket option and confirm mysqld is running"
+	}
+	for _, port := range probePorts {
+		if port == instance.Port {
+			continue
+		}
+		addr := net.JoinHostPort(instance.Host, strconv.Itoa(port))
+		conn, err := net.DialTimeout("tcp", addr, 500*time.Millisecond)
+		if err == nil {
+			conn.Close()
+			return fmt.Sprintf("nothing is listening on port %d, but port %d accepted a connection; check the port option", instance.Port, port)
+		}
+	}
+	return fmt.Sprintf("nothing is listening on port %d; check the port option and confirm mysqld is running", instance.Port)
+}
+
+// CheckConnect verifies that instance can be connected to, returning a
+// *ConnectError if not.
+func CheckConnect(instance *tengo.Instance) error {
+	if ok, err := instance.CanConnect(); !ok {
+		return NewConnectError(instance, err)
+	}
+	return nil
+}
+
+// connectCheckResult tracks the outcome of checking connectivity to a single
+// instance, along with how many dirs map to that instance.
+type connectCheckResult struct {
+	instance *tengo.Instance
+	dirCount int
+	err      error
+}
+
+// CheckConnectivity tests connectivity to every instance that dir and its
+// subdirs map to, in parallel, and then outputs a table of the results to
+// STDOUT. An error is returned if any instance could not be reached.
+func CheckConnectivity(dir *Dir) error {
+	results := make(map[string]*connectCheckResult)
+	var order []string
+	var configErrCount int
+	var walk func(*Dir) error
+	walk = func(d *Dir) error {
+		if d.AllowsEnvironment() && d.HostConfigured() && d.HasSchema() {
+			instances, err := d.Instances()
+			if err != nil {
+				log.Errorf("Skipping %s: %s", d, err)
+				configErrCount++
+			}
+			for _, inst := range instances {
+				key := inst.String()
+				if results[key] == nil {
+					results[key] = &connectCheckResult{instance: inst}
+					order = append(order, key)
+				}
+				results[key].dirCount++
+			}
+		}
+		subdirs, err := d.Subdirs()
+		if err != nil {
+			return err
+		}
+		for _, subdir := range subdirs {
+			if subdir.BaseName()[0] != '.' {
+				if err := walk(subdir); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	if err := walk(dir); err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	for _, result := range results {
+		wg.Add(1)
+		go func(result *connectCheckResult) {
+			defer wg.Done()
+			result.err = CheckConnect(result.instance)
+		}(result)
+	}
+	wg.Wait()
+
+	var failCount int
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "INSTANCE\tSTATUS\tDIRS\tDETAIL")
+	for _, key := range order {
+		result := results[key]
+		status, detail := "ok", ""
+		if result.err != nil {
+			failCount++
+			status, detail = string(ConnectErrorUnknown), result.err.Error()
+			if ce, ok := result.err.(*ConnectError); ok {
+				status = string(ce.Kind)
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\n", key, status, result.dirCount, detail)
+	}
+	w.Flush()
+
+	if configErrCount > 0 {
+		return NewExitValue(CodeBadConfig, "Skipped %d dirs due to invalid configuration; unable to connect to %d of %d instances", configErrCount, failCount, len(results))
+	} else if failCount > 0 {
+		return NewExitValue(CodeFatalError, "Unable to connect to %d of %d instances", failCount, len(results)).WithOutcome(OutcomeConnectionFailure)
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/VividCortex/mysqlerr"
+	"github.com/go-sql-driver/mysql"
+)
+
+func TestNewConnectError(t *testing.T) {
+	if ce := NewConnectError(nil, nil); ce != nil {
+		t.Errorf("Expected nil error to yield nil ConnectError, instead found %+v", ce)
+	}
+
+	expectKind := map[error]ConnectErrorKind{
+		&mysql.MySQLError{Number: mysqlerr.ER_ACCESS_DENIED_ERROR}:   ConnectErrorAuth,
+		&mysql.MySQLError{Number: mysqlerr.ER_DBACCESS_DENIED_ERROR}: ConnectErrorPrivilege,
+		&mysql.MySQLError{Number: mysqlerr.ER_PARSE_ERROR}:           ConnectErrorUnknown,
+		&net.OpError{Op: "dial", Err: &net.DNSError{Name: "bogus"}}:  ConnectErrorDNS,
+		errors.New("x509: certificate signed by unknown authority"):  ConnectErrorTLS,
+		errors.New("something else entirely"):                        ConnectErrorUnknown,
+	}
+	for err, expected := range expectKind {
+		if ce := NewConnectError(nil, err); ce.Kind != expected {
+			t.Errorf("Expected error %q to be classified as %s, instead found %s", err, expected, ce.Kind)
+		} else if ce.Kind != ConnectErrorUnknown && ce.Hint == "" {
+			t.Errorf("Expected error %q to have a hint, but it did not", err)
+		}
+	}
+}
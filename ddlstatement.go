@@ -1,12 +1,23 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
+	"github.com/VividCortex/mysqlerr"
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
 	"github.com/skeema/tengo"
 )
 
@@ -21,8 +32,23 @@ type DDLStatement struct {
 	// command)
 	Err error
 
-	stmt     string
-	shellOut *ShellOut
+	// Warnings contains any warnings reported by the server upon executing the
+	// statement. It is only populated for DDL run directly against a DB.
+	Warnings []ServerWarning
+
+	id        string // see StatementID
+	stmt      string
+	preStmt   string // from pre-statement-sql, unless it is a comment hint
+	postStmt  string // from post-statement-sql, unless it is a comment hint
+	preHint   string // from pre-statement-sql, if it is a comment hint
+	postHint  string // from post-statement-sql, if it is a comment hint
+	shellOut  *ShellOut
+	ddlFile   string // if non-empty, path to write stmt to prior to running shellOut
+	tableName string
+	risky     bool // ALTER TABLE that is potentially destructive or run by an external tool
+
+	retries      int           // max retries upon lock wait timeout or deadlock
+	retryBackoff time.Duration // delay before first retry; doubles for each subsequent retry
 
 	instance   *tengo.Instance
 	schemaName string
@@ -60,18 +86,81 @@ func NewDDLStatement(diff tengo.TableDiff, mods tengo.StatementModifiers, target
 	// If --safe-below-size option in use, enable additional statement modifier
 	// if the table's size is less than the supplied option value
 	safeBelowSize, err := target.Dir.Config.GetBytes("safe-below-size")
-	ddl.setErr(err)
+	if err != nil {
+		ddl.setErr(OptionError(target.Dir.Config, "safe-below-size", err))
+	}
 	if ddl.Err == nil && tableSize < int64(safeBelowSize) {
 		mods.AllowUnsafe = true
 		log.Debugf("Allowing unsafe operations for table %s: size=%d < safe-below-size=%d", tableName, tableSize, safeBelowSize)
 	}
 
+	// Statements failing due to lock contention may be retried, if configured
+	ddl.retries, err = target.Dir.Config.GetInt("retry-count")
+	if err != nil || ddl.retries < 0 {
+		ddl.setErr(OptionError(target.Dir.Config, "retry-count", fmt.Errorf("Option retry-count must be a non-negative integer; found \"%s\"", target.Dir.Config.Get("retry-count"))))
+	}
+	ddl.retryBackoff, err = time.ParseDuration(target.Dir.Config.Get("retry-backoff"))
+	if err != nil || ddl.retryBackoff < 0 {
+		ddl.setErr(OptionError(target.Dir.Config, "retry-backoff", fmt.Errorf("Option retry-backoff must be a duration such as 500ms or 2s; found \"%s\"", target.Dir.Config.Get("retry-backoff"))))
+	}
+
+	// Changing a table's storage engine rebuilds the entire table, and must be
+	// explicitly permitted. Brief diff output is exempt, since it only reports
+	// which instances have differences.
+	var engineChange bool
+	if alter, isAlter := diff.(tengo.AlterTable); isAlter {
+		if newEngine, changed := alterChangesEngine(alter); changed {
+			engineChange = true
+			oldEngine := "(unknown)"
+			if target.SchemaFromInstance != nil {
+				if table, err := target.SchemaFromInstance.Table(tableName); err == nil && table != nil {
+					oldEngine = table.Engine
+				}
+			}
+			log.Debugf("ALTER TABLE %s changes storage engine from %s to %s, requiring a full table rebuild", tableName, oldEngine, newEngine)
+			briefOutput := target.Dir.Config.GetBool("brief") && target.Dir.Config.GetBool("dry-run")
+			if !target.Dir.Config.GetBool("allow-engine-change") && !briefOutput {
+				ddl.setErr(fmt.Errorf("Refusing to change storage engine of table %s from %s to %s, which rebuilds the entire table; use allow-engine-change to permit this", tableName, oldEngine, newEngine))
+			}
+		}
+	}
+
+	// Adding columns flagged as PII by metadata sidecar files may be restricted by
+	// pii-policy, unless the columns are encrypted or masked. Brief diff output is
+	// exempt here too.
+	piiPolicy, err := target.Dir.Config.GetEnum("pii-policy", "allow", "warn", "approve", "block")
+	if err != nil {
+		ddl.setErr(OptionError(target.Dir.Config, "pii-policy", err))
+	} else if piiPolicy != "allow" {
+		piiCols, err := PIIColumnsAdded(diff, target)
+		briefOutput := target.Dir.Config.GetBool("brief") && target.Dir.Config.GetBool("dry-run")
+		if err != nil {
+			ddl.setErr(err)
+		} else if len(piiCols) > 0 && !briefOutput {
+			var plural string
+			if len(piiCols) > 1 {
+				plural = "s"
+			}
+			colList := strings.Join(piiCols, ", ")
+			if piiPolicy == "block" {
+				ddl.setErr(fmt.Errorf("Refusing to add unprotected PII column%s %s to table %s, since pii-policy is block", plural, colList, tableName))
+			} else if piiPolicy == "approve" && !target.Dir.Config.GetBool("approve-pii") {
+				ddl.setErr(fmt.Errorf("Refusing to add unprotected PII column%s %s to table %s without approval; use approve-pii to permit this", plural, colList, tableName))
+			} else {
+				log.Warnf("%s %s table %s: adding unprotected PII column%s %s", target.Instance, ddl.schemaName, tableName, plural, colList)
+			}
+		}
+	}
+
 	// Options may indicate some/all DDL gets executed by shelling out to another program.
 	wrapper := target.Dir.Config.Get("ddl-wrapper")
 	if _, isAlter := diff.(tengo.AlterTable); isAlter && target.Dir.Config.Changed("alter-wrapper") {
 		minSize, err := target.Dir.Config.GetBytes("alter-wrapper-min-size")
-		ddl.setErr(err)
-		if tableSize >= int64(minSize) {
+		if err != nil {
+			ddl.setErr(OptionError(target.Dir.Config, "alter-wrapper-min-size", err))
+		}
+		// Engine changes are full rebuilds, so they always use alter-wrapper
+		if tableSize >= int64(minSize) || engineChange {
 			wrapper = target.Dir.Config.Get("alter-wrapper")
 
 			// If alter-wrapper-min-size is set, and the table is big enough to use
@@ -80,7 +169,11 @@ func NewDDLStatement(diff tengo.TableDiff, mods tengo.StatementModifiers, target
 			// external OSC tool for large tables, without risk of ALGORITHM or LOCK
 			// clauses breaking expectations of the OSC tool.
 			if minSize > 0 {
-				log.Debugf("Using alter-wrapper for table %s: size=%d >= alter-wrapper-min-size=%d", tableName, tableSize, minSize)
+				if tableSize < int64(minSize) {
+					log.Debugf("Using alter-wrapper for table %s: size=%d < alter-wrapper-min-size=%d, but storage engine is changing", tableName, tableSize, minSize)
+				} else {
+					log.Debugf("Using alter-wrapper for table %s: size=%d >= alter-wrapper-min-size=%d", tableName, tableSize, minSize)
+				}
 				if mods.AlgorithmClause != "" || mods.LockClause != "" {
 					log.Debug("Ignoring --alter-algorithm and --alter-lock for generating DDL for alter-wrapper")
 					mods.AlgorithmClause = ""
@@ -92,9 +185,20 @@ func NewDDLStatement(diff tengo.TableDiff, mods tengo.StatementModifiers, target
 		}
 	}
 
+	ddl.tableName = tableName
+	if alter, isAlter := diff.(tengo.AlterTable); isAlter {
+		ddl.risky = (wrapper != "")
+		for _, clause := range alter.Clauses {
+			if clause.Unsafe() {
+				ddl.risky = true
+			}
+		}
+	}
+
 	// Get the raw DDL statement as a string.
 	ddl.stmt, err = diff.Statement(mods)
 	ddl.setErr(err)
+	ddl.id = StatementID(ddl.instance.String(), ddl.schemaName, ddl.stmt)
 	if ddl.stmt == "" {
 		// mods may result in a statement that should be skipped, but not due to
 		// error. For example, the only change may be to next-auto-inc value, which
@@ -102,6 +206,25 @@ func NewDDLStatement(diff tengo.TableDiff, mods tengo.StatementModifiers, target
 		return nil
 	}
 
+	// Options may supply SQL to run before and after each DDL statement on the
+	// same connection, or comment hints to embed in the statement itself, as
+	// required by some proxies or query routing layers. These do not apply to
+	// statements run by an external tool.
+	if wrapper == "" {
+		ddl.applyStatementSQL(target.Dir.Config.Get("pre-statement-sql"), target.Dir.Config.Get("post-statement-sql"))
+	}
+
+	// Statements run directly via the database connection must fit within the
+	// server's max_allowed_packet; detect this now, rather than allowing a
+	// confusing driver error to occur mid-push
+	if wrapper == "" {
+		if maxPacket, err := maxAllowedPacket(ddl.instance); err != nil {
+			log.Debugf("Unable to determine max_allowed_packet for %s: %s", ddl.instance, err)
+		} else if stmtLen := len(ddl.hintedStmt()); stmtLen+1 > maxPacket {
+			ddl.setErr(fmt.Errorf("DDL for table %s is %d bytes, which exceeds max_allowed_packet of %d bytes on %s; increase max_allowed_packet, or use ddl-wrapper to run it via an external tool", tableName, stmtLen, maxPacket, ddl.instance))
+		}
+	}
+
 	// Apply wrapper if relevant
 	if wrapper != "" {
 		extras := map[string]string{
@@ -133,13 +256,114 @@ func NewDDLStatement(diff tengo.TableDiff, mods tengo.StatementModifiers, target
 			ddl.setErr(fmt.Errorf("TableDiff type %T not yet supported", diff))
 		}
 
+		extras["DDLFILE"] = ""
 		ddl.shellOut, err = NewInterpolatedShellOut(wrapper, target.Dir, extras)
 		ddl.setErr(err)
+		if err == nil {
+			ddl.setErr(ddl.limitWrapperLength(wrapper, target.Dir, extras))
+		}
 	}
 
 	return ddl
 }
 
+// limitWrapperLength enforces the wrapper-max-length option, since operating
+// systems limit the length of an external command-line. If the expanded
+// wrapper command is too long, it is rebuilt with {DDL} and {CLAUSES} blank,
+// and {DDLFILE} set to the path of a temporary file which will contain the
+// statement once it is executed. An error is returned if the wrapper does not
+// reference {DDLFILE}, or if the command is still too long without the DDL.
+func (ddl *DDLStatement) limitWrapperLength(wrapper string, dir *Dir, extras map[string]string) error {
+	maxLength, err := dir.Config.GetBytes("wrapper-max-length")
+	if err != nil {
+		return OptionError(dir.Config, "wrapper-max-length", err)
+	}
+	length := uint64(len(ddl.shellOut.Command))
+	if maxLength == 0 || length <= maxLength {
+		return nil
+	}
+	if !strings.Contains(strings.ToUpper(wrapper), "{DDLFILE}") {
+		return fmt.Errorf("Wrapper command for table %s is %d bytes, which exceeds wrapper-max-length of %d bytes; use {DDLFILE} in the wrapper to obtain long DDL from a file instead", ddl.tableName, length, maxLength)
+	}
+	tempDir, err := Resources.TempDir()
+	if err != nil {
+		return err
+	}
+	ddl.ddlFile = filepath.Join(tempDir, fmt.Sprintf("ddl-%s.sql", ddl.id))
+	extras["DDL"], extras["CLAUSES"] = "", ""
+	extras["DDLFILE"] = ddl.ddlFile
+	if ddl.shellOut, err = NewInterpolatedShellOut(wrapper, dir, extras); err != nil {
+		return err
+	}
+	if length = uint64(len(ddl.shellOut.Command)); length > maxLength {
+		return fmt.Errorf("Wrapper command for table %s is %d bytes even without its DDL, which exceeds wrapper-max-length of %d bytes", ddl.tableName, length, maxLength)
+	}
+	log.Debugf("Passing DDL for table %s to wrapper via %s, since the command would exceed wrapper-max-length", ddl.tableName, ddl.ddlFile)
+	return nil
+}
+
+// dryRunNote returns a comment explaining that the {DDLFILE} referenced by
+// ddl's wrapper command is not written in dry-run mode, since the file is only
+// written upon execution. A blank string is returned if ddl does not use a
+// DDL file.
+func (ddl *DDLStatement) dryRunNote() string {
+	if ddl.ddlFile == "" {
+		return ""
+	}
+	return fmt.Sprintf("-- %s is not written in dry-run mode; it will contain the statement once executed", ddl.ddlFile)
+}
+
+// alterChangesEngine returns the new storage engine, and true, if alter
+// includes a clause changing the table's storage engine.
+func alterChangesEngine(alter tengo.AlterTable) (string, bool) {
+	for _, clause := range alter.Clauses {
+		if cse, ok := clause.(tengo.ChangeStorageEngine); ok {
+			return cse.NewStorageEngine, true
+		}
+	}
+	return "", false
+}
+
+// applyStatementSQL handles the pre-statement-sql and post-statement-sql
+// option values. A value consisting of a single /* ... */ comment is treated
+// as a hint, and embedded directly before or after the DDL statement's text
+// upon execution. Any other value is treated as a separate statement, run
+// immediately before or after the DDL statement on the same connection.
+func (ddl *DDLStatement) applyStatementSQL(pre, post string) {
+	pre = strings.TrimSuffix(strings.TrimSpace(pre), ";")
+	post = strings.TrimSuffix(strings.TrimSpace(post), ";")
+	if isCommentHint(pre) {
+		ddl.preHint = pre
+	} else {
+		ddl.preStmt = pre
+	}
+	if isCommentHint(post) {
+		ddl.postHint = post
+	} else {
+		ddl.postStmt = post
+	}
+}
+
+// hintedStmt returns the DDL statement's text as actually sent to the server,
+// including any comment hints from pre-statement-sql or post-statement-sql.
+// Hints are intentionally kept out of ddl.stmt, so that they never affect
+// statement IDs or output text.
+func (ddl *DDLStatement) hintedStmt() string {
+	stmt := ddl.stmt
+	if ddl.preHint != "" {
+		stmt = ddl.preHint + " " + stmt
+	}
+	if ddl.postHint != "" {
+		stmt = stmt + " " + ddl.postHint
+	}
+	return stmt
+}
+
+// isCommentHint returns true if sql consists of a single /* ... */ comment.
+func isCommentHint(sql string) bool {
+	return strings.HasPrefix(sql, "/*") && strings.HasSuffix(sql, "*/") && strings.Count(sql, "*/") == 1
+}
+
 // IsShellOut returns true if the DDL is to be executed via shelling out to an
 // external binary, or false if the DDL represents SQL to be executed directly
 // via a standard database connection.
@@ -147,22 +371,47 @@ func (ddl *DDLStatement) IsShellOut() bool {
 	return (ddl.shellOut != nil)
 }
 
+// ID returns a stable identifier for ddl; see StatementID.
+func (ddl *DDLStatement) ID() string {
+	return ddl.id
+}
+
 // String returns a string representation of ddl. If an external command is in
 // use, the returned string will be prefixed with "\!", the MySQL CLI command
 // shortcut for "system" shellout. If ddl.Err is non-nil, the returned string
-// will be commented-out by wrapping in /* ... */ long-style comment.
+// will be commented-out; see commentOut.
 func (ddl *DDLStatement) String() string {
 	if ddl == nil {
 		return ""
 	}
+	stmt := ddl.Text()
+	if ddl.Err != nil {
+		stmt = commentOut(stmt)
+	}
+	return stmt
+}
+
+// commentOut prefixes each line of text with a "-- " single-line comment.
+// This is used instead of a /* ... */ long-style comment, since text may
+// itself contain "*/" (for example in a column's COMMENT clause), which would
+// prematurely end a long-style comment.
+func commentOut(text string) string {
+	return "-- " + strings.Replace(text, "\n", "\n-- ", -1)
+}
+
+// Text behaves like String, except the result is never commented-out.
+func (ddl *DDLStatement) Text() string {
 	var stmt string
 	if ddl.IsShellOut() {
 		stmt = fmt.Sprintf("\\! %s", ddl.shellOut)
 	} else {
 		stmt = fmt.Sprintf("%s;", ddl.stmt)
-	}
-	if ddl.Err != nil {
-		stmt = fmt.Sprintf("/* %s */", stmt)
+		if ddl.preStmt != "" {
+			stmt = fmt.Sprintf("%s;\n%s", ddl.preStmt, stmt)
+		}
+		if ddl.postStmt != "" {
+			stmt = fmt.Sprintf("%s\n%s;", stmt, ddl.postStmt)
+		}
 	}
 	return stmt
 }
@@ -177,6 +426,13 @@ func (ddl *DDLStatement) Execute() error {
 		return ddl.Err
 	}
 	if ddl.IsShellOut() {
+		if ddl.ddlFile != "" {
+			if err := ioutil.WriteFile(ddl.ddlFile, []byte(ddl.stmt+";\n"), 0600); err != nil {
+				ddl.Err = fmt.Errorf("Unable to write DDL file: %s", err)
+				return ddl.Err
+			}
+			defer os.Remove(ddl.ddlFile)
+		}
 		ddl.Err = ddl.shellOut.Run()
 	} else {
 		if ddl.stmt == "" {
@@ -185,12 +441,66 @@ func (ddl *DDLStatement) Execute() error {
 		if db, err := ddl.instance.Connect(ddl.schemaName, ""); err != nil {
 			ddl.Err = err
 		} else {
-			_, ddl.Err = db.Exec(ddl.stmt)
+			for attempt := 0; ; attempt++ {
+				ddl.Warnings, ddl.Err = execCapturingWarnings(db, ddl.preStmt, ddl.hintedStmt(), ddl.postStmt)
+				if ddl.Err == nil || attempt >= ddl.retries || !isLockContentionError(ddl.Err) {
+					break
+				}
+				wait := ddl.retryBackoff * (1 << uint(attempt))
+				log.Warnf("Lock contention running DDL for table %s on %s %s: %s; retrying in %s (retry %d of %d)", ddl.tableName, ddl.instance, ddl.schemaName, ddl.Err, wait, attempt+1, ddl.retries)
+				time.Sleep(wait)
+			}
 		}
 	}
 	return ddl.Err
 }
 
+// RowCount returns the optimizer's estimate of the number of rows in the
+// table affected by ddl. This is only meaningful for ALTER TABLE and DROP
+// TABLE statements.
+func (ddl *DDLStatement) RowCount() (int64, error) {
+	db, err := ddl.instance.Connect(ddl.schemaName, "")
+	if err != nil {
+		return 0, err
+	}
+	rows, err := db.Query(fmt.Sprintf("EXPLAIN SELECT * FROM %s", tengo.EscapeIdentifier(ddl.tableName)))
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return 0, err
+	}
+	if !rows.Next() {
+		return 0, fmt.Errorf("No EXPLAIN output for table %s", ddl.tableName)
+	}
+	values := make([]sql.NullString, len(cols))
+	dest := make([]interface{}, len(cols))
+	for n := range values {
+		dest[n] = &values[n]
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return 0, err
+	}
+	for n, col := range cols {
+		if strings.ToLower(col) == "rows" {
+			if !values[n].Valid {
+				return 0, nil
+			}
+			return strconv.ParseInt(values[n].String, 10, 64)
+		}
+	}
+	return 0, fmt.Errorf("No rows column in EXPLAIN output for table %s", ddl.tableName)
+}
+
+// NeedsRowCountCheck returns true if ddl is an ALTER TABLE which is either
+// potentially destructive, or executed by an external tool such as an online
+// schema change tool. Such statements are candidates for row-count-tolerance.
+func (ddl *DDLStatement) NeedsRowCountCheck() bool {
+	return ddl != nil && ddl.risky
+}
+
 // setErr sets ddl.Err if the supplied err is non-nil and ddl.Err is nil.
 // DDLStatement uses this slightly unusual error convention because errors
 // intentionally do not cause an early return in NewDDLStatement; instead they
@@ -201,6 +511,130 @@ func (ddl *DDLStatement) setErr(err error) {
 	}
 }
 
+// isLockContentionError returns true if err indicates the statement failed
+// due to a lock wait timeout or deadlock. Such errors are typically transient
+// on busy tables, so the statement may be safely retried.
+func isLockContentionError(err error) bool {
+	if merr, ok := err.(*mysql.MySQLError); ok {
+		return merr.Number == mysqlerr.ER_LOCK_WAIT_TIMEOUT || merr.Number == mysqlerr.ER_LOCK_DEADLOCK
+	}
+	return false
+}
+
+// conversionWarningCodes lists server warning codes indicating that data or
+// column definitions were implicitly converted or truncated by the server.
+var conversionWarningCodes = map[int]bool{
+	1246: true, // ER_AUTO_CONVERT
+	1263: true, // ER_WARN_NULL_TO_NOTNULL
+	1264: true, // ER_WARN_DATA_OUT_OF_RANGE
+	1265: true, // WARN_DATA_TRUNCATED
+	1292: true, // ER_TRUNCATED_WRONG_VALUE
+	1300: true, // ER_INVALID_CHARACTER_STRING
+	1366: true, // ER_TRUNCATED_WRONG_VALUE_FOR_FIELD
+	1406: true, // ER_DATA_TOO_LONG
+}
+
+// ServerWarning represents a single row of SHOW WARNINGS output.
+type ServerWarning struct {
+	Level   string
+	Code    int
+	Message string
+}
+
+// String returns a string representation of sw, similar to how the MySQL
+// client displays warnings.
+func (sw ServerWarning) String() string {
+	return fmt.Sprintf("%s (Code %d): %s", sw.Level, sw.Code, sw.Message)
+}
+
+// IsConversion returns true if sw indicates the server implicitly converted or
+// truncated something, which may mean data was silently altered.
+func (sw ServerWarning) IsConversion() bool {
+	return conversionWarningCodes[sw.Code]
+}
+
+// execCapturingWarnings executes stmt using db, and returns any warnings that
+// the server reports for it. If pre or post are non-blank, they are executed
+// immediately before or after stmt, respectively. A dedicated connection is
+// used, outside of any transaction, to ensure that SHOW WARNINGS, pre, and post
+// are run on the same connection as stmt. Since pre may change session state,
+// the connection is discarded afterwards rather than returned to the pool.
+func execCapturingWarnings(db *sqlx.DB, pre, stmt, post string) ([]ServerWarning, error) {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+		conn.Close()
+	}()
+	if pre != "" {
+		if _, err := conn.ExecContext(ctx, pre); err != nil {
+			return nil, fmt.Errorf("Error running pre-statement-sql: %s", err)
+		}
+	}
+	if _, err := conn.ExecContext(ctx, stmt); err != nil {
+		return nil, err
+	}
+	warnings := showWarnings(ctx, conn)
+	if post != "" {
+		if _, err := conn.ExecContext(ctx, post); err != nil {
+			return warnings, fmt.Errorf("Error running post-statement-sql: %s", err)
+		}
+	}
+	return warnings, nil
+}
+
+// showWarnings returns the warnings reported by the server for the previous
+// statement run on conn. Failure to obtain warnings is logged but otherwise
+// ignored.
+func showWarnings(ctx context.Context, conn *sql.Conn) []ServerWarning {
+	rows, err := conn.QueryContext(ctx, "SHOW WARNINGS")
+	if err != nil {
+		log.Debugf("Unable to obtain warnings for statement: %s", err)
+		return nil
+	}
+	defer rows.Close()
+	var warnings []ServerWarning
+	for rows.Next() {
+		var sw ServerWarning
+		if err := rows.Scan(&sw.Level, &sw.Code, &sw.Message); err != nil {
+			log.Debugf("Unable to obtain warnings for statement: %s", err)
+			return warnings
+		}
+		warnings = append(warnings, sw)
+	}
+	return warnings
+}
+
+// maxAllowedPacketCache stores the max_allowed_packet of each instance, keyed
+// by instance string, to avoid repeatedly querying it for each DDL statement.
+var maxAllowedPacketCache = struct {
+	values map[string]int
+	sync.Mutex
+}{values: make(map[string]int)}
+
+// maxAllowedPacket returns the max_allowed_packet of the supplied instance,
+// which is the largest statement that may be sent to the server.
+func maxAllowedPacket(instance *tengo.Instance) (int, error) {
+	maxAllowedPacketCache.Lock()
+	defer maxAllowedPacketCache.Unlock()
+	if value, ok := maxAllowedPacketCache.values[instance.String()]; ok {
+		return value, nil
+	}
+	db, err := instance.Connect("", "")
+	if err != nil {
+		return 0, err
+	}
+	var value int
+	if err := db.QueryRow("SELECT @@max_allowed_packet").Scan(&value); err != nil {
+		return 0, err
+	}
+	maxAllowedPacketCache.values[instance.String()] = value
+	return value, nil
+}
+
 // getTableSize returns the size of the table on the instance corresponding to
 // the target. If the table has no rows, this method always returns a size of 0,
 // even though information_schema normally indicates at least 16kb in this case.
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+func TestDDLStatementApplyStatementSQL(t *testing.T) {
+	cases := []struct {
+		pre, post string
+		expected  string
+		hinted    string
+	}{
+		{"", "", "ALTER TABLE `foo` ADD COLUMN `bar` int;", "ALTER TABLE `foo` ADD COLUMN `bar` int"},
+		{"SET SESSION lock_wait_timeout=5;", "", "SET SESSION lock_wait_timeout=5;\nALTER TABLE `foo` ADD COLUMN `bar` int;", "ALTER TABLE `foo` ADD COLUMN `bar` int"},
+		{"/* route:primary */", "", "ALTER TABLE `foo` ADD COLUMN `bar` int;", "/* route:primary */ ALTER TABLE `foo` ADD COLUMN `bar` int"},
+		{"", " /* ddl */ ", "ALTER TABLE `foo` ADD COLUMN `bar` int;", "ALTER TABLE `foo` ADD COLUMN `bar` int /* ddl */"},
+		{"/* a */ SET foo=1", "SET foo=DEFAULT", "/* a */ SET foo=1;\nALTER TABLE `foo` ADD COLUMN `bar` int;\nSET foo=DEFAULT;", "ALTER TABLE `foo` ADD COLUMN `bar` int"},
+	}
+	for _, c := range cases {
+		ddl := &DDLStatement{stmt: "ALTER TABLE `foo` ADD COLUMN `bar` int"}
+		ddl.applyStatementSQL(c.pre, c.post)
+		if actual := ddl.String(); actual != c.expected {
+			t.Errorf("Unexpected result for pre=%q post=%q: expected %q, found %q", c.pre, c.post, c.expected, actual)
+		}
+		if actual := ddl.hintedStmt(); actual != c.hinted {
+			t.Errorf("Unexpected hinted statement for pre=%q post=%q: expected %q, found %q", c.pre, c.post, c.hinted, actual)
+		}
+	}
+}
+
+func TestDDLStatementStringCommentOut(t *testing.T) {
+	ddl := &DDLStatement{
+		stmt: "ALTER TABLE `foo` ADD COLUMN `bar` int COMMENT 'evil */ DROP TABLE `foo`; /*'",
+		Err:  errors.New("forbidden"),
+	}
+	ddl.applyStatementSQL("SET SESSION lock_wait_timeout=5", "/* route=primary */")
+	expected := "-- SET SESSION lock_wait_timeout=5;\n-- ALTER TABLE `foo` ADD COLUMN `bar` int COMMENT 'evil */ DROP TABLE `foo`; /*';"
+	if actual := ddl.String(); actual != expected {
+		t.Errorf("Unexpected String() for errored statement: expected %q, found %q", expected, actual)
+	}
+	for _, line := range strings.Split(ddl.String(), "\n") {
+		if !strings.HasPrefix(line, "-- ") {
+			t.Errorf("Expected every line of errored statement to be commented out, but found %q", line)
+		}
+	}
+}
+
+func TestDDLStatementLimitWrapperLength(t *testing.T) {
+	defer Resources.Cleanup()
+	dir := &Dir{
+		Path: "/var/schemas/somehost/someschema",
+		Config: getConfig(map[string]string{ // see dir_test.go
+			"host":               "ahost",
+			"port":               "3306",
+			"schema":             "aschema",
+			"user":               "someone",
+			"password":           "",
+			"connect-options":    "",
+			"wrapper-max-length": "150",
+		}),
+	}
+	newDDL := func(wrapper string, clauses string) (*DDLStatement, error) {
+		ddl := &DDLStatement{
+			id:        "0123456789abcdef",
+			stmt:      "ALTER TABLE `foo` " + clauses,
+			tableName: "foo",
+		}
+		extras := map[string]string{"DDL": ddl.stmt, "CLAUSES": clauses, "DDLFILE": ""}
+		var err error
+		if ddl.shellOut, err = NewInterpolatedShellOut(wrapper, dir, extras); err != nil {
+			t.Fatalf("Unexpected error from NewInterpolatedShellOut: %s", err)
+		}
+		return ddl, ddl.limitWrapperLength(wrapper, dir, extras)
+	}
+
+	// Short commands are unaffected, even if {DDLFILE} is used
+	wrapper := "/bin/wrapper --alter={CLAUSES} --alter-file={DDLFILE}"
+	if ddl, err := newDDL(wrapper, "ADD COLUMN `bar` int"); err != nil || ddl.ddlFile != "" {
+		t.Errorf("Unexpected result for short command: ddlFile=%q err=%v", ddl.ddlFile, err)
+	} else if expected := "/bin/wrapper --alter='ADD COLUMN `bar` int' --alter-file="; ddl.shellOut.Command != expected {
+		t.Errorf("Expected command %s, instead found %s", expected, ddl.shellOut.Command)
+	} else if note := ddl.dryRunNote(); note != "" {
+		t.Errorf("Expected no dry-run note for short command, instead found %q", note)
+	}
+
+	// Long commands instead receive the DDL via a file
+	longClauses := strings.Repeat("ADD COLUMN `bar` int, ", 6) + "ADD COLUMN `baz` int"
+	ddl, err := newDDL(wrapper, longClauses)
+	if err != nil || ddl.ddlFile == "" {
+		t.Fatalf("Unexpected result for long command: ddlFile=%q err=%v", ddl.ddlFile, err)
+	}
+	if expected := "/bin/wrapper --alter= --alter-file=" + ddl.ddlFile; ddl.shellOut.Command != expected {
+		t.Errorf("Expected command %s, instead found %s", expected, ddl.shellOut.Command)
+	}
+	if note := ddl.dryRunNote(); !strings.HasPrefix(note, "-- "+ddl.ddlFile+" is not written") {
+		t.Errorf("Unexpected dry-run note for statement using DDL file: %q", note)
+	}
+	ddl.shellOut.Command = "/bin/cat " + ddl.ddlFile + " >/dev/null"
+	if err := ddl.Execute(); err != nil {
+		t.Errorf("Unexpected error from Execute: %s", err)
+	}
+	if _, err := ioutil.ReadFile(ddl.ddlFile); err == nil {
+		t.Error("Expected DDL file to be removed after execution, but it still exists")
+	}
+
+	// Without {DDLFILE}, or if the command is long even without the DDL, long
+	// commands are an error
+	if _, err := newDDL("/bin/wrapper --alter={CLAUSES}", longClauses); err == nil || !strings.Contains(err.Error(), "{DDLFILE}") {
+		t.Errorf("Expected error mentioning {DDLFILE}, instead found %v", err)
+	}
+	wrapper = "/bin/wrapper --alter={CLAUSES} --alter-file={DDLFILE} " + strings.Repeat("--verbose ", 6)
+	if _, err := newDDL(wrapper, longClauses); err == nil || !strings.Contains(err.Error(), "even without its DDL") {
+		t.Errorf("Expected error for command too long without DDL, instead found %v", err)
+	}
+}
+
+func TestIsLockContentionError(t *testing.T) {
+	cases := []struct {
+		err      error
+		expected bool
+	}{
+		{&mysql.MySQLError{Number: 1205, Message: "Lock wait timeout exceeded; try restarting transaction"}, true},
+		{&mysql.MySQLError{Number: 1213, Message: "Deadlock found when trying to get lock; try restarting transaction"}, true},
+		{&mysql.MySQLError{Number: 1062, Message: "Duplicate entry '1' for key 'PRIMARY'"}, false},
+		{errors.New("Lock wait timeout exceeded"), false},
+		{nil, false},
+	}
+	for n, c := range cases {
+		if actual := isLockContentionError(c.err); actual != c.expected {
+			t.Errorf("Case %d: expected isLockContentionError(%v) to return %t, instead found %t", n, c.err, c.expected, actual)
+		}
+	}
+}
+
+func TestServerWarning(t *testing.T) {
+	cases := []struct {
+		warning    ServerWarning
+		conversion bool
+	}{
+		{ServerWarning{"Warning", 1265, "Data truncated for column 'bar' at row 1"}, true},
+		{ServerWarning{"Warning", 1366, "Incorrect integer value: 'x' for column 'bar' at row 1"}, true},
+		{ServerWarning{"Note", 1246, "Converting column 'bar' from VARCHAR to TEXT"}, true},
+		{ServerWarning{"Warning", 1681, "Integer display width is deprecated and will be removed in a future release."}, false},
+		{ServerWarning{"Note", 1050, "Table 'foo' already exists"}, false},
+	}
+	for _, c := range cases {
+		if actual := c.warning.IsConversion(); actual != c.conversion {
+			t.Errorf("Expected IsConversion to return %t for %s, instead found %t", c.conversion, c.warning, actual)
+		}
+	}
+	sw := ServerWarning{"Warning", 1265, "Data truncated for column 'bar' at row 1"}
+	if expected := "Warning (Code 1265): Data truncated for column 'bar' at row 1"; sw.String() != expected {
+		t.Errorf("Expected String to return %q, instead found %q", expected, sw.String())
+	}
+}
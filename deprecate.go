@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/skeema/tengo"
+)
+
+// deprecatedCommentPrefix begins the comment of every column renamed by
+// DeprecateColumn. The remainder of the comment is the date of deprecation.
+const deprecatedCommentPrefix = "Deprecated by skeema on "
+
+// maxIdentifierLength is the maximum length of a column name in MySQL.
+const maxIdentifierLength = 64
+
+var reDeprecatedComment = regexp.MustCompile(`^` + deprecatedCommentPrefix + `(\d{4}-\d{2}-\d{2})`)
+
+// DeprecateColumn represents a column that was present on the left-side
+// ("from") version of the table but not the right-side ("to") version, which
+// is renamed instead of being dropped. It satisfies the tengo.TableAlterClause
+// interface.
+type DeprecateColumn struct {
+	Table   *tengo.Table
+	Column  *tengo.Column
+	NewName string
+	Date    time.Time
+}
+
+// Clause returns a CHANGE COLUMN clause of an ALTER TABLE statement. The
+// column's comment is replaced with the date of deprecation. If the column is
+// NOT NULL without a default value, it is also made nullable, so that inserts
+// which no longer supply a value for it do not fail.
+func (dc DeprecateColumn) Clause() string {
+	renamed := *dc.Column
+	renamed.Name = dc.NewName
+	renamed.Comment = deprecatedCommentPrefix + dc.Date.Format("2006-01-02")
+	if !renamed.Nullable && renamed.Default.Null && !renamed.AutoIncrement && !columnInPrimaryKey(dc.Table, dc.Column) {
+		renamed.Nullable = true
+	}
+	return fmt.Sprintf("CHANGE COLUMN %s %s", tengo.EscapeIdentifier(dc.Column.Name), renamed.Definition(dc.Table))
+}
+
+// Unsafe returns true if this clause is potentially destructive of data.
+// DeprecateColumn is never unsafe, since the column's data is retained.
+func (dc DeprecateColumn) Unsafe() bool {
+	return false
+}
+
+func columnInPrimaryKey(table *tengo.Table, col *tengo.Column) bool {
+	if table.PrimaryKey == nil {
+		return false
+	}
+	for _, pkCol := range table.PrimaryKey.Columns {
+		if pkCol.Name == col.Name {
+			return true
+		}
+	}
+	return false
+}
+
+// DeprecateDroppedColumns modifies the ALTER TABLEs in diff, replacing each
+// DROP COLUMN clause with a DeprecateColumn clause which renames the column to
+// have the supplied prefix. DROP COLUMN clauses for columns which already have
+// the prefix are removed instead, since such columns are only dropped by
+// `skeema prune-deprecated`. ALTER TABLEs with no remaining clauses are removed
+// from diff. The number of deprecated columns is returned.
+func DeprecateDroppedColumns(diff *tengo.SchemaDiff, prefix string, date time.Time) (count int) {
+	kept := make([]tengo.TableDiff, 0, len(diff.TableDiffs))
+	for _, tableDiff := range diff.TableDiffs {
+		alter, ok := tableDiff.(tengo.AlterTable)
+		if !ok {
+			kept = append(kept, tableDiff)
+			continue
+		}
+		clauses := make([]tengo.TableAlterClause, 0, len(alter.Clauses))
+		for _, clause := range alter.Clauses {
+			drop, ok := clause.(tengo.DropColumn)
+			if !ok {
+				clauses = append(clauses, clause)
+			} else if strings.HasPrefix(drop.Column.Name, prefix) {
+				continue
+			} else if len(prefix)+len(drop.Column.Name) > maxIdentifierLength {
+				// Renaming is impossible, so leave the DROP COLUMN as-is, which will
+				// require allow-unsafe as usual
+				clauses = append(clauses, clause)
+			} else {
+				clauses = append(clauses, DeprecateColumn{
+					Table:   drop.Table,
+					Column:  drop.Column,
+					NewName: prefix + drop.Column.Name,
+					Date:    date,
+				})
+				count++
+			}
+		}
+		if len(clauses) > 0 {
+			alter.Clauses = clauses
+			kept = append(kept, alter)
+		}
+	}
+	diff.TableDiffs = kept
+	return count
+}
+
+// DeprecatedColumnDate returns the date that col was renamed by DeprecateColumn.
+// The second return value is false if col does not have the supplied prefix, or
+// if its comment does not contain a date of deprecation.
+func DeprecatedColumnDate(col *tengo.Column, prefix string) (time.Time, bool) {
+	if !strings.HasPrefix(col.Name, prefix) {
+		return time.Time{}, false
+	}
+	matches := reDeprecatedComment.FindStringSubmatch(col.Comment)
+	if matches == nil {
+		return time.Time{}, false
+	}
+	date, err := time.Parse("2006-01-02", matches[1])
+	if err != nil {
+		return time.Time{}, false
+	}
+	return date, true
+}
+
+// ParseRetention parses a value of the retention option, which is either a
+// number of days such as "30d", or a duration such as "36h".
+func ParseRetention(value string) (time.Duration, error) {
+	if strings.HasSuffix(value, "d") {
+		days, err := strconv.ParseUint(strings.TrimSuffix(value, "d"), 10, 16)
+		if err == nil {
+			return time.Duration(days) * 24 * time.Hour, nil
+		}
+	} else if d, err := time.ParseDuration(value); err == nil && d >= 0 {
+		return d, nil
+	}
+	return 0, fmt.Errorf("Option retention must be a number of days such as \"30d\", or a duration such as \"36h\"; found \"%s\"", value)
+}
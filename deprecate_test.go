@@ -0,0 +1,108 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/skeema/tengo"
+)
+
+func TestDeprecateDroppedColumns(t *testing.T) {
+	id := &tengo.Column{Name: "id", TypeInDB: "int(10) unsigned", AutoIncrement: true, Default: tengo.ColumnDefaultNull}
+	name := &tengo.Column{Name: "name", TypeInDB: "varchar(30)", Default: tengo.ColumnDefaultNull, CharSet: "latin1"}
+	legacy := &tengo.Column{Name: "legacy", TypeInDB: "int(11)", Default: tengo.ColumnDefaultNull}
+	deprecated := &tengo.Column{Name: "zzz_deprecated_old", TypeInDB: "int(11)", Nullable: true, Default: tengo.ColumnDefaultNull, Comment: "Deprecated by skeema on 2018-04-01"}
+	longName := &tengo.Column{Name: strings.Repeat("x", 60), TypeInDB: "int(11)", Nullable: true, Default: tengo.ColumnDefaultNull}
+	table := &tengo.Table{
+		Name:       "users",
+		Columns:    []*tengo.Column{id, name, legacy, deprecated, longName},
+		PrimaryKey: &tengo.Index{Name: "PRIMARY", Columns: []*tengo.Column{id}, PrimaryKey: true, Unique: true},
+		CharSet:    "latin1",
+	}
+	other := &tengo.Table{Name: "other", Columns: []*tengo.Column{deprecated}, CharSet: "latin1"}
+	diff := &tengo.SchemaDiff{
+		TableDiffs: []tengo.TableDiff{
+			tengo.AlterTable{Table: table, Clauses: []tengo.TableAlterClause{
+				tengo.DropColumn{Table: table, Column: legacy},
+				tengo.DropColumn{Table: table, Column: deprecated},
+				tengo.DropColumn{Table: table, Column: longName},
+			}},
+			tengo.AlterTable{Table: other, Clauses: []tengo.TableAlterClause{
+				tengo.DropColumn{Table: other, Column: deprecated},
+			}},
+			tengo.DropTable{Table: other},
+		},
+	}
+	date := time.Date(2018, 5, 1, 23, 30, 0, 0, time.UTC)
+	if count := DeprecateDroppedColumns(diff, "zzz_deprecated_", date); count != 1 {
+		t.Errorf("Expected 1 column to be deprecated, instead found %d", count)
+	}
+	if len(diff.TableDiffs) != 2 {
+		t.Fatalf("Expected ALTER consisting solely of already-deprecated column to be removed; found %d TableDiffs remaining", len(diff.TableDiffs))
+	}
+	alter := diff.TableDiffs[0].(tengo.AlterTable)
+	if len(alter.Clauses) != 2 {
+		t.Fatalf("Expected 2 clauses to remain, instead found %d", len(alter.Clauses))
+	}
+	dc, ok := alter.Clauses[0].(DeprecateColumn)
+	if !ok {
+		t.Fatalf("Expected first clause to be DeprecateColumn, instead found %T", alter.Clauses[0])
+	}
+	expected := "CHANGE COLUMN `legacy` `zzz_deprecated_legacy` int(11) DEFAULT NULL COMMENT 'Deprecated by skeema on 2018-05-01'"
+	if actual := dc.Clause(); actual != expected {
+		t.Errorf("Unexpected clause:\nexpected: %s\nactual:   %s", expected, actual)
+	}
+	if dc.Unsafe() {
+		t.Error("Expected DeprecateColumn to be safe, but it was not")
+	}
+	if _, ok := alter.Clauses[1].(tengo.DropColumn); !ok {
+		t.Errorf("Expected column name too long to rename to be left as DropColumn, instead found %T", alter.Clauses[1])
+	}
+
+	// Primary key and auto-increment columns must not be made nullable
+	dc.Column, dc.NewName = id, "zzz_deprecated_id"
+	if clause := dc.Clause(); strings.Contains(clause, "DEFAULT NULL") || !strings.Contains(clause, "NOT NULL") {
+		t.Errorf("Expected primary key column to remain NOT NULL, instead found %s", clause)
+	}
+}
+
+func TestDeprecatedColumnDate(t *testing.T) {
+	cases := []struct {
+		col      *tengo.Column
+		expected string
+	}{
+		{&tengo.Column{Name: "zzz_deprecated_foo", Comment: "Deprecated by skeema on 2018-04-01"}, "2018-04-01"},
+		{&tengo.Column{Name: "zzz_deprecated_foo", Comment: "Deprecated by skeema on 2018-04-01; was a flag"}, "2018-04-01"},
+		{&tengo.Column{Name: "foo", Comment: "Deprecated by skeema on 2018-04-01"}, ""},
+		{&tengo.Column{Name: "zzz_deprecated_foo", Comment: "hand-renamed"}, ""},
+		{&tengo.Column{Name: "zzz_deprecated_foo", Comment: "Deprecated by skeema on 2018-13-01"}, ""},
+	}
+	for _, c := range cases {
+		date, ok := DeprecatedColumnDate(c.col, "zzz_deprecated_")
+		if c.expected == "" && ok {
+			t.Errorf("Expected column %s with comment %q to not be recognized, but it was", c.col.Name, c.col.Comment)
+		} else if c.expected != "" && (!ok || date.Format("2006-01-02") != c.expected) {
+			t.Errorf("Expected column %s with comment %q to have date %s, instead found %s, %t", c.col.Name, c.col.Comment, c.expected, date, ok)
+		}
+	}
+}
+
+func TestParseRetention(t *testing.T) {
+	cases := map[string]time.Duration{
+		"30d": 30 * 24 * time.Hour,
+		"0d":  0,
+		"36h": 36 * time.Hour,
+		"90m": 90 * time.Minute,
+	}
+	for input, expected := range cases {
+		if actual, err := ParseRetention(input); err != nil || actual != expected {
+			t.Errorf("Expected ParseRetention(%q) to return %s, instead found %s, %v", input, expected, actual, err)
+		}
+	}
+	for _, input := range []string{"", "d", "-5d", "1.5d", "-1h", "week"} {
+		if _, err := ParseRetention(input); err == nil {
+			t.Errorf("Expected error from ParseRetention(%q), but received none", input)
+		}
+	}
+}
@@ -1,7 +1,7 @@
 package main
 
 import (
-	"database/sql"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/url"
@@ -10,6 +10,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
@@ -17,13 +18,51 @@ import (
 	"github.com/skeema/tengo"
 )
 
+// RootMarkerFileName is the name of a file which, if present in a directory,
+// marks that directory as the root of a Skeema directory tree. Option files in
+// its parent directories will not be applied.
+const RootMarkerFileName = ".skeema-root"
+
+// MaxConcurrentReads limits how many files or subdirs of a single dir are read
+// at once. Reading in parallel substantially speeds up traversal of large
+// trees on network storage.
+const MaxConcurrentReads = 16
+
 // Dir represents a directory that Skeema is interacting with.
 type Dir struct {
-	Path    string
-	Config  *mybase.Config // Unified config including this dir's options file (and its parents' open files)
-	section string         // For options files, which section name to use, if any
+	Path           string
+	Config         *mybase.Config // Unified config including this dir's options file (and its parents' open files); see settleConfig regarding concurrent use
+	section        string         // For options files, which section name to use, if any
+	optionFileName string         // Name of option files in this dir and its parents; if blank, ".skeema" is used
+	gitignore      IgnoreRules    // Rules from .gitignore files in this dir and its parents, if respect-gitignore enabled
+	defaultSchema  string         // Schema name implied by parent dir's default-schema-dir, if any
+}
+
+// defaultSchemaSource is a configuration source which supplies only the schema
+// option. It is used for subdirs of a host dir with default-schema-dir enabled.
+type defaultSchemaSource string
+
+// OptionValue satisfies mybase.OptionValuer.
+func (s defaultSchemaSource) OptionValue(optionName string) (string, bool) {
+	if optionName == "schema" {
+		return string(s), true
+	}
+	return "", false
+}
+
+// profileSource is a configuration source which supplies the options set in
+// a connection profile section.
+type profileSource map[string]string
+
+// OptionValue satisfies mybase.OptionValuer.
+func (s profileSource) OptionValue(optionName string) (string, bool) {
+	value, ok := s[optionName]
+	return value, ok
 }
 
+// ProfileOptions lists the options which may be set by a connection profile.
+var ProfileOptions = []string{"user", "password", "connect-options", "port", "socket"}
+
 // NewDir returns a value representing a directory that Skeema may operate upon.
 // This function should be used when initializing a dir when we aren't directly
 // operating on any of its parent dirs.
@@ -38,9 +77,10 @@ func NewDir(path string, baseConfig *mybase.Config) (*Dir, error) {
 	}
 
 	dir := &Dir{
-		Path:    path,
-		Config:  baseConfig.Clone(),
-		section: baseConfig.Get("environment"),
+		Path:           path,
+		Config:         baseConfig.Clone(),
+		section:        baseConfig.Get("environment"),
+		optionFileName: baseConfig.Get("skeema-file"),
 	}
 
 	// Get slice of option files from root on down to this dir, in that order.
@@ -50,17 +90,60 @@ func NewDir(path string, baseConfig *mybase.Config) (*Dir, error) {
 		return nil, err
 	}
 	for _, optionFile := range dirOptionFiles {
-		err := optionFile.Parse(dir.Config)
+		err := ParseOptionFile(optionFile, dir.Config)
 		if err != nil {
 			return nil, err
 		}
 		_ = optionFile.UseSection(dir.section) // we don't care if the section doesn't exist
+		if err := CheckRequiredVersion(optionFile, dir.Config); err != nil {
+			return nil, err
+		}
 		dir.Config.AddSource(optionFile)
 	}
 
+	if dir.Config.GetBool("respect-gitignore") {
+		if dir.gitignore, err = dir.parentGitignoreRules(); err != nil {
+			return nil, err
+		}
+	}
+
+	if name := dir.Config.Get("profile"); name != "" {
+		if err := dir.applyProfile(name); err != nil {
+			return nil, err
+		}
+	}
+
+	// If the parent dir defines a host and enables default-schema-dir, this dir
+	// may map to a schema of the same name
+	for _, optionFile := range dirOptionFiles {
+		if optionFile.Dir == filepath.Dir(dir.Path) {
+			_, parentHasHost := optionFile.OptionValue("host")
+			if !parentHasHost {
+				_, parentHasHost = optionFile.OptionValue("host-group")
+			}
+			dir.applyDefaultSchema(parentHasHost)
+		}
+	}
+
 	return dir, nil
 }
 
+// applyDefaultSchema makes dir map to a schema named after the dir, if its
+// parent dir defines a host (as indicated by parentHasHost), default-schema-dir
+// is enabled, and dir's own option file does not specify a schema.
+func (dir *Dir) applyDefaultSchema(parentHasHost bool) {
+	if !parentHasHost || !dir.Config.GetBool("default-schema-dir") || dir.BaseName()[0] == '.' {
+		return
+	}
+	if optionFile, err := dir.OptionFile(); err == nil && optionFile != nil {
+		if _, ok := optionFile.OptionValue("schema"); ok {
+			return
+		}
+	}
+	dir.defaultSchema = dir.BaseName()
+	dir.Config.AddSource(defaultSchemaSource(dir.defaultSchema))
+}
+
 func (dir *Dir) String() string {
 	return dir.Path
 }
@@ -107,25 +190,49 @@ func (dir *Dir) HasFile(name string) bool {
 	return (err == nil)
 }
 
+// OptionFileName returns the name of the option file used for this dir, which
+// is ".skeema" unless overridden by the skeema-file option.
+func (dir *Dir) OptionFileName() string {
+	if dir.optionFileName == "" {
+		return ".skeema"
+	}
+	return dir.optionFileName
+}
+
 // HasOptionFile returns true if the directory contains a .skeema option file.
 func (dir *Dir) HasOptionFile() bool {
-	return dir.HasFile(".skeema")
+	return dir.HasFile(dir.OptionFileName())
 }
 
-// HasHost returns true if the "host" option has been defined in this dir's
-// .skeema option file in the currently-selected environment section.
+// HasHost returns true if the "host" or "host-group" option has been defined
+// in this dir's .skeema option file in the currently-selected environment
+// section.
 func (dir *Dir) HasHost() bool {
 	optionFile, err := dir.OptionFile()
 	if err != nil || optionFile == nil {
 		return false
 	}
 	_, ok := optionFile.OptionValue("host")
+	if !ok {
+		_, ok = optionFile.OptionValue("host-group")
+	}
 	return ok
 }
 
+// HostConfigured returns true if this dir's configuration (including parent
+// dirs' option files, global option files, and the command-line) specifies
+// a host, either directly or by referencing a host group.
+func (dir *Dir) HostConfigured() bool {
+	return dir.Config.Changed("host") || dir.Config.Changed("host-group")
+}
+
 // HasSchema returns true if the "schema" option has been defined in this dir's
-// .skeema option file in the currently-selected environment section.
+// .skeema option file in the currently-selected environment section, or is
+// implied by the parent dir's default-schema-dir option.
 func (dir *Dir) HasSchema() bool {
+	if dir.defaultSchema != "" {
+		return true
+	}
 	optionFile, err := dir.OptionFile()
 	if err != nil || optionFile == nil {
 		return false
@@ -134,6 +241,58 @@ func (dir *Dir) HasSchema() bool {
 	return ok
 }
 
+// Flavor returns the database flavor and version declared by the dir's flavor
+// option, or nil if the option is not set. An error is returned if the value
+// cannot be parsed.
+func (dir *Dir) Flavor() (*ServerVersion, error) {
+	if dir.Config.Get("flavor") == "" {
+		return nil, nil
+	}
+	flavor, err := ParseFlavor(dir.Config.Get("flavor"))
+	if err != nil {
+		return nil, OptionError(dir.Config, "flavor", err)
+	}
+	return flavor, nil
+}
+
+// AllowsEnvironment returns true if the currently-selected environment may be
+// used with this dir. If the allowed-environments option has been set, the
+// environment must be present in its list; otherwise, all environments are
+// permitted.
+func (dir *Dir) AllowsEnvironment() bool {
+	if !dir.Config.Changed("allowed-environments") {
+		return true
+	}
+	for _, name := range dir.Config.GetSlice("allowed-environments", ',', true) {
+		if name == dir.section {
+			return true
+		}
+	}
+	return false
+}
+
+// declaresAllowedEnvironments returns true if the allowed-environments option
+// has been defined in this dir's .skeema option file, rather than inherited
+// from a parent dir.
+func (dir *Dir) declaresAllowedEnvironments() bool {
+	optionFile, err := dir.OptionFile()
+	if err != nil || optionFile == nil {
+		return false
+	}
+	_, ok := optionFile.OptionValue("allowed-environments")
+	return ok
+}
+
+// logSkippedEnvironment logs that dir is being skipped because the current
+// environment is not permitted by allowed-environments. Since subdirs inherit
+// the option, the message is only logged for the dir which declares it, rather
+// than once per subdir.
+func (dir *Dir) logSkippedEnvironment() {
+	if dir.declaresAllowedEnvironments() {
+		log.Infof("Skipping %s: environment \"%s\" not listed in allowed-environments\n", dir, dir.section)
+	}
+}
+
 // Instances returns 0 or more tengo.Instance pointers, based on the
 // directory's configuration. The Instances will NOT be checked for
 // connectivity. However, if the configuration is invalid (for example, illegal
@@ -143,10 +302,64 @@ func (dir *Dir) Instances() ([]*tengo.Instance, error) {
 	// If no host defined in this dir (meaning this dir's .skeema, as well as
 	// parent dirs' .skeema, global option files, or command-line) then nothing
 	// to do
-	if !dir.Config.Changed("host") {
+	if !dir.HostConfigured() {
+		return nil, nil
+	}
+
+	// Interpret the host value: if host-wrapper is set, use it to interpret the
+	// host list; otherwise if host-group is set, look up the group's host list;
+	// otherwise assume host is a comma-separated list of literal hostnames.
+	var hosts []string
+	if dir.Config.Changed("host-wrapper") {
+		s, err := NewInterpolatedShellOut(dir.Config.Get("host-wrapper"), dir, nil)
+		if err != nil {
+			return nil, err
+		}
+		if hosts, err = s.RunCaptureSplit(); err != nil {
+			return nil, err
+		}
+	} else if dir.Config.Changed("host-group") {
+		var err error
+		if hosts, err = dir.HostGroupHosts(dir.Config.Get("host-group")); err != nil {
+			return nil, err
+		}
+	} else {
+		hosts = dir.Config.GetSlice("host", ',', true)
+	}
+
+	return dir.instancesForHosts(hosts)
+}
+
+// ReadInstances returns a map of instance string to tengo.Instance, mapping
+// each of primaries to a corresponding read replica, based on the read-host
+// option. The caller should supply the full list of instances previously
+// returned by Instances, rather than calling it again, since host-wrapper
+// output may vary between calls. The read-host option must contain the same
+// number of hosts as primaries, in the same order. If read-host has not been
+// set, a nil map is returned. The instances will NOT be checked for
+// connectivity.
+func (dir *Dir) ReadInstances(primaries []*tengo.Instance) (map[string]*tengo.Instance, error) {
+	if !dir.Config.Changed("read-host") {
 		return nil, nil
 	}
+	replicas, err := dir.instancesForHosts(dir.Config.GetSlice("read-host", ',', true))
+	if err != nil {
+		return nil, err
+	}
+	if len(replicas) != len(primaries) {
+		return nil, fmt.Errorf("Option read-host maps to %d instances, but host maps to %d instances", len(replicas), len(primaries))
+	}
+	result := make(map[string]*tengo.Instance, len(primaries))
+	for n, primary := range primaries {
+		result[primary.String()] = replicas[n]
+	}
+	return result, nil
+}
 
+// instancesForHosts returns a tengo.Instance for each supplied host address,
+// using this dir's configuration for all other connection parameters. The
+// Instances will NOT be checked for connectivity.
+func (dir *Dir) instancesForHosts(hosts []string) ([]*tengo.Instance, error) {
 	// Before looping over hostnames, do a single lookup of user, password,
 	// connect-options, port, socket.
 	var userAndPass string
@@ -154,10 +367,11 @@ func (dir *Dir) Instances() ([]*tengo.Instance, error) {
 		userAndPass = dir.Config.Get("user")
 	} else {
 		userAndPass = fmt.Sprintf("%s:%s", dir.Config.Get("user"), dir.Config.Get("password"))
+		Secrets.Add(dir.Config.Get("password"))
 	}
 	params, err := dir.InstanceDefaultParams()
 	if err != nil {
-		return nil, fmt.Errorf("Invalid connection options: %s", err)
+		return nil, OptionError(dir.Config, "connect-options", fmt.Errorf("Invalid connection options: %s", err))
 	}
 	portValue := dir.Config.GetIntOrDefault("port")
 	portWasSupplied := dir.Config.Supplied("port")
@@ -165,22 +379,6 @@ func (dir *Dir) Instances() ([]*tengo.Instance, error) {
 	socketValue := dir.Config.Get("socket")
 	socketWasSupplied := dir.Config.Supplied("socket")
 
-	// Interpret the host value: if host-wrapper is set, use it to interpret the
-	// host list; otherwise assume host is a comma-separated list of literal
-	// hostnames.
-	var hosts []string
-	if dir.Config.Changed("host-wrapper") {
-		s, err := NewInterpolatedShellOut(dir.Config.Get("host-wrapper"), dir, nil)
-		if err != nil {
-			return nil, err
-		}
-		if hosts, err = s.RunCaptureSplit(); err != nil {
-			return nil, err
-		}
-	} else {
-		hosts = dir.Config.GetSlice("host", ',', true)
-	}
-
 	// For each hostname, construct a DSN and use it to create an Instance
 	var instances []*tengo.Instance
 	for _, host := range hosts {
@@ -192,7 +390,7 @@ func (dir *Dir) Instances() ([]*tengo.Instance, error) {
 		} else {
 			splitHost, splitPort, err := tengo.SplitHostOptionalPort(host)
 			if err != nil {
-				return nil, err
+				return nil, OptionError(dir.Config, "host", err)
 			}
 			if splitPort > 0 {
 				if portIsntDefault && portValue != splitPort {
@@ -205,17 +403,120 @@ func (dir *Dir) Instances() ([]*tengo.Instance, error) {
 		}
 		instance, err := tengo.NewInstance("mysql", dsn)
 		if err != nil || instance == nil {
-			if dir.Config.Changed("password") {
-				safeUserPass := fmt.Sprintf("%s:*****", dir.Config.Get("user"))
-				dsn = strings.Replace(dsn, userAndPass, safeUserPass, 1)
-			}
-			return nil, fmt.Errorf("Invalid connection information for %s (DSN=%s): %s", dir, dsn, err)
+			return nil, fmt.Errorf("Invalid connection information for %s (DSN=%s): %s", dir, Redact(dsn), err)
 		}
 		instances = append(instances, instance)
 	}
 	return instances, nil
 }
 
+// hostOverrideSource is a configuration source which overrides a dir's
+// connection options, so that the host supplied by another option (such as
+// check-host or workspace-host) is used in place of the dir's configured hosts.
+type hostOverrideSource struct {
+	optionName string
+	values     map[string]string
+}
+
+// OptionValue satisfies mybase.OptionValuer.
+func (s hostOverrideSource) OptionValue(optionName string) (string, bool) {
+	value, ok := s.values[optionName]
+	return value, ok
+}
+
+// instanceOverridingHost returns a tengo.Instance for the host supplied by the
+// named option, which may include a port. This dir's configuration is used for
+// all other connection parameters. The instance is checked for connectivity.
+func (dir *Dir) instanceOverridingHost(optionName string) (*tengo.Instance, error) {
+	host, port, err := tengo.SplitHostOptionalPort(dir.Config.Get(optionName))
+	if err != nil {
+		return nil, OptionError(dir.Config, optionName, err)
+	}
+	overrides := hostOverrideSource{optionName: optionName, values: map[string]string{"host": host}}
+	if port > 0 {
+		overrides.values["port"] = strconv.Itoa(port)
+	}
+	overrideDir := *dir
+	overrideDir.Config = dir.Config.Clone()
+	overrideDir.Config.AddSource(overrides)
+	instances, err := overrideDir.instancesForHosts([]string{host})
+	if err != nil {
+		return nil, err
+	}
+	if err := CheckConnect(instances[0]); err != nil {
+		return nil, fmt.Errorf("Unable to connect to %s %s: %s", optionName, instances[0], err)
+	}
+	return instances[0], nil
+}
+
+// HostGroupHosts returns the list of hosts belonging to the named host group.
+// Host groups are defined by setting the host option in a section named
+// "hosts:NAME" of any option file in this dir or its parent dirs, typically
+// the option file at the root of the repo. If multiple option files define the
+// same group, the one closest to this dir takes precedence.
+func (dir *Dir) HostGroupHosts(name string) ([]string, error) {
+	files, err := dir.cascadingOptionFiles()
+	if err != nil {
+		return nil, err
+	}
+	sectionName := fmt.Sprintf("hosts:%s", name)
+	for n := len(files) - 1; n >= 0; n-- {
+		f := files[n]
+		if err := ParseOptionFile(f, dir.Config); err != nil {
+			return nil, err
+		}
+		for _, withHost := range f.SectionsWithOption("host") {
+			if withHost == sectionName {
+				// Use a config consisting solely of this section, without any CLI values,
+				// in order to obtain the group's host list
+				_ = f.UseSection(sectionName) // section known to exist
+				cli := &mybase.CommandLine{Command: dir.Config.CLI.Command}
+				return mybase.NewConfig(cli, f).GetSlice("host", ',', true), nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("Host group %s is not defined by any option file for %s", name, dir)
+}
+
+// applyProfile adds the options of the named connection profile to dir's
+// configuration. Profiles are defined in a section named "profile:NAME" of any
+// option file in this dir or its parent dirs, typically the option file at the
+// root of the repo. If multiple option files define the same profile, the one
+// closest to this dir is used. Only ProfileOptions are obtained from the
+// profile; they take precedence over values set elsewhere in option files.
+func (dir *Dir) applyProfile(name string) error {
+	files, err := dir.cascadingOptionFiles()
+	if err != nil {
+		return err
+	}
+	sectionName := fmt.Sprintf("profile:%s", name)
+	for n := len(files) - 1; n >= 0; n-- {
+		f := files[n]
+		if err := ParseOptionFile(f, dir.Config); err != nil {
+			return err
+		}
+		if !f.HasSection(sectionName) {
+			continue
+		}
+		// Use a config consisting solely of this file and section, without any CLI
+		// values, but only obtain options actually set in the section itself
+		_ = f.UseSection(sectionName) // section known to exist
+		cli := &mybase.CommandLine{Command: dir.Config.CLI.Command}
+		profileConfig := mybase.NewConfig(cli, f)
+		source := make(profileSource)
+		for _, opt := range ProfileOptions {
+			for _, withOpt := range f.SectionsWithOption(opt) {
+				if withOpt == sectionName {
+					source[opt] = profileConfig.GetRaw(opt)
+				}
+			}
+		}
+		dir.Config.AddSource(source)
+		return nil
+	}
+	return fmt.Errorf("Profile %s is not defined by any option file for %s", name, dir)
+}
+
 // FirstInstance returns at most one tengo.Instance based on the directory's
 // configuration. If the config maps to multiple instances, only the first will
 // be returned. If the config maps to no instances, nil will be returned. The
@@ -224,15 +525,24 @@ func (dir *Dir) Instances() ([]*tengo.Instance, error) {
 // returned.
 func (dir *Dir) FirstInstance() (*tengo.Instance, error) {
 	instances, err := dir.Instances()
-	if len(instances) == 0 || err != nil {
+	if err != nil {
 		return nil, err
 	}
+	return dir.firstReachableInstance(instances)
+}
 
+// firstReachableInstance returns the first of the supplied instances which can
+// be connected to. If instances is empty, nil will be returned.
+func (dir *Dir) firstReachableInstance(instances []*tengo.Instance) (*tengo.Instance, error) {
+	if len(instances) == 0 {
+		return nil, nil
+	}
 	var lastErr error
 	for _, instance := range instances {
-		var ok bool
-		if ok, lastErr = instance.CanConnect(); ok {
+		if lastErr = CheckConnect(instance); lastErr == nil {
 			return instance, nil
+		} else if ce, ok := lastErr.(*ConnectError); ok {
+			ce.TraceOption(dir.Config)
 		}
 	}
 	if len(instances) == 1 {
@@ -265,8 +575,14 @@ func (dir *Dir) SchemaNames(instance *tengo.Instance) ([]string, error) {
 		return s.RunCaptureSplit()
 	}
 
-	if strings.ContainsAny(schemaValue, ",") {
-		return dir.Config.GetSlice("schema", ',', true), nil
+	// A fully quote-wrapped value is a single schema name, even if it contains a
+	// comma, since names are written this way by OptionFileValue
+	if strings.ContainsAny(schemaValue, ",") && rawSchemaValue == schemaValue {
+		schemaNames := dir.Config.GetSlice("schema", ',', true)
+		for n := range schemaNames {
+			schemaNames[n] = dir.TransformSchemaName(schemaNames[n])
+		}
+		return schemaNames, nil
 	}
 
 	if schemaValue == "*" {
@@ -282,7 +598,26 @@ func (dir *Dir) SchemaNames(instance *tengo.Instance) ([]string, error) {
 		return schemaNames, nil
 	}
 
-	return []string{schemaValue}, nil
+	return []string{dir.TransformSchemaName(schemaValue)}, nil
+}
+
+// TransformSchemaName returns name with the dir's schema-prefix and
+// schema-suffix applied. This permits a single dir tree to map to schemas that
+// are named differently in each environment.
+func (dir *Dir) TransformSchemaName(name string) string {
+	return dir.Config.Get("schema-prefix") + name + dir.Config.Get("schema-suffix")
+}
+
+// UntransformSchemaName reverses TransformSchemaName, returning the name of
+// a schema as it should be recorded in option files. The second return value
+// is false if name lacks the dir's schema-prefix or schema-suffix, meaning the
+// schema does not correspond to this dir's environment.
+func (dir *Dir) UntransformSchemaName(name string) (string, bool) {
+	prefix, suffix := dir.Config.Get("schema-prefix"), dir.Config.Get("schema-suffix")
+	if len(name) <= len(prefix)+len(suffix) || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, suffix) {
+		return name, (prefix == "" && suffix == "")
+	}
+	return name[len(prefix) : len(name)-len(suffix)], true
 }
 
 // InstanceDefaultParams returns a param string for use in constructing a
@@ -318,6 +653,7 @@ func (dir *Dir) InstanceDefaultParams() (string, error) {
 	v.Set("timeout", "5s")
 	v.Set("readTimeout", "5s")
 	v.Set("writeTimeout", "5s")
+	v.Set("charset", "utf8mb4,utf8") // utf8mb4 if supported, so that 4-byte characters in comments and defaults round-trip
 
 	// Set values from connect-options
 	for name, value := range options {
@@ -334,13 +670,49 @@ func (dir *Dir) InstanceDefaultParams() (string, error) {
 	return v.Encode(), nil
 }
 
+// DependsOn returns the cleaned absolute paths of the dirs listed in the
+// depends-on option. Relative paths are interpreted relative to this dir. Since
+// the option may be inherited from a parent dir, any reference to the dir
+// itself is omitted.
+func (dir *Dir) DependsOn() []string {
+	var result []string
+	for _, depPath := range dir.Config.GetSlice("depends-on", ',', true) {
+		if !filepath.IsAbs(depPath) {
+			depPath = path.Join(dir.Path, depPath)
+		}
+		if depPath = filepath.Clean(depPath); depPath != dir.Path {
+			result = append(result, depPath)
+		}
+	}
+	return result
+}
+
+// SingleFile returns true if the dir uses the single-file layout, in which all
+// tables are defined in one file named by SingleFileName, rather than one
+// *.sql file per table.
+func (dir *Dir) SingleFile() bool {
+	return strings.ToLower(dir.Config.Get("layout")) == "single-file"
+}
+
 // SQLFiles returns a slice of SQLFile pointers, representing the valid *.sql
 // files that already exist in a directory. Does not recursively search
 // subdirs.
 // An error will only be returned if we are unable to read the directory.
 // This method attempts to call Read() on each SQLFile to populate it; per-file
 // read errors are tracked within each SQLFile struct.
+// If the dir uses the single-file layout, one SQLFile is returned for each
+// statement in its combined file, all sharing the same FileName.
 func (dir *Dir) SQLFiles() ([]*SQLFile, error) {
+	if layout, err := dir.Config.GetEnum("layout", "per-table", "single-file"); err != nil {
+		return nil, OptionError(dir.Config, "layout", err)
+	} else if layout == "single-file" {
+		return ReadCombinedSQLFile(dir)
+	}
+	return dir.perTableSQLFiles()
+}
+
+// perTableSQLFiles returns SQLFiles for the dir using the per-table layout.
+func (dir *Dir) perTableSQLFiles() ([]*SQLFile, error) {
 	fileInfos, err := ioutil.ReadDir(dir.Path)
 	if err != nil {
 		return nil, err
@@ -355,20 +727,113 @@ func (dir *Dir) SQLFiles() ([]*SQLFile, error) {
 				continue
 			}
 		}
-		if !IsSQLFile(fi) {
+		if !IsSQLFile(fi) || dir.gitignore.Ignored(path.Join(dir.Path, name), false) {
 			continue
 		}
 		sf := &SQLFile{
 			Dir:      dir,
 			FileName: name,
 		}
-		sf.Read()
 		result = append(result, sf)
 	}
 
+	// Each SQLFile tracks its own read error, so no need to check for errors here
+	forEachConcurrently(len(result), MaxConcurrentReads, func(n int) {
+		result[n].Read()
+	})
 	return result, nil
 }
 
+// FileNamePolicy returns the dir's filename-policy, which controls how schema
+// and table names are represented in filenames. If the option is not
+// available, the default of "unicode" is returned.
+func (dir *Dir) FileNamePolicy() string {
+	if dir.Config == nil {
+		return "unicode"
+	} else if _, ok := dir.Config.CLI.Command.OptionValue("filename-policy"); !ok {
+		return "unicode"
+	}
+	policy, err := dir.Config.GetEnum("filename-policy", "unicode", "ascii", "transliterate")
+	if err != nil {
+		return "unicode" // already validated in AddGlobalConfigFiles
+	}
+	return policy
+}
+
+// TableFileName returns the name of the file used for the named table in the
+// per-table layout.
+func (dir *Dir) TableFileName(tableName string) string {
+	return IdentifierFileName(tableName, dir.FileNamePolicy(), false) + ".sql"
+}
+
+// SubdirName returns the name of the subdir used for the named schema.
+func (dir *Dir) SubdirName(schemaName string) string {
+	return IdentifierFileName(schemaName, dir.FileNamePolicy(), true)
+}
+
+// TableSQLFile returns the SQLFile defining the named table, in either layout.
+// An error is returned if the file cannot be read, or if the single-file
+// layout is in use and its file does not define the table.
+func (dir *Dir) TableSQLFile(tableName string) (*SQLFile, error) {
+	if !dir.SingleFile() {
+		sf := &SQLFile{
+			Dir:      dir,
+			FileName: dir.TableFileName(tableName),
+		}
+		_, err := sf.Read()
+		return sf, err
+	}
+	sqlFiles, err := dir.SQLFiles()
+	if err != nil {
+		return nil, err
+	}
+	for _, sf := range sqlFiles {
+		if sf.Table == tableName {
+			return sf, sf.Error
+		}
+	}
+	return nil, fmt.Errorf("%s: no definition found for table %s", path.Join(dir.Path, SingleFileName), tableName)
+}
+
+// WriteTable writes the supplied CREATE TABLE statement to the file for the
+// named table, returning the path of the file written and its new length. In
+// the single-file layout, the table's existing statement is replaced, or the
+// statement is appended if the table was not yet present.
+func (dir *Dir) WriteTable(tableName, createStmt string) (string, int, error) {
+	if !dir.SingleFile() {
+		sf := SQLFile{
+			Dir:      dir,
+			FileName: dir.TableFileName(tableName),
+			Contents: createStmt,
+		}
+		// Different table names may map to the same filename, depending on
+		// filename-policy and filesystem case-sensitivity. Refuse to clobber the
+		// definition of another table in this situation.
+		existing := &SQLFile{Dir: dir, FileName: sf.FileName}
+		if _, err := existing.Read(); err == nil && existing.Table != tableName {
+			return sf.Path(), 0, fmt.Errorf("file already defines table %s, which has a conflicting filename with table %s", existing.Table, tableName)
+		}
+		length, err := sf.Write()
+		return sf.Path(), length, err
+	}
+	return RewriteCombinedSQLFile(dir, tableName, createStmt)
+}
+
+// DeleteTable removes the definition of the named table, returning the path of
+// the affected file. In the per-table layout the table's file is deleted; in
+// the single-file layout the table's statement is removed from the file.
+func (dir *Dir) DeleteTable(tableName string) (string, error) {
+	if !dir.SingleFile() {
+		sf := SQLFile{
+			Dir:      dir,
+			FileName: dir.TableFileName(tableName),
+		}
+		return sf.Path(), sf.Delete()
+	}
+	filePath, _, err := RewriteCombinedSQLFile(dir, tableName, "")
+	return filePath, err
+}
+
 // Subdirs returns a slice of direct subdirectories of the current dir. An
 // error will be returned if there are problems reading the directory list.
 // If the subdirectory has an option file, it will be read and parsed, with
@@ -381,30 +846,73 @@ func (dir *Dir) Subdirs() ([]*Dir, error) {
 	result := make([]*Dir, 0, len(fileInfos))
 	for _, fi := range fileInfos {
 		if fi.IsDir() {
-			subdir := &Dir{
-				Path:    path.Join(dir.Path, fi.Name()),
-				Config:  dir.Config.Clone(),
-				section: dir.section,
+			subdirPath := path.Join(dir.Path, fi.Name())
+			if dir.gitignore.Ignored(subdirPath, true) {
+				log.Debugf("Skipping %s due to .gitignore", subdirPath)
+				continue
 			}
-			if subdir.HasOptionFile() {
-				f, err := subdir.OptionFile()
-				if err != nil {
-					return nil, err
-				}
-				subdir.Config.AddSource(f)
+			subdir := &Dir{
+				Path:           subdirPath,
+				Config:         dir.Config.Clone(),
+				section:        dir.section,
+				optionFileName: dir.optionFileName,
 			}
 			result = append(result, subdir)
 		}
 	}
+
+	// Read each subdir's option file and .gitignore in parallel. If any fail,
+	// the error of the first such subdir (in filename order) is returned.
+	parentHasHost := dir.HasHost()
+	errs := make([]error, len(result))
+	forEachConcurrently(len(result), MaxConcurrentReads, func(n int) {
+		errs[n] = dir.initSubdir(result[n], parentHasHost)
+	})
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
 	return result, nil
 }
 
+// initSubdir applies subdir's own option file, along with any connection
+// profile and .gitignore rules, on top of the configuration inherited from
+// dir.
+func (dir *Dir) initSubdir(subdir *Dir, parentHasHost bool) error {
+	if subdir.HasOptionFile() {
+		f, err := subdir.OptionFile()
+		if err != nil {
+			return err
+		}
+		subdir.Config.AddSource(f)
+		// Re-apply the profile, so that it keeps precedence over the subdir's
+		// option file, or to switch to a different profile named by it
+		if name := subdir.Config.Get("profile"); name != "" {
+			if err := subdir.applyProfile(name); err != nil {
+				return err
+			}
+		}
+	}
+	if subdir.Config.GetBool("respect-gitignore") {
+		var err error
+		if subdir.gitignore, err = dir.gitignore.ReadGitignore(subdir.Path); err != nil {
+			return err
+		}
+	}
+	subdir.applyDefaultSchema(parentHasHost)
+	subdir.settleConfig()
+	return nil
+}
+
 // CreateSubdir creates and returns a new subdir of the current dir.
 func (dir *Dir) CreateSubdir(name string, optionFile *mybase.File) (*Dir, error) {
 	subdir := &Dir{
-		Path:    path.Join(dir.Path, name),
-		Config:  dir.Config.Clone(),
-		section: dir.section,
+		Path:           path.Join(dir.Path, name),
+		Config:         dir.Config.Clone(),
+		section:        dir.section,
+		optionFileName: dir.optionFileName,
+		gitignore:      dir.gitignore,
 	}
 
 	if created, err := subdir.CreateIfMissing(); err != nil {
@@ -444,7 +952,7 @@ func (dir *Dir) TargetGroups(firstOnly, fatalSQLFileErrors bool) <-chan TargetGr
 		targetsByInstance := NewTargetGroupMap()
 		goodDirCount, badDirCount := generateTargetsForDir(dir, targetsByInstance, firstOnly, fatalSQLFileErrors)
 		for _, tg := range targetsByInstance {
-			groups <- tg
+			groups <- tg.SortByDependencies()
 		}
 		if badDirCount >= MaxNonSkeemaDirs {
 			log.Errorf("Aborted directory descent early: traversed %d subdirs that did not define a host and schema", badDirCount)
@@ -492,59 +1000,92 @@ func (dir *Dir) Targets() []*Target {
 // cleans up the temp schema. Errors that occur along the way are handled and
 // tracked accordingly.
 //
-// The supplied instance will be used for temporary schema operations, and
-// will be stored in the returned Target, but may safely be changed to point
-// to a different instance as needed.
+// The supplied instance will be used for temporary schema operations, unless
+// the workspace-host option is set. It will be stored in the returned Target,
+// but may safely be changed to point to a different instance as needed.
 func (dir *Dir) TargetTemplate(instance *tengo.Instance) Target {
 	t := Target{
 		Dir:             dir,
 		Instance:        instance,
 		SQLFileErrors:   make(map[string]*SQLFile),
 		SQLFileWarnings: make([]error, 0),
+		ModuleTables:    make(map[string]string),
 	}
-	tempSchemaName := dir.Config.Get("temp-schema")
 	sqlFiles, err := dir.SQLFiles()
 	if err != nil {
 		t.Err = fmt.Errorf("Unable to list SQL files in %s: %s", dir, err)
 		return t
 	}
 
-	// TODO: want to skip binlogging for all temp schema actions, if super priv available
-	var tx *sql.Tx
-	if tx, err = t.lockTempSchema(30 * time.Second); err != nil {
-		t.Err = fmt.Errorf("Unable to lock temporary schema on %s: %s", instance, err)
+	// Merge in tables from any modules. A module may not define a table that is
+	// also defined by the dir itself or by another module.
+	moduleFiles, err := dir.ModuleSQLFiles()
+	if err != nil {
+		t.Err = fmt.Errorf("Unable to use modules for %s: %s", dir, err)
 		return t
 	}
-	defer func() {
-		unlockErr := t.unlockTempSchema(tx)
-		if unlockErr != nil && t.Err == nil {
-			t.Err = fmt.Errorf("Unable to unlock temporary schema on %s: %s", instance, unlockErr)
+	if len(moduleFiles) > 0 {
+		definedBy := make(map[string]string, len(sqlFiles))
+		for _, sf := range sqlFiles {
+			if sf.Table != "" {
+				definedBy[sf.Table] = sf.Path()
+			}
 		}
-	}()
+		for _, sf := range moduleFiles {
+			if sf.Error != nil {
+				continue
+			}
+			if otherPath, already := definedBy[sf.Table]; already {
+				sf.Error = fmt.Errorf("%s: table %s is already defined by %s", sf.Path(), sf.Table, otherPath)
+				continue
+			}
+			definedBy[sf.Table] = sf.Path()
+			t.ModuleTables[sf.Table] = sf.Path()
+		}
+		sqlFiles = append(sqlFiles, moduleFiles...)
+	}
 
-	tempSchema, err := instance.Schema(tempSchemaName)
+	wsInstance, err := dir.WorkspaceInstance(instance)
 	if err != nil {
-		t.Err = fmt.Errorf("Unable to check for existence of temp schema on %s: %s", instance, err)
+		t.Err = err
 		return t
 	}
-	if tempSchema != nil {
-		// Attempt to drop any tables already present in tempSchema, but fail if
-		// any of them actually have 1 or more rows
-		if err := instance.DropTablesInSchema(tempSchema, true); err != nil {
-			t.Err = fmt.Errorf("Cannot drop existing temp schema tables on %s: %s", instance, err)
-			return t
-		}
-	} else {
-		tempSchema, err = instance.CreateSchema(tempSchemaName, dir.Config.Get("default-character-set"), dir.Config.Get("default-collation"))
-		if err != nil {
-			t.Err = fmt.Errorf("Cannot create temporary schema on %s: %s", instance, err)
+
+	// If the normalize cache indicates that these exact files previously yielded
+	// the same schema that currently exists on instance, the workspace can be
+	// skipped, using the live schema in its place. The cache is keyed on the
+	// version of the workspace instance, since that is what normalizes the files.
+	cache, err := NewNormalizeCache(dir, wsInstance)
+	if err != nil {
+		log.Warnf("Normalize cache disabled: %s", err)
+	}
+	var cacheKey string
+	if cache != nil && !hasSQLFileError(sqlFiles) {
+		cacheKey = cache.Key(dir, sqlFiles)
+		if schema := dir.cachedNormalizedSchema(instance, cache.Get(cacheKey)); schema != nil {
+			log.Debugf("Skipping workspace for %s: *.sql files unchanged since previously normalized, per normalize-cache-dir", dir)
+			for _, sf := range sqlFiles {
+				t.SQLFileWarnings = append(t.SQLFileWarnings, sf.Warnings...)
+			}
+			t.SchemaFromDir = schema
 			return t
 		}
 	}
 
-	db, err := instance.Connect(tempSchemaName, "")
+	ws, err := Workspaces.Acquire(wsInstance, dir)
+	if err != nil {
+		t.Err = err
+		return t
+	}
+	defer func() {
+		if releaseErr := ws.Release(); releaseErr != nil && t.Err == nil {
+			t.Err = releaseErr
+		}
+	}()
+
+	db, err := wsInstance.Connect(ws.SchemaName, "")
 	if err != nil {
-		t.Err = fmt.Errorf("Cannot connect to %s: %s", instance, err)
+		t.Err = fmt.Errorf("Cannot connect to %s: %s", wsInstance, err)
 		return t
 	}
 	for _, sf := range sqlFiles {
@@ -565,20 +1106,68 @@ func (dir *Dir) TargetTemplate(instance *tengo.Instance) Target {
 			t.SQLFileErrors[sf.Path()] = sf
 		}
 	}
-	if t.SchemaFromDir, err = tempSchema.CachedCopy(); err != nil {
-		t.Err = fmt.Errorf("Unable to clone temporary schema on %s: %s", instance, err)
+	if t.SchemaFromDir, err = ws.Schema.CachedCopy(); err != nil {
+		t.Err = fmt.Errorf("Unable to clone temporary schema on %s: %s", wsInstance, err)
+	} else if cacheKey != "" && len(t.SQLFileErrors) == 0 {
+		cache.Add(cacheKey, t.SchemaFromDir)
 	}
+	return t
+}
 
-	if dir.Config.GetBool("reuse-temp-schema") {
-		if err := instance.DropTablesInSchema(tempSchema, true); err != nil {
-			t.Err = fmt.Errorf("Cannot drop tables in temporary schema on %s: %s", instance, err)
+// cachedNormalizedSchema returns a copy of dir's first schema on instance, if
+// its fingerprint matches the supplied one. Otherwise, nil is returned. The
+// copy's tables never have a next auto-increment value, matching the result of
+// normalizing the *.sql files in a workspace; this way, the copy may be used
+// for other instances and schemas without leaking instance's live values.
+func (dir *Dir) cachedNormalizedSchema(instance *tengo.Instance, fingerprint string) *tengo.Schema {
+	if fingerprint == "" {
+		return nil
+	}
+	schemaNames, err := dir.SchemaNames(instance)
+	if err != nil || len(schemaNames) == 0 {
+		return nil
+	}
+	live, err := instance.Schema(schemaNames[0])
+	if err != nil || live == nil {
+		return nil
+	}
+
+	// Introspect the tables into a private copy of the schema, so that they may
+	// be adjusted below without affecting other users of instance's schema
+	schema := *live
+	schema.PurgeTableCache()
+	tables, err := schema.Tables()
+	if err != nil {
+		return nil
+	}
+	if actual, err := SchemaFingerprint(&schema); err != nil || actual != fingerprint {
+		return nil
+	}
+	for n, table := range tables {
+		if table.NextAutoIncrement <= 1 {
+			continue
+		} else if table.UnsupportedDDL {
+			// Without tengo support for the table, its CREATE can't be regenerated
+			// without the next auto-increment value, so the workspace is needed
+			return nil
 		}
-	} else {
-		if err := instance.DropSchema(tempSchema, true); err != nil {
-			t.Err = fmt.Errorf("Cannot drop temporary schema on %s: %s", instance, err)
+		tables[n] = withoutNextAutoInc(table)
+	}
+	result, err := schema.CachedCopy()
+	if err != nil {
+		return nil
+	}
+	return result
+}
+
+// hasSQLFileError returns true if any of sqlFiles has an error.
+func hasSQLFileError(sqlFiles []*SQLFile) bool {
+	for _, sf := range sqlFiles {
+		if sf.Error != nil {
+			return true
 		}
 	}
-	return t
+	return false
 }
 
 // OptionFile returns a pointer to a mybase.File for this directory, representing
@@ -586,25 +1175,131 @@ func (dir *Dir) TargetTemplate(instance *tengo.Instance) Target {
 // errors in either process will be returned. The section specified by
 // dir.section will automatically be selected for use in the file if it exists.
 func (dir *Dir) OptionFile() (*mybase.File, error) {
-	f := mybase.NewFile(dir.Path, ".skeema")
+	f := mybase.NewFile(dir.Path, dir.OptionFileName())
 	if err := f.Read(); err != nil {
 		return nil, err
 	}
-	if err := f.Parse(dir.Config); err != nil {
+	if err := ParseOptionFile(f, dir.Config); err != nil {
 		return nil, err
 	}
 	_ = f.UseSection(dir.section) // we don't care if the section doesn't exist
+	if err := CheckRequiredVersion(f, dir.Config); err != nil {
+		return nil, err
+	}
 	return f, nil
 }
 
+// settleConfig forces dir.Config to finish any pending rebuild of its cached
+// option values. A mybase.Config lazily rebuilds its cache upon the first
+// lookup after a source is added, so lookups are only safe for concurrent use
+// once the config has settled. Callers must not add sources to dir.Config
+// afterwards; a goroutine which needs to override options should use a Clone
+// instead, as check-host handling does.
+func (dir *Dir) settleConfig() {
+	// Looking up any option forces mybase to rebuild its lazy cache now, before
+	// concurrent reads begin. The host option is always present, since it is a
+	// global option.
+	dir.Config.GetRaw("host")
+}
+
+// forEachConcurrently calls fn once for each integer in [0, count), using at
+// most maxWorkers goroutines at a time. It returns once all calls are done.
+func forEachConcurrently(count, maxWorkers int, fn func(n int)) {
+	if count <= 1 || maxWorkers <= 1 {
+		for n := 0; n < count; n++ {
+			fn(n)
+		}
+		return
+	}
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < maxWorkers && w < count; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := range indexes {
+				fn(n)
+			}
+		}()
+	}
+	for n := 0; n < count; n++ {
+		indexes <- n
+	}
+	close(indexes)
+	wg.Wait()
+}
+
+// errFilesystemTimeout is returned by runWithTimeout if the operation did not
+// complete in time.
+var errFilesystemTimeout = errors.New("filesystem operation timed out")
+
+// runWithTimeout runs fn, returning its error, or errFilesystemTimeout if it
+// does not complete within timeout. In the latter case fn continues running in
+// the background, and its results must not be used. A timeout of 0 means no
+// limit.
+func runWithTimeout(timeout time.Duration, fn func() error) error {
+	if timeout == 0 {
+		return fn()
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- fn()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(timeout):
+		return errFilesystemTimeout
+	}
+}
+
+// parentGitignoreRules returns the rules from .gitignore files in this dir and
+// its parents, up to and including the root of the git repo containing it. If
+// the dir is not in a git repo, only its own .gitignore file is used.
+func (dir *Dir) parentGitignoreRules() (IgnoreRules, error) {
+	dirPaths := []string{dir.Path}
+	for curPath := dir.Path; ; {
+		if fi, err := os.Stat(path.Join(curPath, ".git")); err == nil && fi.IsDir() {
+			break
+		}
+		parentPath := path.Dir(curPath)
+		if parentPath == curPath {
+			dirPaths = dirPaths[0:1] // reached filesystem root without finding a repo
+			break
+		}
+		dirPaths = append(dirPaths, parentPath)
+		curPath = parentPath
+	}
+	var rules IgnoreRules
+	var err error
+	for n := len(dirPaths) - 1; n >= 0; n-- {
+		if rules, err = rules.ReadGitignore(dirPaths[n]); err != nil {
+			return nil, err
+		}
+	}
+	return rules, nil
+}
+
 // cascadingOptionFiles returns a slice of *mybase.File, corresponding to the
 // option file in this dir as well as its parent dir hierarchy. Evaluation
-// of parent dirs stops once we hit either a directory containing .git, the
-// user's home directory, or the root of the filesystem. The result is ordered
-// such that the closest-to-root dir's File is returned first and this dir's
-// File last. The files will be read, but not parsed.
+// of parent dirs stops once we hit either a directory containing .git or a
+// root marker file, the user's home directory, or the root of the filesystem.
+// The result is ordered such that the closest-to-root dir's File is returned
+// first and this dir's File last. The files will be read, but not parsed.
 func (dir *Dir) cascadingOptionFiles() (files []*mybase.File, errReturn error) {
 	home := filepath.Clean(os.Getenv("HOME"))
+	maxDepth, err := dir.Config.GetInt("parent-config-depth")
+	if err != nil || maxDepth < 0 {
+		return nil, OptionError(dir.Config, "parent-config-depth", NewExitValue(CodeBadConfig, "Option parent-config-depth must be a non-negative integer; found \"%s\"", dir.Config.Get("parent-config-depth")))
+	}
+	parentConfigs := dir.Config.GetBool("parent-configs")
+	if !parentConfigs {
+		maxDepth = 0
+	}
+	timeout, err := time.ParseDuration(dir.Config.Get("parent-config-timeout"))
+	if err != nil || timeout < 0 {
+		return nil, OptionError(dir.Config, "parent-config-timeout", NewExitValue(CodeBadConfig, "Option parent-config-timeout must be a duration such as 5s; found \"%s\"", dir.Config.Get("parent-config-timeout")))
+	}
 
 	// we know the first character will be a /, so discard the first split result
 	// which we know will be an empty string
@@ -612,25 +1307,38 @@ func (dir *Dir) cascadingOptionFiles() (files []*mybase.File, errReturn error) {
 	files = make([]*mybase.File, 0, len(components))
 
 	// Examine parent dirs, going up one level at a time, stopping early if we
-	// hit either the user's home directory or a directory containing a .git subdir.
-	for n := len(components) - 1; n >= 0; n-- {
+	// hit either the user's home directory or a directory containing a .git subdir
+	// or root marker file, or exceed parent-config-depth levels above dir.
+	for n := len(components) - 1; n >= 0 && n >= len(components)-1-maxDepth; n-- {
 		curPath := "/" + path.Join(components[0:n+1]...)
-		if curPath == home {
+		if curPath == home && parentConfigs {
 			// We already read ~/.skeema as a global file
 			break
 		}
-		fileInfos, err := ioutil.ReadDir(curPath)
-		// We ignore errors here since we expect the dir to not exist in some cases
-		// (for example, init command on a new dir)
+		var fileInfos []os.FileInfo
+		err := runWithTimeout(timeout, func() (err error) {
+			fileInfos, err = ioutil.ReadDir(curPath)
+			return err
+		})
+		if err == errFilesystemTimeout {
+			// Slow or unavailable network mounts should not block the run forever,
+			// but proceeding without their option files could silently omit config
+			return nil, fmt.Errorf("Unable to examine %s for option files: no response after %s", curPath, timeout)
+		}
+		// We ignore other errors here since we expect the dir to not exist in some
+		// cases (for example, init command on a new dir)
 		if err != nil {
 			continue
 		}
 		for _, fi := range fileInfos {
-			if fi.Name() == ".git" {
+			if fi.Name() == ".git" || fi.Name() == RootMarkerFileName {
 				n = -1 // stop outer loop early, after done with this dir
-			} else if fi.Name() == ".skeema" {
-				f := mybase.NewFile(curPath, ".skeema")
-				if readErr := f.Read(); readErr != nil {
+			} else if fi.Name() == dir.OptionFileName() {
+				f := mybase.NewFile(curPath, fi.Name())
+				readErr := runWithTimeout(timeout, f.Read)
+				if readErr == errFilesystemTimeout {
+					return nil, fmt.Errorf("Unable to read option file %s: no response after %s", f.Path(), timeout)
+				} else if readErr != nil {
 					errReturn = readErr
 				} else {
 					files = append(files, f)
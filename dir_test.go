@@ -1,9 +1,15 @@
 package main
 
 import (
+	"fmt"
+	"io/ioutil"
 	"net/url"
+	"os"
+	"path/filepath"
 	"reflect"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/skeema/mybase"
 	"github.com/skeema/tengo"
@@ -116,14 +122,15 @@ func TestInstanceDefaultParams(t *testing.T) {
 			t.Errorf("Expected connect-options=\"%s\" to yield default params \"%s\", instead found \"%s\"", connectOptions, expected, actual)
 		}
 	}
-	baseDefaults := "interpolateParams=true&foreign_key_checks=0&timeout=5s&writeTimeout=5s&readTimeout=5s"
+	baseDefaults := "interpolateParams=true&foreign_key_checks=0&timeout=5s&writeTimeout=5s&readTimeout=5s&charset=utf8mb4%2Cutf8"
 	expectParams := map[string]string{
 		"":                                          baseDefaults,
 		"foo='bar'":                                 baseDefaults + "&foo=%27bar%27",
 		"bool=true,quotes='yes,no'":                 baseDefaults + "&bool=true&quotes=%27yes,no%27",
 		`escaped=we\'re ok`:                         baseDefaults + "&escaped=we%5C%27re ok",
 		`escquotes='we\'re still quoted',this=that`: baseDefaults + "&escquotes=%27we%5C%27re still quoted%27&this=that",
-		"ok=1,writeTimeout=12ms":                    "interpolateParams=true&foreign_key_checks=0&timeout=5s&writeTimeout=12ms&readTimeout=5s&ok=1",
+		"ok=1,writeTimeout=12ms":                    "interpolateParams=true&foreign_key_checks=0&timeout=5s&writeTimeout=12ms&readTimeout=5s&charset=utf8mb4%2Cutf8&ok=1",
+		"charset=latin1":                            "interpolateParams=true&foreign_key_checks=0&timeout=5s&writeTimeout=5s&readTimeout=5s&charset=latin1",
 	}
 	for connOpts, expected := range expectParams {
 		assertDefaultParams(connOpts, expected)
@@ -141,3 +148,516 @@ func TestInstanceDefaultParams(t *testing.T) {
 		}
 	}
 }
+
+func TestCascadingOptionFiles(t *testing.T) {
+	base, err := ioutil.TempDir("", "skeematest")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(base)
+
+	leafPath := filepath.Join(base, "outer", "root", "leaf")
+	if err := os.MkdirAll(leafPath, 0777); err != nil {
+		t.Fatalf("Unable to create dirs: %s", err)
+	}
+	for _, p := range []string{"outer", "outer/root", "outer/root/leaf"} {
+		if err := ioutil.WriteFile(filepath.Join(base, p, ".skeema"), []byte("schema=foo\n"), 0666); err != nil {
+			t.Fatalf("Unable to write option file: %s", err)
+		}
+	}
+
+	assertFileCount := func(expected int, depthAndParentConfigs ...string) {
+		optionValues := map[string]string{"parent-config-depth": "20", "parent-config-timeout": "5s", "parent-configs": "1"}
+		if len(depthAndParentConfigs) > 0 {
+			optionValues["parent-config-depth"] = depthAndParentConfigs[0]
+		}
+		if len(depthAndParentConfigs) > 1 {
+			optionValues["parent-configs"] = depthAndParentConfigs[1]
+		}
+		dir := &Dir{Path: leafPath, Config: getConfig(optionValues)}
+		files, err := dir.cascadingOptionFiles()
+		if err != nil {
+			t.Errorf("Unexpected error from cascadingOptionFiles: %s", err)
+		} else if len(files) != expected {
+			t.Errorf("Expected %d option files, instead found %d", expected, len(files))
+		} else if files[len(files)-1].Dir != leafPath {
+			t.Errorf("Expected last option file to be in %s, instead found %s", leafPath, files[len(files)-1].Dir)
+		}
+	}
+	assertFileCount(3)
+	assertFileCount(2, "1")
+	assertFileCount(1, "0")
+	assertFileCount(1, "20", "0")
+	if err := ioutil.WriteFile(filepath.Join(base, "outer", "root", RootMarkerFileName), []byte{}, 0666); err != nil {
+		t.Fatalf("Unable to write root marker file: %s", err)
+	}
+	assertFileCount(2)
+}
+
+func TestHostGroupHosts(t *testing.T) {
+	base, err := ioutil.TempDir("", "skeematest")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(base)
+	leafPath := filepath.Join(base, "leaf")
+	if err := os.MkdirAll(leafPath, 0777); err != nil {
+		t.Fatalf("Unable to create dirs: %s", err)
+	}
+	contents := "[hosts:shards]\nhost=shard1.db.host,shard2.db.host:3307\n\n[hosts:other]\nhost=other.db.host\n"
+	if err := ioutil.WriteFile(filepath.Join(base, RootMarkerFileName), []byte{}, 0666); err != nil {
+		t.Fatalf("Unable to write root marker file: %s", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(base, ".skeema"), []byte(contents), 0666); err != nil {
+		t.Fatalf("Unable to write option file: %s", err)
+	}
+
+	cmd := mybase.NewCommand("test", "1.0", "this is for testing", nil)
+	AddGlobalOptions(cmd)
+	cli := &mybase.CommandLine{
+		Command: cmd,
+	}
+	dir := &Dir{
+		Path:    leafPath,
+		Config:  mybase.NewConfig(cli, dummySource(map[string]string{"host-group": "shards"})),
+		section: "production",
+	}
+	instances, err := dir.Instances()
+	if err != nil {
+		t.Fatalf("Unexpected error from Instances: %s", err)
+	}
+	var foundInstances []string
+	for _, inst := range instances {
+		foundInstances = append(foundInstances, inst.String())
+	}
+	if expected := []string{"shard1.db.host:3306", "shard2.db.host:3307"}; !reflect.DeepEqual(expected, foundInstances) {
+		t.Errorf("Expected instances %v, instead found %v", expected, foundInstances)
+	}
+
+	if _, err := dir.HostGroupHosts("missing"); err == nil {
+		t.Error("Expected error from HostGroupHosts on undefined group, but it was nil")
+	}
+}
+
+func TestReadInstances(t *testing.T) {
+	getDir := func(optionValues map[string]string) *Dir {
+		cmd := mybase.NewCommand("test", "1.0", "this is for testing", nil)
+		AddGlobalOptions(cmd)
+		cli := &mybase.CommandLine{
+			Command: cmd,
+		}
+		return &Dir{
+			Path:    "/tmp/dummydir",
+			Config:  mybase.NewConfig(cli, dummySource(optionValues)),
+			section: "production",
+		}
+	}
+
+	dir := getDir(map[string]string{"host": "some.db.host"})
+	if readInstances, err := dir.ReadInstances(nil); readInstances != nil || err != nil {
+		t.Errorf("Expected nil map and error without read-host, instead found %v, %v", readInstances, err)
+	}
+
+	dir = getDir(map[string]string{"host": "primary1,primary2:3307", "read-host": "replica1,replica2:3307"})
+	primaries, err := dir.Instances()
+	if err != nil {
+		t.Fatalf("Unexpected error from Instances: %s", err)
+	}
+	readInstances, err := dir.ReadInstances(primaries)
+	if err != nil {
+		t.Fatalf("Unexpected error from ReadInstances: %s", err)
+	}
+	expected := map[string]string{
+		"primary1:3306": "replica1:3306",
+		"primary2:3307": "replica2:3307",
+	}
+	if len(readInstances) != len(expected) {
+		t.Errorf("Expected %d read instances, instead found %d", len(expected), len(readInstances))
+	}
+	for primary, replica := range expected {
+		if readInstances[primary] == nil || readInstances[primary].String() != replica {
+			t.Errorf("Expected %s to map to %s, instead found %v", primary, replica, readInstances[primary])
+		}
+	}
+
+	dir = getDir(map[string]string{"host": "primary1,primary2", "read-host": "replica1"})
+	primaries, err = dir.Instances()
+	if err != nil {
+		t.Fatalf("Unexpected error from Instances: %s", err)
+	}
+	if _, err := dir.ReadInstances(primaries); err == nil {
+		t.Error("Expected error from ReadInstances with mismatched host counts, but it was nil")
+	}
+}
+
+func TestModuleSQLFiles(t *testing.T) {
+	base, err := ioutil.TempDir("", "skeematest")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(base)
+	leafPath := filepath.Join(base, "leaf")
+	modPath := filepath.Join(base, "queuemod")
+	for _, dirPath := range []string{leafPath, modPath} {
+		if err := os.MkdirAll(dirPath, 0777); err != nil {
+			t.Fatalf("Unable to create dirs: %s", err)
+		}
+	}
+	contents := "CREATE TABLE jobs (id int NOT NULL, PRIMARY KEY (id));\n"
+	if err := ioutil.WriteFile(filepath.Join(modPath, "jobs.sql"), []byte(contents), 0666); err != nil {
+		t.Fatalf("Unable to write module file: %s", err)
+	}
+
+	getDir := func(modules string) *Dir {
+		return &Dir{
+			Path:   leafPath,
+			Config: getConfig(map[string]string{"modules": modules}),
+		}
+	}
+	sqlFiles, err := getDir("../queuemod").ModuleSQLFiles()
+	if err != nil {
+		t.Fatalf("Unexpected error from ModuleSQLFiles: %s", err)
+	}
+	if len(sqlFiles) != 1 || sqlFiles[0].FileName != "jobs.sql" || sqlFiles[0].Error != nil {
+		t.Errorf("Unexpected result from ModuleSQLFiles: %+v", sqlFiles)
+	}
+	if sqlFiles, err := getDir("").ModuleSQLFiles(); err != nil || len(sqlFiles) != 0 {
+		t.Errorf("Expected no module files and no error without modules option; instead found %v, %v", sqlFiles, err)
+	}
+	if _, err := getDir("../missing").ModuleSQLFiles(); err == nil {
+		t.Error("Expected error from ModuleSQLFiles with nonexistent module path, but it was nil")
+	}
+	if !isGitModule("https://github.com/example/mod.git#v1") || !isGitModule("git@github.com:example/mod") || isGitModule("../queuemod") {
+		t.Error("Unexpected result from isGitModule")
+	}
+}
+
+func TestTransformSchemaName(t *testing.T) {
+	dir := &Dir{Config: getConfig(map[string]string{"schema-prefix": "stg_", "schema-suffix": "_v2"})}
+	if actual := dir.TransformSchemaName("product"); actual != "stg_product_v2" {
+		t.Errorf("Expected TransformSchemaName to return stg_product_v2, instead found %s", actual)
+	}
+	if actual, ok := dir.UntransformSchemaName("stg_product_v2"); actual != "product" || !ok {
+		t.Errorf("Expected UntransformSchemaName to return product, true; instead found %s, %t", actual, ok)
+	}
+	for _, name := range []string{"product_v2", "stg_product", "stg__v2"} {
+		if _, ok := dir.UntransformSchemaName(name); ok {
+			t.Errorf("Expected UntransformSchemaName(%s) to return false, but it returned true", name)
+		}
+	}
+
+	dir = &Dir{Config: getConfig(map[string]string{"schema-prefix": "", "schema-suffix": ""})}
+	if actual, ok := dir.UntransformSchemaName("product"); actual != "product" || !ok {
+		t.Errorf("Expected UntransformSchemaName with no affixes to return product, true; instead found %s, %t", actual, ok)
+	}
+}
+
+func TestDefaultSchemaDir(t *testing.T) {
+	base, err := ioutil.TempDir("", "skeematest")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(base)
+	files := map[string]string{
+		".skeema":          "host=127.0.0.1\ndefault-schema-dir=true\n",
+		"product/.skeema":  "default-character-set=utf8mb4\n",
+		"billing/foo.sql":  "",
+		"explicit/.skeema": "schema=something_else\n",
+		".hidden/foo.sql":  "",
+	}
+	for name, contents := range files {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(base, name)), 0777); err != nil {
+			t.Fatalf("Unable to create dirs: %s", err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(base, name), []byte(contents), 0666); err != nil {
+			t.Fatalf("Unable to write file: %s", err)
+		}
+	}
+
+	cmd := mybase.NewCommand("test", "1.0", "this is for testing", nil)
+	AddGlobalOptions(cmd)
+	cfg := mybase.NewConfig(&mybase.CommandLine{Command: cmd}, dummySource(map[string]string{}))
+	hostDir := &Dir{Path: base, Config: cfg, section: "production"}
+	if f, err := hostDir.OptionFile(); err != nil {
+		t.Fatalf("Unexpected error reading option file: %s", err)
+	} else {
+		hostDir.Config.AddSource(f)
+	}
+	subdirs, err := hostDir.Subdirs()
+	if err != nil {
+		t.Fatalf("Unexpected error from Subdirs: %s", err)
+	}
+	expected := map[string]string{
+		"product":  "product",
+		"billing":  "billing",
+		"explicit": "something_else",
+		".hidden":  "",
+	}
+	for _, subdir := range subdirs {
+		expectSchema := expected[subdir.BaseName()]
+		if subdir.HasSchema() != (expectSchema != "") {
+			t.Errorf("Unexpected result from HasSchema for %s: %t", subdir.BaseName(), subdir.HasSchema())
+		}
+		if actual := subdir.Config.Get("schema"); actual != expectSchema {
+			t.Errorf("Expected schema of %s to be %q, instead found %q", subdir.BaseName(), expectSchema, actual)
+		}
+	}
+}
+
+func TestRunWithTimeout(t *testing.T) {
+	if err := runWithTimeout(time.Second, func() error { return nil }); err != nil {
+		t.Errorf("Expected nil error, instead found %v", err)
+	}
+	block := make(chan struct{})
+	defer close(block)
+	err := runWithTimeout(10*time.Millisecond, func() error {
+		<-block
+		return nil
+	})
+	if err != errFilesystemTimeout {
+		t.Errorf("Expected errFilesystemTimeout, instead found %v", err)
+	}
+}
+
+func TestForEachConcurrently(t *testing.T) {
+	for _, maxWorkers := range []int{1, 4, MaxConcurrentReads} {
+		var mu sync.Mutex
+		seen := make(map[int]int)
+		forEachConcurrently(100, maxWorkers, func(n int) {
+			mu.Lock()
+			seen[n]++
+			mu.Unlock()
+		})
+		if len(seen) != 100 {
+			t.Errorf("Expected 100 distinct calls with maxWorkers=%d, instead found %d", maxWorkers, len(seen))
+		}
+		for n, count := range seen {
+			if count != 1 {
+				t.Errorf("Expected fn(%d) to be called once with maxWorkers=%d, instead called %d times", n, maxWorkers, count)
+			}
+		}
+	}
+}
+
+func TestSQLFilesOrder(t *testing.T) {
+	base, err := ioutil.TempDir("", "skeematest")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(base)
+	for n := 0; n < 3*MaxConcurrentReads; n++ {
+		contents := fmt.Sprintf("CREATE TABLE t%03d (id int)", n)
+		if err := ioutil.WriteFile(filepath.Join(base, fmt.Sprintf("t%03d.sql", n)), []byte(contents), 0666); err != nil {
+			t.Fatalf("Unable to write file: %s", err)
+		}
+	}
+	cfg := getConfig(map[string]string{"layout": "per-table"})
+	dir := &Dir{Path: base, Config: cfg}
+	sqlFiles, err := dir.SQLFiles()
+	if err != nil {
+		t.Fatalf("Unexpected error from SQLFiles: %s", err)
+	} else if len(sqlFiles) != 3*MaxConcurrentReads {
+		t.Fatalf("Expected %d SQLFiles, instead found %d", 3*MaxConcurrentReads, len(sqlFiles))
+	}
+	for n, sf := range sqlFiles {
+		if expected := fmt.Sprintf("t%03d", n); sf.Error != nil || sf.Table != expected {
+			t.Errorf("Expected SQLFile %d to define table %s, instead found table=%s err=%v", n, expected, sf.Table, sf.Error)
+		}
+	}
+}
+
+func TestProfile(t *testing.T) {
+	base, err := ioutil.TempDir("", "skeematest")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(base)
+	files := map[string]string{
+		RootMarkerFileName: "",
+		".skeema":          "user=fileuser\nport=3307\n\n[profile:app]\nuser=appuser\nconnect-options=\"wait_timeout=10\"\n",
+		"app/.skeema":      "profile=app\nuser=ignoreduser\n",
+		"other/.skeema":    "user=otheruser\n",
+	}
+	for name, contents := range files {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(base, name)), 0777); err != nil {
+			t.Fatalf("Unable to create dirs: %s", err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(base, name), []byte(contents), 0666); err != nil {
+			t.Fatalf("Unable to write file: %s", err)
+		}
+	}
+
+	cmd := mybase.NewCommand("test", "1.0", "this is for testing", nil)
+	AddGlobalOptions(cmd)
+	cfg := mybase.NewConfig(&mybase.CommandLine{Command: cmd}, dummySource(map[string]string{}))
+	baseDir := &Dir{Path: base, Config: cfg, section: "production"}
+	if f, err := baseDir.OptionFile(); err != nil {
+		t.Fatalf("Unexpected error reading option file: %s", err)
+	} else {
+		baseDir.Config.AddSource(f)
+	}
+	subdirs, err := baseDir.Subdirs()
+	if err != nil {
+		t.Fatalf("Unexpected error from Subdirs: %s", err)
+	}
+	expectedUser := map[string]string{"app": "appuser", "other": "otheruser"}
+	for _, subdir := range subdirs {
+		if actual := subdir.Config.Get("user"); actual != expectedUser[subdir.BaseName()] {
+			t.Errorf("Expected %s to have user %s, instead found %s", subdir.BaseName(), expectedUser[subdir.BaseName()], actual)
+		}
+		if actual := subdir.Config.Get("port"); actual != "3307" {
+			t.Errorf("Expected %s to have port 3307, instead found %s", subdir.BaseName(), actual)
+		}
+		if subdir.BaseName() == "app" {
+			if actual := subdir.Config.Get("connect-options"); actual != "wait_timeout=10" {
+				t.Errorf("Expected connect-options from profile, instead found %s", actual)
+			}
+			if err := subdir.applyProfile("missing"); err == nil {
+				t.Error("Expected error from applyProfile on undefined profile, but it was nil")
+			}
+		}
+	}
+}
+
+// TestConcurrentConfigCascade is primarily useful with go test -race. It
+// verifies that subdir configurations cascading from a shared parent may be
+// built and then read from many goroutines at once.
+func TestConcurrentConfigCascade(t *testing.T) {
+	base, err := ioutil.TempDir("", "skeematest")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(base)
+	files := map[string]string{
+		RootMarkerFileName: "",
+		".skeema":          "host=127.0.0.1\nuser=fileuser\ndefault-schema-dir=true\n\n[profile:app]\nuser=appuser\n",
+		".gitignore":       "ignored*\n",
+	}
+	subdirCount := 3 * MaxConcurrentReads
+	for n := 0; n < subdirCount; n++ {
+		var contents string
+		if n%2 == 0 {
+			contents = "profile=app\n"
+		}
+		files[fmt.Sprintf("schema%03d/.skeema", n)] = contents
+		files[fmt.Sprintf("ignored%03d/foo.sql", n)] = ""
+	}
+	for name, contents := range files {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(base, name)), 0777); err != nil {
+			t.Fatalf("Unable to create dirs: %s", err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(base, name), []byte(contents), 0666); err != nil {
+			t.Fatalf("Unable to write file: %s", err)
+		}
+	}
+
+	cmd := mybase.NewCommand("test", "1.0", "this is for testing", nil)
+	AddGlobalOptions(cmd)
+	cfg := mybase.NewConfig(&mybase.CommandLine{Command: cmd}, dummySource(map[string]string{"respect-gitignore": "1"}))
+	hostDir := &Dir{Path: base, Config: cfg, section: "production"}
+	if f, err := hostDir.OptionFile(); err != nil {
+		t.Fatalf("Unexpected error reading option file: %s", err)
+	} else {
+		hostDir.Config.AddSource(f)
+	}
+	if hostDir.gitignore, err = hostDir.gitignore.ReadGitignore(base); err != nil {
+		t.Fatalf("Unexpected error reading .gitignore: %s", err)
+	}
+	subdirs, err := hostDir.Subdirs()
+	if err != nil {
+		t.Fatalf("Unexpected error from Subdirs: %s", err)
+	} else if len(subdirs) != subdirCount {
+		t.Fatalf("Expected %d subdirs, instead found %d", subdirCount, len(subdirs))
+	}
+
+	// Read each subdir's config from several goroutines at once, while other
+	// goroutines clone and override it
+	var wg sync.WaitGroup
+	errs := make(chan error, 4*len(subdirs))
+	for n := 0; n < 4; n++ {
+		wg.Add(1)
+		go func(override bool) {
+			defer wg.Done()
+			for i, subdir := range subdirs {
+				expectSchema, expectUser := fmt.Sprintf("schema%03d", i), "fileuser"
+				if i%2 == 0 {
+					expectUser = "appuser"
+				}
+				config := subdir.Config
+				if override {
+					config = subdir.Config.Clone()
+					config.AddSource(dummySource{"user": "overridden"})
+					expectUser = "overridden"
+				}
+				if schema, user := config.Get("schema"), config.Get("user"); schema != expectSchema || user != expectUser {
+					errs <- fmt.Errorf("Expected %s to have schema=%s user=%s, instead found schema=%s user=%s", subdir, expectSchema, expectUser, schema, user)
+				}
+			}
+		}(n%2 == 1)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestAllowsEnvironment(t *testing.T) {
+	base, err := ioutil.TempDir("", "skeematest")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(base)
+	files := map[string]string{
+		".skeema":            "allowed-environments=dr\n",
+		"inherits/.skeema":   "schema=foo\n",
+		"overrides/.skeema":  "schema=bar\nallowed-environments=dr,production\n",
+		"restricts/.skeema":  "schema=baz\nallowed-environments=staging\n",
+		"unrestricted/x.sql": "",
+	}
+	for name, contents := range files {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(base, name)), 0777); err != nil {
+			t.Fatalf("Unable to create dirs: %s", err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(base, name), []byte(contents), 0666); err != nil {
+			t.Fatalf("Unable to write file: %s", err)
+		}
+	}
+
+	cmd := mybase.NewCommand("test", "1.0", "this is for testing", nil)
+	AddGlobalOptions(cmd)
+	cfg := mybase.NewConfig(&mybase.CommandLine{Command: cmd}, dummySource(map[string]string{}))
+	root := &Dir{Path: base, Config: cfg, section: "production"}
+	if f, err := root.OptionFile(); err != nil {
+		t.Fatalf("Unexpected error reading option file: %s", err)
+	} else {
+		root.Config.AddSource(f)
+	}
+	if root.AllowsEnvironment() || !root.declaresAllowedEnvironments() {
+		t.Errorf("Unexpected results for root dir: AllowsEnvironment=%t declaresAllowedEnvironments=%t", root.AllowsEnvironment(), root.declaresAllowedEnvironments())
+	}
+
+	subdirs, err := root.Subdirs()
+	if err != nil {
+		t.Fatalf("Unexpected error from Subdirs: %s", err)
+	}
+	expected := map[string]struct{ allows, declares bool }{
+		"inherits":     {false, false},
+		"overrides":    {true, true},
+		"restricts":    {false, true},
+		"unrestricted": {false, false},
+	}
+	for _, subdir := range subdirs {
+		exp := expected[subdir.BaseName()]
+		if subdir.AllowsEnvironment() != exp.allows || subdir.declaresAllowedEnvironments() != exp.declares {
+			t.Errorf("Unexpected results for %s: AllowsEnvironment=%t declaresAllowedEnvironments=%t", subdir.BaseName(), subdir.AllowsEnvironment(), subdir.declaresAllowedEnvironments())
+		}
+	}
+
+	// Without the option set anywhere, all environments are permitted
+	cfg = mybase.NewConfig(&mybase.CommandLine{Command: cmd}, dummySource(map[string]string{}))
+	dir := &Dir{Path: filepath.Join(base, "unrestricted"), Config: cfg, section: "production"}
+	if !dir.AllowsEnvironment() {
+		t.Error("Expected AllowsEnvironment to return true without allowed-environments set")
+	}
+}
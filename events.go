@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// SchemaChangeEvent describes a single DDL statement successfully applied by
+// `skeema push`. It is emitted as JSON to the sink configured by the
+// event-sink option.
+type SchemaChangeEvent struct {
+	Schema        string    `json:"schema"`
+	Environment   string    `json:"environment"`
+	Instance      string    `json:"instance"`
+	Table         string    `json:"table"`
+	Type          string    `json:"type"` // "CREATE", "ALTER", or "DROP"
+	Statement     string    `json:"statement"`
+	GitRef        string    `json:"git_ref,omitempty"`
+	Timestamp     time.Time `json:"timestamp"`
+	DurationMS    int64     `json:"duration_ms"`
+	SkeemaVersion string    `json:"skeema_version"`
+}
+
+// EventSink represents a destination for SchemaChangeEvents: either an HTTP(S)
+// endpoint, which receives each event via POST, or a Kafka topic, which is
+// written to by shelling out to kcat.
+type EventSink struct {
+	URL    string
+	Auth   string // value for Authorization header; only used with HTTP(S)
+	GitRef string // commit checked out in dir, included in each event
+	dir    *Dir
+}
+
+// NewEventSink returns the EventSink configured for dir via the event-sink
+// option, or nil if the option is not set.
+func NewEventSink(dir *Dir) (*EventSink, error) {
+	if _, ok := dir.Config.CLI.Command.OptionValue("event-sink"); !ok {
+		return nil, nil
+	}
+	rawURL := dir.Config.Get("event-sink")
+	if rawURL == "" {
+		return nil, nil
+	}
+	parsed, err := url.Parse(rawURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https" && parsed.Scheme != "kafka") || parsed.Host == "" {
+		return nil, OptionError(dir.Config, "event-sink", fmt.Errorf("Option event-sink must be an http://, https://, or kafka:// URL; found \"%s\"", rawURL))
+	}
+	if parsed.Scheme == "kafka" && strings.Trim(parsed.Path, "/") == "" {
+		return nil, OptionError(dir.Config, "event-sink", fmt.Errorf("Option event-sink must include a topic for kafka:// URLs, e.g. kafka://broker:9092/topic; found \"%s\"", rawURL))
+	}
+	es := &EventSink{
+		URL:    rawURL,
+		Auth:   dir.Config.Get("event-sink-auth"),
+		GitRef: CurrentGitRef(dir),
+		dir:    dir,
+	}
+	Secrets.Add(es.Auth)
+	return es, nil
+}
+
+// String returns the URL of the sink.
+func (es *EventSink) String() string {
+	return es.URL
+}
+
+// Emit sends event to the sink as JSON.
+func (es *EventSink) Emit(event *SchemaChangeEvent) error {
+	event.GitRef = es.GitRef
+	event.SkeemaVersion = version
+	contents, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if strings.HasPrefix(es.URL, "kafka://") {
+		return es.emitKafka(contents, event.Schema+"."+event.Table)
+	}
+	req, err := http.NewRequest("POST", es.URL, bytes.NewReader(contents))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if es.Auth != "" {
+		req.Header.Set("Authorization", es.Auth)
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("Event sink %s returned %s: %s", es, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// emitKafka produces contents as a single message with the supplied key, to
+// the topic of the sink's kafka:// URL. The host portion of the URL may be a
+// comma-separated list of brokers.
+func (es *EventSink) emitKafka(contents []byte, key string) error {
+	parsed, err := url.Parse(es.URL)
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile("", "skeema-event")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(contents)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	extra := map[string]string{
+		"BROKERS": parsed.Host,
+		"TOPIC":   strings.Trim(parsed.Path, "/"),
+		"KEY":     key,
+		"FILE":    f.Name(),
+	}
+	s, err := NewInterpolatedShellOut("kcat -P -b {BROKERS} -t {TOPIC} -k {KEY} {FILE}", es.dir, extra)
+	if err != nil {
+		return err
+	}
+	if err := s.Run(); err != nil {
+		return fmt.Errorf("Unable to produce event to %s: %s", es, err)
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/skeema/mybase"
+)
+
+func TestEventSinkEmit(t *testing.T) {
+	var received []SchemaChangeEvent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event SchemaChangeEvent
+		if r.Method != "POST" || r.Header.Get("Authorization") != "Bearer abc123" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		received = append(received, event)
+	}))
+	defer server.Close()
+
+	// Global options are required, since NewEventSink shells out to git
+	getEventConfig := func(values map[string]string) *mybase.Config {
+		cmd := mybase.NewCommand("test", "1.0", "this is for testing", nil)
+		AddGlobalOptions(cmd)
+		cmd.AddOption(mybase.StringOption("event-sink", 0, "", "event-sink"))
+		cmd.AddOption(mybase.StringOption("event-sink-auth", 0, "", "event-sink-auth"))
+		return mybase.NewConfig(&mybase.CommandLine{Command: cmd}, dummySource(values))
+	}
+	values := map[string]string{
+		"event-sink":      server.URL + "/events",
+		"event-sink-auth": "Bearer abc123",
+	}
+	dir := &Dir{Path: "/tmp/dummydir", Config: getEventConfig(values)}
+	sink, err := NewEventSink(dir)
+	if err != nil {
+		t.Fatalf("Unexpected error from NewEventSink: %s", err)
+	}
+	event := &SchemaChangeEvent{
+		Schema:    "product",
+		Table:     "users",
+		Type:      "ALTER",
+		Statement: "ALTER TABLE `users` ADD COLUMN `foo` int;",
+	}
+	if err := sink.Emit(event); err != nil {
+		t.Fatalf("Unexpected error from Emit: %s", err)
+	}
+	if len(received) != 1 || received[0].Table != "users" || received[0].Type != "ALTER" || received[0].SkeemaVersion != version {
+		t.Fatalf("Unexpected events received: %+v", received)
+	}
+	if received[0].Statement != event.Statement {
+		t.Errorf("Expected statement %q to be emitted as-is, instead found %q", event.Statement, received[0].Statement)
+	}
+
+	sink.Auth = ""
+	if err := sink.Emit(event); err == nil {
+		t.Error("Expected error from Emit when sink returns an error status, but it was nil")
+	}
+
+	for _, badURL := range []string{"ftp://example.com/events", "kafka://broker1:9092", "events"} {
+		values["event-sink"] = badURL
+		dir.Config = getEventConfig(values)
+		if _, err := NewEventSink(dir); err == nil {
+			t.Errorf("Expected error from event-sink %s, but it was nil", badURL)
+		}
+	}
+	values["event-sink"] = "kafka://broker1:9092,broker2:9092/schema-changes"
+	dir.Config = getEventConfig(values)
+	if sink, err := NewEventSink(dir); err != nil || sink == nil {
+		t.Errorf("Unexpected result from NewEventSink with kafka URL: %v, %v", sink, err)
+	}
+}
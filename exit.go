@@ -5,6 +5,7 @@ import (
 	"os"
 
 	log "github.com/Sirupsen/logrus"
+	"github.com/skeema/mybase"
 )
 
 // ExitValue represents an exit code for an operation. It satisfies the Error
@@ -15,9 +16,27 @@ import (
 // code 0.
 type ExitValue struct {
 	Code    int
+	Outcome Outcome
 	message string
 }
 
+// Outcome classifies the result of an operation more precisely than Code,
+// which groups several outcomes together for backwards compatibility. Wrapper
+// scripts may opt into receiving a distinct exit code per Outcome by enabling
+// the detailed-exit-codes option.
+type Outcome int
+
+// Constants enumerating Outcomes. OutcomeUnspecified indicates that Code
+// should be used as-is even if detailed-exit-codes is enabled.
+const (
+	OutcomeUnspecified Outcome = iota
+	OutcomeDriftFound
+	OutcomePartialPush
+	OutcomeUnsafeSkipped
+	OutcomeLintFailure
+	OutcomeConnectionFailure
+)
+
 // Constants representing some predefined exit codes used by Skeema. A few of
 // these are loosely adapted from BSD's `man sysexits`.
 const (
@@ -29,9 +48,28 @@ const (
 	CodeBadInput         = 65
 	CodeNoInput          = 66
 	CodeCantCreate       = 73
+	CodeMaxRuntime       = 75
 	CodeBadConfig        = 78
 )
 
+// Constants representing exit codes for each Outcome, used in place of the
+// above codes when detailed-exit-codes is enabled.
+const (
+	CodeDriftFound        = 1
+	CodePartialPush       = 3
+	CodeUnsafeSkipped     = 4
+	CodeLintFailure       = 5
+	CodeConnectionFailure = 68 // EX_NOHOST in BSD's sysexits
+)
+
+var detailedExitCodes = map[Outcome]int{
+	OutcomeDriftFound:        CodeDriftFound,
+	OutcomePartialPush:       CodePartialPush,
+	OutcomeUnsafeSkipped:     CodeUnsafeSkipped,
+	OutcomeLintFailure:       CodeLintFailure,
+	OutcomeConnectionFailure: CodeConnectionFailure,
+}
+
 // NewExitValue is a constructor for ExitValue.
 func NewExitValue(code int, format string, a ...interface{}) *ExitValue {
 	return &ExitValue{
@@ -40,6 +78,31 @@ func NewExitValue(code int, format string, a ...interface{}) *ExitValue {
 	}
 }
 
+// WithOutcome sets the Outcome of ev, returning ev to permit chaining.
+func (ev *ExitValue) WithOutcome(outcome Outcome) *ExitValue {
+	ev.Outcome = outcome
+	return ev
+}
+
+// AdjustExitValue returns err, modified according to the exit code options of
+// cfg. If success-on-drift is enabled, an err with OutcomeDriftFound is
+// replaced by nil. If detailed-exit-codes is enabled, an err with a specified
+// Outcome has its Code replaced by the Outcome's detailed code. Any other err
+// is returned as-is.
+func AdjustExitValue(err error, cfg *mybase.Config) error {
+	ev, ok := err.(*ExitValue)
+	if !ok || ev == nil || ev.Outcome == OutcomeUnspecified {
+		return err
+	}
+	if ev.Outcome == OutcomeDriftFound && cfg.GetBool("success-on-drift") {
+		return nil
+	}
+	if cfg.GetBool("detailed-exit-codes") {
+		ev.Code = detailedExitCodes[ev.Outcome]
+	}
+	return ev
+}
+
 // Error returns an error string, satisfying the Go builtin error interface.
 func (ev *ExitValue) Error() string {
 	if ev == nil {
@@ -52,7 +115,10 @@ func (ev *ExitValue) Error() string {
 // method returns a non-empty string, it will be logged to STDERR. If err is
 // an ExitValue, its Code will be used for the program's exit code. Otherwise,
 // if err is nil, exit code 0 will be used; if non-nil then exit code 2.
+// Any resources still present in the run's ResourceRegistry are cleaned up
+// prior to exiting.
 func Exit(err error) {
+	Resources.Cleanup()
 	if err == nil {
 		log.Debug("Exit code 0 (SUCCESS)")
 		os.Exit(0)
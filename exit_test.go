@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestAdjustExitValue(t *testing.T) {
+	cases := []struct {
+		detailed       string
+		successOnDrift string
+		outcome        Outcome
+		code           int
+		expectedCode   int
+	}{
+		{"0", "0", OutcomeDriftFound, CodeDifferencesFound, CodeDifferencesFound},
+		{"0", "1", OutcomeDriftFound, CodeDifferencesFound, CodeSuccess},
+		{"1", "1", OutcomeDriftFound, CodeDifferencesFound, CodeSuccess},
+		{"0", "0", OutcomePartialPush, CodePartialError, CodePartialError},
+		{"1", "0", OutcomePartialPush, CodePartialError, CodePartialPush},
+		{"1", "0", OutcomeUnsafeSkipped, CodeFatalError, CodeUnsafeSkipped},
+		{"1", "0", OutcomeLintFailure, CodeFatalError, CodeLintFailure},
+		{"1", "0", OutcomeConnectionFailure, CodeFatalError, CodeConnectionFailure},
+		{"1", "1", OutcomeUnspecified, CodeBadConfig, CodeBadConfig},
+	}
+	for _, c := range cases {
+		cfg := getConfig(map[string]string{"detailed-exit-codes": c.detailed, "success-on-drift": c.successOnDrift})
+		err := AdjustExitValue(NewExitValue(c.code, "").WithOutcome(c.outcome), cfg)
+		actualCode := CodeSuccess
+		if ev, ok := err.(*ExitValue); ok && ev != nil {
+			actualCode = ev.Code
+		} else if err != nil {
+			t.Fatalf("Unexpected error type %T returned", err)
+		}
+		if actualCode != c.expectedCode {
+			t.Errorf("Expected outcome %d with detailed-exit-codes=%s success-on-drift=%s to exit %d, instead found %d", c.outcome, c.detailed, c.successOnDrift, c.expectedCode, actualCode)
+		}
+	}
+
+	cfg := getConfig(map[string]string{"detailed-exit-codes": "1", "success-on-drift": "1"})
+	if err := AdjustExitValue(nil, cfg); err != nil {
+		t.Errorf("Expected nil error to remain nil, instead found %v", err)
+	}
+	plain := errors.New("plain error")
+	if err := AdjustExitValue(plain, cfg); err != plain {
+		t.Errorf("Expected non-ExitValue error to be returned as-is, instead found %v", err)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// This file contains a minimal implementation of .gitignore pattern matching,
+// used to skip ignored subdirs and files when the respect-gitignore option is
+// enabled.
+
+// ignoreRule represents a single pattern line from a .gitignore file.
+type ignoreRule struct {
+	base     string // dir containing the .gitignore file
+	pattern  string
+	negate   bool // pattern began with "!"
+	dirOnly  bool // pattern ended with "/"
+	anchored bool // pattern contains a "/" other than a trailing one
+}
+
+// IgnoreRules is an ordered list of .gitignore rules, with rules from parent
+// dirs preceding rules from their subdirs.
+type IgnoreRules []ignoreRule
+
+// ReadGitignore returns the rules in the .gitignore file of dirPath, appended
+// to the receiver's rules. If no such file exists, the receiver is returned
+// as-is.
+func (rules IgnoreRules) ReadGitignore(dirPath string) (IgnoreRules, error) {
+	f, err := os.Open(path.Join(dirPath, ".gitignore"))
+	if os.IsNotExist(err) {
+		return rules, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// Copy, to avoid sharing a backing array between sibling dirs
+	result := make(IgnoreRules, len(rules), len(rules)+8)
+	copy(result, rules)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t")
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		rule := ignoreRule{base: dirPath}
+		if line[0] == '!' {
+			rule.negate = true
+			line = line[1:]
+		} else if line[0] == '\\' {
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		if strings.Contains(line, "/") {
+			rule.anchored = true
+			line = strings.TrimPrefix(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+		rule.pattern = line
+		result = append(result, rule)
+	}
+	return result, scanner.Err()
+}
+
+// Ignored returns true if the supplied absolute path is ignored by the rules.
+// As with git, the last matching rule takes precedence.
+func (rules IgnoreRules) Ignored(fullPath string, isDir bool) bool {
+	var ignored bool
+	for _, rule := range rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		rel, err := filepath.Rel(rule.base, fullPath)
+		if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+			continue
+		}
+		var matched bool
+		if rule.anchored {
+			matched = matchGlobPath(strings.Split(rule.pattern, "/"), strings.Split(rel, "/"))
+		} else {
+			matched, _ = path.Match(rule.pattern, path.Base(rel))
+		}
+		if matched {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+// matchGlobPath returns true if the path components in name match the glob
+// components in pattern. A "**" component matches zero or more components.
+func matchGlobPath(pattern, name []string) bool {
+	if len(pattern) == 0 {
+		return len(name) == 0
+	}
+	if pattern[0] == "**" {
+		for n := 0; n <= len(name); n++ {
+			if matchGlobPath(pattern[1:], name[n:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(name) == 0 {
+		return false
+	}
+	if matched, _ := path.Match(pattern[0], name[0]); !matched {
+		return false
+	}
+	return matchGlobPath(pattern[1:], name[1:])
+}
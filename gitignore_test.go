@@ -0,0 +1,63 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestIgnoreRules(t *testing.T) {
+	base, err := ioutil.TempDir("", "skeematest")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(base)
+	subPath := path.Join(base, "sub")
+	if err := os.MkdirAll(subPath, 0777); err != nil {
+		t.Fatalf("Unable to create dirs: %s", err)
+	}
+	contents := "# comment\n\nbuild/\n*.tmp.sql\n/generated\ndocs/**/*.sql\n!keep.tmp.sql\n"
+	if err := ioutil.WriteFile(path.Join(base, ".gitignore"), []byte(contents), 0666); err != nil {
+		t.Fatalf("Unable to write .gitignore: %s", err)
+	}
+	if err := ioutil.WriteFile(path.Join(subPath, ".gitignore"), []byte("local.sql\n"), 0666); err != nil {
+		t.Fatalf("Unable to write .gitignore: %s", err)
+	}
+
+	var rules IgnoreRules
+	if rules, err = rules.ReadGitignore(base); err != nil {
+		t.Fatalf("Unexpected error from ReadGitignore: %s", err)
+	}
+	if rules, err = rules.ReadGitignore(subPath); err != nil {
+		t.Fatalf("Unexpected error from ReadGitignore: %s", err)
+	}
+	if rules, err = rules.ReadGitignore(path.Join(base, "nonexistent")); err != nil {
+		t.Fatalf("Unexpected error from ReadGitignore on dir without .gitignore: %s", err)
+	}
+
+	cases := []struct {
+		relPath  string
+		isDir    bool
+		expected bool
+	}{
+		{"build", true, true},
+		{"sub/build", true, true},
+		{"build", false, false},
+		{"foo.tmp.sql", false, true},
+		{"sub/foo.tmp.sql", false, true},
+		{"keep.tmp.sql", false, false},
+		{"generated", true, true},
+		{"sub/generated", true, false},
+		{"docs/a/b/foo.sql", false, true},
+		{"docs/foo.sql", false, true},
+		{"sub/local.sql", false, true},
+		{"local.sql", false, false},
+		{"users.sql", false, false},
+	}
+	for _, c := range cases {
+		if actual := rules.Ignored(path.Join(base, c.relPath), c.isDir); actual != c.expected {
+			t.Errorf("Expected Ignored(%s, %t) to return %t, instead found %t", c.relPath, c.isDir, c.expected, actual)
+		}
+	}
+}
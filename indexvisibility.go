@@ -0,0 +1,212 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/skeema/tengo"
+)
+
+// invisibleMarker is appended by MySQL 8's SHOW CREATE TABLE to the definition
+// of each invisible index.
+const invisibleMarker = " /*!80000 INVISIBLE */"
+
+var reInvisibleIndex = regexp.MustCompile("(?m)^  (?:UNIQUE |FULLTEXT |SPATIAL )?KEY `((?:[^`]|``)+)` .*" + regexp.QuoteMeta(invisibleMarker) + ",?$")
+
+// InvisibleIndexes returns the names of invisible indexes in createStatement,
+// along with createStatement stripped of all index visibility markers.
+func InvisibleIndexes(createStatement string) (stripped string, names map[string]bool) {
+	names = make(map[string]bool)
+	for _, matches := range reInvisibleIndex.FindAllStringSubmatch(createStatement, -1) {
+		names[strings.Replace(matches[1], "``", "`", -1)] = true
+	}
+	return strings.Replace(createStatement, invisibleMarker, "", -1), names
+}
+
+// ChangeIndexVisibility represents an index present in both versions of a
+// table, which is visible to the optimizer in only one of them. It satisfies
+// the tengo.TableAlterClause interface.
+type ChangeIndexVisibility struct {
+	Table     *tengo.Table
+	Index     *tengo.Index
+	Invisible bool
+}
+
+// Clause returns an ALTER INDEX clause of an ALTER TABLE statement.
+func (civ ChangeIndexVisibility) Clause() string {
+	visibility := "VISIBLE"
+	if civ.Invisible {
+		visibility = "INVISIBLE"
+	}
+	return fmt.Sprintf("ALTER INDEX %s %s", tengo.EscapeIdentifier(civ.Index.Name), visibility)
+}
+
+// Unsafe returns true if this clause is potentially destructive of data.
+// ChangeIndexVisibility is never unsafe.
+func (civ ChangeIndexVisibility) Unsafe() bool {
+	return false
+}
+
+// AddInvisibleIndex represents a new index which is invisible to the optimizer
+// on the right-side ("to") version of the table. It satisfies the
+// tengo.TableAlterClause interface.
+type AddInvisibleIndex struct {
+	Table *tengo.Table
+	Index *tengo.Index
+}
+
+// Clause returns an ADD INDEX clause of an ALTER TABLE statement.
+func (aii AddInvisibleIndex) Clause() string {
+	return fmt.Sprintf("ADD %s INVISIBLE", aii.Index.Definition())
+}
+
+// Unsafe returns true if this clause is potentially destructive of data.
+// AddInvisibleIndex is never unsafe.
+func (aii AddInvisibleIndex) Unsafe() bool {
+	return false
+}
+
+// visibleCopy returns a copy of table which tengo is able to diff, along with
+// the names of the table's invisible indexes. The last return value is false if
+// table uses unsupported features other than invisible indexes.
+func visibleCopy(table *tengo.Table) (*tengo.Table, map[string]bool, bool) {
+	stripped, invisible := InvisibleIndexes(table.CreateStatement())
+	if table.UnsupportedDDL {
+		expected, _ := tengo.ParseCreateAutoInc(table.GeneratedCreateStatement())
+		actual, _ := tengo.ParseCreateAutoInc(stripped)
+		if expected != actual {
+			return nil, nil, false
+		}
+	}
+	result := *table
+	result.UnsupportedDDL = false
+	return &result, invisible, true
+}
+
+// ResolveIndexVisibility examines diff.UnsupportedTables for tables which tengo
+// could not diff solely due to use of invisible indexes. For each such table,
+// an ALTER TABLE is added to diff.TableDiffs, including ALTER INDEX clauses for
+// any changes in index visibility, and the table is removed from
+// diff.UnsupportedTables. The number of resolved tables is returned.
+func ResolveIndexVisibility(diff *tengo.SchemaDiff) (count int) {
+	if len(diff.UnsupportedTables) == 0 || diff.FromSchema == nil {
+		return 0
+	}
+	fromTables, _ := diff.FromSchema.TablesByName() // can ignore error since we know table list already cached
+	unsupported := make([]*tengo.Table, 0, len(diff.UnsupportedTables))
+	for _, to := range diff.UnsupportedTables {
+		from := fromTables[to.Name]
+		if from == nil {
+			unsupported = append(unsupported, to)
+			continue
+		}
+		fromCopy, fromInvisible, fromOK := visibleCopy(from)
+		toCopy, toInvisible, toOK := visibleCopy(to)
+		if !fromOK || !toOK {
+			unsupported = append(unsupported, to)
+			continue
+		}
+		clauses, _ := fromCopy.Diff(toCopy)
+		rebuilt := make(map[string]bool)
+		for n, clause := range clauses {
+			switch clause := clause.(type) {
+			case tengo.AddIndex:
+				rebuilt[clause.Index.Name] = true
+				if toInvisible[clause.Index.Name] {
+					clauses[n] = AddInvisibleIndex{Table: clause.Table, Index: clause.Index}
+				}
+			case tengo.DropIndex:
+				rebuilt[clause.Index.Name] = true
+			}
+		}
+		fromIndexes := fromCopy.SecondaryIndexesByName()
+		for _, idx := range toCopy.SecondaryIndexes {
+			if fromIndexes[idx.Name] == nil || rebuilt[idx.Name] || fromInvisible[idx.Name] == toInvisible[idx.Name] {
+				continue
+			}
+			clauses = append(clauses, ChangeIndexVisibility{Table: to, Index: idx, Invisible: toInvisible[idx.Name]})
+		}
+		if len(clauses) == 0 {
+			unsupported = append(unsupported, to)
+			continue
+		}
+		diff.TableDiffs = append(diff.TableDiffs, tengo.AlterTable{Table: from, Clauses: clauses})
+		count++
+	}
+	diff.UnsupportedTables = unsupported
+	return count
+}
+
+// HideDroppedIndexes modifies the ALTER TABLEs in diff, replacing each DROP
+// INDEX clause with an ALTER INDEX clause which makes the index invisible, so
+// that the effect of the drop may be observed (and quickly reverted) before
+// the index is actually dropped by a subsequent push. Indexes which are
+// already invisible are dropped as usual. DROP INDEX clauses are also left
+// as-is if the index is being recreated or has a column being dropped by the
+// same ALTER, or if the index is unique in a table lacking a primary key,
+// since MySQL may treat such an index as the primary key. The number of
+// affected indexes is returned.
+func HideDroppedIndexes(diff *tengo.SchemaDiff) (count int) {
+	for n, tableDiff := range diff.TableDiffs {
+		alter, ok := tableDiff.(tengo.AlterTable)
+		if !ok {
+			continue
+		}
+		_, invisible := InvisibleIndexes(alter.Table.CreateStatement())
+		keep := make(map[string]bool)
+		droppedColumns := make(map[string]bool)
+		for _, clause := range alter.Clauses {
+			switch clause := clause.(type) {
+			case tengo.AddIndex:
+				keep[clause.Index.Name] = true
+			case AddInvisibleIndex:
+				keep[clause.Index.Name] = true
+			case tengo.DropColumn:
+				droppedColumns[clause.Column.Name] = true
+			}
+		}
+		for _, clause := range alter.Clauses {
+			drop, ok := clause.(tengo.DropIndex)
+			if !ok || drop.Index.PrimaryKey || invisible[drop.Index.Name] {
+				continue
+			}
+			if drop.Index.Unique && alter.Table.PrimaryKey == nil {
+				keep[drop.Index.Name] = true
+			}
+			for _, col := range drop.Index.Columns {
+				if droppedColumns[col.Name] {
+					keep[drop.Index.Name] = true
+				}
+			}
+		}
+		clauses := make([]tengo.TableAlterClause, len(alter.Clauses))
+		for i, clause := range alter.Clauses {
+			clauses[i] = clause
+			drop, ok := clause.(tengo.DropIndex)
+			if !ok || drop.Index.PrimaryKey || invisible[drop.Index.Name] || keep[drop.Index.Name] {
+				continue
+			}
+			clauses[i] = ChangeIndexVisibility{Table: drop.Table, Index: drop.Index, Invisible: true}
+			count++
+		}
+		alter.Clauses = clauses
+		diff.TableDiffs[n] = alter
+	}
+	return count
+}
+
+// SupportsInvisibleIndexes returns true if instance's flavor and version
+// supports invisible indexes.
+func SupportsInvisibleIndexes(instance *tengo.Instance) (bool, error) {
+	sv, err := GetServerVersion(instance)
+	if err != nil {
+		return false, err
+	}
+	for _, feature := range ServerFeatures {
+		if feature.Name == "invisible indexes" {
+			return sv.Supports(feature), nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/skeema/tengo"
+)
+
+func TestInvisibleIndexes(t *testing.T) {
+	create := "CREATE TABLE `users` (\n" +
+		"  `id` int(10) unsigned NOT NULL AUTO_INCREMENT,\n" +
+		"  `name` varchar(30) NOT NULL,\n" +
+		"  `email` varchar(100) NOT NULL,\n" +
+		"  PRIMARY KEY (`id`),\n" +
+		"  UNIQUE KEY `email` (`email`) /*!80000 INVISIBLE */,\n" +
+		"  KEY `name` (`name`),\n" +
+		"  KEY `weird``name` (`name`,`email`) COMMENT 'old' /*!80000 INVISIBLE */\n" +
+		") ENGINE=InnoDB DEFAULT CHARSET=latin1"
+	stripped, names := InvisibleIndexes(create)
+	if len(names) != 2 || !names["email"] || !names["weird`name"] {
+		t.Errorf("Unexpected result from InvisibleIndexes: %v", names)
+	}
+	expected := "  UNIQUE KEY `email` (`email`),\n"
+	if _, again := InvisibleIndexes(stripped); len(again) > 0 || !strings.Contains(stripped, expected) {
+		t.Errorf("Expected visibility markers to be stripped, instead found %s", stripped)
+	}
+}
+
+func TestIndexVisibilityClauses(t *testing.T) {
+	col := &tengo.Column{Name: "name", TypeInDB: "varchar(30)", Default: tengo.ColumnDefaultNull}
+	table := &tengo.Table{Name: "users", Columns: []*tengo.Column{col}}
+	idx := &tengo.Index{Name: "name", Columns: []*tengo.Column{col}, SubParts: []uint16{0}}
+
+	civ := ChangeIndexVisibility{Table: table, Index: idx, Invisible: true}
+	if clause := civ.Clause(); clause != "ALTER INDEX `name` INVISIBLE" {
+		t.Errorf("Unexpected clause %s", clause)
+	}
+	civ.Invisible = false
+	if clause := civ.Clause(); clause != "ALTER INDEX `name` VISIBLE" {
+		t.Errorf("Unexpected clause %s", clause)
+	}
+	aii := AddInvisibleIndex{Table: table, Index: idx}
+	if clause := aii.Clause(); clause != "ADD KEY `name` (`name`) INVISIBLE" {
+		t.Errorf("Unexpected clause %s", clause)
+	}
+	if civ.Unsafe() || aii.Unsafe() {
+		t.Error("Expected index visibility clauses to be safe, but they were not")
+	}
+	alter := tengo.AlterTable{Table: table, Clauses: []tengo.TableAlterClause{aii}}
+	if !AlterIsTrivial(alter) || !AlterIsAdditive(alter) || !AlterIsIndexOnly(alter) {
+		t.Error("Expected AddInvisibleIndex to be treated like AddIndex, but it was not")
+	}
+}
+
+func TestHideDroppedIndexes(t *testing.T) {
+	id := &tengo.Column{Name: "id", TypeInDB: "int(10) unsigned", Default: tengo.ColumnDefaultNull}
+	name := &tengo.Column{Name: "name", TypeInDB: "varchar(30)", Default: tengo.ColumnDefaultNull}
+	legacy := &tengo.Column{Name: "legacy", TypeInDB: "int(11)", Default: tengo.ColumnDefaultNull}
+	pk := &tengo.Index{Name: "PRIMARY", Columns: []*tengo.Column{id}, SubParts: []uint16{0}, PrimaryKey: true, Unique: true}
+	idxName := &tengo.Index{Name: "name", Columns: []*tengo.Column{name}, SubParts: []uint16{0}}
+	idxLegacy := &tengo.Index{Name: "legacy", Columns: []*tengo.Column{legacy}, SubParts: []uint16{0}}
+	idxRebuilt := &tengo.Index{Name: "rebuilt", Columns: []*tengo.Column{name}, SubParts: []uint16{0}}
+	table := &tengo.Table{
+		Name:             "users",
+		Columns:          []*tengo.Column{id, name, legacy},
+		PrimaryKey:       pk,
+		SecondaryIndexes: []*tengo.Index{idxName, idxLegacy, idxRebuilt},
+	}
+	noPK := &tengo.Table{
+		Name:             "nopk",
+		Columns:          []*tengo.Column{id},
+		SecondaryIndexes: []*tengo.Index{{Name: "id", Columns: []*tengo.Column{id}, SubParts: []uint16{0}, Unique: true}},
+	}
+	diff := &tengo.SchemaDiff{
+		TableDiffs: []tengo.TableDiff{
+			tengo.AlterTable{Table: table, Clauses: []tengo.TableAlterClause{
+				tengo.DropColumn{Table: table, Column: legacy},
+				tengo.DropIndex{Table: table, Index: pk},
+				tengo.DropIndex{Table: table, Index: idxName},
+				tengo.DropIndex{Table: table, Index: idxLegacy},
+				tengo.DropIndex{Table: table, Index: idxRebuilt},
+				tengo.AddIndex{Table: table, Index: idxRebuilt},
+			}},
+			tengo.AlterTable{Table: noPK, Clauses: []tengo.TableAlterClause{
+				tengo.DropIndex{Table: noPK, Index: noPK.SecondaryIndexes[0]},
+			}},
+			tengo.DropTable{Table: noPK},
+		},
+	}
+	if count := HideDroppedIndexes(diff); count != 1 {
+		t.Errorf("Expected 1 index to be made invisible, instead found %d", count)
+	}
+	alter := diff.TableDiffs[0].(tengo.AlterTable)
+	for n, clause := range alter.Clauses {
+		_, hidden := clause.(ChangeIndexVisibility)
+		if hidden != (n == 2) {
+			t.Errorf("Unexpected clause %d: %s", n, clause.Clause())
+		}
+	}
+	if _, ok := diff.TableDiffs[1].(tengo.AlterTable).Clauses[0].(tengo.DropIndex); !ok {
+		t.Error("Expected unique index of table without primary key to be dropped as usual, but it was not")
+	}
+}
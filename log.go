@@ -52,6 +52,6 @@ func (f *customFormatter) Format(entry *log.Entry) ([]byte, error) {
 	}
 	levelText := fmt.Sprintf("[%s%s%s]%s", startColor, levelName, endColor, spacing)
 
-	fmt.Fprintf(b, "%s %s %s\n", entry.Time.Format("2006-01-02 15:04:05"), levelText, entry.Message)
+	fmt.Fprintf(b, "%s %s %s\n", entry.Time.Format("2006-01-02 15:04:05"), levelText, Redact(entry.Message))
 	return b.Bytes(), nil
 }
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/skeema/mybase"
+	"github.com/skeema/tengo"
+)
+
+// DirMetadataFileName is the name of the sidecar file containing metadata for
+// an entire dir (schema). Metadata for an individual table is stored in a
+// sidecar file with the same name as the table's *.sql file, but with a .meta
+// extension instead.
+const DirMetadataFileName = ".meta"
+
+// MaxTableCommentLength is the maximum length of a table COMMENT clause, in
+// characters, permitted by MySQL 5.5+.
+const MaxTableCommentLength = 2048
+
+// metadataCommand defines the options permitted in metadata sidecar files. It
+// is never invoked; it only exists so that sidecar files may be parsed and
+// validated using the same logic as option files.
+var metadataCommand = func() *mybase.Command {
+	cmd := mybase.NewCommand("metadata", "", "", nil)
+	cmd.AddOption(mybase.StringOption("owner", 0, "", "Team or person responsible"))
+	cmd.AddOption(mybase.StringOption("description", 0, "", "Description of purpose"))
+	cmd.AddOption(mybase.StringOption("tier", 0, "", "Criticality tier"))
+	cmd.AddOption(mybase.BoolOption("pii", 0, false, "Whether personally identifiable information is stored"))
+	cmd.AddOption(mybase.StringOption("pii-columns", 0, "", "Comma-separated list of columns storing personally identifiable information"))
+	cmd.AddOption(mybase.StringOption("pii-protected-columns", 0, "", "Comma-separated subset of pii-columns whose values are encrypted or masked"))
+	return cmd
+}()
+
+// Metadata represents the contents of a metadata sidecar file, describing a
+// dir (schema) or table.
+type Metadata struct {
+	Path        string
+	Owner       string
+	Description string
+	Tier        string
+	PII         bool
+	PIIColumns  []string
+
+	// PIIProtectedColumns lists PII columns whose values are encrypted or masked
+	// by the application, and are therefore exempt from pii-policy.
+	PIIProtectedColumns []string
+}
+
+// ReadMetadata reads and parses the metadata sidecar file at filePath. If the
+// file does not exist, nil is returned without an error.
+func ReadMetadata(filePath string) (*Metadata, error) {
+	if _, err := os.Stat(filePath); os.IsNotExist(err) {
+		return nil, nil
+	}
+	f := mybase.NewFile(filePath)
+	cfg := mybase.NewConfig(&mybase.CommandLine{Command: metadataCommand}, f)
+	if err := f.Parse(cfg); err != nil {
+		return nil, err
+	}
+	m := &Metadata{
+		Path:        filePath,
+		Owner:       cfg.Get("owner"),
+		Description: cfg.Get("description"),
+		Tier:        cfg.Get("tier"),
+		PII:         cfg.GetBool("pii"),
+		PIIColumns:  cfg.GetSlice("pii-columns", ',', true),
+
+		PIIProtectedColumns: cfg.GetSlice("pii-protected-columns", ',', true),
+	}
+	if len(m.PIIColumns) > 0 {
+		m.PII = true
+	}
+	return m, nil
+}
+
+// DirMetadata returns the metadata for dir, or nil if it has no metadata
+// sidecar file.
+func (dir *Dir) DirMetadata() (*Metadata, error) {
+	return ReadMetadata(path.Join(dir.Path, DirMetadataFileName))
+}
+
+// TableMetadataPath returns the path of the metadata sidecar file for the
+// named table.
+func (dir *Dir) TableMetadataPath(tableName string) string {
+	return path.Join(dir.Path, strings.TrimSuffix(dir.TableFileName(tableName), ".sql")+".meta")
+}
+
+// TableMetadata returns the metadata for the named table, or nil if neither
+// the table nor dir has a metadata sidecar file. Owner and tier are inherited
+// from the dir's metadata if not specified for the table.
+func (dir *Dir) TableMetadata(tableName string) (*Metadata, error) {
+	filePath := dir.TableMetadataPath(tableName)
+	m, err := ReadMetadata(filePath)
+	if err != nil {
+		return nil, err
+	}
+	dirMeta, err := dir.DirMetadata()
+	if err != nil {
+		return nil, err
+	} else if dirMeta == nil {
+		return m, nil
+	} else if m == nil {
+		return &Metadata{Path: filePath, Owner: dirMeta.Owner, Tier: dirMeta.Tier}, nil
+	}
+	if m.Owner == "" {
+		m.Owner = dirMeta.Owner
+	}
+	if m.Tier == "" {
+		m.Tier = dirMeta.Tier
+	}
+	return m, nil
+}
+
+// Validate returns a list of problems with m. Fields listed in required must be
+// non-blank; if tiers is non-empty, the tier must be one of its values. If
+// table is non-nil, all PII columns must exist in it.
+func (m *Metadata) Validate(table *tengo.Table, required, tiers []string) []error {
+	var problems []error
+	values := map[string]string{
+		"owner":       m.Owner,
+		"description": m.Description,
+		"tier":        m.Tier,
+	}
+	for _, field := range required {
+		if value, known := values[field]; !known {
+			problems = append(problems, fmt.Errorf("%s: metadata-required lists unknown field %s", m.Path, field))
+		} else if value == "" {
+			problems = append(problems, fmt.Errorf("%s: required field %s is missing", m.Path, field))
+		}
+	}
+	if m.Tier != "" && len(tiers) > 0 {
+		var found bool
+		for _, tier := range tiers {
+			found = found || tier == m.Tier
+		}
+		if !found {
+			problems = append(problems, fmt.Errorf("%s: tier %s is not one of the values permitted by metadata-tiers (%s)", m.Path, m.Tier, strings.Join(tiers, ", ")))
+		}
+	}
+	if table != nil {
+		for _, colName := range m.PIIColumns {
+			var found bool
+			for _, col := range table.Columns {
+				found = found || col.Name == colName
+			}
+			if !found {
+				problems = append(problems, fmt.Errorf("%s: pii-columns lists column %s, which does not exist in table %s", m.Path, colName, table.Name))
+			}
+		}
+	}
+	for _, colName := range m.PIIProtectedColumns {
+		var found bool
+		for _, piiColName := range m.PIIColumns {
+			found = found || piiColName == colName
+		}
+		if !found {
+			problems = append(problems, fmt.Errorf("%s: pii-protected-columns lists column %s, which is not listed in pii-columns", m.Path, colName))
+		}
+	}
+	if comment := m.Comment(); len([]rune(comment)) > MaxTableCommentLength {
+		problems = append(problems, fmt.Errorf("%s: metadata is too long to represent as a table comment (%d chars, max %d)", m.Path, len([]rune(comment)), MaxTableCommentLength))
+	}
+	return problems
+}
+
+// Comment returns a table comment representing m, for use with the
+// metadata-comments option. The description is followed by a parenthetical
+// list of the other fields, for example "Customer accounts (owner=payments,
+// tier=1, pii=email,phone)".
+func (m *Metadata) Comment() string {
+	var tags []string
+	if m.Owner != "" {
+		tags = append(tags, "owner="+m.Owner)
+	}
+	if m.Tier != "" {
+		tags = append(tags, "tier="+m.Tier)
+	}
+	if len(m.PIIColumns) > 0 {
+		tags = append(tags, "pii="+strings.Join(m.PIIColumns, ","))
+	} else if m.PII {
+		tags = append(tags, "pii=yes")
+	}
+	if len(tags) == 0 {
+		return m.Description
+	} else if m.Description == "" {
+		return fmt.Sprintf("(%s)", strings.Join(tags, ", "))
+	}
+	return fmt.Sprintf("%s (%s)", m.Description, strings.Join(tags, ", "))
+}
+
+// UnprotectedPIIColumns returns the PII columns of m which are not listed in
+// pii-protected-columns. If table uses InnoDB tablespace encryption, all of its
+// columns are considered protected, and nil is returned.
+func (m *Metadata) UnprotectedPIIColumns(table *tengo.Table) []string {
+	if table != nil && tableIsEncrypted(table) {
+		return nil
+	}
+	protected := make(map[string]bool, len(m.PIIProtectedColumns))
+	for _, colName := range m.PIIProtectedColumns {
+		protected[colName] = true
+	}
+	var result []string
+	for _, colName := range m.PIIColumns {
+		if !protected[colName] {
+			result = append(result, colName)
+		}
+	}
+	return result
+}
+
+// tableIsEncrypted returns true if table's create options enable InnoDB
+// tablespace encryption.
+func tableIsEncrypted(table *tengo.Table) bool {
+	opts := strings.ToUpper(strings.NewReplacer(`"`, "", "'", "", " ", "").Replace(table.CreateOptions))
+	return strings.Contains(opts, "ENCRYPTION=Y")
+}
+
+// PIIColumnsAdded returns the unprotected PII columns which diff would add to
+// its table, according to the table's metadata sidecar file in t's dir. For a
+// CREATE TABLE, this includes all unprotected PII columns; for an ALTER TABLE,
+// only newly-added ones. DROP TABLE never adds PII columns.
+func PIIColumnsAdded(diff tengo.TableDiff, t *Target) ([]string, error) {
+	var tableName string
+	added := make(map[string]bool)
+	switch diff := diff.(type) {
+	case tengo.CreateTable:
+		tableName = diff.Table.Name
+		for _, col := range diff.Table.Columns {
+			added[col.Name] = true
+		}
+	case tengo.AlterTable:
+		tableName = diff.Table.Name
+		for _, clause := range diff.Clauses {
+			if add, ok := clause.(tengo.AddColumn); ok {
+				added[add.Column.Name] = true
+			}
+		}
+	}
+	if len(added) == 0 {
+		return nil, nil
+	}
+	m, err := t.Dir.TableMetadata(tableName)
+	if err != nil || m == nil {
+		return nil, err
+	}
+	desired, err := t.SchemaFromDir.Table(tableName)
+	if err != nil {
+		return nil, err
+	}
+	var result []string
+	for _, colName := range m.UnprotectedPIIColumns(desired) {
+		if added[colName] {
+			result = append(result, colName)
+		}
+	}
+	return result, nil
+}
+
+// CreateStatementWithComment returns the CREATE TABLE statement for table,
+// with its table comment replaced by comment. An error is returned if table
+// uses features which prevent its CREATE TABLE from being regenerated.
+func CreateStatementWithComment(table *tengo.Table, comment string) (string, error) {
+	if table.Comment == comment {
+		return table.CreateStatement(), nil
+	} else if table.UnsupportedDDL {
+		return "", fmt.Errorf("cannot update comment of table %s since it uses features that Skeema cannot regenerate", table.Name)
+	}
+	modified := *table
+	modified.Comment = comment
+	return modified.GeneratedCreateStatement(), nil
+}
+
+// metadataChecker validates the metadata sidecar files of a dir, according to
+// the dir's metadata-required and metadata-tiers options.
+type metadataChecker struct {
+	dir      *Dir
+	required []string
+	tiers    []string
+	sync     bool
+}
+
+// newMetadataChecker returns a metadataChecker for dir.
+func newMetadataChecker(dir *Dir) *metadataChecker {
+	mc := &metadataChecker{dir: dir}
+	if _, ok := dir.Config.CLI.Command.OptionValue("metadata-required"); ok {
+		mc.required = dir.Config.GetSlice("metadata-required", ',', true)
+	}
+	if _, ok := dir.Config.CLI.Command.OptionValue("metadata-tiers"); ok {
+		mc.tiers = dir.Config.GetSlice("metadata-tiers", ',', true)
+	}
+	if _, ok := dir.Config.CLI.Command.OptionValue("metadata-comments"); ok {
+		mc.sync = dir.Config.GetBool("metadata-comments")
+	}
+	return mc
+}
+
+// CheckDir returns problems with the dir's own metadata sidecar file.
+func (mc *metadataChecker) CheckDir() []error {
+	m, err := mc.dir.DirMetadata()
+	if err != nil {
+		return []error{err}
+	} else if m == nil {
+		return nil
+	}
+	return m.Validate(nil, nil, mc.tiers)
+}
+
+// CheckTable returns the desired CREATE TABLE statement for table, taking into
+// account the metadata-comments option, along with any problems with the
+// table's metadata. If metadata is required but the table has no sidecar
+// file, this is considered a problem.
+func (mc *metadataChecker) CheckTable(table *tengo.Table) (string, []error) {
+	m, err := mc.dir.TableMetadata(table.Name)
+	if err != nil {
+		return table.CreateStatement(), []error{err}
+	} else if m == nil {
+		if len(mc.required) > 0 {
+			return table.CreateStatement(), []error{fmt.Errorf("%s: metadata file is missing, but metadata-required is set", mc.dir.TableMetadataPath(table.Name))}
+		}
+		return table.CreateStatement(), nil
+	}
+	problems := m.Validate(table, mc.required, mc.tiers)
+	if !mc.sync {
+		return table.CreateStatement(), problems
+	}
+	createStmt, err := CreateStatementWithComment(table, m.Comment())
+	if err != nil {
+		return table.CreateStatement(), append(problems, err)
+	}
+	return createStmt, problems
+}
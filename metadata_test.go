@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/skeema/tengo"
+)
+
+func TestMetadata(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "skeematest")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(tempDir)
+	writeFile := func(name, contents string) {
+		if err := ioutil.WriteFile(path.Join(tempDir, name), []byte(contents), 0666); err != nil {
+			t.Fatalf("Unable to write %s: %s", name, err)
+		}
+	}
+	writeFile(DirMetadataFileName, "owner=payments\ntier=1\ndescription=Billing system\n")
+	writeFile("customers.meta", "description=\"Customer accounts, one per signup\"\npii-columns=email,phone\ntier=2\n")
+	writeFile("invoices.meta", "pii\n")
+	writeFile("bad.meta", "owner=someone\ncolor=blue\n")
+	dir := &Dir{Path: tempDir}
+
+	customers := &tengo.Table{
+		Name:    "customers",
+		Engine:  "InnoDB",
+		CharSet: "latin1",
+		Columns: []*tengo.Column{
+			{Name: "id", TypeInDB: "int(11)", Default: tengo.ColumnDefaultNull},
+			{Name: "email", TypeInDB: "varchar(100)", Default: tengo.ColumnDefaultNull, CharSet: "latin1"},
+		},
+	}
+
+	m, err := dir.TableMetadata("customers")
+	if err != nil {
+		t.Fatalf("Unexpected error from TableMetadata: %s", err)
+	}
+	if m.Owner != "payments" || m.Tier != "2" || m.Description != "Customer accounts, one per signup" || !m.PII || len(m.PIIColumns) != 2 {
+		t.Errorf("Unexpected metadata for customers: %+v", *m)
+	}
+	if expected := "Customer accounts, one per signup (owner=payments, tier=2, pii=email,phone)"; m.Comment() != expected {
+		t.Errorf("Expected comment %q, instead found %q", expected, m.Comment())
+	}
+	problems := m.Validate(customers, []string{"owner", "description"}, []string{"1", "2"})
+	if len(problems) != 1 || !strings.Contains(problems[0].Error(), "column phone") {
+		t.Errorf("Expected one problem regarding missing column phone, instead found %v", problems)
+	}
+	if problems := m.Validate(customers, []string{"tier", "color"}, []string{"1"}); len(problems) != 3 {
+		t.Errorf("Expected 3 problems, instead found %v", problems)
+	}
+
+	if m, err := dir.TableMetadata("invoices"); err != nil {
+		t.Errorf("Unexpected error from TableMetadata: %s", err)
+	} else if !m.PII || m.Owner != "payments" || m.Comment() != "(owner=payments, tier=1, pii=yes)" {
+		t.Errorf("Unexpected metadata for invoices: %+v", *m)
+	}
+	if m, err := dir.TableMetadata("no_sidecar"); err != nil || m == nil || m.Owner != "payments" || m.Description != "" {
+		t.Errorf("Expected metadata for table without sidecar to be inherited from dir, instead found %+v, %v", m, err)
+	}
+	if _, err := dir.TableMetadata("bad"); err == nil {
+		t.Error("Expected error from metadata file with unknown field, but it was nil")
+	}
+	if m, err := (&Dir{Path: "/nonexistent"}).TableMetadata("customers"); m != nil || err != nil {
+		t.Errorf("Expected nil metadata and no error for dir without metadata files, instead found %+v, %v", m, err)
+	}
+
+	m.PIIProtectedColumns = []string{"phone", "ssn"}
+	if problems := m.Validate(nil, nil, nil); len(problems) != 1 || !strings.Contains(problems[0].Error(), "column ssn") {
+		t.Errorf("Expected one problem regarding protected column ssn, instead found %v", problems)
+	}
+	if cols := m.UnprotectedPIIColumns(customers); len(cols) != 1 || cols[0] != "email" {
+		t.Errorf("Unexpected result from UnprotectedPIIColumns: %v", cols)
+	}
+	encrypted := *customers
+	encrypted.CreateOptions = "ENCRYPTION='Y'"
+	if cols := m.UnprotectedPIIColumns(&encrypted); len(cols) != 0 {
+		t.Errorf("Expected no unprotected PII columns in encrypted table, instead found %v", cols)
+	}
+
+	createStmt, err := CreateStatementWithComment(customers, "new comment")
+	if err != nil || !strings.HasSuffix(createStmt, " COMMENT='new comment'") {
+		t.Errorf("Unexpected result from CreateStatementWithComment: %s, %v", createStmt, err)
+	}
+	customers.UnsupportedDDL = true
+	if _, err := CreateStatementWithComment(customers, "new comment"); err == nil {
+		t.Error("Expected error from CreateStatementWithComment for table with UnsupportedDDL, but it was nil")
+	}
+}
+
+func TestSchemaDocWrite(t *testing.T) {
+	doc := &schemaDoc{
+		dir:  &Dir{Path: "/tmp/mydb"},
+		name: "my_db",
+		meta: &Metadata{Owner: "payments", Description: "Billing"},
+		tables: []tableDoc{
+			{Table: &tengo.Table{Name: "customers"}, meta: &Metadata{Owner: "payments", Tier: "1", PIIColumns: []string{"email"}, PII: true}},
+			{Table: &tengo.Table{Name: "audit_log", Comment: "who did | what"}},
+		},
+	}
+	var buf bytes.Buffer
+	doc.Write(&buf)
+	expected := "## my\\_db\n\nDirectory: `/tmp/mydb`\n\nBilling\n\n* Owner: payments\n\n" +
+		"Table | Owner | Tier | PII | Description\n--- | --- | --- | --- | ---\n" +
+		"customers | payments | 1 | email | \n" +
+		"audit\\_log |  |  |  | who did \\| what\n\n"
+	if buf.String() != expected {
+		t.Errorf("Unexpected output from Write:\n%s\nExpected:\n%s", buf.String(), expected)
+	}
+}
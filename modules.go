@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// This file contains functions relating to schema modules: external
+// collections of *.sql files, declared via the modules option, whose tables are
+// merged into a directory's own table definitions.
+
+// reModuleName is used to convert a git URL into a filesystem-safe dir name.
+var reModuleName = regexp.MustCompile(`[^\w.-]+`)
+
+// moduleCheckouts tracks git URLs which have already been cloned during the
+// current run, mapping them to the local path of the checkout.
+var moduleCheckouts = struct {
+	paths map[string]string
+	sync.Mutex
+}{paths: make(map[string]string)}
+
+// isGitModule returns true if the supplied module location refers to a git
+// repository, rather than a local filesystem path.
+func isGitModule(location string) bool {
+	return strings.Contains(location, "://") || strings.HasPrefix(location, "git@") || strings.HasSuffix(strings.SplitN(location, "#", 2)[0], ".git")
+}
+
+// ModulePaths returns the local filesystem paths of each module declared by the
+// dir's modules option. Relative paths are interpreted relative to the dir.
+// Git URLs are cloned into the run's temporary dir; a specific branch or tag
+// may be requested by appending "#ref" to the URL.
+func (dir *Dir) ModulePaths() ([]string, error) {
+	locations := dir.Config.GetSlice("modules", ',', true)
+	result := make([]string, 0, len(locations))
+	for _, location := range locations {
+		if isGitModule(location) {
+			modPath, err := checkoutGitModule(location, dir)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, modPath)
+			continue
+		}
+		if !filepath.IsAbs(location) {
+			location = path.Join(dir.Path, location)
+		}
+		if fi, err := os.Stat(location); err != nil {
+			return nil, fmt.Errorf("Unable to use module %s: %s", location, err)
+		} else if !fi.IsDir() {
+			return nil, fmt.Errorf("Unable to use module %s: not a directory", location)
+		}
+		result = append(result, filepath.Clean(location))
+	}
+	return result, nil
+}
+
+// checkoutGitModule clones the supplied git URL into the run's temporary dir,
+// returning the path to the checkout. Each URL is only cloned once per run.
+func checkoutGitModule(location string, dir *Dir) (string, error) {
+	moduleCheckouts.Lock()
+	defer moduleCheckouts.Unlock()
+	if modPath, ok := moduleCheckouts.paths[location]; ok {
+		return modPath, nil
+	}
+	tempDir, err := Resources.TempDir()
+	if err != nil {
+		return "", err
+	}
+	parts := strings.SplitN(location, "#", 2)
+	modPath := path.Join(tempDir, "modules", reModuleName.ReplaceAllString(location, "_"))
+	extra := map[string]string{
+		"URL":  parts[0],
+		"PATH": modPath,
+	}
+	command := "git clone --quiet --depth 1 {URL} {PATH}"
+	if len(parts) > 1 {
+		extra["REF"] = parts[1]
+		command = "git clone --quiet --depth 1 --branch {REF} {URL} {PATH}"
+	}
+	log.Infof("Cloning schema module %s", location)
+	s, err := NewInterpolatedShellOut(command, dir, extra)
+	if err != nil {
+		return "", err
+	}
+	if err := s.Run(); err != nil {
+		return "", fmt.Errorf("Unable to clone module %s: %s", location, err)
+	}
+	moduleCheckouts.paths[location] = modPath
+	return modPath, nil
+}
+
+// ModuleSQLFiles returns SQLFiles for every *.sql file in each of the dir's
+// modules. Modules always use the per-table layout. Only the top level of each module is examined; a module's own
+// option files are not used.
+func (dir *Dir) ModuleSQLFiles() ([]*SQLFile, error) {
+	modPaths, err := dir.ModulePaths()
+	if err != nil {
+		return nil, err
+	}
+	var result []*SQLFile
+	for _, modPath := range modPaths {
+		modDir := &Dir{
+			Path:           modPath,
+			Config:         dir.Config,
+			section:        dir.section,
+			optionFileName: dir.optionFileName,
+		}
+		sqlFiles, err := modDir.perTableSQLFiles() // modules always use per-table layout
+		if err != nil {
+			return nil, fmt.Errorf("Unable to list SQL files in module %s: %s", modPath, err)
+		}
+		result = append(result, sqlFiles...)
+	}
+	return result, nil
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/skeema/tengo"
+)
+
+// NormalizeCache tracks how the *.sql files of a dir were normalized by a
+// workspace in previous runs. Each entry is a file whose name is a hash of the
+// dir's *.sql file contents, workspace-related options, and the server flavor
+// and version; its contents are a fingerprint of the resulting schema. If a
+// live schema has the same fingerprint as the cached normalized one, the
+// workspace may be skipped entirely, since the live schema's definition can be
+// used in its place. Entries are never modified once written, so multiple
+// concurrent runs may safely share the cache.
+type NormalizeCache struct {
+	Dir           string
+	ServerVersion string // flavor and full version string of the server used for normalization
+}
+
+// NewNormalizeCache returns a NormalizeCache using the directory configured by
+// the normalize-cache-dir option of dir, or nil if the option is not set or is
+// not supported by the current command. An error is returned if the cache dir
+// cannot be created, or the server version of instance cannot be determined.
+func NewNormalizeCache(dir *Dir, instance *tengo.Instance) (*NormalizeCache, error) {
+	if _, ok := dir.Config.CLI.Command.Options()["normalize-cache-dir"]; !ok {
+		return nil, nil
+	}
+	cacheDir := dir.Config.Get("normalize-cache-dir")
+	if cacheDir == "" {
+		return nil, nil
+	}
+	if strings.HasPrefix(cacheDir, "~/") {
+		cacheDir = path.Join(os.Getenv("HOME"), cacheDir[2:])
+	}
+	if err := os.MkdirAll(cacheDir, 0777); err != nil {
+		return nil, fmt.Errorf("Unable to create normalize-cache-dir %s: %s", cacheDir, err)
+	}
+	sv, err := GetServerVersion(instance)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to determine server version of %s: %s", instance, err)
+	}
+	return &NormalizeCache{
+		Dir:           cacheDir,
+		ServerVersion: fmt.Sprintf("%s %s", sv.Flavor, sv.Raw),
+	}, nil
+}
+
+// Key returns the cache key for normalizing sqlFiles using dir's
+// configuration.
+func (nc *NormalizeCache) Key(dir *Dir, sqlFiles []*SQLFile) string {
+	h := sha256.New()
+	parts := []string{
+		nc.ServerVersion,
+		dir.Config.Get("connect-options"),
+		dir.Config.Get("default-character-set"),
+		dir.Config.Get("default-collation"),
+	}
+	for _, sf := range sqlFiles {
+		parts = append(parts, sf.FileName, sf.Contents)
+	}
+	for _, part := range parts {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Get returns the fingerprint of the schema previously obtained by normalizing
+// the files corresponding to key, or a blank string if there is no such entry.
+// It is safe to call this method on a nil NormalizeCache, which always returns
+// a blank string.
+func (nc *NormalizeCache) Get(key string) string {
+	if nc == nil {
+		return ""
+	}
+	contents, err := ioutil.ReadFile(path.Join(nc.Dir, key))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(contents))
+}
+
+// Add records the fingerprint of schema, which was obtained by normalizing the
+// files corresponding to key. Schemas containing tables with an explicit next
+// auto-increment value are not cached, since a live table's value may differ
+// without being considered a difference. Failures are logged but otherwise
+// ignored, since the cache is merely an optimization. It is safe to call this
+// method on a nil NormalizeCache, which does nothing.
+func (nc *NormalizeCache) Add(key string, schema *tengo.Schema) {
+	if nc == nil {
+		return
+	}
+	tables, err := schema.Tables()
+	if err != nil {
+		return
+	}
+	for _, table := range tables {
+		if table.NextAutoIncrement > 1 {
+			return
+		}
+	}
+	fingerprint, err := SchemaFingerprint(schema)
+	if err != nil {
+		return
+	}
+	entryPath := path.Join(nc.Dir, key)
+	if err := ioutil.WriteFile(entryPath, []byte(fingerprint), 0666); err != nil {
+		log.Debugf("Unable to write normalize cache entry %s: %s", entryPath, err)
+	}
+}
+
+// SchemaFingerprint returns a hash of schema's default character set and
+// collation, and the definitions of all of its tables, ignoring next
+// auto-increment values.
+func SchemaFingerprint(schema *tengo.Schema) (string, error) {
+	tablesByName, err := schema.TablesByName()
+	if err != nil {
+		return "", err
+	}
+	names := make([]string, 0, len(tablesByName))
+	for name := range tablesByName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	h := sha256.New()
+	for _, part := range []string{schema.CharSet, schema.Collation} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	for _, name := range names {
+		create, _ := tengo.ParseCreateAutoInc(tablesByName[name].CreateStatement())
+		h.Write([]byte(create))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// withoutNextAutoInc returns a copy of table without its next auto-increment
+// value, as if the table had just been created. The copy's CREATE TABLE is
+// generated by tengo, so table must not have UnsupportedDDL set.
+func withoutNextAutoInc(table *tengo.Table) *tengo.Table {
+	return &tengo.Table{
+		Name:              table.Name,
+		Engine:            table.Engine,
+		CharSet:           table.CharSet,
+		Collation:         table.Collation,
+		CreateOptions:     table.CreateOptions,
+		Columns:           table.Columns,
+		PrimaryKey:        table.PrimaryKey,
+		SecondaryIndexes:  table.SecondaryIndexes,
+		Comment:           table.Comment,
+		NextAutoIncrement: 1,
+	}
+}
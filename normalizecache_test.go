@@ -0,0 +1,91 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/skeema/tengo"
+)
+
+func TestNormalizeCache(t *testing.T) {
+	cacheDir, err := ioutil.TempDir("", "skeematest")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(cacheDir)
+
+	var nilCache *NormalizeCache
+	nilCache.Add("abc", &tengo.Schema{Name: "foo"})
+	if nilCache.Get("abc") != "" {
+		t.Error("Expected nil NormalizeCache to never have entries")
+	}
+
+	dir := &Dir{Path: "/tmp/foo", Config: getConfig(map[string]string{
+		"connect-options":       "",
+		"default-character-set": "",
+		"default-collation":     "",
+	})}
+	sqlFiles := []*SQLFile{
+		{Dir: dir, FileName: "a.sql", Contents: "CREATE TABLE a (id int)"},
+		{Dir: dir, FileName: "b.sql", Contents: "CREATE TABLE b (id int)"},
+	}
+	nc := &NormalizeCache{Dir: cacheDir, ServerVersion: "mysql 5.7.22"}
+	key := nc.Key(dir, sqlFiles)
+	if key != nc.Key(dir, sqlFiles) {
+		t.Error("Expected Key to be deterministic")
+	}
+	other := &NormalizeCache{Dir: cacheDir, ServerVersion: "mysql 8.0.11"}
+	if key == other.Key(dir, sqlFiles) {
+		t.Error("Expected Key to differ for different server version")
+	}
+	sqlFiles[1].Contents = "CREATE TABLE b (id bigint)"
+	if key == nc.Key(dir, sqlFiles) {
+		t.Error("Expected Key to differ for different file contents")
+	}
+	if key == nc.Key(dir, sqlFiles[0:1]) {
+		t.Error("Expected Key to differ for different file list")
+	}
+
+	if nc.Get(key) != "" {
+		t.Error("Expected empty NormalizeCache to not have entry")
+	}
+	if err := ioutil.WriteFile(path.Join(cacheDir, key), []byte("deadbeef\n"), 0666); err != nil {
+		t.Fatalf("Unable to write cache entry: %s", err)
+	}
+	if actual := nc.Get(key); actual != "deadbeef" {
+		t.Errorf("Expected Get to return stored fingerprint, instead found %q", actual)
+	}
+
+	// A schema detached from its instance, without cached tables, cannot be
+	// fingerprinted, so no entry should be written
+	nc.Add("detached", &tengo.Schema{Name: "foo"})
+	if nc.Get("detached") != "" {
+		t.Error("Expected no entry to be written for schema that cannot be fingerprinted")
+	}
+}
+
+func TestWithoutNextAutoInc(t *testing.T) {
+	col := &tengo.Column{Name: "id", TypeInDB: "int(10) unsigned", AutoIncrement: true, Default: tengo.ColumnDefaultNull}
+	table := &tengo.Table{
+		Name:              "foo",
+		Engine:            "InnoDB",
+		CharSet:           "latin1",
+		Columns:           []*tengo.Column{col},
+		PrimaryKey:        &tengo.Index{Name: "PRIMARY", Columns: []*tengo.Column{col}, SubParts: []uint16{0}, PrimaryKey: true, Unique: true},
+		NextAutoIncrement: 123,
+	}
+	if !strings.Contains(table.CreateStatement(), "AUTO_INCREMENT=123") {
+		t.Fatalf("Test setup problem: expected original CREATE to contain next auto-increment value, instead found %s", table.CreateStatement())
+	}
+	stripped := withoutNextAutoInc(table)
+	if stripped.NextAutoIncrement != 1 || table.NextAutoIncrement != 123 {
+		t.Errorf("Unexpected next auto-increment values: copy %d, original %d", stripped.NextAutoIncrement, table.NextAutoIncrement)
+	}
+	expected, _ := tengo.ParseCreateAutoInc(table.CreateStatement())
+	if actual := stripped.CreateStatement(); actual != expected {
+		t.Errorf("Expected CREATE of copy to be:\n%s\ninstead found:\n%s", expected, actual)
+	}
+}
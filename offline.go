@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/skeema/tengo"
+)
+
+// offlineState tracks output and counters for offline output.
+type offlineState struct {
+	briefOutput bool
+	errCount    int
+}
+
+// OfflineCreateStatements outputs the CREATE TABLE statements from the *.sql
+// files of dir and its subdirs, without connecting to any database server.
+// This is not a diff: no live schema or snapshot is compared, and the
+// statements are not normalized, since no server is available to do so. Each
+// dir must declare its target server using the flavor option; the table files
+// are checked against that flavor's supported features, but are otherwise
+// output as-is.
+func OfflineCreateStatements(dir *Dir, briefOutput bool) error {
+	state := &offlineState{briefOutput: briefOutput}
+	state.processDir(dir)
+	if state.errCount > 0 {
+		var plural string
+		if state.errCount > 1 {
+			plural = "s"
+		}
+		return NewExitValue(CodeFatalError, "Skipped %d operation%s due to error%s", state.errCount, plural, plural)
+	}
+	return nil
+}
+
+func (state *offlineState) processDir(dir *Dir) {
+	if !dir.AllowsEnvironment() {
+		dir.logSkippedEnvironment()
+	} else if dir.HasSchema() {
+		if err := state.outputDir(dir); err != nil {
+			log.Errorf("Skipping %s: %s", dir, err)
+			state.errCount++
+		}
+	}
+
+	subdirs, err := dir.Subdirs()
+	if err != nil {
+		log.Errorf("Unable to list subdirs of %s: %s", dir, err)
+		state.errCount++
+		return
+	}
+	for _, sub := range subdirs {
+		state.processDir(sub)
+	}
+}
+
+func (state *offlineState) outputDir(dir *Dir) error {
+	declared, err := dir.Flavor()
+	if err != nil {
+		return err
+	} else if declared == nil {
+		return fmt.Errorf("offline mode requires the flavor option to be set")
+	}
+	schemaNames, err := dir.OfflineSchemaNames()
+	if err != nil {
+		return err
+	}
+	unsupported, err := declared.UnsupportedFeatures(dir)
+	if err != nil {
+		return err
+	} else if len(unsupported) > 0 {
+		return fmt.Errorf("table files use features not supported by declared flavor %s: %s", declared.Raw, strings.Join(unsupported, ", "))
+	}
+
+	sqlFiles, err := dir.SQLFiles()
+	if err != nil {
+		return err
+	}
+	moduleFiles, err := dir.ModuleSQLFiles()
+	if err != nil {
+		return err
+	}
+	ignoreTable := dir.Config.Get("ignore-table")
+	re, err := regexp.Compile(ignoreTable)
+	if err != nil {
+		return fmt.Errorf("Invalid regular expression on ignore-table: %s; %s", ignoreTable, err)
+	}
+	var kept []*SQLFile
+	for _, sf := range append(sqlFiles, moduleFiles...) {
+		if sf.Error != nil {
+			return sf.Error
+		}
+		if ignoreTable != "" && re.MatchString(sf.Table) {
+			log.Debugf("Skipping table %s because ignore-table matched %s", sf.Table, ignoreTable)
+			continue
+		}
+		kept = append(kept, sf)
+	}
+	if len(kept) == 0 {
+		return nil
+	}
+
+	for _, schemaName := range schemaNames {
+		if state.briefOutput {
+			fmt.Printf("%s (offline: %s) %s\n", dir, declared.Raw, schemaName)
+			continue
+		}
+		fmt.Printf("-- offline: %s (%s)\n", dir, declared.Raw)
+		fmt.Printf("USE %s;\n", tengo.EscapeIdentifier(schemaName))
+		for _, sf := range kept {
+			fmt.Printf("%s;\n", offlineCreateStatement(sf))
+		}
+	}
+	return nil
+}
+
+// offlineCreateStatement returns the CREATE TABLE statement of sf for offline
+// output. The statement has already been reduced to its CREATE TABLE
+// with an escaped table name by SQLFile.Read; this additionally removes any
+// trailing whitespace and statement delimiter, so that the caller may supply
+// exactly one delimiter.
+func offlineCreateStatement(sf *SQLFile) string {
+	return strings.TrimRight(sf.Contents, "; \t\r\n")
+}
+
+// OfflineSchemaNames returns the schema names mapped to dir, without consulting
+// any database server. An error is returned if the dir's schema option can
+// only be resolved using a live instance, i.e. it is "*" or a shellout.
+func (dir *Dir) OfflineSchemaNames() ([]string, error) {
+	if !dir.Config.Changed("schema") {
+		return nil, nil
+	}
+	schemaValue := dir.Config.Get("schema")
+	rawSchemaValue := dir.Config.GetRaw("schema")
+	if schemaValue == "*" || (rawSchemaValue != schemaValue && rawSchemaValue[0] == '`') {
+		return nil, fmt.Errorf("schema value %s cannot be resolved in offline mode", rawSchemaValue)
+	}
+	schemaNames := dir.Config.GetSlice("schema", ',', true)
+	for n := range schemaNames {
+		schemaNames[n] = dir.TransformSchemaName(schemaNames[n])
+	}
+	return schemaNames, nil
+}
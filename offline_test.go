@@ -0,0 +1,44 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestOfflineSchemaNames(t *testing.T) {
+	cases := map[string][]string{
+		"product":       {"stg_product"},
+		"product,users": {"stg_product", "stg_users"},
+	}
+	for schemaValue, expected := range cases {
+		dir := &Dir{Config: getConfig(map[string]string{"schema": schemaValue, "schema-prefix": "stg_", "schema-suffix": ""})}
+		actual, err := dir.OfflineSchemaNames()
+		if err != nil {
+			t.Errorf("Unexpected error from OfflineSchemaNames with schema=%s: %s", schemaValue, err)
+		} else if !reflect.DeepEqual(actual, expected) {
+			t.Errorf("Expected OfflineSchemaNames with schema=%s to return %v, instead found %v", schemaValue, expected, actual)
+		}
+	}
+
+	for _, schemaValue := range []string{"*", "`/bin/echo product`"} {
+		dir := &Dir{Config: getConfig(map[string]string{"schema": schemaValue, "schema-prefix": "", "schema-suffix": ""})}
+		if _, err := dir.OfflineSchemaNames(); err == nil {
+			t.Errorf("Expected OfflineSchemaNames with schema=%s to return an error, but it did not", schemaValue)
+		}
+	}
+}
+
+func TestOfflineCreateStatement(t *testing.T) {
+	cases := map[string]string{
+		"CREATE TABLE `foo` (id int)":         "CREATE TABLE `foo` (id int)",
+		"CREATE TABLE `foo` (id int);\n":      "CREATE TABLE `foo` (id int)",
+		"CREATE TABLE `foo` (id int)\n;;  \n": "CREATE TABLE `foo` (id int)",
+		"CREATE TABLE `foo` (\n  id int\n)\n": "CREATE TABLE `foo` (\n  id int\n)",
+	}
+	for contents, expected := range cases {
+		sf := &SQLFile{Contents: contents}
+		if actual := offlineCreateStatement(sf); actual != expected {
+			t.Errorf("Expected offlineCreateStatement to return %q for contents %q, instead found %q", expected, contents, actual)
+		}
+	}
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/skeema/mybase"
+	"github.com/skeema/tengo"
+)
+
+// PlanFormatVersion is the version of the JSON document output by
+// `skeema diff --format=json` and `skeema push --format=json`. It is only
+// incremented for changes which are not backwards-compatible; new keys may be
+// added without changing the version. See doc/plan-format.md.
+const PlanFormatVersion = 1
+
+// Statuses of PlanStatements
+const (
+	StatusPlanned = "planned" // diff only: statement would be run by push
+	StatusApplied = "applied" // push only: statement was run successfully
+	StatusSkipped = "skipped" // statement was not run due to options or policy
+	StatusFailed  = "failed"  // push only: statement was run but returned an error
+)
+
+// Safety classes of PlanStatements
+const (
+	SafetySafe   = "safe"   // cannot destroy data
+	SafetyUnsafe = "unsafe" // potentially destructive; requires allow-unsafe or safe-below-size
+)
+
+// Reasons for skipping PlanStatements. Every statement with StatusSkipped has
+// one of these as its SkipReason.
+const (
+	SkipReasonUnsafe       = "unsafe"        // forbidden by allow-unsafe and safe-below-size
+	SkipReasonRefused      = "refused"       // forbidden by another option, e.g. strict-replication or pii-policy, or could not be generated
+	SkipReasonFiltered     = "filtered"      // excluded by only or skip
+	SkipReasonOnlyAdditive = "only-additive" // deferred because it is not purely additive
+)
+
+// PlanDocument is the machine-readable output of `skeema diff` or
+// `skeema push` with --format=json.
+type PlanDocument struct {
+	FormatVersion     int                     `json:"format_version"`
+	Command           string                  `json:"command"`
+	Environment       string                  `json:"environment"`
+	DryRun            bool                    `json:"dry_run"`
+	Statements        []*PlanStatement        `json:"statements"`
+	UnsupportedTables []*PlanUnsupportedTable `json:"unsupported_tables"`
+	Summary           PlanSummary             `json:"summary"`
+}
+
+// PlanStatement describes a single generated DDL statement, and what happened
+// to it.
+type PlanStatement struct {
+	ID               string   `json:"id"`
+	IdempotencyToken string   `json:"idempotency_token"`
+	Instance         string   `json:"instance"`
+	Schema           string   `json:"schema"`
+	Table            string   `json:"table,omitempty"`
+	Type             string   `json:"type"`
+	Safety           string   `json:"safety"`
+	Statement        string   `json:"statement"`
+	Status           string   `json:"status"`
+	SkipReason       string   `json:"skip_reason,omitempty"`
+	Error            string   `json:"error,omitempty"`
+	Warnings         []string `json:"warnings,omitempty"`
+}
+
+// PlanUnsupportedTable identifies a table which differs between the instance
+// and filesystem, but for which no statement could be generated.
+type PlanUnsupportedTable struct {
+	Instance string `json:"instance"`
+	Schema   string `json:"schema"`
+	Table    string `json:"table"`
+}
+
+// PlanSummary counts PlanStatements by status. Skipped statements are also
+// counted by SkipReason.
+type PlanSummary struct {
+	Planned     int            `json:"planned"`
+	Applied     int            `json:"applied"`
+	Skipped     int            `json:"skipped"`
+	SkipReasons map[string]int `json:"skip_reasons"`
+	Failed      int            `json:"failed"`
+	Unsupported int            `json:"unsupported"`
+}
+
+// StatementID returns a stable identifier for a DDL statement, derived from
+// the instance, schema, and raw SQL of the statement. The same change to the
+// same target always has the same ID, regardless of wrapper options.
+func StatementID(instance, schema, stmt string) string {
+	return shortHash(instance, schema, stmt)
+}
+
+// IdempotencyToken returns a token identifying the statement with the supplied
+// ID as applied to a specific prior state of its object. before should be the
+// object's current definition on the instance, e.g. SHOW CREATE TABLE, or a
+// blank string if the object does not exist yet. Since a successfully-applied
+// statement changes the object, the same token can never legitimately be
+// applied twice.
+func IdempotencyToken(id, before string) string {
+	return shortHash(id, before)
+}
+
+// shortHash returns the first 16 hex characters of a SHA-256 hash of the
+// supplied values, which are delimited to avoid ambiguity.
+func shortHash(values ...string) string {
+	h := sha256.New()
+	for _, value := range values {
+		h.Write([]byte(value))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// StatementSafety returns the safety class of a TableDiff.
+func StatementSafety(diff tengo.TableDiff) string {
+	switch diff := diff.(type) {
+	case tengo.DropTable:
+		return SafetyUnsafe
+	case tengo.AlterTable:
+		for _, clause := range diff.Clauses {
+			if clause.Unsafe() {
+				return SafetyUnsafe
+			}
+		}
+	}
+	return SafetySafe
+}
+
+// AddWarning appends a warning to ps. It is a no-op if ps is nil, which is the
+// case when JSON output is not in use.
+func (ps *PlanStatement) AddWarning(warning string) {
+	if ps != nil {
+		ps.Warnings = append(ps.Warnings, Redact(warning))
+	}
+}
+
+// setStatus updates the status of ps, along with its error if err is non-nil.
+// It is a no-op if ps is nil.
+func (ps *PlanStatement) setStatus(status string, err error) {
+	if ps != nil {
+		ps.Status = status
+		if err != nil {
+			ps.Error = Redact(err.Error())
+		}
+	}
+}
+
+// skip marks ps as skipped for the supplied reason, which should be one of the
+// SkipReason constants. err describes the specific cause, and may be nil. It is
+// a no-op if ps is nil.
+func (ps *PlanStatement) skip(reason string, err error) {
+	if ps != nil {
+		ps.setStatus(StatusSkipped, err)
+		ps.SkipReason = reason
+	}
+}
+
+// SkipReasonForError returns the SkipReason of a statement which could not be
+// run due to err, a non-nil DDLStatement.Err.
+func SkipReasonForError(err error) string {
+	if _, isForbidden := err.(*tengo.ForbiddenDiffError); isForbidden {
+		return SkipReasonUnsafe
+	}
+	return SkipReasonRefused
+}
+
+// Write outputs doc to w as indented JSON, after computing its summary.
+func (doc *PlanDocument) Write(w io.Writer) error {
+	doc.Summary = PlanSummary{
+		SkipReasons: make(map[string]int),
+		Unsupported: len(doc.UnsupportedTables),
+	}
+	for _, ps := range doc.Statements {
+		switch ps.Status {
+		case StatusPlanned:
+			doc.Summary.Planned++
+		case StatusApplied:
+			doc.Summary.Applied++
+		case StatusSkipped:
+			doc.Summary.Skipped++
+			doc.Summary.SkipReasons[ps.SkipReason]++
+		case StatusFailed:
+			doc.Summary.Failed++
+		}
+	}
+	if doc.Statements == nil {
+		doc.Statements = []*PlanStatement{}
+	}
+	if doc.UnsupportedTables == nil {
+		doc.UnsupportedTables = []*PlanUnsupportedTable{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(doc)
+}
+
+// errStatementFiltered is the Error of a PlanStatement excluded by the only or
+// skip options.
+var errStatementFiltered = errors.New("Statement excluded by only or skip option")
+
+var reStatementID = regexp.MustCompile(`^[0-9a-f]{16}$`)
+
+// StatementFilter restricts which statements are run, based on statement IDs
+// supplied to the only and skip options. A nil StatementFilter permits all
+// statements.
+type StatementFilter struct {
+	Only    map[string]bool // if non-empty, only these IDs are permitted
+	Skip    map[string]bool // these IDs are never permitted
+	matched map[string]bool // IDs from Only or Skip that have been seen by Allows
+	*sync.Mutex
+}
+
+// NewStatementFilter returns a StatementFilter based on the only and skip
+// options in cfg, or nil if neither option is set.
+func NewStatementFilter(cfg *mybase.Config) (*StatementFilter, error) {
+	sf := &StatementFilter{
+		Only:    make(map[string]bool),
+		Skip:    make(map[string]bool),
+		matched: make(map[string]bool),
+		Mutex:   new(sync.Mutex),
+	}
+	for optionName, ids := range map[string]map[string]bool{"only": sf.Only, "skip": sf.Skip} {
+		for _, id := range cfg.GetSlice(optionName, ',', true) {
+			id = strings.ToLower(id)
+			if !reStatementID.MatchString(id) {
+				return nil, OptionError(cfg, optionName, NewExitValue(CodeBadConfig, "Option %s must be a comma-separated list of 16-character statement IDs; found \"%s\"", optionName, id))
+			}
+			ids[id] = true
+		}
+	}
+	if len(sf.Only) == 0 && len(sf.Skip) == 0 {
+		return nil, nil
+	}
+	for id := range sf.Skip {
+		if sf.Only[id] {
+			return nil, NewExitValue(CodeBadConfig, "Statement ID %s cannot be supplied to both only and skip", id)
+		}
+	}
+	return sf, nil
+}
+
+// Allows returns true if the statement with the supplied ID should be run.
+func (sf *StatementFilter) Allows(id string) bool {
+	if sf == nil {
+		return true
+	}
+	sf.Lock()
+	defer sf.Unlock()
+	if sf.Only[id] || sf.Skip[id] {
+		sf.matched[id] = true
+	}
+	return !sf.Skip[id] && (len(sf.Only) == 0 || sf.Only[id])
+}
+
+// Unmatched returns a sorted list of IDs supplied to only or skip which have
+// not been seen by Allows. This typically indicates that the supplied IDs came
+// from an outdated plan.
+func (sf *StatementFilter) Unmatched() (ids []string) {
+	if sf == nil {
+		return nil
+	}
+	sf.Lock()
+	defer sf.Unlock()
+	for _, set := range []map[string]bool{sf.Only, sf.Skip} {
+		for id := range set {
+			if !sf.matched[id] {
+				ids = append(ids, id)
+			}
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/skeema/tengo"
+)
+
+func TestStatementIDAndToken(t *testing.T) {
+	stmt := "ALTER TABLE `foo` ADD COLUMN `bar` int"
+	id := StatementID("localhost:3306", "product", stmt)
+	if len(id) != 16 {
+		t.Errorf("Expected 16-character ID, instead found %q", id)
+	}
+	if id != StatementID("localhost:3306", "product", stmt) {
+		t.Error("Expected StatementID to be deterministic, but it was not")
+	}
+	if id == StatementID("localhost:3307", "product", stmt) || id == StatementID("localhost:3306", "analytics", stmt) {
+		t.Error("Expected StatementID to differ by instance and schema, but it did not")
+	}
+	if StatementID("a", "bc", stmt) == StatementID("ab", "c", stmt) {
+		t.Error("Expected StatementID to delimit its inputs, but it did not")
+	}
+
+	before := "CREATE TABLE `foo` (\n  `id` int NOT NULL\n)"
+	token := IdempotencyToken(id, before)
+	if token == id || token == IdempotencyToken(id, "") {
+		t.Error("Expected IdempotencyToken to vary by prior state, but it did not")
+	}
+}
+
+func TestStatementSafety(t *testing.T) {
+	table := &tengo.Table{Name: "foo"}
+	col := &tengo.Column{Name: "bar", TypeInDB: "int(11)", Default: tengo.ColumnDefaultNull}
+	cases := map[tengo.TableDiff]string{
+		tengo.CreateTable{Table: table}: SafetySafe,
+		tengo.DropTable{Table: table}:   SafetyUnsafe,
+	}
+	for diff, expected := range cases {
+		if actual := StatementSafety(diff); actual != expected {
+			t.Errorf("Expected StatementSafety(%T) to return %s, instead found %s", diff, expected, actual)
+		}
+	}
+	add := tengo.AlterTable{Table: table, Clauses: []tengo.TableAlterClause{tengo.AddColumn{Table: table, Column: col}}}
+	if actual := StatementSafety(add); actual != SafetySafe {
+		t.Errorf("Expected ADD COLUMN to be %s, instead found %s", SafetySafe, actual)
+	}
+	add.Clauses = append(add.Clauses, tengo.DropColumn{Table: table, Column: col})
+	if actual := StatementSafety(add); actual != SafetyUnsafe {
+		t.Errorf("Expected ADD COLUMN + DROP COLUMN to be %s, instead found %s", SafetyUnsafe, actual)
+	}
+}
+
+func TestPlanDocumentWrite(t *testing.T) {
+	doc := &PlanDocument{FormatVersion: PlanFormatVersion, Command: "push", Environment: "production"}
+	var buf bytes.Buffer
+	if err := doc.Write(&buf); err != nil {
+		t.Fatalf("Unexpected error from Write: %s", err)
+	}
+	var raw map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &raw); err != nil {
+		t.Fatalf("Unexpected error unmarshaling output: %s", err)
+	}
+	if stmts, ok := raw["statements"].([]interface{}); !ok || len(stmts) != 0 {
+		t.Errorf("Expected statements to be an empty array, instead found %v", raw["statements"])
+	}
+
+	var nilStatement *PlanStatement
+	nilStatement.AddWarning("should not panic")
+	nilStatement.setStatus(StatusApplied, nil)
+	nilStatement.skip(SkipReasonFiltered, nil)
+
+	doc.Statements = []*PlanStatement{
+		{ID: "a", Status: StatusPlanned},
+		{ID: "b", Status: StatusApplied},
+		{ID: "c", Status: StatusApplied},
+		{ID: "d", Status: StatusPlanned},
+		{ID: "e", Status: StatusPlanned},
+	}
+	doc.Statements[3].skip(SkipReasonUnsafe, tengo.NewForbiddenDiffError("forbidden", "DROP TABLE `d`"))
+	doc.Statements[3].AddWarning("table d uses storage engine MyISAM")
+	doc.Statements[4].skip(SkipReasonOnlyAdditive, nil)
+	doc.UnsupportedTables = []*PlanUnsupportedTable{{Instance: "localhost:3306", Schema: "product", Table: "e"}}
+	buf.Reset()
+	if err := doc.Write(&buf); err != nil {
+		t.Fatalf("Unexpected error from Write: %s", err)
+	}
+	expected := PlanSummary{
+		Planned:     1,
+		Applied:     2,
+		Skipped:     2,
+		SkipReasons: map[string]int{SkipReasonUnsafe: 1, SkipReasonOnlyAdditive: 1},
+		Unsupported: 1,
+	}
+	if !reflect.DeepEqual(doc.Summary, expected) {
+		t.Errorf("Expected summary %+v, instead found %+v", expected, doc.Summary)
+	}
+	var roundTrip PlanDocument
+	if err := json.Unmarshal(buf.Bytes(), &roundTrip); err != nil {
+		t.Fatalf("Unexpected error unmarshaling output: %s", err)
+	}
+	if len(roundTrip.Statements) != 5 || len(roundTrip.Statements[3].Warnings) != 1 || roundTrip.Statements[3].SkipReason != SkipReasonUnsafe || !reflect.DeepEqual(roundTrip.Summary, expected) {
+		t.Errorf("Unexpected result from round-trip: %+v", roundTrip)
+	}
+}
+
+func TestSkipReasonForError(t *testing.T) {
+	if reason := SkipReasonForError(tengo.NewForbiddenDiffError("forbidden", "DROP TABLE `d`")); reason != SkipReasonUnsafe {
+		t.Errorf("Expected ForbiddenDiffError to have reason %s, instead found %s", SkipReasonUnsafe, reason)
+	}
+	if reason := SkipReasonForError(errors.New("strict-replication")); reason != SkipReasonRefused {
+		t.Errorf("Expected other errors to have reason %s, instead found %s", SkipReasonRefused, reason)
+	}
+}
+
+func TestDDLStatementTextString(t *testing.T) {
+	ddl := &DDLStatement{stmt: "DROP TABLE `foo`"}
+	if ddl.Text() != "DROP TABLE `foo`;" || ddl.String() != ddl.Text() {
+		t.Errorf("Unexpected Text() %q or String() %q", ddl.Text(), ddl.String())
+	}
+	ddl.setErr(&tengo.ForbiddenDiffError{})
+	if ddl.Text() != "DROP TABLE `foo`;" || ddl.String() == ddl.Text() {
+		t.Errorf("Expected String() but not Text() to change when Err set; found Text() %q, String() %q", ddl.Text(), ddl.String())
+	}
+}
+
+func TestStatementFilter(t *testing.T) {
+	const (
+		idA = "0123456789abcdef"
+		idB = "fedcba9876543210"
+		idC = "00000000ffffffff"
+	)
+	sf, err := NewStatementFilter(getConfig(map[string]string{"only": "", "skip": ""}))
+	if sf != nil || err != nil {
+		t.Errorf("Expected nil filter and nil error with no IDs, instead found %v, %v", sf, err)
+	}
+	if !sf.Allows(idA) || len(sf.Unmatched()) > 0 {
+		t.Error("Expected nil filter to allow all statements, but it did not")
+	}
+
+	sf, err = NewStatementFilter(getConfig(map[string]string{"only": idA + ", " + strings.ToUpper(idB), "skip": ""}))
+	if err != nil {
+		t.Fatalf("Unexpected error from NewStatementFilter: %s", err)
+	}
+	if !sf.Allows(idA) || sf.Allows(idC) {
+		t.Error("Unexpected result from Allows with only")
+	}
+	if unmatched := sf.Unmatched(); len(unmatched) != 1 || unmatched[0] != idB {
+		t.Errorf("Unexpected result from Unmatched: %v", unmatched)
+	}
+
+	sf, err = NewStatementFilter(getConfig(map[string]string{"only": "", "skip": idC}))
+	if err != nil {
+		t.Fatalf("Unexpected error from NewStatementFilter: %s", err)
+	}
+	if !sf.Allows(idA) || sf.Allows(idC) || len(sf.Unmatched()) > 0 {
+		t.Error("Unexpected result from Allows or Unmatched with skip")
+	}
+
+	badValues := []map[string]string{
+		{"only": "abc123", "skip": ""},
+		{"only": "", "skip": idA + ",not-an-id-at-all"},
+		{"only": idA, "skip": idA},
+	}
+	for _, values := range badValues {
+		if _, err := NewStatementFilter(getConfig(values)); err == nil {
+			t.Errorf("Expected error from NewStatementFilter with %v, but received none", values)
+		}
+	}
+}
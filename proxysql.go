@@ -0,0 +1,86 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/skeema/tengo"
+)
+
+// ProxySQLDefaultAdminPort is the port used for the proxysql-admin option if
+// none is specified.
+const ProxySQLDefaultAdminPort = 6032
+
+// proxySQLFlushStatement is run on a ProxySQL admin interface after schema
+// changes, so that ProxySQL does not continue serving results based on the
+// previous table definitions. Query rules are deliberately not reloaded, since
+// that would also promote any unrelated pending admin changes to runtime.
+const proxySQLFlushStatement = "PROXYSQL FLUSH QUERY CACHE"
+
+// ProxySQLAdmin represents the admin interface of a ProxySQL instance which
+// routes traffic to one or more database instances.
+type ProxySQLAdmin struct {
+	Host     string
+	Port     int
+	User     string
+	Password string
+}
+
+// NewProxySQLAdmin returns the ProxySQLAdmin configured for dir via the
+// proxysql-admin option, or nil if the option is not set.
+func NewProxySQLAdmin(dir *Dir) (*ProxySQLAdmin, error) {
+	if _, ok := dir.Config.CLI.Command.OptionValue("proxysql-admin"); !ok {
+		return nil, nil
+	}
+	value := dir.Config.Get("proxysql-admin")
+	if value == "" {
+		return nil, nil
+	}
+	host, port, err := tengo.SplitHostOptionalPort(value)
+	if err != nil {
+		return nil, OptionError(dir.Config, "proxysql-admin", err)
+	}
+	if port == 0 {
+		port = ProxySQLDefaultAdminPort
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	pa := &ProxySQLAdmin{
+		Host:     host,
+		Port:     port,
+		User:     dir.Config.Get("proxysql-user"),
+		Password: dir.Config.Get("proxysql-password"),
+	}
+	Secrets.Add(pa.Password)
+	return pa, nil
+}
+
+// String returns the address of the admin interface, in host:port format.
+func (pa *ProxySQLAdmin) String() string {
+	return net.JoinHostPort(pa.Host, strconv.Itoa(pa.Port))
+}
+
+// Flush connects to the admin interface and flushes the query cache.
+func (pa *ProxySQLAdmin) Flush() error {
+	cfg := &mysql.Config{
+		User:                 pa.User,
+		Passwd:               pa.Password,
+		Net:                  "tcp",
+		Addr:                 pa.String(),
+		Timeout:              5 * time.Second,
+		AllowNativePasswords: true,
+	}
+	db, err := sql.Open("mysql", cfg.FormatDSN())
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if _, err := db.Exec(proxySQLFlushStatement); err != nil {
+		return fmt.Errorf("Error running %s on ProxySQL admin interface %s: %s", proxySQLFlushStatement, pa, err)
+	}
+	return nil
+}
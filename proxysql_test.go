@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestNewProxySQLAdmin(t *testing.T) {
+	dir := &Dir{Path: "/tmp/dummydir", Config: getConfig(map[string]string{"host": "db1"})}
+	if pa, err := NewProxySQLAdmin(dir); pa != nil || err != nil {
+		t.Errorf("Expected nil ProxySQLAdmin and no error without proxysql-admin option, instead found %+v, %v", pa, err)
+	}
+
+	values := map[string]string{
+		"proxysql-admin":    "",
+		"proxysql-user":     "radmin",
+		"proxysql-password": "hunter22",
+	}
+	dir.Config = getConfig(values)
+	if pa, err := NewProxySQLAdmin(dir); pa != nil || err != nil {
+		t.Errorf("Expected nil ProxySQLAdmin and no error with blank proxysql-admin, instead found %+v, %v", pa, err)
+	}
+
+	values["proxysql-admin"] = "proxy1.example.com"
+	dir.Config = getConfig(values)
+	pa, err := NewProxySQLAdmin(dir)
+	if err != nil {
+		t.Fatalf("Unexpected error from NewProxySQLAdmin: %s", err)
+	}
+	if pa.String() != "proxy1.example.com:6032" || pa.User != "radmin" || pa.Password != "hunter22" {
+		t.Errorf("Unexpected result from NewProxySQLAdmin: %+v", *pa)
+	}
+	if Redact("password hunter22") != "password "+RedactedValue {
+		t.Error("Expected proxysql-password to be registered as a secret, but it was not")
+	}
+
+	values["proxysql-admin"] = "[::1]:6033"
+	dir.Config = getConfig(values)
+	if pa, err := NewProxySQLAdmin(dir); err != nil || pa.String() != "[::1]:6033" {
+		t.Errorf("Unexpected result from NewProxySQLAdmin: %+v, %v", pa, err)
+	}
+	values["proxysql-admin"] = "proxy1:abc"
+	dir.Config = getConfig(values)
+	if _, err := NewProxySQLAdmin(dir); err == nil {
+		t.Error("Expected error from invalid proxysql-admin, but it was nil")
+	}
+}
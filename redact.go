@@ -0,0 +1,72 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// RedactedValue is substituted in place of secrets in all output.
+const RedactedValue = "*****"
+
+// MinSecretLength is the shortest secret that will be redacted. Shorter values
+// would cause excessive false-positive replacements throughout output.
+const MinSecretLength = 4
+
+// reDSNPassword matches the password portion of a go-sql-driver/mysql DSN.
+// Submatch [1] is the user and [2] is the protocol.
+var reDSNPassword = regexp.MustCompile(`([^\s:@/(]*):[^\s@/]*@(tcp|unix)\(`)
+
+// SecretRegistry tracks sensitive values, such as passwords, which must not
+// appear in any log message, error, or STDOUT output.
+type SecretRegistry struct {
+	values []string
+	*sync.RWMutex
+}
+
+// Secrets is the SecretRegistry for the current run.
+var Secrets = &SecretRegistry{RWMutex: new(sync.RWMutex)}
+
+// Add records secret as a value to redact. Blank values and values shorter
+// than MinSecretLength are ignored.
+func (sr *SecretRegistry) Add(secret string) {
+	if len(secret) < MinSecretLength {
+		return
+	}
+	sr.Lock()
+	defer sr.Unlock()
+	for _, existing := range sr.values {
+		if existing == secret {
+			return
+		}
+	}
+	// Keep longer secrets first, so that they are replaced before any shorter
+	// secret that they contain
+	pos := len(sr.values)
+	for n, existing := range sr.values {
+		if len(secret) > len(existing) {
+			pos = n
+			break
+		}
+	}
+	sr.values = append(sr.values, "")
+	copy(sr.values[pos+1:], sr.values[pos:])
+	sr.values[pos] = secret
+}
+
+// Redact returns s with all registered secrets, as well as any passwords
+// found in DSNs, replaced by RedactedValue.
+func (sr *SecretRegistry) Redact(s string) string {
+	s = reDSNPassword.ReplaceAllString(s, "${1}:"+RedactedValue+"@${2}(")
+	sr.RLock()
+	defer sr.RUnlock()
+	for _, secret := range sr.values {
+		s = strings.Replace(s, secret, RedactedValue, -1)
+	}
+	return s
+}
+
+// Redact returns s with all secrets registered in Secrets redacted.
+func Redact(s string) string {
+	return Secrets.Redact(s)
+}
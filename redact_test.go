@@ -0,0 +1,33 @@
+package main
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestSecretRegistryRedact(t *testing.T) {
+	sr := &SecretRegistry{RWMutex: new(sync.RWMutex)}
+	sr.Add("hunter2")
+	sr.Add("hunter2abc")
+	sr.Add("abc") // too short to register
+	sr.Add("")
+	sr.Add("hunter2") // duplicate
+
+	cases := map[string]string{
+		"no secrets here":                            "no secrets here",
+		"password is hunter2":                        "password is *****",
+		"password is hunter2abc":                     "password is *****",
+		"pt-osc --password=hunter2 --user=abc":       "pt-osc --password=***** --user=abc",
+		"DSN=root:s3cr3t@tcp(127.0.0.1:3306)/?foo=1": "DSN=root:*****@tcp(127.0.0.1:3306)/?foo=1",
+		"DSN=root:@unix(/tmp/mysql.sock)/":           "DSN=root:*****@unix(/tmp/mysql.sock)/",
+		"DSN=root@tcp(127.0.0.1:3306)/":              "DSN=root@tcp(127.0.0.1:3306)/",
+	}
+	for input, expected := range cases {
+		if actual := sr.Redact(input); actual != expected {
+			t.Errorf("Expected Redact(%q) to return %q, instead found %q", input, expected, actual)
+		}
+	}
+	if len(sr.values) != 2 || sr.values[0] != "hunter2abc" {
+		t.Errorf("Unexpected registered secrets: %v", sr.values)
+	}
+}
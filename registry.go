@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// SchemaPublication is the document published to a schema registry after a
+// successful push, describing the canonical definition of one schema in one
+// environment.
+type SchemaPublication struct {
+	Schema        string            `json:"schema"`
+	Environment   string            `json:"environment"`
+	GitRef        string            `json:"git_ref,omitempty"`
+	Timestamp     time.Time         `json:"timestamp"`
+	SkeemaVersion string            `json:"skeema_version"`
+	Tables        map[string]string `json:"tables"` // table name => CREATE TABLE statement
+}
+
+// NewSchemaPublication returns a SchemaPublication for the schema of t, as
+// defined by its dir. Tables matching ignore-table are omitted, since push does
+// not manage them.
+func NewSchemaPublication(t *Target, ignoreTable *regexp.Regexp) (*SchemaPublication, error) {
+	tables, err := t.SchemaFromDir.Tables()
+	if err != nil {
+		return nil, err
+	}
+	pub := &SchemaPublication{
+		Schema:        t.SchemaFromDir.Name,
+		Environment:   t.Dir.Config.Get("environment"),
+		GitRef:        CurrentGitRef(t.Dir),
+		Timestamp:     time.Now().UTC().Truncate(time.Second),
+		SkeemaVersion: version,
+		Tables:        make(map[string]string, len(tables)),
+	}
+	for _, table := range tables {
+		if ignoreTable == nil || !ignoreTable.MatchString(table.Name) {
+			pub.Tables[table.Name] = table.CreateStatement()
+		}
+	}
+	return pub, nil
+}
+
+// SchemaRegistry represents a destination for SchemaPublications: either an
+// HTTP(S) endpoint, which receives each publication via PUT, or an S3 location,
+// which is written using the AWS CLI.
+type SchemaRegistry struct {
+	URL  string // with variables already interpolated
+	Auth string // value for Authorization header; only used with HTTP(S)
+	dir  *Dir
+}
+
+var reRegistryVar = regexp.MustCompile(`{[A-Za-z]+}`)
+
+// NewSchemaRegistry returns the SchemaRegistry configured for the schema of t
+// via the schema-registry-url option, or nil if the option is not set. The
+// variables {SCHEMA}, {ENVIRONMENT}, and {DIRNAME} are interpolated in the URL.
+func NewSchemaRegistry(t *Target) (*SchemaRegistry, error) {
+	if _, ok := t.Dir.Config.CLI.Command.OptionValue("schema-registry-url"); !ok {
+		return nil, nil
+	}
+	rawURL := t.Dir.Config.Get("schema-registry-url")
+	if rawURL == "" {
+		return nil, nil
+	}
+	values := map[string]string{
+		"SCHEMA":      t.SchemaFromDir.Name,
+		"ENVIRONMENT": t.Dir.Config.Get("environment"),
+		"DIRNAME":     t.Dir.BaseName(),
+	}
+	var err error
+	interpolated := reRegistryVar.ReplaceAllStringFunc(rawURL, func(input string) string {
+		name := strings.ToUpper(input[1 : len(input)-1])
+		if value, ok := values[name]; ok {
+			return url.PathEscape(value)
+		}
+		err = fmt.Errorf("Unknown variable %s", input)
+		return input
+	})
+	if err != nil {
+		return nil, OptionError(t.Dir.Config, "schema-registry-url", err)
+	}
+	parsed, err := url.Parse(interpolated)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https" && parsed.Scheme != "s3") || parsed.Host == "" {
+		return nil, OptionError(t.Dir.Config, "schema-registry-url", fmt.Errorf("Option schema-registry-url must be an http://, https://, or s3:// URL; found \"%s\"", rawURL))
+	}
+	sr := &SchemaRegistry{
+		URL:  interpolated,
+		Auth: t.Dir.Config.Get("schema-registry-auth"),
+		dir:  t.Dir,
+	}
+	Secrets.Add(sr.Auth)
+	return sr, nil
+}
+
+// String returns the URL of the registry.
+func (sr *SchemaRegistry) String() string {
+	return sr.URL
+}
+
+// Publish sends pub to the registry as JSON.
+func (sr *SchemaRegistry) Publish(pub *SchemaPublication) error {
+	contents, err := json.MarshalIndent(pub, "", "  ")
+	if err != nil {
+		return err
+	}
+	if strings.HasPrefix(sr.URL, "s3://") {
+		return sr.publishS3(contents)
+	}
+	req, err := http.NewRequest("PUT", sr.URL, bytes.NewReader(contents))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if sr.Auth != "" {
+		req.Header.Set("Authorization", sr.Auth)
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("Schema registry %s returned %s: %s", sr, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// publishS3 writes contents to the registry's S3 location by shelling out to
+// the AWS CLI, which obtains credentials in its usual manner.
+func (sr *SchemaRegistry) publishS3(contents []byte) error {
+	f, err := ioutil.TempFile("", "skeema-registry")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(contents)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	extra := map[string]string{
+		"FILE": f.Name(),
+		"DEST": sr.URL,
+	}
+	s, err := NewInterpolatedShellOut("aws s3 cp --only-show-errors --content-type application/json {FILE} {DEST}", sr.dir, extra)
+	if err != nil {
+		return err
+	}
+	if err := s.Run(); err != nil {
+		return fmt.Errorf("Unable to copy schema to %s: %s", sr, err)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/skeema/tengo"
+)
+
+func TestSchemaRegistryPublish(t *testing.T) {
+	var received SchemaPublication
+	var receivedPath, receivedAuth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		receivedPath, receivedAuth = r.URL.Path, r.Header.Get("Authorization")
+		if r.Method != "PUT" || r.Header.Get("Content-Type") != "application/json" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if received.Schema == "broken" {
+			http.Error(w, "schema rejected", http.StatusConflict)
+		}
+	}))
+	defer server.Close()
+
+	values := map[string]string{
+		"environment":          "staging",
+		"schema-registry-url":  server.URL + "/schemas/{ENVIRONMENT}/{schema}.json",
+		"schema-registry-auth": "Bearer s3cr3t-token",
+	}
+	target := &Target{
+		Dir:           &Dir{Path: "/tmp/dummydir", Config: getConfig(values)},
+		SchemaFromDir: &tengo.Schema{Name: "my db"},
+	}
+	registry, err := NewSchemaRegistry(target)
+	if err != nil {
+		t.Fatalf("Unexpected error from NewSchemaRegistry: %s", err)
+	}
+	if expected := server.URL + "/schemas/staging/my%20db.json"; registry.URL != expected {
+		t.Errorf("Expected URL %s, instead found %s", expected, registry.URL)
+	}
+	pub := &SchemaPublication{
+		Schema:      "my db",
+		Environment: "staging",
+		Tables:      map[string]string{"foo": "CREATE TABLE `foo` (\n  `id` int(11) NOT NULL\n) ENGINE=InnoDB DEFAULT CHARSET=latin1"},
+	}
+	if err := registry.Publish(pub); err != nil {
+		t.Errorf("Unexpected error from Publish: %s", err)
+	}
+	if receivedPath != "/schemas/staging/my db.json" || receivedAuth != "Bearer s3cr3t-token" || received.Schema != "my db" || received.Tables["foo"] != pub.Tables["foo"] {
+		t.Errorf("Unexpected request received: path=%s auth=%s body=%+v", receivedPath, receivedAuth, received)
+	}
+	pub.Schema = "broken"
+	if err := registry.Publish(pub); err == nil {
+		t.Error("Expected error from Publish when registry returns an error status, but it was nil")
+	}
+
+	for _, badURL := range []string{"ftp://example.com/x", "/just/a/path", "https://example.com/{TABLE}"} {
+		values["schema-registry-url"] = badURL
+		target.Dir.Config = getConfig(values)
+		if _, err := NewSchemaRegistry(target); err == nil {
+			t.Errorf("Expected error from schema-registry-url %s, but it was nil", badURL)
+		}
+	}
+	values["schema-registry-url"] = ""
+	target.Dir.Config = getConfig(values)
+	if registry, err := NewSchemaRegistry(target); registry != nil || err != nil {
+		t.Errorf("Expected nil registry and no error with blank schema-registry-url, instead found %v, %v", registry, err)
+	}
+}
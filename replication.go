@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/skeema/tengo"
+)
+
+// reAddAutoIncColumn detects an ALTER TABLE clause adding a new auto_increment
+// column, which MySQL documents as potentially yielding different values on
+// replicas than on the master.
+var reAddAutoIncColumn = regexp.MustCompile(`ADD COLUMN [^,]* AUTO_INCREMENT`)
+
+// BinlogSettings represents the binary logging configuration of an instance,
+// which affects how some schema change methods interact with replication.
+type BinlogSettings struct {
+	Enabled  bool
+	Format   string // value of binlog_format, e.g. "ROW", "STATEMENT", "MIXED"
+	RowImage string // value of binlog_row_image; blank if not supported by server version
+}
+
+// GetBinlogSettings queries the supplied instance for its binary logging
+// configuration.
+func GetBinlogSettings(instance *tengo.Instance) (*BinlogSettings, error) {
+	db, err := instance.Connect("", "")
+	if err != nil {
+		return nil, err
+	}
+	var logBin int
+	bs := &BinlogSettings{}
+	if err := db.QueryRow("SELECT @@global.log_bin, @@global.binlog_format").Scan(&logBin, &bs.Format); err != nil {
+		return nil, err
+	}
+	bs.Enabled = (logBin == 1)
+	bs.Format = strings.ToUpper(bs.Format)
+
+	// binlog_row_image only exists in MySQL 5.6+, so ignore any error here
+	if err := db.QueryRow("SELECT @@global.binlog_row_image").Scan(&bs.RowImage); err == nil {
+		bs.RowImage = strings.ToUpper(bs.RowImage)
+	}
+	return bs, nil
+}
+
+// Findings returns a list of warnings describing how the supplied DDL
+// statement interacts badly with the binary logging configuration. An empty
+// result means no problems were detected. It is safe to call this method on
+// a nil BinlogSettings, which always yields no findings.
+func (bs *BinlogSettings) Findings(ddl *DDLStatement) []string {
+	if bs == nil || ddl == nil || !bs.Enabled {
+		return nil
+	}
+	var findings []string
+	if reAddAutoIncColumn.MatchString(ddl.stmt) {
+		findings = append(findings, "adding an AUTO_INCREMENT column to an existing table may assign different values on replicas than on the master")
+	}
+	if ddl.IsShellOut() && strings.Contains(ddl.shellOut.Command, "gh-ost") {
+		if bs.Format != "ROW" {
+			findings = append(findings, fmt.Sprintf("gh-ost requires binlog_format=ROW, but %s uses binlog_format=%s", ddl.instance, bs.Format))
+		}
+		if bs.RowImage != "" && bs.RowImage != "FULL" {
+			findings = append(findings, fmt.Sprintf("gh-ost requires binlog_row_image=FULL, but %s uses binlog_row_image=%s", ddl.instance, bs.RowImage))
+		}
+	}
+	return findings
+}
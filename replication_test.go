@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/skeema/tengo"
+)
+
+func TestBinlogSettingsFindings(t *testing.T) {
+	inst, err := tengo.NewInstance("mysql", "root@tcp(127.0.0.1:3306)/")
+	if err != nil {
+		t.Fatalf("Unable to create instance: %s", err)
+	}
+	addAutoInc := &DDLStatement{
+		stmt:     "ALTER TABLE `foo` ADD COLUMN `id` int(10) unsigned NOT NULL AUTO_INCREMENT, ADD PRIMARY KEY (`id`)",
+		instance: inst,
+	}
+	ghost := &DDLStatement{
+		stmt:     "ALTER TABLE `foo` ADD COLUMN `bar` int",
+		shellOut: NewShellOut("gh-ost --alter='ADD COLUMN `bar` int' --execute", ""),
+		instance: inst,
+	}
+
+	var nilSettings *BinlogSettings
+	if findings := nilSettings.Findings(ghost); len(findings) > 0 {
+		t.Errorf("Expected nil BinlogSettings to yield no findings, instead found %v", findings)
+	}
+
+	assertFindingCount := func(bs *BinlogSettings, ddl *DDLStatement, expected int) {
+		if findings := bs.Findings(ddl); len(findings) != expected {
+			t.Errorf("Expected %+v to yield %d findings for %s, instead found %d: %v", *bs, expected, ddl, len(findings), findings)
+		}
+	}
+	assertFindingCount(&BinlogSettings{Enabled: false, Format: "STATEMENT"}, ghost, 0)
+	assertFindingCount(&BinlogSettings{Enabled: true, Format: "ROW", RowImage: "FULL"}, ghost, 0)
+	assertFindingCount(&BinlogSettings{Enabled: true, Format: "MIXED", RowImage: "FULL"}, ghost, 1)
+	assertFindingCount(&BinlogSettings{Enabled: true, Format: "STATEMENT", RowImage: "MINIMAL"}, ghost, 2)
+	assertFindingCount(&BinlogSettings{Enabled: true, Format: "ROW"}, addAutoInc, 1)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// scheduleClockFormats are the accepted formats for a time of day supplied to
+// the at option.
+var scheduleClockFormats = []string{"15:04", "15:04:05"}
+
+// scheduleDateFormats are the accepted formats for an absolute date and time
+// supplied to the at option. Formats lacking a time zone are interpreted in
+// the local time zone.
+var scheduleDateFormats = []string{time.RFC3339, "2006-01-02 15:04", "2006-01-02 15:04:05", "2006-01-02T15:04", "2006-01-02T15:04:05"}
+
+// ParseScheduledTime converts a value of the at option to an absolute time.
+// A time of day, such as "02:00", refers to its next occurrence after now in
+// now's time zone. An absolute date and time must not be in the past.
+func ParseScheduledTime(value string, now time.Time) (time.Time, error) {
+	for _, format := range scheduleClockFormats {
+		if clock, err := time.Parse(format, value); err == nil {
+			at := time.Date(now.Year(), now.Month(), now.Day(), clock.Hour(), clock.Minute(), clock.Second(), 0, now.Location())
+			if !at.After(now) {
+				at = at.AddDate(0, 0, 1)
+			}
+			return at, nil
+		}
+	}
+	for _, format := range scheduleDateFormats {
+		if at, err := time.ParseInLocation(format, value, now.Location()); err == nil {
+			if !at.After(now) {
+				return at, fmt.Errorf("Option at refers to a time in the past: %s", value)
+			}
+			return at, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("Option at must be a time of day such as \"02:00\", or a date and time such as \"2018-05-01 02:00\" or RFC3339 format; found \"%s\"", value)
+}
+
+// waitForScheduledPush computes and displays the plan for dir, waits until at,
+// and then confirms the plan is still identical before returning true. If
+// there is nothing to do, it returns false and a nil error. Otherwise, if the
+// plan could not be computed, contains statements which would be skipped, or
+// has changed since it was displayed, it returns false and an error. No
+// changes are made to any database instance.
+func waitForScheduledPush(dir *Dir, workerCount int, at time.Time, filter *StatementFilter, deprecationDate time.Time) (bool, error) {
+	planned, err := scheduledPushTokens(dir, workerCount, false, filter, deprecationDate)
+	if err != nil {
+		return false, err
+	} else if len(planned) == 0 {
+		log.Info("No differences found; nothing to schedule")
+		return false, nil
+	}
+	var plural string
+	if len(planned) > 1 {
+		plural = "s"
+	}
+	log.Infof("Waiting until %s to apply %d statement%s", at.Format("2006-01-02 15:04:05 MST"), len(planned), plural)
+	time.Sleep(at.Sub(time.Now()))
+
+	log.Info("Verifying that plan has not changed")
+	actual, err := scheduledPushTokens(dir, workerCount, true, filter, deprecationDate)
+	if err != nil {
+		return false, err
+	}
+	if len(actual) != len(planned) {
+		return false, NewExitValue(CodeFatalError, "Drift detected: plan now contains %d statements instead of %d. No changes were applied; re-run skeema push to compute a new plan.", len(actual), len(planned))
+	}
+	for token := range planned {
+		if !actual[token] {
+			return false, NewExitValue(CodeFatalError, "Drift detected: an instance or *.sql file has changed since the plan was computed. No changes were applied; re-run skeema push to compute a new plan.")
+		}
+	}
+	return true, nil
+}
+
+// scheduledPushTokens performs a dry-run of push for dir, returning the set of
+// idempotency tokens of the generated statements. If silent is false, the
+// statements are also output to STDOUT as with `skeema diff`. Statements
+// excluded by filter are not included.
+func scheduledPushTokens(dir *Dir, workerCount int, silent bool, filter *StatementFilter, deprecationDate time.Time) (map[string]bool, error) {
+	sps := &sharedPushState{
+		targetGroups:    dir.TargetGroups(dir.Config.GetBool("first-only"), true),
+		dryRun:          true,
+		silent:          silent,
+		tokens:          make(map[string]bool),
+		filter:          filter,
+		deprecationDate: deprecationDate,
+		Mutex:           new(sync.Mutex),
+		WaitGroup:       new(sync.WaitGroup),
+	}
+	sps.run(workerCount)
+	if sps.fatalError != nil {
+		return nil, sps.fatalError
+	}
+	if skipCount := sps.errCount + sps.unsupportedCount; skipCount > 0 {
+		var plural string
+		if skipCount > 1 {
+			plural = "s"
+		}
+		return nil, NewExitValue(CodeFatalError, "Plan would skip %d operation%s due to unsupported features or error; not scheduling. See above output.", skipCount, plural)
+	}
+	return sps.tokens, nil
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseScheduledTime(t *testing.T) {
+	loc := time.FixedZone("TEST", -5*3600)
+	now := time.Date(2018, 5, 1, 14, 30, 0, 0, loc)
+	cases := map[string]time.Time{
+		"15:00":                     time.Date(2018, 5, 1, 15, 0, 0, 0, loc),
+		"02:00":                     time.Date(2018, 5, 2, 2, 0, 0, 0, loc),
+		"14:30":                     time.Date(2018, 5, 2, 14, 30, 0, 0, loc),
+		"14:30:01":                  time.Date(2018, 5, 1, 14, 30, 1, 0, loc),
+		"2018-05-03 01:15":          time.Date(2018, 5, 3, 1, 15, 0, 0, loc),
+		"2018-05-03T01:15:30":       time.Date(2018, 5, 3, 1, 15, 30, 0, loc),
+		"2018-05-01T23:00:00Z":      time.Date(2018, 5, 1, 18, 0, 0, 0, loc),
+		"2018-05-02T02:00:00-05:00": time.Date(2018, 5, 2, 2, 0, 0, 0, loc),
+	}
+	for input, expected := range cases {
+		actual, err := ParseScheduledTime(input, now)
+		if err != nil {
+			t.Errorf("Unexpected error parsing %q: %s", input, err)
+		} else if !actual.Equal(expected) {
+			t.Errorf("Expected %q to parse to %s, instead found %s", input, expected, actual)
+		}
+	}
+
+	for _, input := range []string{"", "2am", "25:00", "2018-05-01 02:00", "2018-04-30T23:00:00Z", "tomorrow"} {
+		if _, err := ParseScheduledTime(input, now); err == nil {
+			t.Errorf("Expected error parsing %q, but received none", input)
+		}
+	}
+}
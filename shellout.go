@@ -23,11 +23,12 @@ type ShellOut struct {
 	PrintableCommand string // Same as Command, but used in String() if non-empty; useful for hiding passwords in output
 }
 
+// String returns the printable form of the command, with any secrets redacted.
 func (s *ShellOut) String() string {
 	if s.PrintableCommand != "" {
-		return s.PrintableCommand
+		return Redact(s.PrintableCommand)
 	}
-	return s.Command
+	return Redact(s.Command)
 }
 
 // Run shells out to the external command and blocks until it completes. It
@@ -20,13 +20,14 @@ var CommandSuite = mybase.NewCommandSuite("skeema", version, rootDesc)
 func main() {
 	// Add global options. Sub-commands may override these when needed.
 	AddGlobalOptions(CommandSuite)
+	HandleSignals()
 
 	var cfg *mybase.Config
 
 	defer func() {
 		if err := recover(); err != nil {
 			if cfg == nil || !cfg.GetBool("debug") {
-				Exit(NewExitValue(CodeFatalError, fmt.Sprint(err)))
+				Exit(NewExitValue(CodeFatalError, "%s", fmt.Sprint(err)))
 			} else {
 				log.Error(err)
 				log.Debug(string(debug.Stack()))
@@ -37,8 +38,8 @@ func main() {
 
 	cfg, err := mybase.ParseCLI(CommandSuite, os.Args)
 	if err != nil {
-		Exit(NewExitValue(CodeBadConfig, err.Error()))
+		Exit(NewExitValue(CodeBadConfig, "%s", err.Error()))
 	}
 
-	Exit(cfg.HandleCommand())
+	Exit(AdjustExitValue(cfg.HandleCommand(), cfg))
 }
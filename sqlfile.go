@@ -1,30 +1,51 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/skeema/tengo"
 )
 
 // Regexp for parsing CREATE TABLE statements. Submatches:
 // [1] is any text preceeding the CREATE TABLE -- we ignore this
-// [2] is the table name -- note we do not allow whitespace even if backtick-escaped
+// [2] is the table name -- if backtick-quoted, it may contain whitespace or doubled backticks; see unquoteIdentifier
 // [3] is the table body -- later we scan this for disallowed things
 // [4] is any text after the table body -- we ignore this
-var reParseCreate = regexp.MustCompile(`(?is)^(.*)\s*create\s+table\s+(?:if\s+not\s+exists\s+)?` + "`?([^\\s`]+)`?" + `\s+([^;]+);?\s*(.*)$`)
+var reParseCreate = regexp.MustCompile(`(?is)^(.*)\s*create\s+table\s+(?:if\s+not\s+exists\s+)?` + "(`(?:[^`]|``)+`|[^\\s`]+)" + `\s+([^;]+);?\s*(.*)$`)
 
 // We disallow CREATE TABLE SELECT and CREATE TABLE LIKE expressions
 var reBodyDisallowed = regexp.MustCompile(`(?i)^(as\s+select|select|like|[(]\s+like)`)
 
+// Regexp for parsing include directives, which are single-line comments
+// referencing a .sqlpart file whose contents are substituted in place of the
+// directive. Submatch [1] is the referenced file name.
+var reIncludeDirective = regexp.MustCompile(`(?m)^[ \t]*--[ \t]*skeema:include[ \t]+(\S+)[ \t]*$`)
+
 // MaxSQLFileSize specifies the largest SQL file that is considered valid;
 // we assume legit CREATE TABLE statements should always be under 16KB.
 const MaxSQLFileSize = 16 * 1024
 
+// MaxCombinedSQLFileSize specifies the largest single-file layout file that is
+// considered valid. Each statement within it is still subject to
+// MaxSQLFileSize.
+const MaxCombinedSQLFileSize = 1024 * MaxSQLFileSize
+
+// SQLFileReadTimeout specifies how long to wait for a single SQL file read to
+// complete, so that an unresponsive network mount cannot block a run forever.
+const SQLFileReadTimeout = 10 * time.Second
+
+// SingleFileName is the name of the file containing all table definitions for
+// a dir using the single-file layout.
+const SingleFileName = "schema.sql"
+
 // IsSQLFile returns true if the supplied os.FileInfo has a .sql extension and
 // is a regular file. It is the caller's responsibility to resolve symlinks
 // prior to passing them to this function.
@@ -38,6 +59,74 @@ func IsSQLFile(fi os.FileInfo) bool {
 	return true
 }
 
+// unquoteIdentifier strips the backticks from a backtick-quoted identifier,
+// and unescapes any doubled backticks within it. Unquoted identifiers are
+// returned as-is.
+func unquoteIdentifier(ident string) string {
+	if len(ident) < 2 || ident[0] != '`' || ident[len(ident)-1] != '`' {
+		return ident
+	}
+	return strings.Replace(ident[1:len(ident)-1], "``", "`", -1)
+}
+
+// IdentifierFileName returns a name suitable for a file or directory
+// representing the supplied schema or table name, according to policy. The
+// "unicode" policy only escapes characters which cannot be used in filenames.
+// The "ascii" policy also escapes all non-ASCII characters, as well as those
+// which are problematic on Windows or macOS filesystems. The "transliterate"
+// policy is like "ascii", but first replaces accented Latin letters with their
+// unaccented equivalents. Escaped characters are represented as @ followed by 4 or more lowercase hex
+// digits of their Unicode code point, mirroring MySQL's own filename encoding.
+// If isDir is true, a leading dot is escaped as well, since hidden dirs are
+// always skipped. Since the table name is always obtained from file contents,
+// rather than the filename, this encoding never needs to be reversed.
+func IdentifierFileName(name, policy string, isDir bool) string {
+	var b bytes.Buffer
+	for n, r := range name {
+		if policy == "transliterate" {
+			if replacement, ok := transliterations[r]; ok {
+				b.WriteString(replacement)
+				continue
+			}
+		}
+		escape := r < 0x20 || r == 0x7f || r == '/' || r == '\\' || (isDir && n == 0 && r == '.')
+		if policy != "unicode" && (r >= 0x80 || strings.ContainsRune(`:*?"<>|`, r)) {
+			escape = true
+		}
+		if escape {
+			fmt.Fprintf(&b, "@%04x", r)
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// transliterations maps accented Latin letters to unaccented ASCII
+// equivalents, for use by the "transliterate" filename-policy. Characters not
+// listed here are escaped instead.
+var transliterations = map[rune]string{
+	'À': "A", 'Á': "A", 'Â': "A", 'Ã': "A", 'Ä': "A", 'Å': "A", 'Æ': "AE", 'Ç': "C",
+	'È': "E", 'É': "E", 'Ê': "E", 'Ë': "E", 'Ì': "I", 'Í': "I", 'Î': "I", 'Ï': "I",
+	'Ð': "D", 'Ñ': "N", 'Ò': "O", 'Ó': "O", 'Ô': "O", 'Õ': "O", 'Ö': "O", 'Ø': "O",
+	'Ù': "U", 'Ú': "U", 'Û': "U", 'Ü': "U", 'Ý': "Y", 'Þ': "TH", 'ß': "ss",
+	'à': "a", 'á': "a", 'â': "a", 'ã': "a", 'ä': "a", 'å': "a", 'æ': "ae", 'ç': "c",
+	'è': "e", 'é': "e", 'ê': "e", 'ë': "e", 'ì': "i", 'í': "i", 'î': "i", 'ï': "i",
+	'ð': "d", 'ñ': "n", 'ò': "o", 'ó': "o", 'ô': "o", 'õ': "o", 'ö': "o", 'ø': "o",
+	'ù': "u", 'ú': "u", 'û': "u", 'ü': "u", 'ý': "y", 'þ': "th", 'ÿ': "y",
+	'Ā': "A", 'ā': "a", 'Ă': "A", 'ă': "a", 'Ą': "A", 'ą': "a", 'Ć': "C", 'ć': "c",
+	'Č': "C", 'č': "c", 'Ď': "D", 'ď': "d", 'Đ': "D", 'đ': "d", 'Ē': "E", 'ē': "e",
+	'Ė': "E", 'ė': "e", 'Ę': "E", 'ę': "e", 'Ě': "E", 'ě': "e", 'Ğ': "G", 'ğ': "g",
+	'Ī': "I", 'ī': "i", 'Į': "I", 'į': "i", 'İ': "I", 'ı': "i", 'Ķ': "K", 'ķ': "k",
+	'Ĺ': "L", 'ĺ': "l", 'Ļ': "L", 'ļ': "l", 'Ľ': "L", 'ľ': "l", 'Ł': "L", 'ł': "l",
+	'Ń': "N", 'ń': "n", 'Ņ': "N", 'ņ': "n", 'Ň': "N", 'ň': "n", 'Ō': "O", 'ō': "o",
+	'Ő': "O", 'ő': "o", 'Œ': "OE", 'œ': "oe", 'Ŕ': "R", 'ŕ': "r", 'Ř': "R", 'ř': "r",
+	'Ś': "S", 'ś': "s", 'Ş': "S", 'ş': "s", 'Š': "S", 'š': "s", 'Ţ': "T", 'ţ': "t",
+	'Ť': "T", 'ť': "t", 'Ū': "U", 'ū': "u", 'Ů': "U", 'ů': "u", 'Ű': "U", 'ű': "u",
+	'Ų': "U", 'ų': "u", 'Ÿ': "Y", 'Ź': "Z", 'ź': "z", 'Ż': "Z", 'ż': "z", 'Ž': "Z",
+	'ž': "z",
+}
+
 // SQLFile represents a file containing a CREATE TABLE statement.
 type SQLFile struct {
 	Dir      *Dir
@@ -45,6 +134,9 @@ type SQLFile struct {
 	Contents string
 	Error    error
 	Warnings []error
+	Includes []string // file names of any .sqlpart files included by this file
+	Table    string   // name of the table defined, once contents have been validated
+	combined bool     // true if this is one statement from a single-file layout file
 }
 
 // Path returns the full absolute path to a SQLFile.
@@ -56,12 +148,15 @@ func (sf *SQLFile) Path() string {
 // sf.Contents. If the contents were valid, they will be returned; if not,
 // a blank string and an error will be returned.
 func (sf *SQLFile) Read() (string, error) {
-	byteContents, err := ioutil.ReadFile(sf.Path())
+	contents, err := readLimited(sf.Path(), MaxSQLFileSize)
 	if err != nil {
-		sf.Error = fmt.Errorf("%s: Error reading file: %s", sf.Path(), err)
+		sf.Error = fmt.Errorf("%s: %s", sf.Path(), err)
+		return "", sf.Error
+	}
+	sf.Contents = contents
+	if sf.expandIncludes() != nil {
 		return "", sf.Error
 	}
-	sf.Contents = string(byteContents)
 	if sf.validateContents() != nil {
 		return "", sf.Error
 	}
@@ -90,6 +185,40 @@ func (sf *SQLFile) Delete() error {
 	return os.Remove(sf.Path())
 }
 
+// expandIncludes replaces any include directives in sf.Contents with the
+// contents of the referenced .sqlpart file. Referenced files are located
+// relative to sf's directory, and may not themselves contain include
+// directives.
+func (sf *SQLFile) expandIncludes() error {
+	var expandErr error
+	sf.Includes = nil
+	sf.Contents = reIncludeDirective.ReplaceAllStringFunc(sf.Contents, func(directive string) string {
+		if expandErr != nil {
+			return directive
+		}
+		fileName := reIncludeDirective.FindStringSubmatch(directive)[1]
+		if !strings.HasSuffix(fileName, ".sqlpart") {
+			expandErr = fmt.Errorf("%s: included file %s does not end in .sqlpart extension", sf.Path(), fileName)
+			return directive
+		}
+		partPath := path.Join(sf.Dir.Path, fileName)
+		contents, err := readLimited(partPath, MaxSQLFileSize)
+		if err != nil {
+			expandErr = fmt.Errorf("%s: included file %s: %s", sf.Path(), fileName, err)
+			return directive
+		}
+		part := strings.TrimRight(contents, "\n")
+		if reIncludeDirective.MatchString(part) {
+			expandErr = fmt.Errorf("%s: included file %s may not itself contain include directives", sf.Path(), fileName)
+			return directive
+		}
+		sf.Includes = append(sf.Includes, fileName)
+		return part
+	})
+	sf.Error = expandErr
+	return expandErr
+}
+
 // ValidateContents sanity-checks, and normalizes, the value of sf.Contents.
 // It is the caller's responsibility to populate sf.Contents prior to calling
 // this method.
@@ -108,8 +237,9 @@ func (sf *SQLFile) validateContents() error {
 		warning := fmt.Errorf("%s: ignoring %d chars before CREATE TABLE and %d chars after CREATE TABLE", sf.Path(), len(matches[1]), len(matches[4]))
 		sf.Warnings = append(sf.Warnings, warning)
 	}
-	if sf.FileName != fmt.Sprintf("%s.sql", matches[2]) {
-		warning := fmt.Errorf("%s: filename does not match table name of %s", sf.Path(), matches[2])
+	tableName := unquoteIdentifier(matches[2])
+	if !sf.combined && sf.FileName != sf.Dir.TableFileName(tableName) {
+		warning := fmt.Errorf("%s: filename does not match table name of %s", sf.Path(), tableName)
 		sf.Warnings = append(sf.Warnings, warning)
 	}
 	if reBodyDisallowed.MatchString(matches[3]) {
@@ -117,6 +247,170 @@ func (sf *SQLFile) validateContents() error {
 		return sf.Error
 	}
 
-	sf.Contents = fmt.Sprintf("CREATE TABLE %s %s", tengo.EscapeIdentifier(matches[2]), matches[3])
+	sf.Table = tableName
+	sf.Contents = fmt.Sprintf("CREATE TABLE %s %s", tengo.EscapeIdentifier(tableName), matches[3])
 	return nil
 }
+
+// ReadCombinedSQLFile reads the single-file layout file of dir, returning one
+// SQLFile per statement. If the file does not exist, an empty slice is
+// returned. Per-statement problems are tracked within each SQLFile; if the file
+// as a whole cannot be read, a single SQLFile with an Error is returned.
+func ReadCombinedSQLFile(dir *Dir) ([]*SQLFile, error) {
+	whole := &SQLFile{
+		Dir:      dir,
+		FileName: SingleFileName,
+	}
+	if _, err := os.Stat(whole.Path()); os.IsNotExist(err) {
+		return []*SQLFile{}, nil
+	}
+	contents, err := readLimited(whole.Path(), MaxCombinedSQLFileSize)
+	if err != nil {
+		whole.Error = fmt.Errorf("%s: %s", whole.Path(), err)
+		return []*SQLFile{whole}, nil
+	}
+	whole.Contents = contents
+	if whole.expandIncludes() != nil {
+		return []*SQLFile{whole}, nil
+	}
+	stmts := splitStatements(whole.Contents)
+	result := make([]*SQLFile, 0, len(stmts))
+	for _, stmt := range stmts {
+		sf := &SQLFile{
+			Dir:      dir,
+			FileName: SingleFileName,
+			Contents: stmt,
+			Includes: whole.Includes,
+			combined: true,
+		}
+		sf.validateContents()
+		result = append(result, sf)
+	}
+	return result, nil
+}
+
+// RewriteCombinedSQLFile updates the single-file layout file of dir, replacing
+// the statement for the named table with createStmt, or appending createStmt
+// if the table is not yet present. If createStmt is blank, the table's
+// statement is removed instead. Other statements are preserved as-is. The path
+// of the file and its new length are returned.
+func RewriteCombinedSQLFile(dir *Dir, tableName, createStmt string) (string, int, error) {
+	filePath := path.Join(dir.Path, SingleFileName)
+	byteContents, err := ioutil.ReadFile(filePath)
+	if err != nil && !os.IsNotExist(err) {
+		return filePath, 0, err
+	}
+	if reIncludeDirective.Match(byteContents) {
+		return filePath, 0, fmt.Errorf("%s uses include directives, and must be updated manually", filePath)
+	}
+	var stmts []string
+	var found bool
+	for _, stmt := range splitStatements(string(byteContents)) {
+		if matches := reParseCreate.FindStringSubmatch(stmt); matches != nil && unquoteIdentifier(matches[2]) == tableName {
+			found = true
+			if createStmt != "" {
+				stmts = append(stmts, createStmt)
+			}
+			continue
+		}
+		stmts = append(stmts, stmt)
+	}
+	if !found {
+		if createStmt == "" {
+			return filePath, 0, fmt.Errorf("%s: no definition found for table %s", filePath, tableName)
+		}
+		stmts = append(stmts, createStmt)
+	}
+	if len(stmts) == 0 {
+		return filePath, 0, os.Remove(filePath)
+	}
+	value := fmt.Sprintf("%s;\n", strings.Join(stmts, ";\n\n"))
+	if err := ioutil.WriteFile(filePath, []byte(value), 0666); err != nil {
+		return filePath, 0, err
+	}
+	return filePath, len(value), nil
+}
+
+// splitStatements splits contents into individual statements, delimited by
+// semicolons that are not inside quotes or comments. The returned statements
+// are trimmed and do not include their delimiter; any that consist solely of
+// whitespace and comments are omitted.
+func splitStatements(contents string) []string {
+	var result []string
+	var start int
+	var inQuote byte
+	var hasCode bool
+	for n := 0; n < len(contents); n++ {
+		c := contents[n]
+		switch {
+		case inQuote != 0:
+			if c == '\\' && inQuote != '`' {
+				n++
+			} else if c == inQuote {
+				inQuote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			inQuote = c
+			hasCode = true
+		case c == '#' || (c == '-' && strings.HasPrefix(contents[n:], "-- ")):
+			if end := strings.IndexByte(contents[n:], '\n'); end > -1 {
+				n += end
+			} else {
+				n = len(contents)
+			}
+		case c == '/' && strings.HasPrefix(contents[n:], "/*"):
+			if end := strings.Index(contents[n+2:], "*/"); end > -1 {
+				n += end + 3
+			} else {
+				n = len(contents)
+			}
+		case c == ';':
+			if hasCode {
+				result = append(result, strings.TrimSpace(contents[start:n]))
+			}
+			start, hasCode = n+1, false
+		case c != ' ' && c != '\t' && c != '\n' && c != '\r':
+			hasCode = true
+		}
+	}
+	if hasCode && start < len(contents) {
+		result = append(result, strings.TrimSpace(contents[start:]))
+	}
+	return result
+}
+
+// readLimited returns the contents of the file at filePath, guarding against
+// pathological inputs such as a database dump accidentally placed in a schema
+// dir. An error is returned if the file exceeds maxSize bytes, appears to be a
+// binary file, or cannot be read within SQLFileReadTimeout.
+func readLimited(filePath string, maxSize int64) (string, error) {
+	var contents []byte
+	var size int64
+	err := runWithTimeout(SQLFileReadTimeout, func() error {
+		f, err := os.Open(filePath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		fi, err := f.Stat()
+		if err != nil {
+			return err
+		} else if size = fi.Size(); size > maxSize {
+			return nil
+		}
+		// The file may have grown since the Stat call, so limit the read as well
+		contents, err = ioutil.ReadAll(io.LimitReader(f, maxSize+1))
+		size = int64(len(contents))
+		return err
+	})
+	if err == errFilesystemTimeout {
+		return "", fmt.Errorf("Error reading file: no response after %s", SQLFileReadTimeout)
+	} else if err != nil {
+		return "", fmt.Errorf("Error reading file: %s", err)
+	} else if size > maxSize {
+		return "", fmt.Errorf("file is too large; size of %d bytes exceeds max of %d bytes", size, maxSize)
+	} else if bytes.IndexByte(contents, 0) > -1 {
+		return "", fmt.Errorf("file appears to be binary, not SQL")
+	}
+	return string(contents), nil
+}
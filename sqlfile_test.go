@@ -0,0 +1,227 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/skeema/tengo"
+)
+
+func TestSQLFileExpandIncludes(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "skeematest")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(tempDir)
+	dir := &Dir{Path: tempDir}
+	writeFile := func(name, contents string) {
+		if err := ioutil.WriteFile(path.Join(tempDir, name), []byte(contents), 0666); err != nil {
+			t.Fatalf("Unable to write %s: %s", name, err)
+		}
+	}
+	writeFile("audit.sqlpart", "  created_at timestamp NOT NULL,\n  updated_at timestamp NOT NULL,\n")
+	writeFile("nested.sqlpart", "  -- skeema:include audit.sqlpart\n")
+	writeFile("good.sql", "CREATE TABLE good (\n  id int NOT NULL,\n  -- skeema:include audit.sqlpart\n  PRIMARY KEY (id)\n);\n")
+	writeFile("missing.sql", "CREATE TABLE missing (\n  id int NOT NULL,\n  -- skeema:include nope.sqlpart\n  PRIMARY KEY (id)\n);\n")
+	writeFile("badext.sql", "CREATE TABLE badext (\n  id int NOT NULL,\n  -- skeema:include good.sql\n  PRIMARY KEY (id)\n);\n")
+	writeFile("nested.sql", "CREATE TABLE nested (\n  id int NOT NULL,\n  -- skeema:include nested.sqlpart\n  PRIMARY KEY (id)\n);\n")
+
+	sf := &SQLFile{Dir: dir, FileName: "good.sql"}
+	contents, err := sf.Read()
+	if err != nil {
+		t.Fatalf("Unexpected error reading %s: %s", sf.FileName, err)
+	}
+	expected := "CREATE TABLE `good` (\n  id int NOT NULL,\n  created_at timestamp NOT NULL,\n  updated_at timestamp NOT NULL,\n  PRIMARY KEY (id)\n)"
+	if contents != expected {
+		t.Errorf("Unexpected contents after expanding includes: %q", contents)
+	}
+	if len(sf.Includes) != 1 || sf.Includes[0] != "audit.sqlpart" {
+		t.Errorf("Unexpected value for Includes: %v", sf.Includes)
+	}
+
+	for _, fileName := range []string{"missing.sql", "badext.sql", "nested.sql"} {
+		sf := &SQLFile{Dir: dir, FileName: fileName}
+		if _, err := sf.Read(); err == nil {
+			t.Errorf("Expected %s to return an error, but it did not", fileName)
+		}
+	}
+}
+
+func TestSQLFileReadLimits(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "skeematest")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(tempDir)
+	dir := &Dir{Path: tempDir}
+	writeFile := func(name, contents string) {
+		if err := ioutil.WriteFile(path.Join(tempDir, name), []byte(contents), 0666); err != nil {
+			t.Fatalf("Unable to write %s: %s", name, err)
+		}
+	}
+	huge := "CREATE TABLE huge (\n" + strings.Repeat("  -- padding\n", MaxSQLFileSize) + "  id int\n);\n"
+	writeFile("huge.sql", huge)
+	writeFile("binary.sql", "CREATE TABLE bin\x00\x01\x02 (id int);\n")
+	writeFile("hugepart.sqlpart", huge)
+	writeFile("includes.sql", "CREATE TABLE includes (\n  -- skeema:include hugepart.sqlpart\n  id int\n);\n")
+
+	expectErrs := map[string]string{
+		"huge.sql":     "file is too large",
+		"binary.sql":   "appears to be binary",
+		"includes.sql": "file is too large",
+		"missing.sql":  "Error reading file",
+	}
+	for fileName, expected := range expectErrs {
+		sf := &SQLFile{Dir: dir, FileName: fileName}
+		if _, err := sf.Read(); err == nil {
+			t.Errorf("Expected %s to return an error, but it did not", fileName)
+		} else if !strings.Contains(err.Error(), expected) || !strings.HasPrefix(err.Error(), sf.Path()) {
+			t.Errorf("Unexpected error message for %s: %s", fileName, err)
+		} else if sf.Error != err {
+			t.Errorf("Expected %s to track its error in sf.Error", fileName)
+		}
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	contents := "-- leading comment; with semicolon\nCREATE TABLE a (id int COMMENT 'x;y');\n\n/* block; comment */\nCREATE TABLE `b` (name varchar(10) DEFAULT \"it\\\"s;\");\n# trailing comment;\n"
+	stmts := splitStatements(contents)
+	expected := []string{
+		"-- leading comment; with semicolon\nCREATE TABLE a (id int COMMENT 'x;y')",
+		"/* block; comment */\nCREATE TABLE `b` (name varchar(10) DEFAULT \"it\\\"s;\")",
+	}
+	if len(stmts) != len(expected) {
+		t.Fatalf("Expected %d statements, instead found %d: %q", len(expected), len(stmts), stmts)
+	}
+	for n := range expected {
+		if stmts[n] != expected[n] {
+			t.Errorf("Statement %d: expected %q, instead found %q", n, expected[n], stmts[n])
+		}
+	}
+}
+
+func TestCombinedSQLFile(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "skeematest")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(tempDir)
+	dir := &Dir{Path: tempDir}
+
+	if sqlFiles, err := ReadCombinedSQLFile(dir); err != nil || len(sqlFiles) != 0 {
+		t.Errorf("Expected no files and no error for missing combined file, instead found %v, %v", sqlFiles, err)
+	}
+	if _, _, err := RewriteCombinedSQLFile(dir, "a", "CREATE TABLE `a` (id int)"); err != nil {
+		t.Fatalf("Unexpected error from RewriteCombinedSQLFile: %s", err)
+	}
+	if _, _, err := RewriteCombinedSQLFile(dir, "b", "CREATE TABLE `b` (id int)"); err != nil {
+		t.Fatalf("Unexpected error from RewriteCombinedSQLFile: %s", err)
+	}
+	if _, _, err := RewriteCombinedSQLFile(dir, "a", "CREATE TABLE `a` (id bigint)"); err != nil {
+		t.Fatalf("Unexpected error from RewriteCombinedSQLFile: %s", err)
+	}
+	sqlFiles, err := ReadCombinedSQLFile(dir)
+	if err != nil || len(sqlFiles) != 2 {
+		t.Fatalf("Expected 2 statements and no error, instead found %d, %v", len(sqlFiles), err)
+	}
+	if sqlFiles[0].Table != "a" || sqlFiles[0].Contents != "CREATE TABLE `a` (id bigint)" || sqlFiles[1].Table != "b" {
+		t.Errorf("Unexpected results from ReadCombinedSQLFile: %+v, %+v", *sqlFiles[0], *sqlFiles[1])
+	}
+	for _, sf := range sqlFiles {
+		if sf.Error != nil || len(sf.Warnings) > 0 {
+			t.Errorf("Unexpected error or warnings for table %s: %v, %v", sf.Table, sf.Error, sf.Warnings)
+		}
+	}
+
+	if _, _, err := RewriteCombinedSQLFile(dir, "a", ""); err != nil {
+		t.Fatalf("Unexpected error removing table: %s", err)
+	}
+	if _, _, err := RewriteCombinedSQLFile(dir, "missing", ""); err == nil {
+		t.Error("Expected error removing nonexistent table, but it was nil")
+	}
+	if _, _, err := RewriteCombinedSQLFile(dir, "b", ""); err != nil {
+		t.Fatalf("Unexpected error removing table: %s", err)
+	}
+	if _, err := os.Stat(path.Join(tempDir, SingleFileName)); !os.IsNotExist(err) {
+		t.Errorf("Expected combined file to be removed once empty, but it still exists")
+	}
+}
+
+func TestIdentifierFileName(t *testing.T) {
+	cases := []struct {
+		name     string
+		policy   string
+		isDir    bool
+		expected string
+	}{
+		{"plain_name", "unicode", false, "plain_name"},
+		{"café", "unicode", false, "café"},
+		{"café", "ascii", false, "caf@00e9"},
+		{"café", "transliterate", false, "cafe"},
+		{"Straße 東京", "transliterate", false, "Strasse @6771@4eac"},
+		{"a/b\\c", "unicode", false, "a@002fb@005cc"},
+		{"what?", "unicode", false, "what?"},
+		{"what?", "ascii", false, "what@003f"},
+		{".hidden", "unicode", false, ".hidden"},
+		{".hidden", "unicode", true, "@002ehidden"},
+		{"tab\there", "unicode", false, "tab@0009here"},
+		{"emoji😀", "ascii", false, "emoji@1f600"},
+	}
+	for _, c := range cases {
+		if actual := IdentifierFileName(c.name, c.policy, c.isDir); actual != c.expected {
+			t.Errorf("Expected IdentifierFileName(%q, %q, %t) to return %q, instead found %q", c.name, c.policy, c.isDir, c.expected, actual)
+		}
+	}
+}
+
+func TestSQLFileIdentifiers(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "skeematest")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	for _, policy := range []string{"unicode", "ascii", "transliterate"} {
+		dir := &Dir{
+			Path:   tempDir,
+			Config: getConfig(map[string]string{"layout": "per-table", "filename-policy": policy}),
+		}
+		for _, tableName := range []string{"café", "my table", "we`re", "a/b", "東京"} {
+			createStmt := fmt.Sprintf("CREATE TABLE %s (\n  id int\n)", tengo.EscapeIdentifier(tableName))
+			filePath, _, err := dir.WriteTable(tableName, createStmt)
+			if err != nil {
+				t.Errorf("policy %s: Unexpected error writing table %q: %s", policy, tableName, err)
+				continue
+			}
+			if path.Dir(filePath) != tempDir {
+				t.Errorf("policy %s: Expected table %q to be written to %s, instead found %s", policy, tableName, tempDir, filePath)
+			}
+			sf, err := dir.TableSQLFile(tableName)
+			if err != nil {
+				t.Errorf("policy %s: Unexpected error reading table %q: %s", policy, tableName, err)
+			} else if sf.Table != tableName || sf.Contents != createStmt || len(sf.Warnings) > 0 {
+				t.Errorf("policy %s: Table %q did not round-trip: %+v", policy, tableName, *sf)
+			}
+			if _, err := dir.DeleteTable(tableName); err != nil {
+				t.Errorf("policy %s: Unexpected error deleting table %q: %s", policy, tableName, err)
+			}
+		}
+	}
+
+	// Names which collide under the transliterate policy should not clobber each
+	// other's files
+	dir := &Dir{
+		Path:   tempDir,
+		Config: getConfig(map[string]string{"layout": "per-table", "filename-policy": "transliterate"}),
+	}
+	if _, _, err := dir.WriteTable("café", "CREATE TABLE `café` (\n  id int\n)"); err != nil {
+		t.Fatalf("Unexpected error writing table: %s", err)
+	}
+	if _, _, err := dir.WriteTable("cafe", "CREATE TABLE `cafe` (\n  id int\n)"); err == nil {
+		t.Error("Expected error writing table with colliding filename, but it was nil")
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/skeema/mybase"
+)
+
+// PushState records the most recent successful push to each environment. It
+// is persisted as JSON in the file configured by the state-file option, which
+// is intended to be committed to the repo alongside the table files.
+type PushState struct {
+	Environments map[string]*PushRecord `json:"environments"`
+}
+
+// PushRecord describes a single successful push.
+type PushRecord struct {
+	GitRef        string    `json:"git_ref,omitempty"`
+	Timestamp     time.Time `json:"timestamp"`
+	Statements    int       `json:"statements"`
+	SkeemaVersion string    `json:"skeema_version"`
+}
+
+// StateFilePath returns the path of the state file configured for dir, or a
+// blank string if the state-file option is not set. Relative paths are
+// interpreted relative to the directory of the option file that sets the
+// option, so that the same file is used regardless of which subdirectory
+// Skeema is run from. Relative paths from other sources, such as the command
+// line, are interpreted relative to dir.
+func StateFilePath(dir *Dir) string {
+	statePath := dir.Config.Get("state-file")
+	if statePath == "" {
+		return ""
+	}
+	if strings.HasPrefix(statePath, "~/") {
+		statePath = path.Join(os.Getenv("HOME"), statePath[2:])
+	} else if !filepath.IsAbs(statePath) {
+		base := dir.Path
+		if f, ok := dir.Config.Source("state-file").(*mybase.File); ok {
+			base = f.Dir
+		}
+		statePath = path.Join(base, statePath)
+	}
+	return statePath
+}
+
+// ReadPushState reads the state file at statePath. If the file does not exist,
+// an empty PushState is returned.
+func ReadPushState(statePath string) (*PushState, error) {
+	state := &PushState{Environments: make(map[string]*PushRecord)}
+	contents, err := ioutil.ReadFile(statePath)
+	if os.IsNotExist(err) {
+		return state, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(contents, state); err != nil {
+		return nil, fmt.Errorf("Unable to parse state file %s: %s", statePath, err)
+	}
+	if state.Environments == nil {
+		state.Environments = make(map[string]*PushRecord)
+	}
+	return state, nil
+}
+
+// Write persists the state to statePath. The file is replaced atomically, so
+// that an interrupted write cannot leave it truncated.
+func (state *PushState) Write(statePath string) error {
+	contents, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	tempPath := fmt.Sprintf("%s.tmp%d", statePath, os.Getpid())
+	if err := ioutil.WriteFile(tempPath, append(contents, '\n'), 0666); err != nil {
+		return err
+	}
+	return os.Rename(tempPath, statePath)
+}
+
+// RecordPush updates the state file configured for dir, if any, to note a
+// successful push of the supplied number of statements to dir's environment.
+func RecordPush(dir *Dir, statementCount int) error {
+	statePath := StateFilePath(dir)
+	if statePath == "" {
+		return nil
+	}
+	state, err := ReadPushState(statePath)
+	if err != nil {
+		return err
+	}
+	state.Environments[dir.section] = &PushRecord{
+		GitRef:        CurrentGitRef(dir),
+		Timestamp:     time.Now().UTC().Truncate(time.Second),
+		Statements:    statementCount,
+		SkeemaVersion: version,
+	}
+	return state.Write(statePath)
+}
+
+// CurrentGitRef returns the commit hash checked out in dir's git working
+// tree, or a blank string if dir is not in a git working tree.
+func CurrentGitRef(dir *Dir) string {
+	s, err := NewInterpolatedShellOut("git -C {DIRPATH} rev-parse HEAD 2>/dev/null", dir, nil)
+	if err != nil {
+		return ""
+	}
+	ref, err := s.RunCapture()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(ref)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/skeema/mybase"
+)
+
+func TestStateFilePath(t *testing.T) {
+	cases := map[string]string{
+		"":                     "",
+		"state.json":           "/var/repo/state.json",
+		"../shared/state.json": "/var/shared/state.json",
+		"/etc/state.json":      "/etc/state.json",
+	}
+	for value, expected := range cases {
+		dir := &Dir{Path: "/var/repo", Config: getConfig(map[string]string{"state-file": value})}
+		if actual := StateFilePath(dir); actual != expected {
+			t.Errorf("Expected state-file=%s to yield path %s, instead found %s", value, expected, actual)
+		}
+	}
+}
+
+func TestStateFilePathFromOptionFile(t *testing.T) {
+	base, err := ioutil.TempDir("", "skeematest")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(base)
+	sub := path.Join(base, "sub")
+	if err := os.Mkdir(sub, 0777); err != nil {
+		t.Fatalf("Unable to create subdir: %s", err)
+	}
+	if err := ioutil.WriteFile(path.Join(base, ".skeema"), []byte("state-file=state.json\n"), 0666); err != nil {
+		t.Fatalf("Unable to write option file: %s", err)
+	}
+	if err := ioutil.WriteFile(path.Join(base, RootMarkerFileName), []byte{}, 0666); err != nil {
+		t.Fatalf("Unable to write root marker file: %s", err)
+	}
+
+	cmd := mybase.NewCommand("push", "1.0", "this is for testing", nil)
+	AddGlobalOptions(cmd)
+	cmd.AddArg("environment", "production", false)
+	cli := &mybase.CommandLine{Command: cmd}
+	dir, err := NewDir(sub, mybase.NewConfig(cli))
+	if err != nil {
+		t.Fatalf("Unexpected error from NewDir: %s", err)
+	}
+	if actual, expected := StateFilePath(dir), path.Join(base, "state.json"); actual != expected {
+		t.Errorf("Expected state-file from parent option file to yield path %s, instead found %s", expected, actual)
+	}
+}
+
+func TestPushStateReadWrite(t *testing.T) {
+	base, err := ioutil.TempDir("", "skeematest")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(base)
+	statePath := path.Join(base, "state.json")
+
+	state, err := ReadPushState(statePath)
+	if err != nil {
+		t.Fatalf("Unexpected error reading nonexistent state file: %s", err)
+	} else if len(state.Environments) != 0 {
+		t.Errorf("Expected nonexistent state file to yield no environments, instead found %d", len(state.Environments))
+	}
+
+	ts := time.Date(2018, 3, 14, 15, 9, 26, 0, time.UTC)
+	state.Environments["production"] = &PushRecord{GitRef: "abc123", Timestamp: ts, Statements: 3, SkeemaVersion: version}
+	if err := state.Write(statePath); err != nil {
+		t.Fatalf("Unexpected error writing state file: %s", err)
+	}
+	reread, err := ReadPushState(statePath)
+	if err != nil {
+		t.Fatalf("Unexpected error rereading state file: %s", err)
+	}
+	record := reread.Environments["production"]
+	if record == nil || record.GitRef != "abc123" || !record.Timestamp.Equal(ts) || record.Statements != 3 {
+		t.Errorf("State file did not round-trip as expected; found %+v", record)
+	}
+
+	if err := ioutil.WriteFile(statePath, []byte("not json"), 0666); err != nil {
+		t.Fatalf("Unable to write file: %s", err)
+	}
+	if _, err := ReadPushState(statePath); err == nil {
+		t.Error("Expected error reading invalid state file, but it was nil")
+	}
+}
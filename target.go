@@ -1,11 +1,8 @@
 package main
 
 import (
-	"database/sql"
-	"errors"
 	"fmt"
 	"strings"
-	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/pmezard/go-difflib/difflib"
@@ -29,6 +26,7 @@ type Target struct {
 	Err                error
 	SQLFileErrors      map[string]*SQLFile // map of string path to *SQLFile that contains an error
 	SQLFileWarnings    []error             // slice of all warnings for Target.Dir (no need to organize by file or path)
+	ModuleTables       map[string]string   // map of table name to path of the module *.sql file defining it
 }
 
 // TargetGroup represents a group of Targets that all have the same Instance.
@@ -69,6 +67,264 @@ func (tgm TargetGroupMap) AddInstanceError(instance *tengo.Instance, dir *Dir, e
 	tgm.Add(t)
 }
 
+// AlterIsTrivial returns true if alter consists of a single clause which either
+// adds a new column at the end of the table, adds a new index, or changes the
+// next auto-increment value (which verification ignores). Such ALTERs cannot
+// produce a table differing from the expected definition, so verify=auto skips
+// verifying them. Multi-clause ALTERs are never considered trivial.
+func AlterIsTrivial(alter tengo.AlterTable) bool {
+	if len(alter.Clauses) > 1 {
+		return false
+	}
+	for _, clause := range alter.Clauses {
+		switch clause := clause.(type) {
+		case tengo.AddColumn:
+			if clause.PositionFirst || clause.PositionAfter != nil {
+				return false
+			}
+		case tengo.AddIndex, AddInvisibleIndex, tengo.ChangeAutoIncrement:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// AlterIsAdditive returns true if every clause of alter adds a new column or
+// a new index. Such ALTERs never remove or modify existing data or
+// definitions.
+func AlterIsAdditive(alter tengo.AlterTable) bool {
+	for _, clause := range alter.Clauses {
+		switch clause.(type) {
+		case tengo.AddColumn, tengo.AddIndex, AddInvisibleIndex:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// AlterIsIndexOnly returns true if alter adds at least one secondary index, and
+// otherwise only adds or drops secondary indexes. Such ALTERs on different
+// tables may be run concurrently per the index-concurrency option.
+func AlterIsIndexOnly(alter tengo.AlterTable) bool {
+	var adds bool
+	for _, clause := range alter.Clauses {
+		switch clause := clause.(type) {
+		case tengo.AddIndex:
+			if clause.Index.PrimaryKey {
+				return false
+			}
+			adds = true
+		case AddInvisibleIndex:
+			adds = true
+		case tengo.DropIndex:
+			if clause.Index.PrimaryKey {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return adds
+}
+
+// ClauseIsCollationOnly returns true if clause, from an ALTER TABLE of table,
+// only changes the collation of a column or the table's default collation,
+// without changing the character set or anything else.
+func ClauseIsCollationOnly(table *tengo.Table, clause tengo.TableAlterClause) bool {
+	switch clause := clause.(type) {
+	case tengo.ModifyColumn:
+		if clause.PositionFirst || clause.PositionAfter != nil {
+			return false
+		}
+		if clause.OldColumn.CharSet != clause.NewColumn.CharSet || clause.OldColumn.Collation == clause.NewColumn.Collation {
+			return false
+		}
+		oldCol := *clause.OldColumn
+		oldCol.Collation = clause.NewColumn.Collation
+		return oldCol.Equals(clause.NewColumn)
+	case tengo.ChangeCharSet:
+		return table.CharSet == clause.CharSet && table.Collation != clause.Collation
+	}
+	return false
+}
+
+// FilterCollationChanges removes clauses which only change collations from the
+// ALTER TABLEs in diff, per ClauseIsCollationOnly. ALTER TABLEs with no
+// remaining clauses are removed entirely. Descriptions of the removed changes
+// are returned.
+func FilterCollationChanges(diff *tengo.SchemaDiff) (removed []string) {
+	kept := make([]tengo.TableDiff, 0, len(diff.TableDiffs))
+	for _, tableDiff := range diff.TableDiffs {
+		alter, ok := tableDiff.(tengo.AlterTable)
+		if !ok {
+			kept = append(kept, tableDiff)
+			continue
+		}
+		clauses := make([]tengo.TableAlterClause, 0, len(alter.Clauses))
+		for _, clause := range alter.Clauses {
+			if !ClauseIsCollationOnly(alter.Table, clause) {
+				clauses = append(clauses, clause)
+				continue
+			}
+			switch clause := clause.(type) {
+			case tengo.ModifyColumn:
+				removed = append(removed, fmt.Sprintf("table %s column %s: %s vs %s", alter.Table.Name, clause.OldColumn.Name, collationName(clause.OldColumn.Collation), collationName(clause.NewColumn.Collation)))
+			case tengo.ChangeCharSet:
+				removed = append(removed, fmt.Sprintf("table %s default: %s vs %s", alter.Table.Name, collationName(alter.Table.Collation), collationName(clause.Collation)))
+			}
+		}
+		if len(clauses) > 0 {
+			alter.Clauses = clauses
+			kept = append(kept, alter)
+		}
+	}
+	diff.TableDiffs = kept
+	return removed
+}
+
+// collationName returns name, or a description of the default collation if
+// name is blank.
+func collationName(name string) string {
+	if name == "" {
+		return "default collation"
+	}
+	return name
+}
+
+// FilterAdditive removes all non-additive TableDiffs from diff, leaving only
+// CREATE TABLE statements, and ALTER TABLE statements consisting solely of
+// additive clauses. The removed TableDiffs are returned. An ALTER TABLE mixing
+// additive and non-additive clauses is removed in its entirety.
+func FilterAdditive(diff *tengo.SchemaDiff) (deferred []tengo.TableDiff) {
+	kept := make([]tengo.TableDiff, 0, len(diff.TableDiffs))
+	for _, tableDiff := range diff.TableDiffs {
+		switch td := tableDiff.(type) {
+		case tengo.CreateTable:
+			kept = append(kept, td)
+		case tengo.AlterTable:
+			if AlterIsAdditive(td) {
+				kept = append(kept, td)
+			} else {
+				deferred = append(deferred, td)
+			}
+		default:
+			deferred = append(deferred, td)
+		}
+	}
+	diff.TableDiffs = kept
+	return deferred
+}
+
+// EnginePolicies maps lowercased storage engine names to a policy: "allow"
+// to manage tables of that engine normally, "warn" to manage them but emit a
+// warning, or "ignore" to exclude them from management entirely.
+type EnginePolicies map[string]string
+
+// ParseEnginePolicies parses a value of the engine-policy option, which is a
+// comma-separated list of engine=policy pairs, such as
+// "federated=ignore,blackhole=warn".
+func ParseEnginePolicies(value string) (EnginePolicies, error) {
+	policies := make(EnginePolicies)
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		tokens := strings.SplitN(pair, "=", 2)
+		if len(tokens) < 2 || strings.TrimSpace(tokens[0]) == "" {
+			return nil, fmt.Errorf("Invalid engine-policy entry \"%s\": must be of form engine=policy", pair)
+		}
+		engine := strings.ToLower(strings.TrimSpace(tokens[0]))
+		policy := strings.ToLower(strings.TrimSpace(tokens[1]))
+		switch policy {
+		case "allow", "warn", "ignore":
+			policies[engine] = policy
+		default:
+			return nil, fmt.Errorf("Invalid engine-policy entry \"%s\": policy must be allow, warn, or ignore", pair)
+		}
+	}
+	return policies, nil
+}
+
+// Policy returns the most restrictive policy among the supplied engines, along
+// with the engine responsible for it. Engines without a configured policy are
+// allowed.
+func (ep EnginePolicies) Policy(engines ...string) (policy, engine string) {
+	policy = "allow"
+	for _, e := range engines {
+		switch ep[strings.ToLower(e)] {
+		case "ignore":
+			return "ignore", e
+		case "warn":
+			policy, engine = "warn", e
+		}
+	}
+	return policy, engine
+}
+
+// tableEngines returns the storage engines involved in a table: its engine in
+// the filesystem definition, and its engine on the instance (if it exists there).
+func tableEngines(fromInstance *tengo.Schema, table *tengo.Table) []string {
+	engines := []string{table.Engine}
+	if fromInstance != nil {
+		if instTable, err := fromInstance.Table(table.Name); err == nil && instTable != nil && instTable.Engine != table.Engine {
+			engines = append(engines, instTable.Engine)
+		}
+	}
+	return engines
+}
+
+// SortByDependencies returns a copy of tg, reordered so that the Targets of
+// any dir listed in another dir's depends-on option come before those of the
+// depending dir. Apart from this, the original order is preserved. Targets
+// whose dirs form a dependency cycle have their Err set. Dependencies on dirs
+// without Targets in tg are ignored, since ordering only matters between
+// schemas on the same instance.
+func (tg TargetGroup) SortByDependencies() TargetGroup {
+	targetsByDir := make(map[string][]*Target)
+	var dirOrder []*Dir
+	for _, t := range tg {
+		if targetsByDir[t.Dir.Path] == nil {
+			dirOrder = append(dirOrder, t.Dir)
+		}
+		targetsByDir[t.Dir.Path] = append(targetsByDir[t.Dir.Path], t)
+	}
+
+	result := make(TargetGroup, 0, len(tg))
+	const visiting, visited = 1, 2
+	state := make(map[string]int, len(dirOrder))
+	var visit func(dir *Dir)
+	visit = func(dir *Dir) {
+		state[dir.Path] = visiting
+		for _, depPath := range dir.DependsOn() {
+			depTargets := targetsByDir[depPath]
+			if len(depTargets) == 0 {
+				continue
+			}
+			switch state[depPath] {
+			case visiting:
+				for _, t := range targetsByDir[dir.Path] {
+					if t.Err == nil {
+						t.Err = fmt.Errorf("Dependency cycle between %s and %s", dir, depPath)
+					}
+				}
+			case 0:
+				visit(depTargets[0].Dir)
+			}
+		}
+		state[dir.Path] = visited
+		result = append(result, targetsByDir[dir.Path]...)
+	}
+	for _, dir := range dirOrder {
+		if state[dir.Path] == 0 {
+			visit(dir)
+		}
+	}
+	return result
+}
+
 // generateTargetsForDir examines dir's configuration, figures out what Target
 // or Targets the dir maps to, indexes them in targetsByInstance, and then
 // recursively descends through dir's subdirectories to do the same.
@@ -82,29 +338,38 @@ func (tgm TargetGroupMap) AddInstanceError(instance *tengo.Instance, dir *Dir, e
 // did or did not (respectively) define a host+schema for at least one
 // environment.
 func generateTargetsForDir(dir *Dir, targetsByInstance TargetGroupMap, firstOnly, fatalSQLFileErrors bool) (skeemaDirs, otherDirs int) {
+	// Targets for different instances share dir, and may be processed by
+	// concurrent workers, so dir's configuration must be read-only from here on
+	dir.settleConfig()
+
 	// Generate targets if this dir's .skeema file defines a schema (for current
 	// environment section), and the dir's config hierarchy defines a host
-	// somewhere (here, or a parent dir)
-	if dir.Config.Changed("host") && dir.HasSchema() {
+	// somewhere (here, or a parent dir), unless the dir's configuration does not
+	// permit use of the current environment
+	if !dir.AllowsEnvironment() {
+		dir.logSkippedEnvironment()
+		skeemaDirs++ // still counts as a skeema-relevant dir though
+	} else if dir.HostConfigured() && dir.HasSchema() {
 		var instances []*tengo.Instance
-		var instancesErr error
+		rawInstances, instancesErr := dir.Instances()
 
-		if firstOnly {
+		if instancesErr == nil && firstOnly {
 			var onlyInstance *tengo.Instance
-			onlyInstance, instancesErr = dir.FirstInstance()
+			onlyInstance, instancesErr = dir.firstReachableInstance(rawInstances)
 			if onlyInstance == nil && instancesErr == nil {
 				instancesErr = fmt.Errorf("No instance defined for %s", dir)
 			}
 			if instancesErr == nil {
-				// dir.FirstInstance already checks for connectivity, so no need to redo that here
+				// dir.firstReachableInstance already checks for connectivity, so no need to redo that here
 				instances = []*tengo.Instance{onlyInstance}
 			}
-		} else {
-			var rawInstances []*tengo.Instance
-			rawInstances, instancesErr = dir.Instances()
+		} else if instancesErr == nil {
 			// dir.Instances doesn't pre-check for connectivity problems, so do that now
 			for _, inst := range rawInstances {
-				if ok, err := inst.CanConnect(); !ok {
+				if err := CheckConnect(inst); err != nil {
+					if ce, ok := err.(*ConnectError); ok {
+						ce.TraceOption(dir.Config)
+					}
 					targetsByInstance.AddInstanceError(inst, dir, err)
 				} else {
 					instances = append(instances, inst)
@@ -112,6 +377,15 @@ func generateTargetsForDir(dir *Dir, targetsByInstance TargetGroupMap, firstOnly
 			}
 		}
 
+		// If read-host is in use, schema introspection for each instance is routed
+		// to the corresponding replica instead
+		var readInstances map[string]*tengo.Instance
+		if instancesErr == nil {
+			if readInstances, instancesErr = dir.ReadInstances(rawInstances); instancesErr != nil {
+				instances = nil
+			}
+		}
+
 		// This class of error means the config was invalid (i.e. some option had a gibberish value)
 		if instancesErr != nil {
 			targetsByInstance.AddDirError(dir, instancesErr)
@@ -141,12 +415,21 @@ func generateTargetsForDir(dir *Dir, targetsByInstance TargetGroupMap, firstOnly
 		}
 
 		for _, inst := range instances {
-			schemaNames, err := dir.SchemaNames(inst)
+			introspectInst := inst
+			if readInst := readInstances[inst.String()]; readInst != nil {
+				if err := CheckConnect(readInst); err != nil {
+					targetsByInstance.AddInstanceError(inst, dir, fmt.Errorf("Unable to connect to read-host %s: %s", readInst, err))
+					continue
+				}
+				introspectInst = readInst
+				log.Debugf("Using %s for introspection of %s", readInst, inst)
+			}
+			schemaNames, err := dir.SchemaNames(introspectInst)
 			if err != nil {
 				targetsByInstance.AddInstanceError(inst, dir, err)
 				continue
 			}
-			schemasByName, err := inst.SchemasByName()
+			schemasByName, err := introspectInst.SchemasByName()
 			if err != nil {
 				targetsByInstance.AddInstanceError(inst, dir, err)
 				continue
@@ -168,7 +451,7 @@ func generateTargetsForDir(dir *Dir, targetsByInstance TargetGroupMap, firstOnly
 			}
 		}
 		skeemaDirs++
-	} else if !dir.Config.Changed("host") && dir.HasSchema() {
+	} else if !dir.HostConfigured() && dir.HasSchema() {
 		// If we have a schema defined but no host, display a warning
 		log.Warnf("Skipping %s: no host defined for environment \"%s\"\n", dir, dir.section)
 		skeemaDirs++ // still counts as a skeema-relevant dir though
@@ -212,96 +495,164 @@ func generateTargetsForDir(dir *Dir, targetsByInstance TargetGroupMap, firstOnly
 // bring a table from the version in SchemaFromInstance to the version in
 // SchemaFromDir.
 func (t *Target) verifyDiff(diff *tengo.SchemaDiff) (err error) {
-	// Populate the temp schema with a copy of the tables from SchemaFromInstance,
-	// the "before" state of the tables
-	tempSchemaName := t.Dir.Config.Get("temp-schema")
-
-	// TODO: want to skip binlogging for all temp schema actions, if super priv available
-	var tx *sql.Tx
-	if tx, err = t.lockTempSchema(30 * time.Second); err != nil {
+	// Determine which ALTERs require verification. With verify=auto, trivially
+	// safe ALTERs are skipped; if nothing remains, the temp schema isn't needed.
+	mods := tengo.StatementModifiers{
+		NextAutoInc: tengo.NextAutoIncIgnore,
+	}
+	autoVerify := strings.ToLower(t.Dir.Config.Get("verify")) == "auto"
+	wsInstance, err := t.Dir.WorkspaceInstance(t.Instance)
+	if err != nil {
 		return fmt.Errorf("verifyDiff: %s", err)
 	}
-	defer func() {
-		unlockErr := t.unlockTempSchema(tx)
-		if unlockErr != nil && err == nil {
-			err = fmt.Errorf("verifyDiff: %s", unlockErr)
-		}
-	}()
-
-	tempSchema, err := t.Instance.Schema(tempSchemaName)
+	cache, err := NewVerifyCache(t.Dir, wsInstance)
+	if err != nil {
+		log.Warnf("Verify cache disabled: %s", err)
+	}
+	beforeTables, err := t.SchemaFromInstance.TablesByName()
 	if err != nil {
 		return err
 	}
-	if tempSchema != nil {
-		// Attempt to drop any tables already present in tempSchema, but fail if
-		// any of them actually have 1 or more rows
-		if err := t.Instance.DropTablesInSchema(tempSchema, true); err != nil {
-			return fmt.Errorf("verifyDiff: cannot drop existing tables for %s on %s: %s", t.Dir, t.Instance, err)
+	expectTables, _ := t.SchemaFromDir.TablesByName() // can ignore error since we know table list already cached
+	var alters []tengo.AlterTable
+	for _, tableDiff := range diff.TableDiffs {
+		alter, ok := tableDiff.(tengo.AlterTable)
+		if !ok {
+			continue
 		}
-	} else {
-		tempSchema, err = t.Instance.CreateSchema(tempSchemaName, t.Dir.Config.Get("default-character-set"), t.Dir.Config.Get("default-collation"))
-		if err != nil {
-			return fmt.Errorf("verifyDiff: cannot create temporary schema for %s on %s: %s", t.Dir, t.Instance, err)
+		if autoVerify && AlterIsTrivial(alter) {
+			log.Debugf("Skipping verification of trivial ALTER TABLE for %s due to verify=auto", alter.Table.Name)
+			continue
+		}
+		stmt, _ := alter.Statement(mods)
+		if cache.Has(stmt, beforeTables[alter.Table.Name], expectTables[alter.Table.Name]) {
+			log.Debugf("Skipping verification of ALTER TABLE for %s: previously verified per verify-cache-dir", alter.Table.Name)
+			continue
 		}
+		alters = append(alters, alter)
 	}
-	if err = t.Instance.CloneSchema(t.SchemaFromInstance, tempSchema); err != nil {
-		return err
+	if len(alters) == 0 {
+		return nil
 	}
 
-	db, err := t.Instance.Connect(tempSchemaName, "")
+	// Populate a workspace with a copy of the tables from SchemaFromInstance,
+	// the "before" state of the tables
+	ws, err := Workspaces.Acquire(wsInstance, t.Dir)
 	if err != nil {
-		return fmt.Errorf("verifyDiff: cannot connect to %s: %s", t.Instance, err)
+		return fmt.Errorf("verifyDiff: %s", err)
 	}
-	mods := tengo.StatementModifiers{
-		NextAutoInc: tengo.NextAutoIncIgnore,
+	defer func() {
+		if releaseErr := ws.Release(); releaseErr != nil && err == nil {
+			err = fmt.Errorf("verifyDiff: %s", releaseErr)
+		}
+	}()
+	if err = wsInstance.CloneSchema(t.SchemaFromInstance, ws.Schema); err != nil {
+		return err
+	}
+
+	db, err := wsInstance.Connect(ws.SchemaName, "")
+	if err != nil {
+		return fmt.Errorf("verifyDiff: cannot connect to %s: %s", wsInstance, err)
 	}
 	tableNameToDDL := make(map[string]string)
 
-	// Iterate over the TableDiffs in the SchemaDiff. For any that are an ALTER,
-	// run it against the table in the temp schema, and see if the table now matches
-	// the version in the toTables map.
-	for _, tableDiff := range diff.TableDiffs {
-		alter, ok := tableDiff.(tengo.AlterTable)
-		if !ok {
-			continue
-		}
-		stmt, _ := tableDiff.Statement(mods) // fine to ignore errors for verifying DDL against temporary schema
+	// Iterate over the ALTERs requiring verification. Run each against the table
+	// in the temp schema, and see if the table now matches the version in the
+	// toTables map.
+	for _, alter := range alters {
+		stmt, _ := alter.Statement(mods) // fine to ignore errors for verifying DDL against temporary schema
 		if stmt == "" {
 			continue
 		}
-		if _, err = db.Exec(stmt); err != nil {
+		warnings, err := execCapturingWarnings(db, "", stmt, "")
+		if err != nil {
 			return err
 		}
+		for _, warning := range warnings {
+			if warning.IsConversion() {
+				log.Warnf("Verifying ALTER TABLE for %s on %s: server reported %s", alter.Table.Name, wsInstance, warning)
+			}
+		}
 		tableNameToDDL[alter.Table.Name] = stmt
 	}
-	postAlterTables, err := tempSchema.TablesByName()
+	postAlterTables, err := ws.Schema.TablesByName()
 	if err != nil {
 		return err
 	}
-	expectTables, _ := t.SchemaFromDir.TablesByName() // can ignore error since we know table list already cached
 
 	for name, stmt := range tableNameToDDL {
-		// We have to compare CREATE TABLE statements without their next auto-inc
-		// values, since divergence there may be expected depending on settings
-		expected, _ := tengo.ParseCreateAutoInc(expectTables[name].CreateStatement())
-		actual, _ := tengo.ParseCreateAutoInc(postAlterTables[name].CreateStatement())
-		if expected != actual {
+		if !t.verifyMatches(postAlterTables[name], expectTables[name]) {
+			// We have to compare CREATE TABLE statements without their next auto-inc
+			// values, since divergence there may be expected depending on settings
+			expected, _ := tengo.ParseCreateAutoInc(expectTables[name].CreateStatement())
+			actual, _ := tengo.ParseCreateAutoInc(postAlterTables[name].CreateStatement())
 			return fmt.Errorf("verifyDiff: Failure on table %s\nDDL:\n%s\n\nEXPECTED POST-ALTER:\n%s\n\nACTUAL POST-ALTER:\n%s\n\nRun command again with --skip-verify if this discrepancy is safe to ignore", name, stmt, expected, actual)
 		}
 	}
+	for name, stmt := range tableNameToDDL {
+		cache.Add(stmt, beforeTables[name], expectTables[name])
+	}
 
-	// Clean up the temp schema
-	if t.Dir.Config.GetBool("reuse-temp-schema") {
-		if err = t.Instance.DropTablesInSchema(tempSchema, true); err != nil {
-			return fmt.Errorf("verifyDiff: cannot drop tables in temporary schema for %s on %s: %s", t.Dir, t.Instance, err)
+	return nil
+}
+
+// verifyMatches returns true if actual, the result of running an ALTER in a
+// workspace, matches expected. Since the diff may have been rewritten prior to
+// verification, differences between the two are permitted only if they are
+// intentional results of such a rewrite, per verifyPermits.
+func (t *Target) verifyMatches(actual, expected *tengo.Table) bool {
+	// We have to compare CREATE TABLE statements without their next auto-inc
+	// values, since divergence there may be expected depending on settings
+	actualCreate, _ := tengo.ParseCreateAutoInc(actual.CreateStatement())
+	expectedCreate, _ := tengo.ParseCreateAutoInc(expected.CreateStatement())
+	if actualCreate == expectedCreate {
+		return true
+	}
+	actualCopy, actualInvisible, actualOK := visibleCopy(actual)
+	expectedCopy, expectedInvisible, expectedOK := visibleCopy(expected)
+	if !actualOK || !expectedOK {
+		return false
+	}
+
+	// visibleCopy ignores index visibility, so compare it separately for indexes
+	// present in both versions of the table
+	expectedIndexes := expectedCopy.SecondaryIndexesByName()
+	for _, idx := range actualCopy.SecondaryIndexes {
+		if expectedIndexes[idx.Name] != nil && actualInvisible[idx.Name] != expectedInvisible[idx.Name] {
+			return false
 		}
-	} else {
-		if err = t.Instance.DropSchema(tempSchema, true); err != nil {
-			return fmt.Errorf("verifyDiff: cannot drop temporary schema for %s on %s: %s", t.Dir, t.Instance, err)
+	}
+	clauses, supported := actualCopy.Diff(expectedCopy)
+	if !supported {
+		return false
+	}
+	for _, clause := range clauses {
+		if !t.verifyPermits(actualCopy, actualInvisible, clause) {
+			return false
 		}
 	}
+	return true
+}
 
-	return nil
+// verifyPermits returns true if clause, which would be needed to bring table
+// from its post-ALTER state to its expected state, represents a difference
+// that was intentionally left in place by a rewrite of the diff. invisible
+// should contain the names of table's invisible indexes.
+func (t *Target) verifyPermits(table *tengo.Table, invisible map[string]bool, clause tengo.TableAlterClause) bool {
+	if _, ok := clause.(tengo.ChangeAutoIncrement); ok {
+		return true
+	}
+	if ClauseIsCollationOnly(table, clause) {
+		return strings.ToLower(t.Dir.Config.Get("compare-collations")) != "enforce"
+	}
+	if drop, ok := clause.(tengo.DropColumn); ok && t.Dir.Config.GetBool("deprecate-dropped-columns") {
+		prefix := t.Dir.Config.Get("deprecated-column-prefix")
+		return prefix != "" && strings.HasPrefix(drop.Column.Name, prefix)
+	}
+	if drop, ok := clause.(tengo.DropIndex); ok && t.Dir.Config.GetBool("invisible-before-drop") {
+		return invisible[drop.Index.Name]
+	}
+	return false
 }
 
 // logUnsupportedTableDiff provides debug logging to identify why a table (or
@@ -341,38 +692,3 @@ func (t *Target) logUnsupportedTableDiff(name string) {
 		}
 	}
 }
-
-func (t *Target) lockTempSchema(maxWait time.Duration) (*sql.Tx, error) {
-	db, err := t.Instance.Connect("", "")
-	if err != nil {
-		return nil, err
-	}
-	tx, err := db.Begin()
-	if err != nil {
-		return nil, err
-	}
-
-	var getLockResult int
-	lockName := fmt.Sprintf("skeema.%s", t.Dir.Config.Get("temp-schema"))
-	start := time.Now()
-
-	for time.Since(start) < maxWait {
-		// Only using a timeout of 1 sec on each query to avoid potential issues with
-		// query killers, spurious slow query logging, etc
-		err := tx.QueryRow("SELECT GET_LOCK(?, 1)", lockName).Scan(&getLockResult)
-		if err == nil && getLockResult == 1 {
-			return tx, nil
-		}
-	}
-	return nil, errors.New("Unable to acquire lock")
-}
-
-func (t *Target) unlockTempSchema(tx *sql.Tx) error {
-	lockName := fmt.Sprintf("skeema.%s", t.Dir.Config.Get("temp-schema"))
-	var releaseLockResult int
-	err := tx.QueryRow("SELECT RELEASE_LOCK(?)", lockName).Scan(&releaseLockResult)
-	if err != nil || releaseLockResult != 1 {
-		return errors.New("Failed to release lock, or connection holding lock already dropped")
-	}
-	return tx.Rollback()
-}
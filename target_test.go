@@ -0,0 +1,347 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/skeema/mybase"
+	"github.com/skeema/tengo"
+)
+
+func TestSortByDependencies(t *testing.T) {
+	getTarget := func(dirPath, dependsOn string) *Target {
+		return &Target{
+			Dir: &Dir{
+				Path:   dirPath,
+				Config: getConfig(map[string]string{"depends-on": dependsOn}),
+			},
+		}
+	}
+	orders := getTarget("/repo/host/orders", "../accounts")
+	accounts := getTarget("/repo/host/accounts", "/repo/host/common")
+	common := getTarget("/repo/host/common", "../other-instance")
+	misc := getTarget("/repo/host/misc", "")
+	tg := TargetGroup{misc, orders, accounts, common}
+	sorted := tg.SortByDependencies()
+	expected := TargetGroup{misc, common, accounts, orders}
+	if len(sorted) != len(expected) {
+		t.Fatalf("Expected %d targets, instead found %d", len(expected), len(sorted))
+	}
+	for n := range expected {
+		if sorted[n] != expected[n] {
+			t.Errorf("Expected position %d to be %s, instead found %s", n, expected[n].Dir, sorted[n].Dir)
+		}
+		if sorted[n].Err != nil {
+			t.Errorf("Unexpected error for %s: %s", sorted[n].Dir, sorted[n].Err)
+		}
+	}
+
+	// Introduce a cycle
+	a := getTarget("/repo/host/a", "../b")
+	b := getTarget("/repo/host/b", "../a")
+	tg = TargetGroup{a, b, misc}
+	sorted = tg.SortByDependencies()
+	if len(sorted) != 3 {
+		t.Fatalf("Expected 3 targets, instead found %d", len(sorted))
+	}
+	if a.Err == nil && b.Err == nil {
+		t.Error("Expected dependency cycle to set an error on at least one target, but it did not")
+	}
+	if misc.Err != nil {
+		t.Errorf("Unexpected error for target outside of cycle: %s", misc.Err)
+	}
+}
+
+func TestAlterIsTrivial(t *testing.T) {
+	table := &tengo.Table{Name: "foo"}
+	newCol := &tengo.Column{Name: "bar", TypeInDB: "int"}
+	otherCol := &tengo.Column{Name: "id", TypeInDB: "int"}
+	cases := []struct {
+		clauses  []tengo.TableAlterClause
+		expected bool
+	}{
+		{[]tengo.TableAlterClause{tengo.AddColumn{Table: table, Column: newCol}}, true},
+		{[]tengo.TableAlterClause{tengo.AddColumn{Table: table, Column: newCol}, tengo.AddIndex{Table: table, Index: &tengo.Index{Name: "idx"}}}, false},
+		{[]tengo.TableAlterClause{tengo.AddIndex{Table: table, Index: &tengo.Index{Name: "idx"}}}, true},
+		{[]tengo.TableAlterClause{tengo.AddColumn{Table: table, Column: newCol, PositionFirst: true}}, false},
+		{[]tengo.TableAlterClause{tengo.AddColumn{Table: table, Column: newCol, PositionAfter: otherCol}}, false},
+		{[]tengo.TableAlterClause{tengo.AddColumn{Table: table, Column: newCol}, tengo.DropColumn{Table: table, Column: otherCol}}, false},
+	}
+	for n, c := range cases {
+		alter := tengo.AlterTable{Table: table, Clauses: c.clauses}
+		if actual := AlterIsTrivial(alter); actual != c.expected {
+			t.Errorf("Case %d: expected AlterIsTrivial to return %t, instead found %t", n, c.expected, actual)
+		}
+	}
+}
+
+func TestAlterIsIndexOnly(t *testing.T) {
+	table := &tengo.Table{Name: "foo"}
+	col := &tengo.Column{Name: "bar", TypeInDB: "int"}
+	idx := &tengo.Index{Name: "idx_bar", Columns: []*tengo.Column{col}}
+	oldIdx := &tengo.Index{Name: "idx_old", Columns: []*tengo.Column{col}}
+	pk := &tengo.Index{Name: "PRIMARY", Columns: []*tengo.Column{col}, PrimaryKey: true, Unique: true}
+	cases := []struct {
+		clauses  []tengo.TableAlterClause
+		expected bool
+	}{
+		{[]tengo.TableAlterClause{tengo.AddIndex{Table: table, Index: idx}}, true},
+		{[]tengo.TableAlterClause{tengo.DropIndex{Table: table, Index: oldIdx}, tengo.AddIndex{Table: table, Index: idx}}, true},
+		{[]tengo.TableAlterClause{tengo.DropIndex{Table: table, Index: oldIdx}}, false},
+		{[]tengo.TableAlterClause{tengo.AddIndex{Table: table, Index: pk}}, false},
+		{[]tengo.TableAlterClause{tengo.AddIndex{Table: table, Index: idx}, tengo.AddColumn{Table: table, Column: col}}, false},
+	}
+	for n, c := range cases {
+		alter := tengo.AlterTable{Table: table, Clauses: c.clauses}
+		if actual := AlterIsIndexOnly(alter); actual != c.expected {
+			t.Errorf("Case %d: expected AlterIsIndexOnly to return %t, instead found %t", n, c.expected, actual)
+		}
+	}
+}
+
+func TestFilterCollationChanges(t *testing.T) {
+	table := &tengo.Table{Name: "foo", CharSet: "utf8", Collation: ""}
+	oldName := &tengo.Column{Name: "name", TypeInDB: "varchar(30)", CharSet: "utf8"}
+	newName := &tengo.Column{Name: "name", TypeInDB: "varchar(30)", CharSet: "utf8", Collation: "utf8_unicode_ci"}
+	widerName := &tengo.Column{Name: "name", TypeInDB: "varchar(40)", CharSet: "utf8", Collation: "utf8_unicode_ci"}
+	oldDesc := &tengo.Column{Name: "descr", TypeInDB: "text", CharSet: "latin1"}
+	newDesc := &tengo.Column{Name: "descr", TypeInDB: "text", CharSet: "utf8"}
+	cases := []struct {
+		clause   tengo.TableAlterClause
+		expected bool
+	}{
+		{tengo.ModifyColumn{Table: table, OldColumn: oldName, NewColumn: newName}, true},
+		{tengo.ModifyColumn{Table: table, OldColumn: oldName, NewColumn: widerName}, false},
+		{tengo.ModifyColumn{Table: table, OldColumn: oldName, NewColumn: newName, PositionFirst: true}, false},
+		{tengo.ModifyColumn{Table: table, OldColumn: oldDesc, NewColumn: newDesc}, false},
+		{tengo.ChangeCharSet{Table: table, CharSet: "utf8", Collation: "utf8_unicode_ci"}, true},
+		{tengo.ChangeCharSet{Table: table, CharSet: "utf8mb4", Collation: ""}, false},
+		{tengo.AddColumn{Table: table, Column: oldDesc}, false},
+	}
+	for n, c := range cases {
+		if actual := ClauseIsCollationOnly(table, c.clause); actual != c.expected {
+			t.Errorf("Case %d: expected ClauseIsCollationOnly to return %t, instead found %t", n, c.expected, actual)
+		}
+	}
+
+	other := &tengo.Table{Name: "bar", CharSet: "utf8"}
+	diff := &tengo.SchemaDiff{
+		TableDiffs: []tengo.TableDiff{
+			tengo.AlterTable{Table: table, Clauses: []tengo.TableAlterClause{
+				tengo.ChangeCharSet{Table: table, CharSet: "utf8", Collation: "utf8_unicode_ci"},
+				tengo.ModifyColumn{Table: table, OldColumn: oldName, NewColumn: newName},
+				tengo.ModifyColumn{Table: table, OldColumn: oldDesc, NewColumn: newDesc},
+			}},
+			tengo.AlterTable{Table: other, Clauses: []tengo.TableAlterClause{
+				tengo.ModifyColumn{Table: other, OldColumn: oldName, NewColumn: newName},
+			}},
+			tengo.CreateTable{Table: other},
+		},
+	}
+	removed := FilterCollationChanges(diff)
+	if len(removed) != 3 {
+		t.Errorf("Expected 3 changes to be removed, instead found %d: %v", len(removed), removed)
+	} else if removed[1] != "table foo column name: default collation vs utf8_unicode_ci" {
+		t.Errorf("Unexpected description of removed change: %s", removed[1])
+	}
+	if len(diff.TableDiffs) != 2 {
+		t.Fatalf("Expected 2 TableDiffs to remain, instead found %d", len(diff.TableDiffs))
+	}
+	if alter := diff.TableDiffs[0].(tengo.AlterTable); len(alter.Clauses) != 1 {
+		t.Errorf("Expected 1 clause to remain in ALTER, instead found %d", len(alter.Clauses))
+	}
+}
+
+func TestVerifyPermits(t *testing.T) {
+	table := &tengo.Table{Name: "foo", CharSet: "utf8"}
+	oldName := &tengo.Column{Name: "name", TypeInDB: "varchar(30)", CharSet: "utf8"}
+	newName := &tengo.Column{Name: "name", TypeInDB: "varchar(30)", CharSet: "utf8", Collation: "utf8_unicode_ci"}
+	collationChange := tengo.ModifyColumn{Table: table, OldColumn: oldName, NewColumn: newName}
+	autoIncChange := tengo.ChangeAutoIncrement{Table: table, NewNextAutoIncrement: 5}
+	dropName := tengo.DropColumn{Table: table, Column: oldName}
+	dropDeprecated := tengo.DropColumn{Table: table, Column: &tengo.Column{Name: "zzz_name", TypeInDB: "varchar(30)"}}
+	dropHidden := tengo.DropIndex{Table: table, Index: &tengo.Index{Name: "idx_hidden"}}
+	dropVisible := tengo.DropIndex{Table: table, Index: &tengo.Index{Name: "idx_visible"}}
+	invisible := map[string]bool{"idx_hidden": true}
+	cases := []struct {
+		values   map[string]string
+		clause   tengo.TableAlterClause
+		expected bool
+	}{
+		{map[string]string{}, autoIncChange, true},
+		{map[string]string{}, collationChange, false},
+		{map[string]string{"compare-collations": "warn"}, collationChange, true},
+		{map[string]string{"compare-collations": "ignore"}, collationChange, true},
+		{map[string]string{"compare-collations": "ignore"}, dropName, false},
+		{map[string]string{"deprecate-dropped-columns": "1"}, dropName, false},
+		{map[string]string{"deprecate-dropped-columns": "1"}, dropDeprecated, true},
+		{map[string]string{}, dropDeprecated, false},
+		{map[string]string{"invisible-before-drop": "1"}, dropHidden, true},
+		{map[string]string{"invisible-before-drop": "1"}, dropVisible, false},
+		{map[string]string{}, dropHidden, false},
+	}
+	for n, c := range cases {
+		values := map[string]string{
+			"compare-collations":        "enforce",
+			"deprecate-dropped-columns": "0",
+			"deprecated-column-prefix":  "zzz_",
+			"invisible-before-drop":     "0",
+		}
+		for k, v := range c.values {
+			values[k] = v
+		}
+		target := &Target{Dir: &Dir{Path: "/repo/host/foo", Config: getConfig(values)}}
+		if actual := target.verifyPermits(table, invisible, c.clause); actual != c.expected {
+			t.Errorf("Case %d: expected verifyPermits to return %t, instead found %t", n, c.expected, actual)
+		}
+	}
+}
+
+func TestFilterAdditive(t *testing.T) {
+	table := &tengo.Table{Name: "foo"}
+	col := &tengo.Column{Name: "bar", TypeInDB: "int(11)"}
+	idx := &tengo.Index{Name: "idx_bar", Columns: []*tengo.Column{col}}
+	create := tengo.CreateTable{Table: table}
+	drop := tengo.DropTable{Table: table}
+	additive := tengo.AlterTable{Table: table, Clauses: []tengo.TableAlterClause{
+		tengo.AddColumn{Table: table, Column: col, PositionFirst: true},
+		tengo.AddIndex{Table: table, Index: idx},
+	}}
+	mixed := tengo.AlterTable{Table: table, Clauses: []tengo.TableAlterClause{
+		tengo.AddIndex{Table: table, Index: idx},
+		tengo.DropColumn{Table: table, Column: col},
+	}}
+	diff := &tengo.SchemaDiff{
+		TableDiffs: []tengo.TableDiff{create, drop, additive, mixed},
+	}
+	deferred := FilterAdditive(diff)
+	if len(diff.TableDiffs) != 2 || len(deferred) != 2 {
+		t.Fatalf("Expected 2 kept and 2 deferred TableDiffs, instead found %d kept and %d deferred", len(diff.TableDiffs), len(deferred))
+	}
+	if _, ok := diff.TableDiffs[0].(tengo.CreateTable); !ok {
+		t.Errorf("Expected CreateTable to be kept, instead found %T", diff.TableDiffs[0])
+	}
+	if _, ok := deferred[0].(tengo.DropTable); !ok {
+		t.Errorf("Expected DropTable to be deferred, instead found %T", deferred[0])
+	}
+	if AlterIsAdditive(mixed) {
+		t.Error("Expected ALTER with DROP COLUMN clause to not be considered additive")
+	}
+}
+
+func TestParseEnginePolicies(t *testing.T) {
+	policies, err := ParseEnginePolicies("FEDERATED=ignore, blackhole=warn,InnoDB=allow")
+	if err != nil {
+		t.Fatalf("Unexpected error from ParseEnginePolicies: %s", err)
+	}
+	cases := []struct {
+		engines        []string
+		expectedPolicy string
+		expectedEngine string
+	}{
+		{[]string{"InnoDB"}, "allow", ""},
+		{[]string{"ARCHIVE"}, "allow", ""},
+		{[]string{"BLACKHOLE"}, "warn", "BLACKHOLE"},
+		{[]string{"InnoDB", "FEDERATED"}, "ignore", "FEDERATED"},
+		{[]string{"BLACKHOLE", "federated"}, "ignore", "federated"},
+	}
+	for _, c := range cases {
+		if policy, engine := policies.Policy(c.engines...); policy != c.expectedPolicy || engine != c.expectedEngine {
+			t.Errorf("Expected Policy(%v) to return %s, %s; instead found %s, %s", c.engines, c.expectedPolicy, c.expectedEngine, policy, engine)
+		}
+	}
+
+	if policies, err := ParseEnginePolicies(""); err != nil || len(policies) != 0 {
+		t.Errorf("Expected blank value to return empty policies and no error; instead found %v, %v", policies, err)
+	}
+	for _, value := range []string{"federated", "federated=skip", "=ignore"} {
+		if _, err := ParseEnginePolicies(value); err == nil {
+			t.Errorf("Expected ParseEnginePolicies(\"%s\") to return an error, but it did not", value)
+		}
+	}
+}
+
+func TestAlterChangesEngine(t *testing.T) {
+	table := &tengo.Table{Name: "foo", Engine: "InnoDB"}
+	col := &tengo.Column{Name: "bar", TypeInDB: "int(11)"}
+	alter := tengo.AlterTable{Table: table, Clauses: []tengo.TableAlterClause{tengo.AddColumn{Table: table, Column: col}}}
+	if _, changed := alterChangesEngine(alter); changed {
+		t.Error("Expected alterChangesEngine to return false for ALTER without engine change")
+	}
+	alter.Clauses = append(alter.Clauses, tengo.ChangeStorageEngine{Table: table, NewStorageEngine: "InnoDB"})
+	if newEngine, changed := alterChangesEngine(alter); !changed || newEngine != "InnoDB" {
+		t.Errorf("Expected alterChangesEngine to return InnoDB, true; instead found %s, %t", newEngine, changed)
+	}
+}
+
+// TestTargetGroupsConcurrent is primarily useful with go test -race. It
+// generates Targets for a tree of dirs without any live database, by using a
+// host-group that cannot be resolved, and then consumes the Targets from
+// several goroutines at once, as push does with concurrent-instances.
+func TestTargetGroupsConcurrent(t *testing.T) {
+	base, err := ioutil.TempDir("", "skeematest")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(base)
+	files := map[string]string{
+		RootMarkerFileName: "",
+		".skeema":          "host-group=missing\ndefault-schema-dir=true\n",
+	}
+	dirCount := 2 * MaxConcurrentReads
+	for n := 0; n < dirCount; n++ {
+		files[fmt.Sprintf("schema%03d/.skeema", n)] = fmt.Sprintf("user=user%03d\n", n)
+	}
+	for name, contents := range files {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(base, name)), 0777); err != nil {
+			t.Fatalf("Unable to create dirs: %s", err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(base, name), []byte(contents), 0666); err != nil {
+			t.Fatalf("Unable to write file: %s", err)
+		}
+	}
+
+	cmd := mybase.NewCommand("test", "1.0", "this is for testing", nil)
+	AddGlobalOptions(cmd)
+	cfg := mybase.NewConfig(&mybase.CommandLine{Command: cmd}, dummySource(map[string]string{}))
+	dir := &Dir{Path: base, Config: cfg, section: "production"}
+	if f, err := dir.OptionFile(); err != nil {
+		t.Fatalf("Unexpected error reading option file: %s", err)
+	} else {
+		dir.Config.AddSource(f)
+	}
+
+	var targets []*Target
+	for tg := range dir.TargetGroups(false, true) {
+		targets = append(targets, tg...)
+	}
+	if len(targets) != dirCount {
+		t.Fatalf("Expected %d targets, instead found %d", dirCount, len(targets))
+	}
+	var wg sync.WaitGroup
+	errs := make(chan error, 4*len(targets))
+	for n := 0; n < 4; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, target := range targets {
+				expectUser := strings.Replace(target.Dir.BaseName(), "schema", "user", 1)
+				if target.Err == nil || !strings.Contains(target.Err.Error(), "missing") {
+					errs <- fmt.Errorf("Expected host group error for %s, instead found %v", target.Dir, target.Err)
+				} else if user := target.Dir.Config.Get("user"); user != expectUser {
+					errs <- fmt.Errorf("Expected %s to have user %s, instead found %s", target.Dir, expectUser, user)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
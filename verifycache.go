@@ -0,0 +1,88 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/skeema/tengo"
+)
+
+// VerifyCache tracks ALTER TABLE statements which have already been verified
+// successfully. Each entry is an empty file whose name is a hash of the
+// statement, the table definitions before and after the statement, and the
+// server flavor and version. Since the cache only ever records successes, and
+// entries are never modified, multiple concurrent runs may safely share it.
+type VerifyCache struct {
+	Dir           string
+	ServerVersion string // flavor and full version string of the server used for verification
+}
+
+// NewVerifyCache returns a VerifyCache using the directory configured by the
+// verify-cache-dir option of dir, or nil if the option is not set. Entries are
+// keyed on the server version of instance, which should be the instance used
+// for verification. An error is returned if the dir cannot be created, or the
+// server version cannot be determined.
+func NewVerifyCache(dir *Dir, instance *tengo.Instance) (*VerifyCache, error) {
+	cacheDir := dir.Config.Get("verify-cache-dir")
+	if cacheDir == "" {
+		return nil, nil
+	}
+	if strings.HasPrefix(cacheDir, "~/") {
+		cacheDir = path.Join(os.Getenv("HOME"), cacheDir[2:])
+	}
+	if err := os.MkdirAll(cacheDir, 0777); err != nil {
+		return nil, fmt.Errorf("Unable to create verify-cache-dir %s: %s", cacheDir, err)
+	}
+	sv, err := GetServerVersion(instance)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to determine server version of %s: %s", instance, err)
+	}
+	return &VerifyCache{
+		Dir:           cacheDir,
+		ServerVersion: fmt.Sprintf("%s %s", sv.Flavor, sv.Raw),
+	}, nil
+}
+
+// key returns the cache key for running stmt against a table with definition
+// before, expecting to result in definition after.
+func (vc *VerifyCache) key(stmt string, before, after *tengo.Table) string {
+	beforeCreate, _ := tengo.ParseCreateAutoInc(before.CreateStatement())
+	afterCreate, _ := tengo.ParseCreateAutoInc(after.CreateStatement())
+	h := sha256.New()
+	for _, part := range []string{vc.ServerVersion, stmt, beforeCreate, afterCreate} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Has returns true if the supplied combination was previously verified
+// successfully. It is safe to call this method on a nil VerifyCache, which
+// always returns false.
+func (vc *VerifyCache) Has(stmt string, before, after *tengo.Table) bool {
+	if vc == nil {
+		return false
+	}
+	_, err := os.Stat(path.Join(vc.Dir, vc.key(stmt, before, after)))
+	return err == nil
+}
+
+// Add records that the supplied combination was verified successfully.
+// Failures are logged but otherwise ignored, since the cache is merely an
+// optimization. It is safe to call this method on a nil VerifyCache, which
+// does nothing.
+func (vc *VerifyCache) Add(stmt string, before, after *tengo.Table) {
+	if vc == nil {
+		return
+	}
+	entryPath := path.Join(vc.Dir, vc.key(stmt, before, after))
+	if err := ioutil.WriteFile(entryPath, []byte{}, 0666); err != nil {
+		log.Debugf("Unable to write verify cache entry %s: %s", entryPath, err)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/skeema/tengo"
+)
+
+func TestVerifyCache(t *testing.T) {
+	cacheDir, err := ioutil.TempDir("", "skeematest")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(cacheDir)
+
+	before := &tengo.Table{
+		Name:    "foo",
+		Engine:  "InnoDB",
+		CharSet: "latin1",
+		Columns: []*tengo.Column{{Name: "id", TypeInDB: "int(11)"}},
+	}
+	after := &tengo.Table{
+		Name:    "foo",
+		Engine:  "InnoDB",
+		CharSet: "latin1",
+		Columns: []*tengo.Column{{Name: "id", TypeInDB: "bigint(20)"}},
+	}
+	stmt := "ALTER TABLE `foo` MODIFY COLUMN `id` bigint(20) NOT NULL"
+
+	var nilCache *VerifyCache
+	nilCache.Add(stmt, before, after)
+	if nilCache.Has(stmt, before, after) {
+		t.Error("Expected nil VerifyCache to never have entries")
+	}
+
+	vc := &VerifyCache{Dir: cacheDir, ServerVersion: "mysql 5.7.22"}
+	if vc.Has(stmt, before, after) {
+		t.Error("Expected empty VerifyCache to not have entry")
+	}
+	vc.Add(stmt, before, after)
+	if !vc.Has(stmt, before, after) {
+		t.Error("Expected VerifyCache to have entry after Add")
+	}
+	if vc.Has(stmt, after, before) {
+		t.Error("Expected VerifyCache to not have entry for different table definitions")
+	}
+	other := &VerifyCache{Dir: cacheDir, ServerVersion: "mysql 8.0.11"}
+	if other.Has(stmt, before, after) {
+		t.Error("Expected VerifyCache to not have entry for different server version")
+	}
+}
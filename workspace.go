@@ -0,0 +1,293 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/skeema/tengo"
+)
+
+// Workspace represents a temporary schema used for intermediate operations,
+// such as executing *.sql files or verifying generated ALTERs. Workspaces are
+// obtained via WorkspacePool.Acquire and must be returned via Release.
+type Workspace struct {
+	Instance   *tengo.Instance
+	SchemaName string
+	Schema     *tengo.Schema
+	tx         *sql.Tx // holds the workspace's lock
+	cleanupID  int     // only used if not pooled
+	reuse      bool
+	pool       *WorkspacePool // nil if not pooled
+	poolKey    string
+	poolEntry  *pooledWorkspace
+}
+
+// pooledWorkspace tracks a workspace schema which remains in existence across
+// targets, along with the character set and collation options it was created
+// with.
+type pooledWorkspace struct {
+	name       string
+	charSet    string
+	collation  string
+	registered map[bool]bool // whether cleanup upon exit has been registered, keyed by reuse-temp-schema
+}
+
+// WorkspacePool tracks workspaces that are kept warm for the duration of the
+// run, rather than being created and dropped for each target. Pooling is only
+// used for dirs with a positive value for workspace-pool-size; each instance
+// then gets up to that many workspace schemas, named using temp-schema as a
+// prefix. Pooled workspaces are dropped upon exit.
+type WorkspacePool struct {
+	idle    map[string][]*pooledWorkspace // keyed by instance and temp-schema
+	created map[string]int
+	*sync.Cond
+}
+
+// Workspaces is the workspace pool for the current run.
+var Workspaces = &WorkspacePool{
+	idle:    make(map[string][]*pooledWorkspace),
+	created: make(map[string]int),
+	Cond:    sync.NewCond(new(sync.Mutex)),
+}
+
+// WorkspaceInstance returns the instance that should hold dir's temp schemas
+// for operations relating to instance. This is instance itself, unless the
+// workspace-host option is set, in which case that host is used with dir's
+// other connection options. The workspace-host instance is checked for
+// connectivity.
+func (dir *Dir) WorkspaceInstance(instance *tengo.Instance) (*tengo.Instance, error) {
+	if dir.Config.Get("workspace-host") == "" {
+		return instance, nil
+	}
+	return dir.instanceOverridingHost("workspace-host")
+}
+
+// workspaceName returns the schema name for the workspace at position n
+// (starting at 0) in a pool using baseName as its temp-schema. The first
+// workspace simply uses baseName, for consistency with unpooled operation.
+func workspaceName(baseName string, n int) string {
+	if n == 0 {
+		return baseName
+	}
+	return fmt.Sprintf("%s_%d", baseName, n+1)
+}
+
+// Acquire returns a Workspace on instance configured according to dir's
+// options. The workspace's schema will exist and be empty. If dir uses
+// workspace pooling and all of the pool's workspaces on instance are in use,
+// this method blocks until one is released.
+func (wp *WorkspacePool) Acquire(instance *tengo.Instance, dir *Dir) (*Workspace, error) {
+	poolSize, err := dir.Config.GetInt("workspace-pool-size")
+	if err != nil || poolSize < 0 {
+		return nil, OptionError(dir.Config, "workspace-pool-size", fmt.Errorf("Option workspace-pool-size must be a non-negative integer; found \"%s\"", dir.Config.Get("workspace-pool-size")))
+	}
+	charSet := dir.Config.Get("default-character-set")
+	collation := dir.Config.Get("default-collation")
+	ws := &Workspace{
+		Instance:   instance,
+		SchemaName: dir.Config.Get("temp-schema"),
+		reuse:      dir.Config.GetBool("reuse-temp-schema"),
+	}
+	var recreate bool
+	if poolSize > 0 {
+		ws.pool = wp
+		ws.poolKey = fmt.Sprintf("%s %s", instance, ws.SchemaName)
+		ws.poolEntry, recreate = wp.take(ws.poolKey, ws.SchemaName, poolSize, charSet, collation)
+		ws.SchemaName = ws.poolEntry.name
+	}
+
+	// TODO: want to skip binlogging for all temp schema actions, if super priv available
+	if ws.tx, err = lockWorkspace(instance, ws.SchemaName, 30*time.Second); err != nil {
+		ws.returnToPool()
+		return nil, fmt.Errorf("Unable to lock temporary schema %s on %s: %s", ws.SchemaName, instance, err)
+	}
+	if err := ws.prepare(charSet, collation, recreate); err != nil {
+		unlockWorkspace(ws.tx, ws.SchemaName)
+		ws.returnToPool()
+		return nil, err
+	}
+	return ws, nil
+}
+
+// take obtains a pooled workspace for key, blocking if the pool is exhausted.
+// An idle workspace with matching character set and collation is preferred.
+// The returned bool indicates whether the workspace schema must be recreated
+// due to having been created with a different character set or collation.
+func (wp *WorkspacePool) take(key, baseName string, poolSize int, charSet, collation string) (*pooledWorkspace, bool) {
+	wp.L.Lock()
+	defer wp.L.Unlock()
+	for len(wp.idle[key]) == 0 && wp.created[key] >= poolSize {
+		wp.Wait()
+	}
+	idle := wp.idle[key]
+	if len(idle) == 0 {
+		entry := &pooledWorkspace{
+			name:       workspaceName(baseName, wp.created[key]),
+			charSet:    charSet,
+			collation:  collation,
+			registered: make(map[bool]bool),
+		}
+		wp.created[key]++
+		return entry, false
+	}
+	pick := len(idle) - 1
+	for n, entry := range idle {
+		if entry.charSet == charSet && entry.collation == collation {
+			pick = n
+			break
+		}
+	}
+	entry := idle[pick]
+	wp.idle[key] = append(idle[:pick], idle[pick+1:]...)
+	recreate := entry.charSet != charSet || entry.collation != collation
+	entry.charSet, entry.collation = charSet, collation
+	return entry, recreate
+}
+
+// prepare ensures the workspace schema exists and is empty. If recreate is
+// true, any existing schema is dropped and created again.
+func (ws *Workspace) prepare(charSet, collation string, recreate bool) error {
+	schema, err := ws.Instance.Schema(ws.SchemaName)
+	if err != nil {
+		return fmt.Errorf("Unable to check for existence of temp schema on %s: %s", ws.Instance, err)
+	}
+	if schema != nil && recreate {
+		if err := ws.Instance.DropSchema(schema, true); err != nil {
+			return fmt.Errorf("Cannot drop temporary schema %s on %s: %s", ws.SchemaName, ws.Instance, err)
+		}
+		schema = nil
+	}
+	if schema != nil {
+		// Attempt to drop any tables already present in schema, but fail if any of
+		// them actually have 1 or more rows
+		if err := ws.Instance.DropTablesInSchema(schema, true); err != nil {
+			return fmt.Errorf("Cannot drop existing temp schema tables on %s: %s", ws.Instance, err)
+		}
+	} else {
+		if schema, err = ws.Instance.CreateSchema(ws.SchemaName, charSet, collation); err != nil {
+			return fmt.Errorf("Cannot create temporary schema on %s: %s", ws.Instance, err)
+		}
+	}
+	ws.Schema = schema
+
+	// Pooled workspaces are only registered for cleanup once per value of
+	// reuse-temp-schema, since they remain in existence until exit. Dirs sharing
+	// a pooled workspace may differ in reuse-temp-schema; if any of them disable
+	// it, the workspace schema is dropped at exit.
+	if ws.pool == nil {
+		ws.cleanupID = registerWorkspaceCleanup(ws.Instance, ws.SchemaName, ws.reuse)
+	} else if !ws.poolEntry.registered[ws.reuse] {
+		registerWorkspaceCleanup(ws.Instance, ws.SchemaName, ws.reuse)
+		ws.poolEntry.registered[ws.reuse] = true
+	}
+	return nil
+}
+
+// Release empties the workspace and unlocks it. Unpooled workspaces are also
+// dropped, unless reuse-temp-schema is enabled. Pooled workspaces are returned
+// to the pool for use by subsequent targets.
+func (ws *Workspace) Release() (err error) {
+	defer ws.returnToPool()
+	defer func() {
+		unlockErr := unlockWorkspace(ws.tx, ws.SchemaName)
+		if unlockErr != nil && err == nil {
+			err = fmt.Errorf("Unable to unlock temporary schema on %s: %s", ws.Instance, unlockErr)
+		}
+	}()
+	if ws.pool != nil || ws.reuse {
+		if err := ws.Instance.DropTablesInSchema(ws.Schema, true); err != nil {
+			return fmt.Errorf("Cannot drop tables in temporary schema on %s: %s", ws.Instance, err)
+		}
+	} else {
+		if err := ws.Instance.DropSchema(ws.Schema, true); err != nil {
+			return fmt.Errorf("Cannot drop temporary schema on %s: %s", ws.Instance, err)
+		}
+	}
+	if ws.pool == nil {
+		Resources.Unregister(ws.cleanupID)
+	}
+	return nil
+}
+
+// returnToPool makes a pooled workspace available for use by other callers.
+// It has no effect on unpooled workspaces.
+func (ws *Workspace) returnToPool() {
+	if ws.pool == nil || ws.poolEntry == nil {
+		return
+	}
+	ws.pool.L.Lock()
+	ws.pool.idle[ws.poolKey] = append(ws.pool.idle[ws.poolKey], ws.poolEntry)
+	ws.poolEntry = nil
+	ws.pool.L.Unlock()
+	ws.pool.Signal()
+}
+
+// registerWorkspaceCleanup records the temporary schema in the run's
+// ResourceRegistry, so that it is removed even if the run is terminated before
+// the caller cleans it up normally. The returned ID should be passed to
+// Resources.Unregister once the caller's own cleanup succeeds.
+// The cleanup obtains the workspace's named lock before dropping anything, and
+// skips the drop if the lock cannot be obtained, since the schema may then be
+// in use by a concurrent run, or by a worker of this run if it is being
+// terminated by a signal.
+func registerWorkspaceCleanup(instance *tengo.Instance, schemaName string, reuse bool) int {
+	description := fmt.Sprintf("temporary schema %s on %s", schemaName, instance)
+	return Resources.Register(description, func() error {
+		tx, err := lockWorkspace(instance, schemaName, 2*time.Second)
+		if err != nil {
+			return errors.New("Temporary schema is locked, and may be in use by another run; skipping")
+		}
+		defer unlockWorkspace(tx, schemaName)
+		schema, err := instance.Schema(schemaName)
+		if err != nil || schema == nil {
+			return err
+		}
+		if reuse {
+			return instance.DropTablesInSchema(schema, true)
+		}
+		return instance.DropSchema(schema, true)
+	})
+}
+
+// lockWorkspace obtains a named lock for schemaName on instance, so that
+// concurrent runs do not attempt to use the same temporary schema at the same
+// time. The returned transaction holds the lock, and must be supplied to
+// unlockWorkspace.
+func lockWorkspace(instance *tengo.Instance, schemaName string, maxWait time.Duration) (*sql.Tx, error) {
+	db, err := instance.Connect("", "")
+	if err != nil {
+		return nil, err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	var getLockResult int
+	lockName := fmt.Sprintf("skeema.%s", schemaName)
+	start := time.Now()
+
+	for time.Since(start) < maxWait {
+		// Only using a timeout of 1 sec on each query to avoid potential issues with
+		// query killers, spurious slow query logging, etc
+		err := tx.QueryRow("SELECT GET_LOCK(?, 1)", lockName).Scan(&getLockResult)
+		if err == nil && getLockResult == 1 {
+			return tx, nil
+		}
+	}
+	tx.Rollback()
+	return nil, errors.New("Unable to acquire lock")
+}
+
+func unlockWorkspace(tx *sql.Tx, schemaName string) error {
+	lockName := fmt.Sprintf("skeema.%s", schemaName)
+	var releaseLockResult int
+	err := tx.QueryRow("SELECT RELEASE_LOCK(?)", lockName).Scan(&releaseLockResult)
+	if err != nil || releaseLockResult != 1 {
+		return errors.New("Failed to release lock, or connection holding lock already dropped")
+	}
+	return tx.Rollback()
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/skeema/mybase"
+	"github.com/skeema/tengo"
+)
+
+func TestWorkspaceName(t *testing.T) {
+	cases := map[int]string{
+		0: "_skeema_tmp",
+		1: "_skeema_tmp_2",
+		4: "_skeema_tmp_5",
+	}
+	for n, expected := range cases {
+		if actual := workspaceName("_skeema_tmp", n); actual != expected {
+			t.Errorf("Expected workspaceName(..., %d) to return %s, instead found %s", n, expected, actual)
+		}
+	}
+}
+
+func TestWorkspacePoolTake(t *testing.T) {
+	wp := &WorkspacePool{
+		idle:    make(map[string][]*pooledWorkspace),
+		created: make(map[string]int),
+		Cond:    sync.NewCond(new(sync.Mutex)),
+	}
+	first, recreate := wp.take("key", "_skeema_tmp", 2, "utf8mb4", "")
+	if first.name != "_skeema_tmp" || recreate {
+		t.Errorf("Unexpected result from first take: %+v, %t", *first, recreate)
+	}
+	second, recreate := wp.take("key", "_skeema_tmp", 2, "latin1", "")
+	if second.name != "_skeema_tmp_2" || recreate {
+		t.Errorf("Unexpected result from second take: %+v, %t", *second, recreate)
+	}
+
+	// Pool is now exhausted, so a third take should block until a workspace is
+	// returned
+	taken := make(chan *pooledWorkspace)
+	go func() {
+		entry, _ := wp.take("key", "_skeema_tmp", 2, "latin1", "")
+		taken <- entry
+	}()
+	(&Workspace{pool: wp, poolKey: "key", poolEntry: second}).returnToPool()
+	if entry := <-taken; entry != second {
+		t.Errorf("Expected blocked take to obtain returned workspace, instead found %+v", *entry)
+	}
+
+	// Idle workspaces with matching charset should be preferred; otherwise the
+	// workspace must be recreated
+	(&Workspace{pool: wp, poolKey: "key", poolEntry: first}).returnToPool()
+	(&Workspace{pool: wp, poolKey: "key", poolEntry: second}).returnToPool()
+	if entry, recreate := wp.take("key", "_skeema_tmp", 2, "utf8mb4", ""); entry != first || recreate {
+		t.Errorf("Expected take to prefer workspace with matching charset, instead found %+v, %t", *entry, recreate)
+	}
+	if entry, recreate := wp.take("key", "_skeema_tmp", 2, "utf8mb4", ""); entry != second || !recreate {
+		t.Errorf("Expected take to require recreation of workspace with different charset, instead found %+v, %t", *entry, recreate)
+	}
+	if wp.created["key"] != 2 {
+		t.Errorf("Expected 2 workspaces to be created, instead found %d", wp.created["key"])
+	}
+}
+
+func TestWorkspaceInstancePerEnvironment(t *testing.T) {
+	base, err := ioutil.TempDir("", "skeematest")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(base)
+	contents := "host=prod-db\n\n[development]\nhost=localhost\nskip-verify\n\n[ci]\nhost=127.0.0.1\nverify=auto\nworkspace-host=ci-db:3307:bad\n"
+	if err := ioutil.WriteFile(filepath.Join(base, ".skeema"), []byte(contents), 0666); err != nil {
+		t.Fatalf("Unable to write file: %s", err)
+	}
+
+	cmd := mybase.NewCommand("test", "1.0", "this is for testing", nil)
+	AddGlobalOptions(cmd)
+	cmd.AddOption(mybase.BoolOption("verify", 0, true, "verify"))
+	expectVerify := map[string]string{"production": "1", "development": "", "ci": "auto"}
+	for environment, expected := range expectVerify {
+		cfg := mybase.NewConfig(&mybase.CommandLine{Command: cmd}, dummySource(map[string]string{}))
+		dir := &Dir{Path: base, Config: cfg, section: environment}
+		if f, err := dir.OptionFile(); err != nil {
+			t.Fatalf("Unexpected error reading option file: %s", err)
+		} else {
+			dir.Config.AddSource(f)
+		}
+		if actual := dir.Config.Get("verify"); actual != expected {
+			t.Errorf("Expected verify=%q in environment %s, instead found %q", expected, environment, actual)
+		}
+		inst, err := dir.WorkspaceInstance(&tengo.Instance{Host: "target"})
+		if environment == "ci" {
+			if err == nil {
+				t.Error("Expected error from invalid workspace-host in environment ci, but received none")
+			}
+		} else if err != nil || inst.Host != "target" {
+			t.Errorf("Expected target instance to be used as workspace in environment %s, instead found %v, %v", environment, inst, err)
+		}
+	}
+}